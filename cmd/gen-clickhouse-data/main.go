@@ -2,9 +2,11 @@ package main
 
 import (
 	"bufio"
+	"compress/gzip"
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"math/rand"
@@ -17,6 +19,7 @@ import (
 	ch "github.com/ClickHouse/clickhouse-go/v2"
 
 	"github.com/pv/uniset-timemachine-go/pkg/config"
+	"github.com/pv/uniset-timemachine-go/pkg/timex"
 )
 
 type options struct {
@@ -32,6 +35,8 @@ type options struct {
 	batchSize int
 	sqlOutput string // если задано, пишем SQL в файл вместо подключения к CH
 	truncate  bool
+	maxRows   int64 // порог оценки числа строк (0 — без проверки)
+	force     bool  // генерировать, даже если оценка больше maxRows
 }
 
 // sensorGenerator генерирует события для одного датчика
@@ -80,7 +85,7 @@ func main() {
 		iotype := meta.IOType
 		if iotype == "" {
 			// попробуем определить по имени
-			iotype = guessIOType(name)
+			iotype = config.GuessIOType(name)
 		}
 		sensors = append(sensors, sensorInfo{name: name, iotype: iotype})
 	}
@@ -88,11 +93,12 @@ func main() {
 		log.Fatalf("no sensors resolved for selector %s", opts.selector)
 	}
 
-	startTs, err := time.Parse(time.RFC3339, opts.start)
+	startTs, err := timex.Parse(opts.start)
 	if err != nil {
 		log.Fatalf("invalid --start: %v", err)
 	}
 	endTs := startTs.Add(opts.duration)
+	checkRowLimit(opts, sensors)
 
 	// SQL output mode
 	if opts.sqlOutput != "" {
@@ -264,38 +270,50 @@ func (g *sensorGenerator) stablePhaseDuration() time.Duration {
 	return time.Duration(40+g.rng.Intn(21)) * time.Second
 }
 
-func guessIOType(name string) string {
-	upper := strings.ToUpper(name)
-	if strings.HasPrefix(upper, "DI") {
-		return "DI"
-	}
-	if strings.HasPrefix(upper, "DO") {
-		return "DO"
-	}
-	if strings.HasPrefix(upper, "AI") {
-		return "AI"
-	}
-	if strings.HasPrefix(upper, "AO") {
-		return "AO"
-	}
-	// по умолчанию аналоговый
-	return "AI"
-}
-
 type sensorInfo struct {
 	name   string
 	iotype string
 }
 
+const (
+	// defaultMaxRows — порог оценки объёма, выше которого генерация требует --force.
+	defaultMaxRows = 50_000_000
+	// analogSaveRatio — доля секунд, в которые аналоговый датчик попадает в историю
+	// (значение считается каждую секунду, пишется при изменении > 0.5); замерено на генераторе.
+	analogSaveRatio = 0.62
+	// discreteMeanPeriod — среднее время между переключениями дискретного датчика (10-50 с).
+	discreteMeanPeriod = 30 * time.Second
+)
+
+// estimateRows оценивает число строк, которое сгенерируют датчики за duration:
+// дискретные пишутся только при переключении, аналоговые — почти каждую секунду.
+func estimateRows(sensors []sensorInfo, duration time.Duration) int64 {
+	var total float64
+	for _, s := range sensors {
+		switch strings.ToUpper(s.iotype) {
+		case "DI", "DO":
+			total += duration.Seconds()/discreteMeanPeriod.Seconds() + 1
+		default:
+			total += duration.Seconds() * analogSaveRatio
+		}
+	}
+	return int64(math.Ceil(total))
+}
+
+// checkRowLimit печатает оценку объёма и останавливает генерацию, если она больше --max-rows и не задан --force.
+func checkRowLimit(opts options, sensors []sensorInfo) {
+	estimate := estimateRows(sensors, opts.duration)
+	log.Printf("estimated rows: ~%d (%d sensors, %s)", estimate, len(sensors), opts.duration)
+	if opts.maxRows > 0 && estimate > opts.maxRows && !opts.force {
+		log.Fatalf("estimated %d rows exceed --max-rows=%d; reduce --sensors/--duration or pass --force", estimate, opts.maxRows)
+	}
+}
+
 func generateSQL(opts options, sensors []sensorInfo, start, end time.Time) error {
-	f, err := os.Create(opts.sqlOutput)
+	w, closeOutput, err := createOutput(opts.sqlOutput)
 	if err != nil {
 		return fmt.Errorf("create file: %w", err)
 	}
-	defer f.Close()
-
-	w := bufio.NewWriter(f)
-	defer w.Flush()
 
 	fmt.Fprintf(w, "-- Generated sensor data for ClickHouse\n")
 	fmt.Fprintf(w, "-- Sensors: %d, Duration: %v, Start: %s\n\n", len(sensors), opts.duration, opts.start)
@@ -343,10 +361,42 @@ func generateSQL(opts options, sensors []sensorInfo, start, end time.Time) error
 	}
 	flushRows()
 
+	if err := closeOutput(); err != nil {
+		return fmt.Errorf("write file: %w", err)
+	}
 	log.Printf("done: wrote %d rows to %s", totalRows, opts.sqlOutput)
 	return nil
 }
 
+// createOutput создаёт файл вывода; если имя оканчивается на .gz, данные сжимаются gzip.
+// closeOutput сбрасывает буфер, завершает gzip-поток и закрывает файл.
+func createOutput(path string) (w *bufio.Writer, closeOutput func() error, err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var dst io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		zw = gzip.NewWriter(f)
+		dst = zw
+	}
+	w = bufio.NewWriter(dst)
+	closeOutput = func() error {
+		err := w.Flush()
+		if zw != nil {
+			if cerr := zw.Close(); err == nil {
+				err = cerr
+			}
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return w, closeOutput, nil
+}
+
 func parseFlags() options {
 	var opt options
 	flag.StringVar(&opt.dsn, "db", "clickhouse://default:@localhost:9000/uniset", "ClickHouse DSN")
@@ -355,13 +405,15 @@ func parseFlags() options {
 	flag.StringVar(&opt.selector, "selector", "ALL", "sensor selector")
 	flag.IntVar(&opt.sensors, "sensors", 0, "limit number of sensors (0 = all)")
 	flag.DurationVar(&opt.duration, "duration", 10*time.Minute, "total time range to generate")
+	flag.Int64Var(&opt.maxRows, "max-rows", defaultMaxRows, "refuse to generate when the estimated row count exceeds this (0 = no limit)")
+	flag.BoolVar(&opt.force, "force", false, "generate even if the estimate exceeds --max-rows")
 	// Default start: 7 days ago (to avoid TTL expiration in CH)
 	defaultStart := time.Now().UTC().AddDate(0, 0, -7).Truncate(24*time.Hour).Format(time.RFC3339)
-	flag.StringVar(&opt.start, "start", defaultStart, "start timestamp (RFC3339)")
+	flag.StringVar(&opt.start, "start", defaultStart, "start timestamp ("+timex.Syntax+")")
 	flag.StringVar(&opt.nodename, "nodename", "node1", "value for nodename column")
 	flag.StringVar(&opt.producer, "producer", "gen-data", "value for producer column")
 	flag.IntVar(&opt.batchSize, "batch", 10000, "rows per batch send (direct mode)")
-	flag.StringVar(&opt.sqlOutput, "sql-output", "", "write SQL to file instead of inserting (e.g. data.sql)")
+	flag.StringVar(&opt.sqlOutput, "sql-output", "", "write SQL to file instead of inserting (e.g. data.sql; .gz suffix enables gzip)")
 	flag.BoolVar(&opt.truncate, "truncate", false, "truncate table before insert")
 	flag.Parse()
 	return opt
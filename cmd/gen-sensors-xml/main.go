@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+
+	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
 type options struct {
@@ -59,10 +61,13 @@ func generate(opt options) error {
 
 	for i := 0; i < opt.count; i++ {
 		id := opt.startID + i
-		name := fmt.Sprintf("%s%d%s", opt.namePrefix, id, opt.nameSuffix)
-		text := fmt.Sprintf("%s %d", opt.textPrefix, id)
-		line := fmt.Sprintf(`	<item id="%d" iotype="%s" name="%s" textname="%s"/>`, id, opt.ioType, name, text)
-		if _, err := fmt.Fprintln(file, line); err != nil {
+		item := config.GeneratedSensor{
+			ID:       int64(id),
+			Name:     fmt.Sprintf("%s%d%s", opt.namePrefix, id, opt.nameSuffix),
+			IOType:   opt.ioType,
+			TextName: fmt.Sprintf("%s %d", opt.textPrefix, id),
+		}
+		if _, err := fmt.Fprintln(file, "\t"+item.XMLItem()); err != nil {
 			return err
 		}
 	}
@@ -11,6 +11,7 @@ import (
 	_ "modernc.org/sqlite"
 
 	"github.com/pv/uniset-timemachine-go/pkg/config"
+	"github.com/pv/uniset-timemachine-go/pkg/timex"
 )
 
 type options struct {
@@ -24,8 +25,13 @@ type options struct {
 	startTS     string
 	reset       bool
 	randomRange float64
+	maxRows     int64 // порог числа строк (0 — без проверки)
+	force       bool  // генерировать, даже если строк больше maxRows
 }
 
+// defaultMaxRows — число строк, выше которого генерация требует --force.
+const defaultMaxRows = 50_000_000
+
 func main() {
 	opts := parseFlags()
 	rand.Seed(time.Now().UnixNano())
@@ -38,7 +44,13 @@ func main() {
 		log.Fatal("no sensors to generate")
 	}
 
-	start, err := time.Parse(time.RFC3339, opts.startTS)
+	total := int64(len(sensorIDs)) * int64(opts.points)
+	log.Printf("rows to generate: %d (%d sensors × %d points)", total, len(sensorIDs), opts.points)
+	if opts.maxRows > 0 && total > opts.maxRows && !opts.force {
+		log.Fatalf("%d rows exceed --max-rows=%d; reduce --sensors/--points or pass --force", total, opts.maxRows)
+	}
+
+	start, err := timex.Parse(opts.startTS)
 	if err != nil {
 		log.Fatalf("invalid --start: %v", err)
 	}
@@ -68,8 +80,7 @@ func main() {
 		log.Fatalf("prepare insert: %v", err)
 	}
 
-	total := len(sensorIDs) * opts.points
-	var inserted int
+	var inserted int64
 	for _, sensorID := range sensorIDs {
 		ts := start
 		for i := 0; i < opts.points; i++ {
@@ -102,9 +113,11 @@ func parseFlags() options {
 	flag.IntVar(&opt.maxSensors, "sensors", 1000, "number of sensors to generate (ignored if configuration has fewer sensors)")
 	flag.IntVar(&opt.points, "points", 1000, "records per sensor")
 	flag.DurationVar(&opt.step, "step", time.Second, "time delta between records")
-	flag.StringVar(&opt.startTS, "start", "2024-06-01T00:00:00Z", "start timestamp (RFC3339)")
+	flag.StringVar(&opt.startTS, "start", "2024-06-01T00:00:00Z", "start timestamp ("+timex.Syntax+")")
 	flag.BoolVar(&opt.reset, "reset", true, "clear existing data in main_history")
 	flag.Float64Var(&opt.randomRange, "random", 0, "if >0, add random variation (-range..+range) to sensor values")
+	flag.Int64Var(&opt.maxRows, "max-rows", defaultMaxRows, "refuse to generate more rows than this (0 = no limit)")
+	flag.BoolVar(&opt.force, "force", false, "generate even if the row count exceeds --max-rows")
 	flag.Parse()
 	return opt
 }
@@ -23,6 +23,8 @@ import (
 type options struct {
 	configYAML string
 	smURL      string
+	setPath    string
+	getPath    string
 	supplier   string
 	sensorID   int64
 	value      float64
@@ -43,6 +45,8 @@ func main() {
 	client := &sharedmem.HTTPClient{
 		BaseURL:  opts.smURL,
 		Supplier: opts.supplier,
+		SetPath:  opts.setPath,
+		GetPath:  opts.getPath,
 		ParamFormatter: func(hash int64, _ *config.SensorRegistry) string {
 			return strconv.FormatInt(hash, 10)
 		},
@@ -57,7 +61,7 @@ func main() {
 		log.Fatalf("SM set failed: %v", err)
 	}
 
-	body, err := smGet(ctx, opts.smURL, opts.sensorID)
+	body, err := smGet(ctx, client, opts.sensorID)
 	if err != nil {
 		log.Fatalf("SM get failed: %v", err)
 	}
@@ -68,6 +72,8 @@ func parseFlags() options {
 	var opt options
 	flag.StringVar(&opt.configYAML, "config-yaml", "", "path to YAML file with default flag values")
 	flag.StringVar(&opt.smURL, "sm-url", "http://localhost:9191/api/v01/SharedMemory", "SharedMemory endpoint base URL")
+	flag.StringVar(&opt.setPath, "sm-set-path", sharedmem.DefaultSetPath, "SharedMemory set sub-path relative to --sm-url")
+	flag.StringVar(&opt.getPath, "sm-get-path", sharedmem.DefaultGetPath, "SharedMemory get sub-path relative to --sm-url")
 	flag.StringVar(&opt.supplier, "supplier", "TimeMachine", "supplier name (required if SM enforces ACL)")
 	flag.Int64Var(&opt.sensorID, "sensor-id", 10001, "sensor ID to update")
 	flag.Float64Var(&opt.value, "value", 0, "value to set (ignored if --random)")
@@ -85,12 +91,15 @@ func parseFlags() options {
 	return opt
 }
 
-func smGet(ctx context.Context, base string, sensorID int64) (string, error) {
-	u, err := url.Parse(base)
+func smGet(ctx context.Context, client *sharedmem.HTTPClient, sensorID int64) (string, error) {
+	endpoint, err := client.GetEndpoint()
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(endpoint)
 	if err != nil {
 		return "", err
 	}
-	u.Path = joinPath(u.Path, "/get")
 	q := u.Query()
 	q.Set(strconv.FormatInt(sensorID, 10), "")
 	q.Set("shortInfo", "")
@@ -116,16 +125,6 @@ func smGet(ctx context.Context, base string, sensorID int64) (string, error) {
 	return string(data), nil
 }
 
-func joinPath(base, suffix string) string {
-	if base == "" || base == "/" {
-		return suffix
-	}
-	if base[len(base)-1] == '/' {
-		return base + suffix[1:]
-	}
-	return base + suffix
-}
-
 func findConfigYAML(args []string) string {
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
@@ -206,6 +205,8 @@ func mapYAMLKey(key string) string {
 	mappings := map[string]string{
 		"output.sm-url":       "sm-url",
 		"output.sm-supplier":  "supplier",
+		"output.sm-set-path":  "sm-set-path",
+		"output.sm-get-path":  "sm-get-path",
 		"sm-test.sensor-id":   "sensor-id",
 		"sm-test.value":       "value",
 		"sm-test.random":      "random",
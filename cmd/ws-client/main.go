@@ -2,8 +2,11 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/flate"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
@@ -23,6 +26,8 @@ type wsMessage struct {
 	StepTs   string            `json:"step_ts"`
 	StepUnix int64             `json:"step_unix"`
 	Updates  []wsMessageUpdate `json:"updates"`
+	Reason   string            `json:"reason,omitempty"` // done: completed | stopped | failed
+	Error    string            `json:"error,omitempty"`
 }
 
 type wsMessageUpdate struct {
@@ -35,13 +40,19 @@ type wsMessageUpdate struct {
 
 func main() {
 	var (
-		raw    bool
-		limit  int
-		urlStr string
+		raw      bool
+		compress bool
+		insecure bool
+		limit    int
+		urlStr   string
+		sensors  string
 	)
 	flag.StringVar(&urlStr, "url", "ws://127.0.0.1:19001/api/v2/ws/state", "WebSocket URL of timemachine server")
 	flag.BoolVar(&raw, "raw", false, "print raw JSON messages")
+	flag.BoolVar(&compress, "compress", true, "offer permessage-deflate (used only if the server runs with --ws-compress)")
 	flag.IntVar(&limit, "limit", 0, "stop after N update messages (0 = infinite)")
+	flag.BoolVar(&insecure, "insecure", false, "wss://: skip server certificate verification (self-signed certificates in tests)")
+	flag.StringVar(&sensors, "sensors", "", "receive only these sensors (comma-separated names, sent as ?sensors=); the job working set is not changed")
 	flag.Parse()
 
 	u, err := url.Parse(urlStr)
@@ -51,6 +62,11 @@ func main() {
 	if u.Scheme != "ws" && u.Scheme != "wss" {
 		log.Fatalf("url must start with ws:// or wss://")
 	}
+	if sensors != "" {
+		q := u.Query()
+		q.Set("sensors", sensors)
+		u.RawQuery = q.Encode()
+	}
 	addr := u.Host
 	if !strings.Contains(addr, ":") {
 		if u.Scheme == "wss" {
@@ -60,21 +76,31 @@ func main() {
 		}
 	}
 
-	conn, err := net.Dial("tcp", addr)
+	var conn net.Conn
+	if u.Scheme == "wss" {
+		conn, err = tls.Dial("tcp", addr, &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: insecure})
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
 	if err != nil {
 		log.Fatalf("dial: %v", err)
 	}
 	defer conn.Close()
 
-	if err := sendHandshake(conn, u); err != nil {
+	deflate, err := sendHandshake(conn, u, compress)
+	if err != nil {
 		log.Fatalf("handshake: %v", err)
 	}
-	log.Printf("connected to %s", urlStr)
+	if deflate {
+		log.Printf("connected to %s (permessage-deflate)", urlStr)
+	} else {
+		log.Printf("connected to %s", urlStr)
+	}
 
 	reader := bufio.NewReader(conn)
 	updatesSeen := 0
 	for {
-		op, payload, err := readFrame(reader)
+		op, compressed, payload, err := readFrame(reader)
 		if err != nil {
 			if err == io.EOF {
 				log.Println("connection closed by peer")
@@ -89,17 +115,31 @@ func main() {
 		if op != 0x1 {
 			continue
 		}
-
-		if raw {
-			fmt.Println(string(payload))
-			continue
+		if compressed {
+			if !deflate {
+				log.Fatalf("read frame: compressed frame without negotiated permessage-deflate")
+			}
+			if payload, err = inflate(payload); err != nil {
+				log.Fatalf("inflate frame: %v", err)
+			}
 		}
 
 		var msg wsMessage
 		if err := json.Unmarshal(payload, &msg); err != nil {
+			if raw {
+				fmt.Println(string(payload))
+				continue
+			}
 			log.Printf("invalid json: %v", err)
 			continue
 		}
+		if raw {
+			fmt.Println(string(payload))
+			if msg.Type == "done" {
+				return // end of stream
+			}
+			continue
+		}
 
 		switch strings.ToLower(msg.Type) {
 		case "snapshot":
@@ -118,16 +158,24 @@ func main() {
 				log.Printf("limit reached (%d updates), exiting", limit)
 				return
 			}
+		case "done":
+			if msg.Error != "" {
+				log.Printf("done: reason=%s step=%d ts=%s error=%s", msg.Reason, msg.StepID, msg.StepTs, msg.Error)
+			} else {
+				log.Printf("done: reason=%s step=%d ts=%s", msg.Reason, msg.StepID, msg.StepTs)
+			}
+			return
 		default:
 			log.Printf("message type=%s (ignored)", msg.Type)
 		}
 	}
 }
 
-func sendHandshake(conn net.Conn, u *url.URL) error {
+// sendHandshake performs the upgrade and reports whether the server accepted permessage-deflate.
+func sendHandshake(conn net.Conn, u *url.URL, compress bool) (bool, error) {
 	key := make([]byte, 16)
 	if _, err := rand.Read(key); err != nil {
-		return err
+		return false, err
 	}
 	secKey := base64.StdEncoding.EncodeToString(key)
 	host := u.Host
@@ -138,24 +186,28 @@ func sendHandshake(conn net.Conn, u *url.URL) error {
 	if path == "" {
 		path = "/"
 	}
-	req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: %s\r\n\r\n", path, host, secKey)
+	extensions := ""
+	if compress {
+		extensions = "Sec-WebSocket-Extensions: permessage-deflate; client_no_context_takeover\r\n"
+	}
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: %s\r\n%s\r\n", path, host, secKey, extensions)
 	if _, err := io.WriteString(conn, req); err != nil {
-		return err
+		return false, err
 	}
 
 	reader := bufio.NewReader(conn)
 	status, err := reader.ReadString('\n')
 	if err != nil {
-		return err
+		return false, err
 	}
 	if !strings.HasPrefix(status, "HTTP/1.1 101") {
-		return fmt.Errorf("unexpected status: %s", strings.TrimSpace(status))
+		return false, fmt.Errorf("unexpected status: %s", strings.TrimSpace(status))
 	}
-	var acceptOk bool
+	var acceptOk, deflate bool
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			return err
+			return false, err
 		}
 		if line == "\r\n" {
 			break
@@ -168,11 +220,14 @@ func sendHandshake(conn net.Conn, u *url.URL) error {
 				acceptOk = accept == expected
 			}
 		}
+		if strings.HasPrefix(strings.ToLower(line), "sec-websocket-extensions") {
+			deflate = compress && strings.Contains(strings.ToLower(line), "permessage-deflate")
+		}
 	}
 	if !acceptOk {
-		return fmt.Errorf("handshake failed: Sec-WebSocket-Accept mismatch")
+		return false, fmt.Errorf("handshake failed: Sec-WebSocket-Accept mismatch")
 	}
-	return nil
+	return deflate, nil
 }
 
 func computeAccept(key string) string {
@@ -182,41 +237,52 @@ func computeAccept(key string) string {
 }
 
 // readFrame reads a single unmasked text frame (server-to-client).
-func readFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+// compressed reports the RSV1 bit set by permessage-deflate.
+func readFrame(r *bufio.Reader) (opcode byte, compressed bool, payload []byte, err error) {
 	h1, err := r.ReadByte()
 	if err != nil {
-		return 0, nil, err
+		return 0, false, nil, err
 	}
 	h2, err := r.ReadByte()
 	if err != nil {
-		return 0, nil, err
+		return 0, false, nil, err
 	}
 	opcode = h1 & 0x0f
+	compressed = h1&0x40 != 0
 	masked := h2&0x80 != 0
 	if masked {
-		return 0, nil, fmt.Errorf("server sent masked frame")
+		return 0, false, nil, fmt.Errorf("server sent masked frame")
 	}
 	length := int(h2 & 0x7f)
 	switch length {
 	case 126:
 		var buf [2]byte
 		if _, err := io.ReadFull(r, buf[:]); err != nil {
-			return 0, nil, err
+			return 0, false, nil, err
 		}
 		length = int(binary.BigEndian.Uint16(buf[:]))
 	case 127:
 		var buf [8]byte
 		if _, err := io.ReadFull(r, buf[:]); err != nil {
-			return 0, nil, err
+			return 0, false, nil, err
 		}
 		length = int(binary.BigEndian.Uint64(buf[:]))
 	}
 	if length < 0 {
-		return 0, nil, fmt.Errorf("invalid length %d", length)
+		return 0, false, nil, fmt.Errorf("invalid length %d", length)
 	}
 	payload = make([]byte, length)
 	if _, err := io.ReadFull(r, payload); err != nil {
-		return 0, nil, err
+		return 0, false, nil, err
 	}
-	return opcode, payload, nil
+	return opcode, compressed, payload, nil
+}
+
+// inflate restores a permessage-deflate payload: the server strips the 00 00 ff ff tail,
+// and a final empty stored block lets the reader finish without io.ErrUnexpectedEOF.
+func inflate(payload []byte) ([]byte, error) {
+	data := append(payload, 0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff)
+	fr := flate.NewReader(bytes.NewReader(data))
+	defer fr.Close()
+	return io.ReadAll(fr)
 }
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Машинные коды ошибок API. Значения стабильны: UI и внешние клиенты ветвятся по ним.
+const (
+	codeValidation       = "validation"
+	codeTooLarge         = "too_large"
+	codeConflict         = "conflict"
+	codeJobActive        = "job_active"
+	codeNoActiveJob      = "no_active_job"
+	codeRangeNotSet      = "range_not_set"
+	codeControlLocked    = "control_locked"
+	codeSessionRequired  = "session_required"
+	codeUnknownSensors   = "unknown_sensors"
+	codeNoData           = "no_data"
+	codeNotFound         = "not_found"
+	codeNotSupported     = "not_supported"
+	codeMethodNotAllowed = "method_not_allowed"
+	codeTimeout          = "timeout"
+	codeUnavailable      = "unavailable"
+	codeInternal         = "internal"
+)
+
+// errorBody — тело ответа с ошибкой: {"error":{"code","message","details"}}.
+type errorBody struct {
+	Error errorInfo `json:"error"`
+}
+
+type errorInfo struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Details map[string]any `json:"details"`
+}
+
+// codedError задаёт код и детали ошибки явно, когда их нельзя вывести из самой ошибки.
+type codedError struct {
+	err     error
+	code    string
+	details map[string]any
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+// withCode помечает ошибку машинным кодом и необязательными деталями для writeError.
+func withCode(err error, code string, details map[string]any) error {
+	return &codedError{err: err, code: code, details: details}
+}
+
+// errorCode выбирает код ошибки: явный (withCode), по известной ошибке менеджера или по HTTP-статусу.
+func errorCode(status int, err error) (string, map[string]any) {
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code, coded.details
+	}
+	var strict *strictStartError
+	if errors.As(err, &strict) {
+		return codeUnknownSensors, map[string]any{"sensors": strict.sensors, "count": strict.count}
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return codeTooLarge, map[string]any{"limit_bytes": tooLarge.Limit}
+	}
+	switch {
+	case errors.Is(err, errJobActive):
+		return codeJobActive, nil
+	case errors.Is(err, errNoActiveJob), errors.Is(err, errJobFinished):
+		return codeNoActiveJob, nil
+	case errors.Is(err, errRangeNotSet):
+		return codeRangeNotSet, nil
+	case errors.Is(err, errStepOutOfRange):
+		return codeValidation, map[string]any{"field": "step_id"}
+	case errors.Is(err, errSeekInGap):
+		return codeValidation, map[string]any{"field": "ts"}
+	case errors.Is(err, errControlLocked):
+		return codeControlLocked, nil
+	case errors.Is(err, errSessionRequired):
+		return codeSessionRequired, nil
+	case errors.Is(err, errNoValidSensors), errors.Is(err, errSensorNotFound):
+		return codeUnknownSensors, nil
+	case errors.Is(err, errNoStashedPos), errors.Is(err, errPreviewNoState):
+		return codeNoData, nil
+	case errors.Is(err, errNoEventHistory), errors.Is(err, errNoChangeHistory):
+		return codeNotSupported, nil
+	case errors.Is(err, errCommandTimeout):
+		return codeTimeout, nil
+	}
+	switch status {
+	case http.StatusForbidden:
+		return codeControlLocked, nil
+	case http.StatusConflict:
+		return codeConflict, nil
+	case http.StatusNotFound:
+		return codeNotFound, nil
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed, nil
+	case http.StatusNotImplemented:
+		return codeNotSupported, nil
+	case http.StatusServiceUnavailable:
+		return codeUnavailable, nil
+	}
+	if status >= http.StatusInternalServerError {
+		return codeInternal, nil
+	}
+	return codeValidation, nil
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	code, details := errorCode(status, err)
+	if code == codeTooLarge {
+		// Хендлеры отвечают 400 на любую ошибку разбора тела; превышение лимита — отдельный статус.
+		status = http.StatusRequestEntityTooLarge
+	}
+	if details == nil {
+		details = map[string]any{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	log.Printf("[http] error %d %s: %v", status, code, err)
+	_ = json.NewEncoder(w).Encode(errorBody{Error: errorInfo{Code: code, Message: err.Error(), Details: details}})
+}
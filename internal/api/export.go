@@ -0,0 +1,443 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pv/uniset-timemachine-go/internal/replay"
+	"github.com/pv/uniset-timemachine-go/internal/sharedmem"
+)
+
+// Состояния выгрузки.
+const (
+	exportRunning  = "running"
+	exportDone     = "done"
+	exportFailed   = "failed"
+	exportCanceled = "canceled"
+)
+
+// maxExports — сколько выгрузок хранится: при превышении удаляются самые старые завершённые вместе с файлами.
+const maxExports = 32
+
+var (
+	errExportDisabled = errors.New("export is disabled (start the server with --export-dir)")
+	errExportNotFound = errors.New("export not found")
+	errExportNotReady = errors.New("export is not finished")
+)
+
+// Exporter выгружает воспроизведение периода в JSONL-файлы в каталоге dir (POST /api/v2/export).
+// Выгрузка идёт в фоне без пауз между шагами и не затрагивает задачу менеджера и SM; готовый файл
+// отдаётся с поддержкой Range, поэтому прерванную загрузку можно докачать.
+type Exporter struct {
+	dir       string
+	service   replay.Service // хранилище и преобразования значений — как у задач менеджера
+	window    time.Duration
+	aggregate replay.StepAggregate
+
+	mu    sync.Mutex
+	jobs  map[string]*exportJob
+	order []string // id в порядке создания
+}
+
+type exportJob struct {
+	id      string
+	path    string
+	from    time.Time
+	to      time.Time
+	step    time.Duration
+	sensors int
+	created time.Time
+	cancel  context.CancelFunc
+
+	// Поля ниже защищены Exporter.mu.
+	status   string
+	stepID   int64
+	stepTs   time.Time
+	err      string
+	finished time.Time
+}
+
+// ExportStatus — состояние выгрузки для /api/v2/export/{id}/status.
+type ExportStatus struct {
+	ID      string    `json:"id"`
+	Status  string    `json:"status"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Step    string    `json:"step"`
+	Sensors int       `json:"sensors"`
+	StepID  int64     `json:"step_id"`
+	// Progress — доля проигранного периода, 0..100.
+	Progress float64 `json:"progress"`
+	// Bytes — размер записанной части файла; у завершённой выгрузки — полный размер для загрузки.
+	Bytes      int64      `json:"bytes"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ExportRequest — параметры выгрузки: период, шаг, датчики (пусто — рабочий список) и агрегация шага.
+type ExportRequest struct {
+	From          time.Time
+	To            time.Time
+	Step          time.Duration
+	Sensors       []int64
+	StepAggregate replay.StepAggregate // пусто — --step-aggregate сервера
+}
+
+// NewExporter создаёт каталог dir и выгрузчик с хранилищем, окном и агрегацией шага менеджера.
+func NewExporter(dir string, manager *Manager) (*Exporter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("export dir: %w", err)
+	}
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return &Exporter{
+		dir:       dir,
+		service:   manager.service,
+		window:    manager.defaults.window,
+		aggregate: manager.defaults.aggregate,
+		jobs:      make(map[string]*exportJob),
+	}, nil
+}
+
+// Start запускает выгрузку в фоне и возвращает её начальное состояние.
+func (e *Exporter) Start(req ExportRequest) (ExportStatus, error) {
+	if !req.To.After(req.From) {
+		return ExportStatus{}, fmt.Errorf("invalid period: %s → %s", req.From.Format(time.RFC3339), req.To.Format(time.RFC3339))
+	}
+	if req.Step <= 0 {
+		return ExportStatus{}, fmt.Errorf("step must be > 0")
+	}
+	if len(req.Sensors) == 0 {
+		return ExportStatus{}, errNoValidSensors
+	}
+	aggregate := req.StepAggregate
+	if aggregate == "" {
+		aggregate = e.aggregate
+	}
+	id := uuid.NewString()
+	path := filepath.Join(e.dir, "export-"+id+".jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		return ExportStatus{}, fmt.Errorf("create export file: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &exportJob{
+		id:      id,
+		path:    path,
+		from:    req.From,
+		to:      req.To,
+		step:    req.Step,
+		sensors: len(req.Sensors),
+		created: time.Now(),
+		cancel:  cancel,
+		status:  exportRunning,
+	}
+	e.mu.Lock()
+	e.jobs[id] = j
+	e.order = append(e.order, id)
+	e.pruneLocked()
+	st := e.statusLocked(j)
+	e.mu.Unlock()
+
+	params := replay.Params{
+		Sensors:       req.Sensors,
+		From:          req.From,
+		To:            req.To,
+		Step:          req.Step,
+		Window:        e.window,
+		SaveOutput:    true,
+		NoPace:        true,
+		StepAggregate: aggregate,
+	}
+	go e.run(ctx, j, f, params)
+	log.Printf("[export] %s started: %s → %s step=%s sensors=%d", id, req.From.Format(time.RFC3339), req.To.Format(time.RFC3339), req.Step, len(req.Sensors))
+	return st, nil
+}
+
+// run проигрывает период в файл; незавершённый файл при ошибке или отмене удаляется.
+func (e *Exporter) run(ctx context.Context, j *exportJob, f *os.File, params replay.Params) {
+	defer j.cancel()
+	w := bufio.NewWriterSize(f, 64<<10)
+	svc := e.service
+	svc.Output = &sharedmem.StdoutClient{Writer: w, Format: sharedmem.FormatJSONL}
+	err := svc.RunWithControl(ctx, params, replay.Control{
+		OnStep: func(info replay.StepInfo) {
+			e.mu.Lock()
+			j.stepID, j.stepTs = info.StepID, info.StepTs
+			e.mu.Unlock()
+		},
+	})
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	j.finished = time.Now()
+	switch {
+	case ctx.Err() != nil:
+		j.status = exportCanceled
+	case err != nil:
+		j.status = exportFailed
+		j.err = err.Error()
+	default:
+		j.status = exportDone
+	}
+	if j.status != exportDone {
+		os.Remove(j.path)
+	}
+	log.Printf("[export] %s %s in %s", j.id, j.status, j.finished.Sub(j.created).Round(time.Millisecond))
+}
+
+// Status возвращает состояние выгрузки id.
+func (e *Exporter) Status(id string) (ExportStatus, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	j, ok := e.jobs[id]
+	if !ok {
+		return ExportStatus{}, errExportNotFound
+	}
+	return e.statusLocked(j), nil
+}
+
+// List возвращает состояние всех хранящихся выгрузок, новые — последними.
+func (e *Exporter) List() []ExportStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]ExportStatus, 0, len(e.order))
+	for _, id := range e.order {
+		out = append(out, e.statusLocked(e.jobs[id]))
+	}
+	return out
+}
+
+// Open открывает файл завершённой выгрузки для загрузки.
+func (e *Exporter) Open(id string) (*os.File, ExportStatus, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	j, ok := e.jobs[id]
+	if !ok {
+		return nil, ExportStatus{}, errExportNotFound
+	}
+	st := e.statusLocked(j)
+	if j.status != exportDone {
+		return nil, st, errExportNotReady
+	}
+	f, err := os.Open(j.path)
+	if err != nil {
+		return nil, st, fmt.Errorf("open export file: %w", err)
+	}
+	return f, st, nil
+}
+
+// Delete отменяет выгрузку, если она идёт, и удаляет её файл.
+func (e *Exporter) Delete(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	j, ok := e.jobs[id]
+	if !ok {
+		return errExportNotFound
+	}
+	e.removeLocked(j)
+	return nil
+}
+
+func (e *Exporter) removeLocked(j *exportJob) {
+	j.cancel()
+	os.Remove(j.path)
+	delete(e.jobs, j.id)
+	for i, id := range e.order {
+		if id == j.id {
+			e.order = append(e.order[:i], e.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// pruneLocked удаляет самые старые завершённые выгрузки сверх maxExports; идущие не трогает.
+func (e *Exporter) pruneLocked() {
+	for i := 0; len(e.order) > maxExports && i < len(e.order); {
+		j := e.jobs[e.order[i]]
+		if j.status == exportRunning {
+			i++
+			continue
+		}
+		e.removeLocked(j)
+	}
+}
+
+func (e *Exporter) statusLocked(j *exportJob) ExportStatus {
+	st := ExportStatus{
+		ID:        j.id,
+		Status:    j.status,
+		From:      j.from,
+		To:        j.to,
+		Step:      j.step.String(),
+		Sensors:   j.sensors,
+		StepID:    j.stepID,
+		Error:     j.err,
+		CreatedAt: j.created,
+	}
+	switch {
+	case j.status == exportDone:
+		st.Progress = 100
+	case !j.stepTs.IsZero():
+		// Шаг stepTs уже записан: проиграна часть периода до следующего шага.
+		done := j.stepTs.Add(j.step).Sub(j.from)
+		st.Progress = min(100, 100*done.Seconds()/j.to.Sub(j.from).Seconds())
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		st.FinishedAt = &finished
+	}
+	if fi, err := os.Stat(j.path); err == nil {
+		st.Bytes = fi.Size()
+	}
+	return st
+}
+
+// exportRequest — тело POST /api/v2/export.
+type exportRequest struct {
+	From    string     `json:"from"`
+	To      string     `json:"to"`
+	For     string     `json:"for,omitempty"` // длительность вместо to (например "24h")
+	Step    string     `json:"step"`
+	Sensors sensorRefs `json:"sensors,omitempty"` // имена, hash или ID; пусто — рабочий список
+	// StepAggregate — значение шага при нескольких событиях: last, avg, min или max (пусто — --step-aggregate).
+	StepAggregate string `json:"step_aggregate,omitempty"`
+}
+
+// handleExport запускает выгрузку (POST) или возвращает список выгрузок (GET).
+func (s *Server) handleExport(w http.ResponseWriter, r *http.Request) {
+	if s.exports == nil {
+		writeError(w, http.StatusServiceUnavailable, withCode(errExportDisabled, codeUnavailable, nil))
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		list := s.exports.List()
+		writeJSON(w, http.StatusOK, map[string]any{"exports": list, "count": len(list)})
+	case http.MethodPost:
+		var req exportRequest
+		if err := decodeJSON(r, &req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		from, to, err := startRequest{From: req.From, To: req.To, For: req.For}.period()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, withCode(err, codeValidation, nil))
+			return
+		}
+		step, err := time.ParseDuration(req.Step)
+		if err != nil || step <= 0 {
+			writeError(w, http.StatusBadRequest, withCode(fmt.Errorf("invalid step %q: want positive duration", req.Step), codeValidation, map[string]any{"field": "step"}))
+			return
+		}
+		var aggregate replay.StepAggregate
+		if req.StepAggregate != "" {
+			if aggregate, err = replay.ParseStepAggregate(req.StepAggregate); err != nil {
+				writeError(w, http.StatusBadRequest, withCode(err, codeValidation, map[string]any{"field": "step_aggregate"}))
+				return
+			}
+		}
+		sensors, ok := s.sensorsOverride(w, req.Sensors, http.StatusBadRequest)
+		if !ok {
+			return
+		}
+		if sensors == nil {
+			sensors = s.manager.WorkingSensors()
+		}
+		st, err := s.exports.Start(ExportRequest{From: from, To: to, Step: step, Sensors: sensors, StepAggregate: aggregate})
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errNoValidSensors) {
+				status = http.StatusUnprocessableEntity
+			}
+			writeError(w, status, err)
+			return
+		}
+		w.Header().Set("Location", "/api/v2/export/"+st.ID)
+		writeJSON(w, http.StatusAccepted, st)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleExportFile отдаёт файл завершённой выгрузки (GET/HEAD, с поддержкой Range и If-Range)
+// или удаляет выгрузку (DELETE).
+func (s *Server) handleExportFile(w http.ResponseWriter, r *http.Request) {
+	if s.exports == nil {
+		writeError(w, http.StatusServiceUnavailable, withCode(errExportDisabled, codeUnavailable, nil))
+		return
+	}
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		f, st, err := s.exports.Open(id)
+		if err != nil {
+			writeExportError(w, id, st, err)
+			return
+		}
+		defer f.Close()
+		// Загрузка большого файла может длиться дольше WriteTimeout сервера.
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "export-"+id+".jsonl"))
+		// Сильный ETag: файл готовой выгрузки не меняется, If-Range по нему безопасен.
+		w.Header().Set("ETag", `"`+id+`"`)
+		http.ServeContent(w, r, "", *st.FinishedAt, f)
+	case http.MethodDelete:
+		if err := s.exports.Delete(id); err != nil {
+			writeExportError(w, id, ExportStatus{}, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleExportStatus возвращает состояние и прогресс выгрузки.
+func (s *Server) handleExportStatus(w http.ResponseWriter, r *http.Request) {
+	if s.exports == nil {
+		writeError(w, http.StatusServiceUnavailable, withCode(errExportDisabled, codeUnavailable, nil))
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.PathValue("id")
+	st, err := s.exports.Status(id)
+	if err != nil {
+		writeExportError(w, id, st, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// writeExportError: неизвестная выгрузка — 404, незавершённая — 409 с её состоянием в деталях.
+func writeExportError(w http.ResponseWriter, id string, st ExportStatus, err error) {
+	switch {
+	case errors.Is(err, errExportNotFound):
+		writeError(w, http.StatusNotFound, withCode(err, codeNotFound, map[string]any{"id": id}))
+	case errors.Is(err, errExportNotReady):
+		writeError(w, http.StatusConflict, withCode(err, codeConflict, map[string]any{"id": id, "status": st.Status, "progress": st.Progress}))
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
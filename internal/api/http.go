@@ -1,21 +1,29 @@
 package api
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"embed"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"net/http/pprof"
+	"os"
+	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/pv/uniset-timemachine-go/internal/replay"
+	"github.com/pv/uniset-timemachine-go/internal/storage"
 )
 
 // Server реализует HTTP API управления проигрывателем.
@@ -24,11 +32,81 @@ type Server struct {
 	mux         *http.ServeMux
 	streamer    *StateStreamer
 	unknownMode string
+	runtime     RuntimeInfo
+	socketMode  os.FileMode // права Unix-сокета (0 — по umask)
+	tlsConfig   *tls.Config // HTTPS (SetTLS); nil — обычный HTTP
+	logs        *LogBuffer  // последние строки лога (nil — /api/v2/logs недоступен)
+	limits      Limits
+	exports     *Exporter // фоновые выгрузки (nil — /api/v2/export недоступен)
 }
 
+// Limits — таймауты соединений и предельный размер тела запроса HTTP-сервера (0 — без ограничения).
+// WebSocket-соединения после upgrade таймаутам не подчиняются.
+type Limits struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxBodyBytes      int64
+}
+
+// DefaultLimits — ограничения по умолчанию: медленный клиент не держит соединение бесконечно,
+// а WriteTimeout оставляет запас на долгие запросы к хранилищу и /debug/pprof/profile.
+var DefaultLimits = Limits{
+	ReadHeaderTimeout: 10 * time.Second,
+	ReadTimeout:       time.Minute,
+	WriteTimeout:      2 * time.Minute,
+	IdleTimeout:       2 * time.Minute,
+	MaxBodyBytes:      1 << 20,
+}
+
+// unixAddrPrefix — префикс адреса для прослушивания Unix-сокета: "unix:/run/tm.sock".
+const unixAddrPrefix = "unix:"
+
+// RuntimeInfo описывает параметры запуска для /api/v2/config.
+// Секреты в DB и Output скрываются при выдаче.
+type RuntimeInfo struct {
+	Version    string
+	GitCommit  string // коммит сборки (-ldflags или vcs.revision из debug.ReadBuildInfo); пусто — неизвестен
+	BuildTime  string // время сборки (RFC3339); пусто — неизвестно
+	DB         string
+	Table      string
+	Output     string
+	SMSupplier string
+	HashMode   string // режим поиска датчиков в ClickHouse (uniset_hid, name_hid, name); пусто — не ClickHouse
+}
+
+// configResponse — ответ /api/v2/config.
+type configResponse struct {
+	Version     string          `json:"version,omitempty"`
+	DB          string          `json:"db"`
+	Table       string          `json:"table,omitempty"`
+	HashMode    string          `json:"hash_mode,omitempty"`
+	Output      string          `json:"output"`
+	SMSupplier  string          `json:"sm_supplier,omitempty"`
+	UnknownMode string          `json:"unknown_mode"`
+	Defaults    RuntimeDefaults `json:"defaults"`
+}
+
+// versionResponse — ответ /api/v2/version.
+type versionResponse struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	BuildTime string `json:"build_time,omitempty"`
+	GitCommit string `json:"git_commit,omitempty"`
+}
+
+// versionHeader — заголовок с версией сервера в ответах API.
+const versionHeader = "X-TM-Version"
+
 //go:embed ui/*
 var staticFS embed.FS
 
+// openAPISpec — описание API v2 (OpenAPI 3), поддерживается вручную вместе со структурами запросов.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
 // NewServer создаёт HTTP сервер с зарегистрированными хендлерами.
 func NewServer(manager *Manager, streamer *StateStreamer, unknownMode string) *Server {
 	uiFS, err := fs.Sub(staticFS, "ui")
@@ -41,20 +119,70 @@ func NewServer(manager *Manager, streamer *StateStreamer, unknownMode string) *S
 		mux:         http.NewServeMux(),
 		streamer:    streamer,
 		unknownMode: strings.ToLower(strings.TrimSpace(unknownMode)),
+		limits:      DefaultLimits,
 	}
 	s.routes(http.FS(uiFS))
 	return s
 }
 
+// SetRuntimeInfo задаёт параметры запуска, отдаваемые через /api/v2/config.
+func (s *Server) SetRuntimeInfo(info RuntimeInfo) {
+	s.runtime = info
+}
+
+// SetSocketMode задаёт права на файл Unix-сокета (0 — оставить по umask).
+func (s *Server) SetSocketMode(mode os.FileMode) {
+	s.socketMode = mode
+}
+
+// SetLogBuffer подключает буфер строк лога для /api/v2/logs и /api/v2/ws/logs.
+func (s *Server) SetLogBuffer(logs *LogBuffer) {
+	s.logs = logs
+}
+
+// SetExporter подключает фоновые выгрузки воспроизведения для /api/v2/export.
+func (s *Server) SetExporter(exports *Exporter) {
+	s.exports = exports
+}
+
+// SetLimits задаёт таймауты и предельный размер тела запроса (по умолчанию DefaultLimits).
+func (s *Server) SetLimits(limits Limits) {
+	s.limits = limits
+}
+
+// handler возвращает корневой обработчик: mux с ограничением размера тела запроса.
+func (s *Server) handler() http.Handler {
+	if s.limits.MaxBodyBytes <= 0 {
+		return s.mux
+	}
+	return http.MaxBytesHandler(s.mux, s.limits.MaxBodyBytes)
+}
+
 // Listen запускает сервер и блокируется до остановки.
+// Адрес вида "unix:/path/to.sock" — прослушивание Unix-сокета вместо TCP.
 func (s *Server) Listen(ctx context.Context, addr string) error {
+	ln, cleanup, err := s.listen(addr)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
 	server := &http.Server{
-		Addr:    addr,
-		Handler: s.mux,
+		Addr:              addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: s.limits.ReadHeaderTimeout,
+		ReadTimeout:       s.limits.ReadTimeout,
+		WriteTimeout:      s.limits.WriteTimeout,
+		IdleTimeout:       s.limits.IdleTimeout,
+		TLSConfig:         s.tlsConfig,
 	}
 	errCh := make(chan error, 1)
 	go func() {
-		errCh <- server.ListenAndServe()
+		if s.tlsConfig != nil {
+			// Сертификат отдаёт TLSConfig.GetCertificate, пути к файлам не нужны.
+			errCh <- server.ServeTLS(ln, "", "")
+			return
+		}
+		errCh <- server.Serve(ln)
 	}()
 
 	select {
@@ -71,11 +199,54 @@ func (s *Server) Listen(ctx context.Context, addr string) error {
 	}
 }
 
+// listen открывает TCP- или Unix-листенер. cleanup удаляет файл сокета после остановки.
+func (s *Server) listen(addr string) (net.Listener, func(), error) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		if addr == "" {
+			addr = ":http"
+		}
+		ln, err := net.Listen("tcp", addr)
+		return ln, func() {}, err
+	}
+	if path == "" {
+		return nil, nil, fmt.Errorf("empty unix socket path in %q", addr)
+	}
+	// Остаток от прошлого запуска удаляем, но только если это сокет.
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, nil, fmt.Errorf("unix socket %s: file exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, nil, fmt.Errorf("remove stale socket %s: %w", path, err)
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("remove socket %s: %v", path, err)
+		}
+	}
+	if s.socketMode != 0 {
+		if err := os.Chmod(path, s.socketMode); err != nil {
+			ln.Close()
+			cleanup()
+			return nil, nil, fmt.Errorf("chmod socket %s: %w", path, err)
+		}
+	}
+	return ln, cleanup, nil
+}
+
 func (s *Server) routes(uiFS http.FileSystem) {
 	s.mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok\n"))
 	})
+	s.mux.HandleFunc("/readyz", s.handleReadyz)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
 	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
 	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
@@ -103,32 +274,60 @@ func (s *Server) routes(uiFS http.FileSystem) {
 	s.mux.HandleFunc("/ui", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/ui/", http.StatusMovedPermanently)
 	})
-	apiRoutes := []struct {
-		path    string
-		handler http.Handler
-	}{
+	for _, route := range s.apiRoutes() {
+		s.mux.Handle(route.path, s.withVersion(s.withCORS(route.handler)))
+	}
+}
+
+// apiRoute — маршрут API v2; каждый из них должен быть описан в openapi.json.
+type apiRoute struct {
+	path    string
+	handler http.Handler
+}
+
+func (s *Server) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{"/api/v2/openapi.json", http.HandlerFunc(s.handleOpenAPI)},
+		{"/api/v2/preflight", http.HandlerFunc(s.handlePreflight)},
+		{"/api/v2/config", http.HandlerFunc(s.handleConfig)},
+		{"/api/v2/version", http.HandlerFunc(s.handleVersion)},
 		{"/api/v2/session", http.HandlerFunc(s.handleSession)},
 		{"/api/v2/session/claim", http.HandlerFunc(s.handleSessionClaim)},
 		{"/api/v2/session/logout", http.HandlerFunc(s.handleSessionLogout)},
 		{"/api/v2/sensors", http.HandlerFunc(s.handleSensors)},
+		{"/api/v2/sets", http.HandlerFunc(s.handleSets)},
+		{"/api/v2/sensors/{sensor}/history", http.HandlerFunc(s.handleSensorHistory)},
 		{"/api/v2/job/sensors", http.HandlerFunc(s.handleJobSensors)},
 		{"/api/v2/job/sensors/count", http.HandlerFunc(s.handleSensorCount)},
+		{"/api/v2/job/sensors/group", http.HandlerFunc(s.handleJobSensorsGroup)},
+		{"/api/v2/job/sensors/ids", http.HandlerFunc(s.handleJobSensorsIDs)},
+		{"/api/v2/job/sensors/iotype", http.HandlerFunc(s.handleJobSensorsIOType)},
+		{"/api/v2/job/sensors/upload", http.HandlerFunc(s.handleJobSensorsUpload)},
 		{"/api/v2/job", http.HandlerFunc(s.handleJobV2)},
 		{"/api/v2/job/range", http.HandlerFunc(s.handleSetRange)},
 		{"/api/v2/job/seek", http.HandlerFunc(s.handleSetSeek)},
+		{"/api/v2/job/seek/step", http.HandlerFunc(s.handleSeekStep)},
+		{"/api/v2/job/seek/percent", http.HandlerFunc(s.handleSeekPercent)},
 		{"/api/v2/job/start", http.HandlerFunc(s.handleStartPending)},
+		{"/api/v2/job/continue", http.HandlerFunc(s.handleContinue)},
+		{"/api/v2/job/playlist", http.HandlerFunc(s.handlePlaylist)},
+		{"/api/v2/job/segment", http.HandlerFunc(s.handleJobSegment)},
 		{"/api/v2/job/pause", http.HandlerFunc(s.wrapSimpleWithLog("pause", s.manager.Pause))},
 		{"/api/v2/job/resume", http.HandlerFunc(s.handleResume)},
+		{"/api/v2/job/play-until", http.HandlerFunc(s.handlePlayUntil)},
 		{"/api/v2/job/stop", http.HandlerFunc(s.wrapSimpleWithLog("stop", s.manager.Stop))},
 		{"/api/v2/job/apply", http.HandlerFunc(s.wrapSimpleWithLog("apply", s.manager.Apply))},
-		{"/api/v2/job/step/forward", http.HandlerFunc(s.wrapSimpleWithLog("step_forward", s.manager.StepForward))},
-		{"/api/v2/job/step/backward", http.HandlerFunc(s.handleStepBackward)},
+		{"/api/v2/job/step/forward", s.handleStep(true)},
+		{"/api/v2/job/step/backward", s.handleStep(false)},
 		{"/api/v2/snapshot", http.HandlerFunc(s.handleSnapshot)},
+		{"/api/v2/snapshot/batch", http.HandlerFunc(s.handleSnapshotBatch)},
 		{"/api/v2/ws/state", http.HandlerFunc(s.handleWSState)},
+		{"/api/v2/logs", http.HandlerFunc(s.handleLogs)},
+		{"/api/v2/ws/logs", http.HandlerFunc(s.handleWSLogs)},
 		{"/api/v2/job/reset", http.HandlerFunc(s.handleReset)},
-	}
-	for _, route := range apiRoutes {
-		s.mux.Handle(route.path, s.withCORS(route.handler))
+		{"/api/v2/export", http.HandlerFunc(s.handleExport)},
+		{"/api/v2/export/{id}", http.HandlerFunc(s.handleExportFile)},
+		{"/api/v2/export/{id}/status", http.HandlerFunc(s.handleExportStatus)},
 	}
 }
 
@@ -238,14 +437,9 @@ func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
-		from, err := time.Parse(time.RFC3339, req.From)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid from: %w", err))
-			return
-		}
-		to, err := time.Parse(time.RFC3339, req.To)
+		from, to, err := req.period()
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid to: %w", err))
+			writeError(w, http.StatusBadRequest, err)
 			return
 		}
 		step, err := time.ParseDuration(req.Step)
@@ -262,12 +456,8 @@ func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		logDebugf("[http] job start from=%s to=%s step=%s speed=%f window=%s save=%v", from.Format(time.RFC3339), to.Format(time.RFC3339), step, req.Speed, window, req.SaveOutput)
-		if err := s.manager.Start(r.Context(), from, to, step, req.Speed, window, req.SaveOutput); err != nil {
-			code := http.StatusBadRequest
-			if err.Error() == "job is already active" {
-				code = http.StatusConflict
-			}
-			writeError(w, code, err)
+		if err := s.manager.Start(r.Context(), from, to, step, req.Speed, window, req.SaveOutput, req.startOptions()...); err != nil {
+			writeError(w, startErrorStatus(err), err)
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]string{"status": "running"})
@@ -289,23 +479,83 @@ func (s *Server) handleJobV2(w http.ResponseWriter, r *http.Request) {
 }
 
 // handleSensors возвращает список датчиков с именами (для подсказок в UI).
+// ?q= — фильтр по подстроке имени, ?offset=&limit= — страница отсортированного по имени списка.
 func (s *Server) handleSensors(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	q := r.URL.Query()
+	var page [2]int // offset, limit
+	for i, name := range []string{"offset", "limit"} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, withCode(fmt.Errorf("invalid %s: %q", name, v), codeValidation, map[string]any{name: v}))
+			return
+		}
+		page[i] = n
+	}
 	list := s.manager.Sensors()
 	if len(list) == 0 && s.streamer != nil {
 		list = s.streamer.ListSensors()
 	}
+	// Без limit отдаётся весь (отфильтрованный) список, как раньше.
+	sensors, total := pageSensors(list, q.Get("q"), page[0], page[1])
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sensors": sensors,
+		"count":   len(sensors),
+		"total":   total,
+	})
+}
+
+// handleSets возвращает именованные наборы датчиков из конфига с числом датчиков в каждом.
+func (s *Server) handleSets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sets := s.manager.Sets()
+	if sets == nil {
+		sets = []SetInfo{}
+	}
 	writeJSON(w, http.StatusOK, map[string]any{
-		"sensors": list,
-		"count":   len(list),
+		"sets":  sets,
+		"count": len(sets),
 	})
 }
 
 type jobSensorsRequest struct {
-	Sensors []string `json:"sensors"` // sensor names
+	Sensors sensorRefs `json:"sensors"` // имена, hash или ID из конфига
+}
+
+// sensorRefs — список датчиков в теле запроса: строки (имя, hash, ID) и числа вперемешку.
+// Числа сохраняются в исходной записи, поэтому 64-битные hash не теряют точность.
+type sensorRefs []string
+
+func (refs *sensorRefs) UnmarshalJSON(data []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	out := make(sensorRefs, 0, len(raw))
+	for _, item := range raw {
+		var name string
+		if err := json.Unmarshal(item, &name); err == nil {
+			out = append(out, name)
+			continue
+		}
+		var num json.Number
+		if err := json.Unmarshal(item, &num); err != nil {
+			return fmt.Errorf("sensors: want name or numeric hash, got %s", item)
+		}
+		out = append(out, num.String())
+	}
+	*refs = out
+	return nil
 }
 
 // handleJobSensors управляет текущим рабочим списком датчиков.
@@ -337,7 +587,7 @@ func (s *Server) handleJobSensors(w http.ResponseWriter, r *http.Request) {
 		}
 		accepted, rejected, err := s.manager.SetWorkingSensorsByNames(req.Sensors)
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err)
+			writeError(w, http.StatusBadRequest, withCode(err, codeUnknownSensors, map[string]any{"rejected": rejected}))
 			return
 		}
 		working := s.manager.WorkingSensorNames()
@@ -355,44 +605,256 @@ func (s *Server) handleJobSensors(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+type jobSensorsGroupRequest struct {
+	Groups []string `json:"groups"`
+}
+
+// handleJobSensorsGroup устанавливает рабочий список из всех датчиков указанных групп.
+func (s *Server) handleJobSensorsGroup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireController(w, r); !ok {
+		return
+	}
+	var req jobSensorsGroupRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.Groups) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no groups provided"))
+		return
+	}
+	accepted, rejected, err := s.manager.SetWorkingSensorsByGroups(req.Groups)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, withCode(err, codeUnknownSensors, map[string]any{"rejected_groups": rejected}))
+		return
+	}
+	working := s.manager.WorkingSensorNames()
+	all := s.manager.Sensors()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":          "ok",
+		"sensors":         working,
+		"accepted_count":  accepted,
+		"rejected_groups": rejected,
+		"count":           len(working),
+		"default":         len(working) == len(all),
+	})
+}
+
+type jobSensorsIDsRequest struct {
+	IDs []int64 `json:"ids"`
+}
+
+// handleJobSensorsIDs устанавливает рабочий список по ID датчиков из конфига.
+func (s *Server) handleJobSensorsIDs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireController(w, r); !ok {
+		return
+	}
+	var req jobSensorsIDsRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no ids provided"))
+		return
+	}
+	accepted, rejected, err := s.manager.SetWorkingSensorsByConfigIDs(req.IDs)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, withCode(err, codeUnknownSensors, map[string]any{"rejected_ids": rejected}))
+		return
+	}
+	working := s.manager.WorkingSensorNames()
+	all := s.manager.Sensors()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":         "ok",
+		"sensors":        working,
+		"accepted_count": accepted,
+		"rejected_ids":   rejected,
+		"count":          len(working),
+		"default":        len(working) == len(all),
+	})
+}
+
+type jobSensorsIOTypeRequest struct {
+	IOTypes []string `json:"iotypes"`
+}
+
+// handleJobSensorsIOType сужает рабочий список до датчиков с указанными iotype.
+func (s *Server) handleJobSensorsIOType(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireController(w, r); !ok {
+		return
+	}
+	var req jobSensorsIOTypeRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.IOTypes) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no iotypes provided"))
+		return
+	}
+	accepted, rejected, err := s.manager.SetWorkingSensorsByIOTypes(req.IOTypes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, withCode(err, codeUnknownSensors, map[string]any{"rejected_iotypes": rejected}))
+		return
+	}
+	working := s.manager.WorkingSensorNames()
+	all := s.manager.Sensors()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":           "ok",
+		"sensors":          working,
+		"accepted_count":   accepted,
+		"rejected_iotypes": rejected,
+		"count":            len(working),
+		"default":          len(working) == len(all),
+	})
+}
+
+// Ограничения списка датчиков в POST /api/v2/job/sensors/upload (размер тела — Limits.MaxBodyBytes).
+const (
+	maxSensorListLines    = 100000
+	maxSensorListLineSize = 1024
+)
+
+// handleJobSensorsUpload устанавливает рабочий список из текстового файла: по одному имени
+// (или hash/ID) в строке, пустые строки и комментарии после # пропускаются.
+func (s *Server) handleJobSensorsUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireController(w, r); !ok {
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(strings.ToLower(ct), "text/plain") {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type %q: want text/plain", ct))
+		return
+	}
+	names, err := readSensorList(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(names) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no sensors provided"))
+		return
+	}
+	accepted, rejected, err := s.manager.SetWorkingSensorsByNames(names)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, withCode(err, codeUnknownSensors, map[string]any{"rejected": rejected}))
+		return
+	}
+	working := s.manager.WorkingSensorNames()
+	all := s.manager.Sensors()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":         "ok",
+		"sensors":        working,
+		"accepted_count": accepted,
+		"rejected":       rejected,
+		"count":          len(working),
+		"default":        len(working) == len(all),
+	})
+}
+
+// readSensorList разбирает текстовый список датчиков: одна запись в строке, # — начало комментария.
+// Больше maxSensorListLines строк или строка длиннее maxSensorListLineSize — ошибка с кодом too_large.
+func readSensorList(body io.ReadCloser) ([]string, error) {
+	defer body.Close()
+	lineTooLong := func(n int) error {
+		return withCode(fmt.Errorf("sensor list line %d too long: limit %d bytes", n, maxSensorListLineSize),
+			codeTooLarge, map[string]any{"limit_line_bytes": maxSensorListLineSize})
+	}
+	sc := bufio.NewScanner(body)
+	var names []string
+	lines := 0
+	for sc.Scan() {
+		lines++
+		if lines > maxSensorListLines {
+			return nil, withCode(fmt.Errorf("sensor list too long: more than %d lines", maxSensorListLines),
+				codeTooLarge, map[string]any{"limit_lines": maxSensorListLines})
+		}
+		if len(sc.Bytes()) > maxSensorListLineSize {
+			return nil, lineTooLong(lines)
+		}
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			names = append(names, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			return nil, fmt.Errorf("request body too large: limit %d bytes: %w", tooLarge.Limit, err)
+		case errors.Is(err, bufio.ErrTooLong):
+			return nil, lineTooLong(lines + 1)
+		}
+		return nil, err
+	}
+	return names, nil
+}
+
 // handleSetRange сохраняет параметры диапазона без старта задачи.
 func (s *Server) handleSetRange(w http.ResponseWriter, r *http.Request) {
 	mode := s.unknownModeNormalized()
 	switch r.Method {
 	case http.MethodGet:
+		sensors, ok := s.sensorsOverride(w, splitQueryList(r.URL.Query()["sensors"]), http.StatusUnprocessableEntity)
+		if !ok {
+			return
+		}
 		var (
 			min, max       time.Time
 			count, unknown int64
 			err            error
 		)
-		if mode == "off" {
+		storageStart := time.Now()
+		switch {
+		case sensors != nil:
+			min, max, count, unknown, err = s.manager.RangeWithUnknownBounds(r.Context(), time.Time{}, time.Time{}, sensors)
+			if mode == "off" {
+				unknown = 0
+			}
+		case mode == "off":
 			min, max, count, err = s.manager.Range(r.Context())
-		} else {
+		default:
 			min, max, count, unknown, err = s.manager.RangeWithUnknown(r.Context())
 		}
+		storageMs := time.Since(storageStart).Milliseconds()
 		if err != nil {
-			writeError(w, http.StatusBadRequest, err)
+			writeRangeError(w, err)
 			return
 		}
 		if mode == "strict" && unknown > 0 {
-			writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("range contains %d sensors missing in config (strict mode)", unknown))
+			writeError(w, http.StatusUnprocessableEntity, withCode(fmt.Errorf("range contains %d sensors missing in config (strict mode)", unknown), codeUnknownSensors, map[string]any{"unknown_count": unknown}))
 			return
 		}
-		resp := map[string]string{
-			"from": "",
-			"to":   "",
-		}
+		// Пустое окно для известных датчиков — не ошибка: границы null, sensor_count=0.
+		var from, to any
 		if !min.IsZero() {
-			resp["from"] = min.Format(time.RFC3339)
+			from = min.Format(time.RFC3339)
 		}
 		if !max.IsZero() {
-			resp["to"] = max.Format(time.RFC3339)
+			to = max.Format(time.RFC3339)
 		}
 		respMap := map[string]any{
-			"from":          resp["from"],
-			"to":            resp["to"],
+			"from":          from,
+			"to":            to,
 			"sensor_count":  count,
 			"unknown_count": unknown,
+			"storage_ms":    storageMs,
 		}
 		writeJSON(w, http.StatusOK, respMap)
 	case http.MethodPost:
@@ -404,14 +866,9 @@ func (s *Server) handleSetRange(w http.ResponseWriter, r *http.Request) {
 			writeError(w, http.StatusBadRequest, err)
 			return
 		}
-		from, err := time.Parse(time.RFC3339, req.From)
+		from, to, err := req.period()
 		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid from: %w", err))
-			return
-		}
-		to, err := time.Parse(time.RFC3339, req.To)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid to: %w", err))
+			writeError(w, http.StatusBadRequest, err)
 			return
 		}
 		step, err := time.ParseDuration(req.Step)
@@ -430,23 +887,35 @@ func (s *Server) handleSetRange(w http.ResponseWriter, r *http.Request) {
 		if req.Speed <= 0 {
 			req.Speed = 1
 		}
+		if err := s.manager.CheckOutput(req.Output); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if _, err := replay.ParseStepAggregate(req.StepAggregate); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := replay.ValidateStep(from, to, step); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
 		logDebugf("[http] set range v2 from=%s to=%s step=%s speed=%f window=%s save=%v", from.Format(time.RFC3339), to.Format(time.RFC3339), step, req.Speed, window, req.SaveOutput)
 		unknown := int64(0)
 		if mode != "off" {
-			_, _, _, unknown, err = s.manager.RangeWithUnknownBounds(r.Context(), from, to)
+			_, _, _, unknown, err = s.manager.RangeWithUnknownBounds(r.Context(), from, to, nil)
 			if err != nil {
-				writeError(w, http.StatusBadRequest, err)
+				writeRangeError(w, err)
 				return
 			}
 			if mode == "strict" && unknown > 0 {
-				writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("range contains %d sensors missing in config (strict mode)", unknown))
+				writeError(w, http.StatusUnprocessableEntity, withCode(fmt.Errorf("range contains %d sensors missing in config (strict mode)", unknown), codeUnknownSensors, map[string]any{"unknown_count": unknown}))
 				return
 			}
 			if unknown > 0 {
 				log.Printf("[http] set range: found %d unknown sensors (mode=%s)", unknown, mode)
 			}
 		}
-		s.manager.SetRange(from, to, step, req.Speed, window, req.SaveOutput)
+		s.manager.SetRange(from, to, step, req.Speed, window, req.SaveOutput, req.startOptions()...)
 		resp := map[string]any{"status": "ok"}
 		if mode != "off" {
 			resp["unknown_count"] = unknown
@@ -457,6 +926,31 @@ func (s *Server) handleSetRange(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// startErrorStatus выбирает HTTP-статус ошибки старта задачи: активная задача — 409,
+// отказ строгого режима — 422, остальное — 400.
+func startErrorStatus(err error) int {
+	var strict *strictStartError
+	switch {
+	case errors.Is(err, errJobActive):
+		return http.StatusConflict
+	case errors.As(err, &strict):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errRangeStorage):
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// writeRangeError отвечает на ошибку запроса диапазона: пустой список датчиков — 422,
+// сбой хранилища (нет таблицы, нет соединения) — 500.
+func writeRangeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoValidSensors) {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
 // handleSetSeek сохраняет отложенный seek.
 func (s *Server) handleSetSeek(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -476,22 +970,51 @@ func (s *Server) handleSetSeek(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ts: %w", err))
 		return
 	}
-	logDebugf("[http] set seek v2 ts=%s apply=%t", ts.Format(time.RFC3339), req.Apply)
-	if err := s.manager.Seek(ts, req.Apply); err != nil {
-		if err.Error() == "no active job" || err.Error() == "job is already finished" {
-			log.Printf("[http] set pending seek ts=%s (pending: %v)", ts.Format(time.RFC3339), err)
-			s.manager.SetPendingSeek(ts)
+	logDebugf("[http] set seek v2 ts=%s apply=%t preview=%t", ts.Format(time.RFC3339), req.Apply, req.Preview)
+	if req.Preview && req.Apply {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("preview cannot be combined with apply"))
+		return
+	}
+	var snap SnapshotValues
+	if req.Preview {
+		snap, err = s.manager.SeekPreview(ts)
+	} else {
+		err = s.manager.Seek(ts, req.Apply)
+	}
+	status := "paused"
+	if err != nil {
+		if !errors.Is(err, errNoActiveJob) && !errors.Is(err, errJobFinished) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Printf("[http] set pending seek ts=%s (pending: %v)", ts.Format(time.RFC3339), err)
+		s.manager.SetPendingSeek(ts)
+		if !req.Preview {
 			writeJSON(w, http.StatusOK, map[string]string{"status": "pending"})
 			return
 		}
-		writeError(w, http.StatusBadRequest, err)
+		// Задачи нет — считаем состояние напрямую по истории.
+		snaps, err := s.manager.SnapshotBatch(r.Context(), []time.Time{ts}, nil, 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		snap, status = snaps[0], "pending"
+	}
+	if !req.Preview {
+		writeJSON(w, http.StatusOK, map[string]string{"status": status})
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "paused"})
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status": status,
+		"ts":     snap.TS.UTC().Format(time.RFC3339Nano),
+		"values": snap.Values,
+	})
 }
 
-// handleResume возобновляет задачу, опционально меняя флаг сохранения в SM.
-func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+// handlePlaylist запускает плейлист: сегменты проигрываются друг за другом с warmup перед каждым.
+// Остальные поля — как у диапазона задачи; from/to/for не задаются, их заменяют сегменты.
+func (s *Server) handlePlaylist(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -499,24 +1022,58 @@ func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
 	if _, ok := s.requireController(w, r); !ok {
 		return
 	}
-	var req resumeRequest
-	_ = decodeJSON(r, &req) // тело может быть пустым
-	logDebugf("[http] command resume save_output=%v", req.SaveOutput)
-	if req.SaveOutput != nil {
-		if err := s.manager.SetSaveOutput(*req.SaveOutput); err != nil {
-			writeError(w, http.StatusBadRequest, err)
+	var req playlistRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.From != "" || req.To != "" || req.For != "" {
+		writeError(w, http.StatusBadRequest, withCode(fmt.Errorf("playlist period is set by segments, not from/to/for"), codeValidation, nil))
+		return
+	}
+	segments := make([]replay.Segment, 0, len(req.Segments))
+	for i, seg := range req.Segments {
+		from, err := time.Parse(time.RFC3339, seg.From)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, withCode(fmt.Errorf("segment %d: invalid from: %w", i, err), codeValidation, map[string]any{"segment": i}))
+			return
+		}
+		to, err := time.Parse(time.RFC3339, seg.To)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, withCode(fmt.Errorf("segment %d: invalid to: %w", i, err), codeValidation, map[string]any{"segment": i}))
 			return
 		}
+		segments = append(segments, replay.Segment{From: from, To: to})
 	}
-	if err := s.manager.Resume(); err != nil {
-		writeError(w, http.StatusBadRequest, err)
+	if err := replay.ValidateSegments(segments); err != nil {
+		writeError(w, http.StatusBadRequest, withCode(err, codeValidation, nil))
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	step, err := time.ParseDuration(req.Step)
+	if err != nil || step <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid step: %v", err))
+		return
+	}
+	var window time.Duration
+	if req.Window != "" {
+		window, err = time.ParseDuration(req.Window)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid window: %v", err))
+			return
+		}
+	}
+	from, to := segments[0].From, segments[len(segments)-1].To
+	logDebugf("[http] playlist start segments=%d from=%s to=%s step=%s speed=%f", len(segments), from.Format(time.RFC3339), to.Format(time.RFC3339), step, req.Speed)
+	opts := append(req.startOptions(), WithSegments(segments))
+	if err := s.manager.Start(r.Context(), from, to, step, req.Speed, window, req.SaveOutput, opts...); err != nil {
+		writeError(w, startErrorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "running", "segment_count": len(segments)})
 }
 
-// handleStartPending запускает задачу из отложенного диапазона.
-func (s *Server) handleStartPending(w http.ResponseWriter, r *http.Request) {
+// handleJobSegment переходит к сегменту плейлиста по номеру (с 0); задача встаёт на паузу в его начале.
+func (s *Server) handleJobSegment(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -524,19 +1081,325 @@ func (s *Server) handleStartPending(w http.ResponseWriter, r *http.Request) {
 	if _, ok := s.requireController(w, r); !ok {
 		return
 	}
-	if err := s.manager.StartPending(r.Context()); err != nil {
-		code := http.StatusBadRequest
-		if err.Error() == "job is already active" {
-			code = http.StatusConflict
-		}
-		writeError(w, code, err)
-		return
+	var req segmentRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.manager.JumpSegment(req.Index); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errNoActiveJob) || errors.Is(err, errJobFinished) {
+			status = http.StatusConflict
+		}
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"status": "paused", "segment_index": req.Index})
+}
+
+// handleOpenAPI отдаёт спецификацию OpenAPI для API v2.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openAPISpec)
+}
+
+// handleConfig возвращает параметры запуска сервера (без секретов).
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	db := s.runtime.DB
+	if db == "" {
+		db = "memstore"
+	}
+	output := s.runtime.Output
+	if output == "" {
+		output = "stdout"
+	}
+	writeJSON(w, http.StatusOK, configResponse{
+		Version:     s.runtime.Version,
+		DB:          storage.RedactDSN(db),
+		Table:       s.runtime.Table,
+		HashMode:    s.runtime.HashMode,
+		Output:      storage.RedactDSN(output),
+		SMSupplier:  s.runtime.SMSupplier,
+		UnknownMode: s.unknownModeNormalized(),
+		Defaults:    s.manager.RuntimeDefaults(),
+	})
+}
+
+// handleVersion возвращает версию и сведения о сборке сервера.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, versionResponse{
+		Version:   s.runtime.Version,
+		GoVersion: runtime.Version(),
+		BuildTime: s.runtime.BuildTime,
+		GitCommit: s.runtime.GitCommit,
+	})
+}
+
+// readyTimeout ограничивает время проверок в /readyz.
+const readyTimeout = 5 * time.Second
+
+// handleReadyz проверяет хранилище и SM; при недоступности возвращает 503 с деталями.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	res := s.manager.Readiness(ctx)
+	code := http.StatusOK
+	if res.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, res)
+}
+
+// handleMetrics отдаёт счётчики текущей задачи в текстовом формате Prometheus.
+// Счётчики относятся к последнему запуску и обнуляются при старте новой задачи.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	st := s.manager.Status()
+	var b strings.Builder
+	metric := func(name, typ, help string, samples ...string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, sample := range samples {
+			fmt.Fprintf(&b, "%s%s\n", name, sample)
+		}
+	}
+	metric("timemachine_cache_hits_total", "counter", "State cache hits on seek and step backward: exact (state reused as is), le (earlier state fast-forwarded).",
+		fmt.Sprintf(`{kind="exact"} %d`, st.Cache.ExactHits),
+		fmt.Sprintf(`{kind="le"} %d`, st.Cache.LEHits))
+	metric("timemachine_cache_rebuilds_total", "counter", "State cache misses: state rebuilt from storage history.",
+		fmt.Sprintf(" %d", st.Cache.Rebuilds))
+	metric("timemachine_cache_entries", "gauge", "State cache entries in use.",
+		fmt.Sprintf(" %d", st.Cache.Entries))
+	metric("timemachine_cache_limit", "gauge", "State cache capacity.",
+		fmt.Sprintf(" %d", st.Cache.Limit))
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = io.WriteString(w, b.String())
+}
+
+// handlePreflight проверяет хранилище, данные в диапазоне и SM без отправки значений.
+// Диапазон — from/to (RFC3339) в query или pending-диапазон; при неудачной проверке — 503 с деталями.
+func (s *Server) handlePreflight(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	var from, to time.Time
+	for name, dst := range map[string]*time.Time{"from": &from, "to": &to} {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", name, err))
+			return
+		}
+		*dst = ts
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), preflightTimeout)
+	defer cancel()
+	res := s.manager.Preflight(ctx, from, to)
+	code := http.StatusOK
+	if res.Status != "ok" {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, res)
+}
+
+// preflightTimeout ограничивает время проверок /api/v2/preflight (запрос диапазона может быть долгим).
+const preflightTimeout = 30 * time.Second
+
+// handleSeekStep выполняет seek по номеру шага (или сохраняет его как отложенный seek).
+func (s *Server) handleSeekStep(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireController(w, r); !ok {
+		return
+	}
+	var req seekStepRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	logDebugf("[http] seek step=%d apply=%t", req.StepID, req.Apply)
+	ts, err := s.manager.SeekStep(req.StepID, req.Apply)
+	s.seekOrPending(w, fmt.Sprintf("step=%d", req.StepID), ts, err)
+}
+
+// handleSeekPercent выполняет seek к проценту диапазона (или сохраняет его как отложенный seek).
+func (s *Server) handleSeekPercent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireController(w, r); !ok {
+		return
+	}
+	var req seekPercentRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.Percent == nil {
+		writeError(w, http.StatusBadRequest, withCode(fmt.Errorf("percent is required"), codeValidation, map[string]any{"field": "percent"}))
+		return
+	}
+	logDebugf("[http] seek percent=%g apply=%t", *req.Percent, req.Apply)
+	ts, err := s.manager.SeekPercent(*req.Percent, req.Apply)
+	s.seekOrPending(w, fmt.Sprintf("percent=%g", *req.Percent), ts, err)
+}
+
+// seekOrPending отвечает на seek к ts с результатом err: без активной задачи (или после
+// её завершения) запоминает pending seek, остальные ошибки — 400.
+func (s *Server) seekOrPending(w http.ResponseWriter, what string, ts time.Time, err error) {
+	if err != nil {
+		if !ts.IsZero() && (errors.Is(err, errNoActiveJob) || errors.Is(err, errJobFinished)) {
+			log.Printf("[http] set pending seek %s ts=%s (pending: %v)", what, ts.Format(time.RFC3339), err)
+			s.manager.SetPendingSeek(ts)
+			writeJSON(w, http.StatusOK, map[string]string{"status": "pending", "ts": ts.Format(time.RFC3339Nano)})
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "paused", "ts": ts.Format(time.RFC3339Nano)})
+}
+
+// handleResume возобновляет задачу, опционально меняя флаг сохранения в SM.
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireController(w, r); !ok {
+		return
+	}
+	var req resumeRequest
+	_ = decodeJSON(r, &req) // тело может быть пустым
+	logDebugf("[http] command resume save_output=%v", req.SaveOutput)
+	if req.SaveOutput != nil {
+		if err := s.manager.SetSaveOutput(*req.SaveOutput); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if err := s.manager.Resume(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// startPendingRequest — необязательное тело /api/v2/job/start.
+type startPendingRequest struct {
+	Paused bool   `json:"paused"`
+	Output string `json:"output,omitempty"` // переопределяет output из /api/v2/job/range
+}
+
+// handleStartPending запускает задачу из отложенного диапазона; с {"paused":true}
+// задача после warmup встаёт на паузу, не выполняя первый шаг. output выбирает клиент вывода.
+func (s *Server) handleStartPending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireController(w, r); !ok {
+		return
+	}
+	var req startPendingRequest
+	if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	opts := []StartOption{WithStartPaused(req.Paused)}
+	if req.Output != "" {
+		opts = append(opts, WithOutput(req.Output))
+	}
+	if err := s.manager.StartPending(r.Context(), opts...); err != nil {
+		writeError(w, startErrorStatus(err), err)
+		return
+	}
+	logDebugf("[http] start pending paused=%t output=%q", req.Paused, req.Output)
+	status := "running"
+	if req.Paused {
+		status = "paused"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": status})
+}
+
+// handleContinue продолжает остановленную/завершённую задачу с последней позиции.
+func (s *Server) handleContinue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := s.requireController(w, r); !ok {
+		return
 	}
-	logDebugf("[http] start pending")
+	if err := s.manager.Continue(r.Context()); err != nil {
+		writeError(w, startErrorStatus(err), err)
+		return
+	}
+	logDebugf("[http] continue")
 	writeJSON(w, http.StatusOK, map[string]string{"status": "running"})
 }
 
-func (s *Server) handleStepBackward(w http.ResponseWriter, r *http.Request) {
+// handleStep сдвигает позицию на count шагов вперёд или назад; тело {"count":10,"apply":true}
+// необязательно (по умолчанию один шаг).
+func (s *Server) handleStep(forward bool) http.HandlerFunc {
+	label := "step_backward"
+	if forward {
+		label = "step_forward"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if _, ok := s.requireController(w, r); !ok {
+			return
+		}
+		var req stepRequest
+		if err := decodeJSON(r, &req); err != nil && !errors.Is(err, io.EOF) {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Count < 0 {
+			writeError(w, http.StatusBadRequest, withCode(fmt.Errorf("count must be >= 1"), codeValidation, map[string]any{"count": req.Count}))
+			return
+		}
+		logDebugf("[http] command %s count=%d apply=%t", label, req.Count, req.Apply)
+		step, status := s.manager.StepBackward, "paused"
+		if forward {
+			step, status = s.manager.StepForward, "ok"
+		}
+		if err := step(req.Count, req.Apply); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": status})
+	}
+}
+
+// handlePlayUntil проигрывает до заданного момента и ставит задачу на паузу.
+func (s *Server) handlePlayUntil(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
@@ -544,17 +1407,22 @@ func (s *Server) handleStepBackward(w http.ResponseWriter, r *http.Request) {
 	if _, ok := s.requireController(w, r); !ok {
 		return
 	}
-	var req applyRequest
+	var req playUntilRequest
 	if err := decodeJSON(r, &req); err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	logDebugf("[http] command step_backward apply=%t", req.Apply)
-	if err := s.manager.StepBackward(req.Apply); err != nil {
+	ts, err := time.Parse(time.RFC3339, req.TS)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ts: %w", err))
+		return
+	}
+	logDebugf("[http] command play-until ts=%s", ts.Format(time.RFC3339))
+	if err := s.manager.PlayUntil(r.Context(), ts); err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "paused"})
+	writeJSON(w, http.StatusOK, map[string]string{"status": "running", "pause_at": ts.Format(time.RFC3339Nano)})
 }
 
 func (s *Server) handleSeek(w http.ResponseWriter, r *http.Request) {
@@ -583,6 +1451,43 @@ func (s *Server) handleSeek(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "paused"})
 }
 
+// handleSnapshotBatch возвращает состояния на несколько моментов времени (метки по возрастанию).
+func (s *Server) handleSnapshotBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req snapshotBatchRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	timestamps := make([]time.Time, 0, len(req.Timestamps))
+	for _, raw := range req.Timestamps {
+		ts, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ts %q: %w", raw, err))
+			return
+		}
+		timestamps = append(timestamps, ts)
+	}
+	maxStale, err := parseMaxStaleness(req.MaxStaleness)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	sensors, ok := s.sensorsOverride(w, req.Sensors, http.StatusBadRequest)
+	if !ok {
+		return
+	}
+	snaps, err := s.manager.SnapshotBatch(r.Context(), timestamps, sensors, maxStale)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, snaps)
+}
+
 func (s *Server) handleState(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -596,6 +1501,7 @@ func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
+	start := time.Now()
 	var req snapshotRequest
 	if err := decodeJSON(r, &req); err != nil {
 		writeError(w, http.StatusBadRequest, err)
@@ -606,24 +1512,113 @@ func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ts: %w", err))
 		return
 	}
-	start := time.Now()
-	if _, err := s.manager.Snapshot(r.Context(), ts); err != nil {
+	maxStale, err := parseMaxStaleness(req.MaxStaleness)
+	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]interface{}{
-		"ts":          ts.Format(time.RFC3339),
-		"duration_ms": time.Since(start).Milliseconds(),
-		"status":      "ok",
-	})
+	sensors, ok := s.sensorsOverride(w, req.Sensors, http.StatusBadRequest)
+	if !ok {
+		return
+	}
+	storageStart := time.Now()
+	snap, err := s.manager.Snapshot(r.Context(), ts, sensors, maxStale)
+	storageMs := time.Since(storageStart).Milliseconds()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	resp := map[string]interface{}{
+		"ts":         ts.Format(time.RFC3339),
+		"storage_ms": storageMs,
+		"status":     "ok",
+	}
+	if maxStale > 0 {
+		stale := s.manager.StaleNames(snap.Stale)
+		if stale == nil {
+			stale = []string{}
+		}
+		resp["stale"] = stale
+		resp["stale_count"] = len(stale)
+	}
+	// duration_ms — полное время обработки, storage_ms — только расчёт по хранилищу.
+	resp["duration_ms"] = time.Since(start).Milliseconds()
+	writeJSON(w, http.StatusOK, resp)
 }
 
+// handleWSState подключает клиента к потоку состояния. ?sensors=a,b задаёт фильтр только для
+// этого клиента (например, наблюдателя без управления): рабочий набор задачи не меняется.
 func (s *Server) handleWSState(w http.ResponseWriter, r *http.Request) {
 	if s.streamer == nil {
-		http.Error(w, "websocket streamer not configured", http.StatusServiceUnavailable)
+		writeError(w, http.StatusServiceUnavailable, errors.New("websocket streamer not configured"))
+		return
+	}
+	view, ok := s.sensorsOverride(w, splitQueryList(r.URL.Query()["sensors"]), http.StatusBadRequest)
+	if !ok {
+		return
+	}
+	s.streamer.ServeWSView(w, r, view)
+}
+
+// handleLogs возвращает последние строки лога сервера: ?tail=N (по умолчанию 200, 0 — весь буфер).
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.logs == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("log buffer not configured"))
+		return
+	}
+	tail, err := parseLogTail(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	lines := s.logs.Tail(tail)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"lines": lines,
+		"count": len(lines),
+	})
+}
+
+// handleWSLogs передаёт строки лога через WebSocket по мере записи.
+func (s *Server) handleWSLogs(w http.ResponseWriter, r *http.Request) {
+	if s.logs == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("log buffer not configured"))
 		return
 	}
-	s.streamer.ServeWS(w, r)
+	s.logs.ServeWS(w, r)
+}
+
+// sensorsOverride разбирает необязательный список датчиков запроса (имена, хеши или ID из конфига).
+// nil — использовать рабочий список. Если ни один датчик не распознан, отвечает 400 и возвращает false.
+func (s *Server) sensorsOverride(w http.ResponseWriter, items []string, status int) ([]int64, bool) {
+	if len(items) == 0 {
+		return nil, true
+	}
+	hashes, rejected, err := s.manager.ResolveSensors(items)
+	if err != nil {
+		writeError(w, status, withCode(fmt.Errorf("%w: %s", err, strings.Join(rejected, ",")), codeUnknownSensors, map[string]any{"rejected": rejected}))
+		return nil, false
+	}
+	if len(rejected) > 0 {
+		logDebugf("[http] ignoring unknown sensors in request: %v", rejected)
+	}
+	return hashes, true
+}
+
+// splitQueryList объединяет повторяющийся параметр и значения через запятую: ?sensors=a,b&sensors=c.
+func splitQueryList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+	}
+	return out
 }
 
 // handleSensorCount возвращает количество уникальных датчиков в указанном диапазоне.
@@ -649,7 +1644,13 @@ func (s *Server) handleSensorCount(w http.ResponseWriter, r *http.Request) {
 		}
 		to = t
 	}
-	count, err := s.manager.SensorsCount(r.Context(), from, to)
+	sensors, ok := s.sensorsOverride(w, splitQueryList(r.URL.Query()["sensors"]), http.StatusBadRequest)
+	if !ok {
+		return
+	}
+	storageStart := time.Now()
+	count, err := s.manager.SensorsCount(r.Context(), from, to, sensors)
+	storageMs := time.Since(storageStart).Milliseconds()
 	if err != nil {
 		writeError(w, http.StatusBadRequest, err)
 		return
@@ -657,9 +1658,55 @@ func (s *Server) handleSensorCount(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]any{
 		"sensor_count": count,
 		"count":        count, // совместимость
+		"storage_ms":   storageMs,
 	})
 }
 
+// handleSensorHistory отдаёт сырые записи одного датчика: ?from=...&to=...&limit=...&changes_only=1
+func (s *Server) handleSensorHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	from, err := time.Parse(time.RFC3339, q.Get("from"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid from: %w", err))
+		return
+	}
+	to, err := time.Parse(time.RFC3339, q.Get("to"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid to: %w", err))
+		return
+	}
+	if to.Before(from) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("to must not be before from"))
+		return
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %q", v))
+			return
+		}
+	}
+	changesOnly := q.Get("changes_only") == "1"
+	history, err := s.manager.SensorHistory(r.Context(), r.PathValue("sensor"), from, to, limit, changesOnly)
+	switch {
+	case errors.Is(err, errSensorNotFound):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, errNoEventHistory), errors.Is(err, errNoChangeHistory):
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, history)
+}
+
 func (s *Server) wrapSimple(fn func() error) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -720,11 +1767,23 @@ func (s *Server) wrapSimpleWithLog(label string, fn func() error) http.HandlerFu
 	}
 }
 
+// withVersion добавляет версию сервера в заголовок каждого ответа API, чтобы сверять сборку при выкатке
+// и в отчётах об ошибках; заголовок доступен и браузерным клиентам (Access-Control-Expose-Headers).
+func (s *Server) withVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.runtime.Version != "" {
+			w.Header().Set(versionHeader, s.runtime.Version)
+			w.Header().Set("Access-Control-Expose-Headers", versionHeader)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Server) withCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-TM-Session")
-		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET,POST,DELETE,OPTIONS")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusNoContent)
 			return
@@ -736,41 +1795,154 @@ func (s *Server) withCORS(next http.Handler) http.Handler {
 type startRequest struct {
 	From       string  `json:"from"`
 	To         string  `json:"to"`
+	For        string  `json:"for,omitempty"` // длительность вместо to (например "1h")
 	Step       string  `json:"step"`
 	Speed      float64 `json:"speed,omitempty"`
 	Window     string  `json:"window,omitempty"`
 	SaveOutput bool    `json:"save_output,omitempty"`
+	// BestEffort переопределяет --sm-best-effort для этой задачи.
+	BestEffort *bool `json:"best_effort,omitempty"`
+	// InclusiveEnd переопределяет --inclusive-end для этой задачи.
+	InclusiveEnd *bool `json:"inclusive_end,omitempty"`
+	// Output выбирает клиент вывода задачи: "sm" или "ui_only" (пусто — --output сервера).
+	Output string `json:"output,omitempty"`
+	// StepAggregate переопределяет --step-aggregate для этой задачи: last, avg, min или max.
+	StepAggregate string `json:"step_aggregate,omitempty"`
+	// NoPace переопределяет --no-pace для этой задачи: шаги без пауз, speed игнорируется.
+	NoPace *bool `json:"no_pace,omitempty"`
+}
+
+// startOptions переводит необязательные поля запроса в опции менеджера.
+func (req startRequest) startOptions() []StartOption {
+	var opts []StartOption
+	if req.BestEffort != nil {
+		opts = append(opts, WithBestEffort(*req.BestEffort))
+	}
+	if req.InclusiveEnd != nil {
+		opts = append(opts, WithInclusiveEnd(*req.InclusiveEnd))
+	}
+	if req.Output != "" {
+		opts = append(opts, WithOutput(req.Output))
+	}
+	if req.StepAggregate != "" {
+		opts = append(opts, WithStepAggregate(replay.StepAggregate(req.StepAggregate)))
+	}
+	if req.NoPace != nil {
+		opts = append(opts, WithNoPace(*req.NoPace))
+	}
+	return opts
+}
+
+// period возвращает границы диапазона: from и to либо from и for.
+func (req startRequest) period() (time.Time, time.Time, error) {
+	from, err := time.Parse(time.RFC3339, req.From)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %w", err)
+	}
+	if req.For != "" {
+		if req.To != "" {
+			return time.Time{}, time.Time{}, fmt.Errorf("specify either to or for, not both")
+		}
+		d, err := time.ParseDuration(req.For)
+		if err != nil || d <= 0 {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid for %q: want positive duration", req.For)
+		}
+		return from, from.Add(d), nil
+	}
+	to, err := time.Parse(time.RFC3339, req.To)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %w", err)
+	}
+	return from, to, nil
+}
+
+// playlistRequest — тело /api/v2/job/playlist: параметры задачи и сегменты вместо from/to.
+type playlistRequest struct {
+	startRequest
+	Segments []playlistSegment `json:"segments"`
+}
+
+type playlistSegment struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type segmentRequest struct {
+	Index int `json:"index"`
 }
 
 type applyRequest struct {
 	Apply bool `json:"apply"`
 }
 
+// stepRequest — тело step/forward и step/backward; count 0 равен одному шагу.
+type stepRequest struct {
+	Count int  `json:"count,omitempty"`
+	Apply bool `json:"apply"`
+}
+
 type resumeRequest struct {
 	SaveOutput *bool `json:"save_output,omitempty"`
 }
 
+type playUntilRequest struct {
+	TS string `json:"ts"`
+}
+
 type seekRequest struct {
-	TS    string `json:"ts"`
-	Apply bool   `json:"apply"`
+	TS      string `json:"ts"`
+	Apply   bool   `json:"apply"`
+	Preview bool   `json:"preview,omitempty"` // вернуть восстановленное состояние {ts, values}
+}
+
+type seekStepRequest struct {
+	StepID int64 `json:"step_id"`
+	Apply  bool  `json:"apply"`
+}
+
+type seekPercentRequest struct {
+	Percent *float64 `json:"percent"` // 0..100, вне диапазона ограничивается
+	Apply   bool     `json:"apply"`
 }
 
 type snapshotRequest struct {
-	TS string `json:"ts"`
+	TS           string   `json:"ts"`
+	Sensors      []string `json:"sensors,omitempty"`       // переопределение рабочего списка на этот запрос
+	MaxStaleness string   `json:"max_staleness,omitempty"` // длительность: значения старше ts-max_staleness считаются устаревшими
+}
+
+type snapshotBatchRequest struct {
+	Timestamps   []string `json:"timestamps"`
+	Sensors      []string `json:"sensors,omitempty"` // переопределение рабочего списка на этот запрос
+	MaxStaleness string   `json:"max_staleness,omitempty"`
 }
 
+// parseMaxStaleness разбирает необязательное окно актуальности значений (пусто — без ограничения).
+func parseMaxStaleness(raw string) (time.Duration, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid max_staleness %q: want positive duration", raw)
+	}
+	return d, nil
+}
+
+// decodeJSON разбирает тело запроса. Размер тела ограничен Limits.MaxBodyBytes (см. Server.handler):
+// превышение возвращается как *http.MaxBytesError, и writeError отвечает 413.
 func decodeJSON(r *http.Request, v interface{}) error {
 	defer r.Body.Close()
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
-	return dec.Decode(v)
-}
-
-func writeError(w http.ResponseWriter, code int, err error) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	log.Printf("[http] error %d: %v", code, err)
-	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return fmt.Errorf("request body too large: limit %d bytes: %w", tooLarge.Limit, err)
+		}
+		return err
+	}
+	return nil
 }
 
 func writeJSON(w http.ResponseWriter, code int, v interface{}) {
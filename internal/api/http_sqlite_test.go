@@ -37,7 +37,7 @@ func TestHTTPAPIWithSQLiteAutoSeed(t *testing.T) {
 		Storage: store,
 		Output:  output,
 	}
-	mgr := NewManager(svc, []int64{10001, 10002}, nil, 50, 2*time.Second, 16, nil, true, false, 0)
+	mgr := NewManager(svc, []int64{10001, 10002}, nil, nil, ManagerOptions{Speed: 50, Window: 2 * time.Second, BatchSize: 16, SaveAllowed: true})
 	srv := NewServer(mgr, nil, "")
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -77,6 +77,60 @@ func TestHTTPAPIWithSQLiteAutoSeed(t *testing.T) {
 	}
 }
 
+func TestHTTPSensorHistoryWithSQLite(t *testing.T) {
+	ctx := context.Background()
+	dbPath := filepath.Join(t.TempDir(), "api-history.db")
+	seedSQLiteIfEmpty(t, dbPath)
+
+	store, err := sqliteStore.New(ctx, sqliteStore.Config{Source: dbPath})
+	if err != nil {
+		t.Fatalf("sqlite.New: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	svc := replay.Service{Storage: store, Output: &captureClient{}}
+	mgr := NewManager(svc, []int64{10001, 10002}, nil, nil, ManagerOptions{Speed: 50, Window: 2 * time.Second, BatchSize: 16, SaveAllowed: true})
+	srv := NewServer(mgr, nil, "")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("skip: tcp listen not permitted: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(srv.mux)
+	ts.Listener = ln
+	ts.Start()
+	defer ts.Close()
+
+	query := "?from=2024-06-01T00:00:00Z&to=2024-06-01T00:00:05Z"
+	var history SensorHistory
+	getJSON(t, ts.URL+"/api/v2/sensors/10001/history"+query, &history)
+	if len(history.Points) != 2 || history.Truncated {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+	if history.Points[1].Value != 11 || !history.Points[1].TS.Equal(time.Date(2024, 6, 1, 0, 0, 1, 500000000, time.UTC)) {
+		t.Fatalf("second point mismatch: %+v", history.Points[1])
+	}
+
+	getJSON(t, ts.URL+"/api/v2/sensors/hash10001/history"+query+"&limit=1", &history)
+	if len(history.Points) != 1 || !history.Truncated {
+		t.Fatalf("expected truncated history by name, got %+v", history)
+	}
+
+	// Значения датчика различаются, поэтому фильтр изменений оставляет обе записи.
+	getJSON(t, ts.URL+"/api/v2/sensors/10001/history"+query+"&changes_only=1", &history)
+	if len(history.Points) != 2 || history.Points[1].Value != 11 {
+		t.Fatalf("unexpected changes-only history: %+v", history)
+	}
+
+	resp, err := http.Get(ts.URL + "/api/v2/sensors/777/history" + query)
+	if err != nil {
+		t.Fatalf("get unknown sensor history: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown sensor status = %d, want 404", resp.StatusCode)
+	}
+}
+
 func seedSQLiteIfEmpty(t *testing.T, path string) {
 	t.Helper()
 	db, err := sql.Open("sqlite", path)
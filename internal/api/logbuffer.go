@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/pv/uniset-timemachine-go/internal/storage"
+)
+
+// DefaultLogBufferSize — сколько последних строк лога хранит сервер по умолчанию.
+const DefaultLogBufferSize = 1000
+
+// defaultLogTail — число строк в ответе /api/v2/logs и при подключении к /api/v2/ws/logs без ?tail.
+const defaultLogTail = 200
+
+// LogLine — строка лога сервера. Seq растёт монотонно, по нему клиент находит пропуски.
+type LogLine struct {
+	Seq  int64  `json:"seq"`
+	Text string `json:"text"`
+}
+
+// logMessage — сообщение /api/v2/ws/logs.
+type logMessage struct {
+	Type string `json:"type"` // всегда "log"
+	LogLine
+}
+
+// LogBuffer — кольцевой буфер последних строк стандартного логгера для /api/v2/logs и /api/v2/ws/logs.
+// Подключается как io.Writer (log.SetOutput(io.MultiWriter(os.Stderr, buf))); пароли и токены
+// в строках скрываются до записи в буфер.
+type LogBuffer struct {
+	mu      sync.Mutex
+	lines   []LogLine // кольцо, lines[next] — самая старая строка после заполнения
+	next    int
+	seq     int64
+	partial []byte // хвост без перевода строки до следующего Write
+	clients map[*wsClient]struct{}
+}
+
+// NewLogBuffer создаёт буфер на size строк (<=0 — DefaultLogBufferSize).
+func NewLogBuffer(size int) *LogBuffer {
+	if size <= 0 {
+		size = DefaultLogBufferSize
+	}
+	return &LogBuffer{
+		lines:   make([]LogLine, 0, size),
+		clients: make(map[*wsClient]struct{}),
+	}
+}
+
+// Write реализует io.Writer: сохраняет каждую завершённую строку и рассылает её WS-клиентам.
+// Внутри не пишет в log, иначе логгер заблокируется на собственном мьютексе.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data := append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		b.addLocked(string(data[:i]))
+		data = data[i+1:]
+	}
+	b.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+func (b *LogBuffer) addLocked(text string) {
+	b.seq++
+	line := LogLine{Seq: b.seq, Text: storage.RedactText(text)}
+	if len(b.lines) < cap(b.lines) {
+		b.lines = append(b.lines, line)
+	} else {
+		b.lines[b.next] = line
+		b.next = (b.next + 1) % len(b.lines)
+	}
+	if len(b.clients) == 0 {
+		return
+	}
+	data, err := json.Marshal(logMessage{Type: "log", LogLine: line})
+	if err != nil {
+		return
+	}
+	for c := range b.clients {
+		select {
+		case c.send <- data:
+		default:
+			// Клиент не успевает читать — отключаем, как в StateStreamer.
+			delete(b.clients, c)
+			go c.close()
+		}
+	}
+}
+
+// Tail возвращает не более n последних строк от старых к новым (n <= 0 — все).
+func (b *LogBuffer) Tail(n int) []LogLine {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tailLocked(n)
+}
+
+func (b *LogBuffer) tailLocked(n int) []LogLine {
+	ordered := make([]LogLine, 0, len(b.lines))
+	ordered = append(ordered, b.lines[b.next:]...)
+	ordered = append(ordered, b.lines[:b.next]...)
+	if n > 0 && n < len(ordered) {
+		ordered = ordered[len(ordered)-n:]
+	}
+	return ordered
+}
+
+// ServeWS отдаёт последние ?tail строк (по умолчанию defaultLogTail), а затем новые строки
+// по мере записи: сообщения {type:"log", seq, text}.
+func (b *LogBuffer) ServeWS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	tail, err := parseLogTail(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, rw, err := websocketUpgrade(w, r, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	client := newWSClient(conn, rw)
+
+	// Хвост и регистрация под одним мьютексом: строки между ними не теряются и не дублируются.
+	b.mu.Lock()
+	backlog := b.tailLocked(tail)
+	b.clients[client] = struct{}{}
+	b.mu.Unlock()
+	for _, line := range backlog {
+		data, err := json.Marshal(logMessage{Type: "log", LogLine: line})
+		if err == nil {
+			err = client.writeMessage(data)
+		}
+		if err != nil {
+			b.removeClient(client)
+			return
+		}
+	}
+	go client.writePump(func() {
+		b.removeClient(client)
+	})
+}
+
+func (b *LogBuffer) removeClient(c *wsClient) {
+	b.mu.Lock()
+	delete(b.clients, c)
+	b.mu.Unlock()
+	c.close()
+}
+
+// parseLogTail разбирает ?tail=N (0 — весь буфер, пусто — defaultLogTail).
+func parseLogTail(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("tail")
+	if raw == "" {
+		return defaultLogTail, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return 0, withCode(fmt.Errorf("invalid tail: %q", raw), codeValidation, map[string]any{"tail": raw})
+	}
+	return n, nil
+}
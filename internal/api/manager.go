@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,11 +18,60 @@ import (
 	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
+const (
+	// defaultCommandTimeout — ожидание подтверждения команды циклом воспроизведения.
+	defaultCommandTimeout = 30 * time.Second
+	// seekTimeoutFactor увеличивает таймаут для seek/шага назад: они могут перестраивать состояние.
+	seekTimeoutFactor = 4
+	// slowCommandThreshold — после этого времени ожидания команда логируется как медленная.
+	slowCommandThreshold = 5 * time.Second
+)
+
+// Имена клиентов вывода для поля output задачи (/api/v2/job/range, /api/v2/job/start).
+const (
+	// OutputSM — клиент SharedMemory из --output; доступен, только если сохранение в SM разрешено.
+	OutputSM = "sm"
+	// OutputUIOnly — без отправки в SM: шаги транслируются только в WebSocket.
+	OutputUIOnly = "ui_only"
+)
+
 var (
 	errControlLocked   = errors.New("control is locked by another session")
 	errSessionRequired = errors.New("session token is required")
+	errSensorNotFound  = errors.New("sensor not found")
+	errNoEventHistory  = errors.New("storage does not support event history")
+	errNoChangeHistory = errors.New("storage does not support change-only history")
+	errPlayUntilRange  = errors.New("play-until target is out of range")
+	errNoStashedPos    = errors.New("no stashed position to continue from")
+	errUnknownOutput   = errors.New("unknown output")
+	errJobActive       = errors.New("job is already active")
+	errJobFinished     = errors.New("job is already finished")
+	errNoActiveJob     = errors.New("no active job")
+	errRangeNotSet     = errors.New("range is not set")
+	errNoValidSensors  = errors.New("no valid sensors")
+	errRangeStorage    = errors.New("storage range query failed")
+	errCommandTimeout  = errors.New("command timeout")
+	errPreviewNoState  = errors.New("seek preview: state is not available")
+	errNotPlaylist     = errors.New("job is not a playlist")
+	errStepOutOfRange  = errors.New("step_id is out of range")
+	errSeekInGap       = errors.New("seek target is between playlist segments")
 )
 
+// maxStrictListed — сколько датчиков перечисляется в ошибке строгого старта.
+const maxStrictListed = 50
+
+// strictStartError — отказ в старте задачи в строгом режиме (--unknown-sensors-mode strict):
+// часть рабочего списка не найдена в конфиге или не имеет данных в периоде.
+type strictStartError struct {
+	reason  string
+	sensors []string // имена датчиков, не больше maxStrictListed
+	count   int
+}
+
+func (e *strictStartError) Error() string {
+	return fmt.Sprintf("%d working sensors %s (strict mode): %s", e.count, e.reason, strings.Join(e.sensors, ", "))
+}
+
 // Manager отвечает за одну задачу воспроизведения и её управление.
 type Manager struct {
 	mu sync.Mutex
@@ -31,20 +83,45 @@ type Manager struct {
 	job            *job
 	jobCancel      context.CancelFunc
 	streamer       *StateStreamer
-	sensorInfo     map[int64]SensorInfo // hash → SensorInfo
+	sensorInfo     map[int64]SensorInfo        // hash → SensorInfo
+	registry       *config.SensorRegistry      // реестр датчиков конфига (nil — без конфига)
+	sets           []SetInfo                   // именованные наборы из конфига
+	outputs        map[string]sharedmem.Client // клиенты вывода по имени (OutputSM, OutputUIOnly)
 	pending        pendingState
 	// Управляющая сессия
 	controllerSession  string
 	controllerLastSeen time.Time
 	controlTimeout     time.Duration
+	commandTimeout     time.Duration
+	idleStop           bool // останавливать идущую задачу, когда reaper освобождает просроченного контроллера
+	// Тёплый старт: состояние датчиков на последнем шаге задачи, проигравшей период до конца.
+	warmStart bool
+	warm      *warmState
+}
+
+// warmState — конечное состояние завершённой задачи для тёплого старта следующей.
+type warmState struct {
+	ts      time.Time
+	sensors []int64 // отсортированный набор датчиков задачи
+	events  []storage.SensorEvent
 }
 
 type defaults struct {
 	speed       float64
 	window      time.Duration
+	seekWindow  time.Duration // окно потока при seek и шагах на паузе (0 — как window)
 	batchSize   int
 	saveOutput  bool
 	saveAllowed bool
+	bestEffort  bool // режим отправки в SM без остановки задачи по ошибке
+	inclusive   bool // шаг ровно в To включается в период
+	aggregate   replay.StepAggregate
+	noPace      bool                // шаги без пауз между ними (replay.Params.NoPace)
+	startDelay  time.Duration       // выдержка перед первым шагом (replay.Params.StartDelay)
+	strict      bool                // строгий старт: все рабочие датчики есть в реестре конфига
+	strictData  bool                // строгий старт: у каждого датчика есть данные до конца периода
+	primeSM     bool                // полный снимок в SM до первого шага (replay.Params.PrimeOnStart)
+	activeHours *replay.ActiveHours // суточное окно воспроизведения (replay.Params.ActiveHours)
 }
 
 type pendingState struct {
@@ -52,6 +129,7 @@ type pendingState struct {
 	rng      replay.Params
 	seekSet  bool
 	seekTs   time.Time
+	pauseAt  time.Time
 }
 
 type job struct {
@@ -62,8 +140,18 @@ type job struct {
 	stepID      int64
 	lastTs      time.Time
 	updatesSent int64
+	sendErrors  int64  // батчи, не принятые SM (режим best-effort)
+	lastSendErr string // последняя ошибка отправки в SM
+	violations  int64  // обновления за границами min/max из конфига
+	cache       replay.CacheStats
 	err         error
 	commands    chan replay.Command
+	autoPaused  bool  // цикл сам встал на паузу по play-until
+	warmStart   bool  // начальное состояние взято из предыдущей задачи, без Warmup из БД
+	skipped     int64 // пропущенные промежутки вне ActiveHours
+	skippedTime time.Duration
+	segment     int // текущий сегмент плейлиста (Params.Segments)
+	done        chan struct{}
 }
 
 type SessionStatus struct {
@@ -73,7 +161,9 @@ type SessionStatus struct {
 	ControllerSession string `json:"controller_session"`
 	ControllerAgeSec  int64  `json:"controller_age_sec"`
 	ControlTimeoutSec int64  `json:"control_timeout_sec"`
-	CanClaim          bool   `json:"can_claim"`
+	// KeepaliveIntervalSec — рекомендуемый период ping (/api/v2/session?ping=1), с запасом до таймаута.
+	KeepaliveIntervalSec int64 `json:"keepalive_interval_sec"`
+	CanClaim             bool  `json:"can_claim"`
 }
 
 // ControlStatus возвращает наличие контроллера и таймаут (секунды).
@@ -83,39 +173,287 @@ func (m *Manager) ControlStatus() (present bool, timeoutSec int) {
 	return m.controllerSession != "", int(m.controlTimeout.Seconds())
 }
 
+// ManagerOptions — параметры задач по умолчанию и таймауты Manager.
+type ManagerOptions struct {
+	// DefaultSet — селектор рабочего набора по умолчанию (имя набора из конфига, см. config.Resolve);
+	// пустая строка или "ALL" — весь словарь конфига. К этому же набору возвращает Reset.
+	DefaultSet string
+	Speed      float64
+	Window     time.Duration
+	BatchSize  int
+	// SaveAllowed разрешает отправку в SM; DefaultSave — отправлять ли по умолчанию.
+	SaveAllowed bool
+	DefaultSave bool
+	// ControlTimeout — простой контроллера, после которого управление освобождается (0 — без таймаута).
+	ControlTimeout time.Duration
+	// CommandTimeout — ожидание ответа цикла на команду (0 — defaultCommandTimeout).
+	CommandTimeout time.Duration
+}
+
 // NewManager создаёт менеджер с заданным сервисом и списком хешей датчиков.
 // sensors содержит hashes (cityhash64(name)).
-func NewManager(service replay.Service, sensors []int64, cfg *config.Config, speed float64, window time.Duration, batchSize int, streamer *StateStreamer, saveAllowed bool, defaultSave bool, controlTimeout time.Duration) *Manager {
+func NewManager(service replay.Service, sensors []int64, cfg *config.Config, streamer *StateStreamer, opts ManagerOptions) *Manager {
+	commandTimeout := opts.CommandTimeout
+	if commandTimeout <= 0 {
+		commandTimeout = defaultCommandTimeout
+	}
+	defaultSet := opts.DefaultSet
 	metaHashes := sensors
 	if cfg != nil && cfg.Registry != nil && cfg.Registry.Count() > 0 {
 		metaHashes = cfg.Registry.AllHashesSortedByName()
 		// По умолчанию рабочий список — полный словарь из конфига.
 		sensors = metaHashes
 	}
+	if cfg != nil && defaultSet != "" && !strings.EqualFold(defaultSet, "ALL") {
+		resolved, err := cfg.Resolve(defaultSet)
+		switch {
+		case err != nil:
+			log.Printf("[manager] default set %q: %v; using all sensors", defaultSet, err)
+		case len(resolved) == 0:
+			log.Printf("[manager] default set %q is empty; using all sensors", defaultSet)
+		default:
+			sensors = resolved
+		}
+	}
 	defaultSensors := append([]int64(nil), sensors...)
 	info := BuildSensorInfo(cfg, metaHashes)
+	var sets []SetInfo
+	for _, set := range cfg.NamedSets() {
+		item := SetInfo{Name: set.Name, Count: len(set.Members)}
+		if set.Err != nil {
+			item.Error = set.Err.Error()
+		}
+		sets = append(sets, item)
+	}
+	var registry *config.SensorRegistry
+	if cfg != nil {
+		registry = cfg.Registry
+	}
+	outputs := map[string]sharedmem.Client{OutputUIOnly: sharedmem.DiscardClient{}}
+	if opts.SaveAllowed && service.Output != nil {
+		outputs[OutputSM] = service.Output
+	}
 	m := &Manager{
 		service:        service,
 		sensors:        sensors,
 		defaultSensors: defaultSensors,
 		defaults: defaults{
-			speed:       speed,
-			window:      window,
-			batchSize:   batchSize,
-			saveAllowed: saveAllowed,
-			saveOutput:  saveAllowed && defaultSave,
+			speed:       opts.Speed,
+			window:      opts.Window,
+			batchSize:   opts.BatchSize,
+			saveAllowed: opts.SaveAllowed,
+			saveOutput:  opts.SaveAllowed && opts.DefaultSave,
+			aggregate:   replay.AggregateLast,
 		},
 		streamer:           streamer,
 		sensorInfo:         info,
-		controlTimeout:     controlTimeout,
+		registry:           registry,
+		sets:               sets,
+		outputs:            outputs,
+		controlTimeout:     opts.ControlTimeout,
+		commandTimeout:     commandTimeout,
 		controllerLastSeen: time.Time{},
 	}
 	if m.streamer != nil {
+		m.streamer.SetWorkingSensors(sensors)
 		m.streamer.Reset(info)
 	}
 	return m
 }
 
+// SetBestEffort задаёт режим отправки в SM по умолчанию для новых задач (см. replay.Params.BestEffort).
+func (m *Manager) SetBestEffort(on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults.bestEffort = on
+}
+
+// SetInclusiveEnd задаёт границу периода по умолчанию для новых задач: true — [From, To], false — [From, To)
+// (см. replay.Params.InclusiveEnd).
+func (m *Manager) SetInclusiveEnd(on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults.inclusive = on
+}
+
+// SetSeekWindow задаёт окно подкачки потока при seek и шагах на паузе для новых задач
+// (см. replay.Params.SeekWindow); 0 — то же окно, что и при воспроизведении.
+func (m *Manager) SetSeekWindow(window time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults.seekWindow = window
+}
+
+// SetStepAggregate задаёт агрегацию значений внутри шага по умолчанию для новых задач
+// (см. replay.Params.StepAggregate).
+func (m *Manager) SetStepAggregate(agg replay.StepAggregate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults.aggregate = agg
+}
+
+// SetNoPace включает по умолчанию воспроизведение без пауз между шагами (см. replay.Params.NoPace).
+func (m *Manager) SetNoPace(on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults.noPace = on
+}
+
+// StartOption уточняет параметры задачи при Start/SetRange.
+type StartOption func(*replay.Params)
+
+// WithBestEffort переопределяет режим отправки best-effort для одной задачи.
+func WithBestEffort(on bool) StartOption {
+	return func(p *replay.Params) { p.BestEffort = on }
+}
+
+// WithInclusiveEnd переопределяет включение шага ровно в To для одной задачи.
+func WithInclusiveEnd(on bool) StartOption {
+	return func(p *replay.Params) { p.InclusiveEnd = on }
+}
+
+// WithStepAggregate переопределяет агрегацию значений внутри шага для одной задачи.
+func WithStepAggregate(agg replay.StepAggregate) StartOption {
+	return func(p *replay.Params) { p.StepAggregate = agg }
+}
+
+// SetStartDelay задаёт выдержку перед первым шагом новых задач (см. replay.Params.StartDelay).
+func (m *Manager) SetStartDelay(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults.startDelay = d
+}
+
+// SetPrimeOnStart включает отправку полного начального снимка в SM до первого шага новых задач
+// (см. replay.Params.PrimeOnStart).
+func (m *Manager) SetPrimeOnStart(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults.primeSM = enabled
+}
+
+// SetStrictStart включает проверку рабочего списка перед стартом задачи: каждый датчик должен
+// быть в реестре конфига, а с requireData — иметь хотя бы одно значение до конца периода.
+// Иначе Start возвращает ошибку, а не проигрывает период без части датчиков.
+func (m *Manager) SetStrictStart(enabled, requireData bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults.strict = enabled
+	m.defaults.strictData = enabled && requireData
+}
+
+// checkStrictStart проверяет рабочий список в строгом режиме (см. SetStrictStart).
+func (m *Manager) checkStrictStart(ctx context.Context, to time.Time) error {
+	m.mu.Lock()
+	if !m.defaults.strict {
+		m.mu.Unlock()
+		return nil
+	}
+	requireData := m.defaults.strictData
+	sensors := append([]int64(nil), m.sensors...)
+	names := make(map[int64]string, len(sensors))
+	for _, hash := range sensors {
+		names[hash] = m.sensorInfo[hash].Name
+	}
+	m.mu.Unlock()
+
+	failed := func(reason string, missing []int64) error {
+		e := &strictStartError{reason: reason, count: len(missing)}
+		for _, hash := range missing[:min(len(missing), maxStrictListed)] {
+			e.sensors = append(e.sensors, names[hash])
+		}
+		return e
+	}
+	var unknown []int64
+	for _, hash := range sensors {
+		if _, ok := m.registry.ByHash(hash); !ok {
+			unknown = append(unknown, hash)
+		}
+	}
+	if len(unknown) > 0 {
+		return failed("missing in config", unknown)
+	}
+	if !requireData {
+		return nil
+	}
+	// Последнее значение до конца периода есть у каждого датчика, который хоть раз попадёт в шаги.
+	events, err := m.service.Storage.Warmup(ctx, sensors, to)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errRangeStorage, err)
+	}
+	seen := make(map[int64]struct{}, len(events))
+	for _, ev := range events {
+		seen[ev.SensorID] = struct{}{}
+	}
+	var noData []int64
+	for _, hash := range sensors {
+		if _, ok := seen[hash]; !ok {
+			noData = append(noData, hash)
+		}
+	}
+	if len(noData) > 0 {
+		return failed("have no data before the end of the period", noData)
+	}
+	return nil
+}
+
+// SetActiveHours ограничивает новые задачи суточным окном (nil — непрерывно, см. replay.Params.ActiveHours).
+func (m *Manager) SetActiveHours(a *replay.ActiveHours) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.defaults.activeHours = a
+}
+
+// WithNoPace переопределяет для одной задачи воспроизведение без пауз между шагами.
+func WithNoPace(on bool) StartOption {
+	return func(p *replay.Params) { p.NoPace = on }
+}
+
+// WithStartPaused запускает задачу сразу в паузе: после warmup она стоит на From и ждёт resume/seek/step.
+func WithStartPaused(on bool) StartOption {
+	return func(p *replay.Params) { p.StartPaused = on }
+}
+
+// WithSegments делает задачу плейлистом: периоды проигрываются по очереди (см. replay.Params.Segments).
+func WithSegments(segments []replay.Segment) StartOption {
+	return func(p *replay.Params) { p.Segments = append([]replay.Segment(nil), segments...) }
+}
+
+// WithOutput выбирает клиент вывода задачи по имени (OutputSM, OutputUIOnly); пустое имя — клиент сервиса.
+func WithOutput(name string) StartOption {
+	return func(p *replay.Params) { p.Output = name }
+}
+
+// outputNamesLocked возвращает имена доступных клиентов вывода в алфавитном порядке.
+func (m *Manager) outputNamesLocked() []string {
+	names := make([]string, 0, len(m.outputs))
+	for name := range m.outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CheckOutput проверяет, что клиент вывода с таким именем зарегистрирован (пустое имя допустимо всегда).
+func (m *Manager) CheckOutput(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.outputLocked(name)
+	return err
+}
+
+// outputLocked возвращает клиент вывода по имени; пустое имя — клиент сервиса.
+func (m *Manager) outputLocked(name string) (sharedmem.Client, error) {
+	if name == "" {
+		return m.service.Output, nil
+	}
+	client, ok := m.outputs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q (available: %s)", errUnknownOutput, name, strings.Join(m.outputNamesLocked(), ", "))
+	}
+	return client, nil
+}
+
 // RequireControl гарантирует, что токен принадлежит активной сессии.
 // Если контроллер отсутствует, закрепляет токен как контроллера.
 func (m *Manager) RequireControl(token string) error {
@@ -191,14 +529,109 @@ func (m *Manager) SessionStatus(token string) SessionStatus {
 		}
 	}
 	return SessionStatus{
-		Session:           token,
-		IsController:      isCtrl,
-		ControllerPresent: m.controllerSession != "",
-		ControllerSession: m.controllerSession,
-		ControllerAgeSec:  age,
-		ControlTimeoutSec: timeoutSec,
-		CanClaim:          canClaim,
+		Session:              token,
+		IsController:         isCtrl,
+		ControllerPresent:    m.controllerSession != "",
+		ControllerSession:    m.controllerSession,
+		ControllerAgeSec:     age,
+		ControlTimeoutSec:    timeoutSec,
+		KeepaliveIntervalSec: int64(m.keepaliveInterval().Seconds()),
+		CanClaim:             canClaim,
+	}
+}
+
+// keepaliveInterval — рекомендуемый период keepalive: треть таймаута, но не меньше секунды.
+// 0, если таймаут управления отключён.
+func (m *Manager) keepaliveInterval() time.Duration {
+	if m.controlTimeout <= 0 {
+		return 0
 	}
+	return max(m.controlTimeout/3, time.Second)
+}
+
+// SetIdleStop включает остановку брошенной задачи: если контроллер не присылал keepalive дольше
+// control timeout, а задача идёт (running), RunControlReaper вместе с управлением останавливает и её.
+func (m *Manager) SetIdleStop(on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.idleStop = on
+}
+
+// SetWarmStart включает тёплый старт: если задача проиграла период до конца, следующая задача
+// с From, равным её последнему шагу, и тем же набором датчиков берёт начальное состояние
+// из памяти, а не запросом Warmup к БД.
+func (m *Manager) SetWarmStart(on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.warmStart = on
+	if !on {
+		m.warm = nil
+	}
+}
+
+// warmSeedLocked возвращает сохранённое состояние, если оно подходит задаче params (иначе nil).
+func (m *Manager) warmSeedLocked(params replay.Params) []storage.SensorEvent {
+	if !m.warmStart || m.warm == nil || !m.warm.ts.Equal(params.From) {
+		return nil
+	}
+	sensors := sortedSensors(params.Sensors)
+	if len(sensors) != len(m.warm.sensors) {
+		return nil
+	}
+	for i := range sensors {
+		if sensors[i] != m.warm.sensors[i] {
+			return nil
+		}
+	}
+	return m.warm.events
+}
+
+func sortedSensors(sensors []int64) []int64 {
+	out := append([]int64(nil), sensors...)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// RunControlReaper освобождает управление, когда контроллер не присылал keepalive дольше таймаута,
+// чтобы controller_present сбрасывался сразу, а не при следующем claim. При SetIdleStop(true)
+// заодно останавливает идущую задачу. Блокируется до отмены ctx.
+func (m *Manager) RunControlReaper(ctx context.Context) {
+	if m.controlTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(max(m.controlTimeout/10, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if !m.reapController(now) {
+				continue
+			}
+			if err := m.Stop(); err != nil {
+				log.Printf("[control] idle-stop: stop job: %v", err)
+			}
+		}
+	}
+}
+
+// reapController освобождает просроченного контроллера и сообщает, нужно ли остановить задачу (idle-stop).
+func (m *Manager) reapController(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.controllerSession == "" || now.Sub(m.controllerLastSeen) <= m.controlTimeout {
+		return false
+	}
+	idle := now.Sub(m.controllerLastSeen).Round(time.Second)
+	log.Printf("[control] releasing stale controller (idle %s > %s)", idle, m.controlTimeout)
+	m.controllerSession = ""
+	m.controllerLastSeen = time.Time{}
+	if !m.idleStop || m.job == nil || m.job.status != "running" {
+		return false
+	}
+	log.Printf("[control] idle-stop: stopping job abandoned by controller (no keepalive for %s > %s)", idle, m.controlTimeout)
+	return true
 }
 
 // KeepAlive обновляет lastSeen для текущего контроллера (не меняя владельца).
@@ -247,10 +680,16 @@ func (m *Manager) Reset() {
 	}
 	m.job = nil
 	m.pending = pendingState{}
+	m.warm = nil
 	if len(m.defaultSensors) > 0 {
 		m.sensors = append([]int64(nil), m.defaultSensors...)
 	}
+	sensors := append([]int64(nil), m.sensors...)
+	streamer := m.streamer
 	m.mu.Unlock()
+	if streamer != nil {
+		streamer.SetWorkingSensors(sensors)
+	}
 }
 
 // SensorsInfo возвращает копию метаданных датчиков.
@@ -264,8 +703,21 @@ func (m *Manager) SensorsInfo() map[int64]SensorInfo {
 	return clone
 }
 
-// StartPending запускает задачу, используя отложенный диапазон.
-func (m *Manager) StartPending(ctx context.Context) error {
+// SetInfo описывает именованный набор датчиков из конфига (значение для --slist/--default-set).
+type SetInfo struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`           // число датчиков набора
+	Error string `json:"error,omitempty"` // набор не резолвится (неизвестный датчик и т.п.)
+}
+
+// Sets возвращает именованные наборы датчиков из конфига, отсортированные по имени.
+func (m *Manager) Sets() []SetInfo {
+	return append([]SetInfo(nil), m.sets...)
+}
+
+// StartPending запускает задачу, используя отложенный диапазон. opts применяются поверх
+// сохранённых параметров; с WithStartPaused(true) задача после отложенного seek остаётся в паузе.
+func (m *Manager) StartPending(ctx context.Context, opts ...StartOption) error {
 	m.mu.Lock()
 	hasRange := m.pending.rangeSet
 	rng := m.pending.rng
@@ -273,15 +725,16 @@ func (m *Manager) StartPending(ctx context.Context) error {
 	seekTs := m.pending.seekTs
 	m.mu.Unlock()
 	if !hasRange {
-		return fmt.Errorf("pending range is not set")
+		return fmt.Errorf("pending %w", errRangeNotSet)
 	}
-	if err := m.Start(ctx, rng.From, rng.To, rng.Step, rng.Speed, rng.Window, rng.SaveOutput); err != nil {
+	startOpts := append(pendingStartOptions(rng), opts...)
+	if err := m.Start(ctx, rng.From, rng.To, rng.Step, rng.Speed, rng.Window, rng.SaveOutput, startOpts...); err != nil {
 		return err
 	}
 	if seekSet {
 		if err := m.Seek(seekTs, false); err != nil {
 			logDebugf("[manager] pending seek apply failed: %v", err)
-		} else {
+		} else if !m.startedPaused() {
 			// После отложенного seek остаёмся в paused внутри сервиса; нужно возобновить.
 			if err := m.Resume(); err != nil {
 				logDebugf("[manager] pending seek resume failed: %v", err)
@@ -291,21 +744,69 @@ func (m *Manager) StartPending(ctx context.Context) error {
 	return nil
 }
 
+// pendingStartOptions переносит сохранённые параметры диапазона, которых нет среди аргументов Start.
+func pendingStartOptions(rng replay.Params) []StartOption {
+	opts := []StartOption{WithBestEffort(rng.BestEffort), WithInclusiveEnd(rng.InclusiveEnd), WithOutput(rng.Output), WithStepAggregate(rng.StepAggregate), WithNoPace(rng.NoPace)}
+	if len(rng.Segments) > 0 {
+		opts = append(opts, WithSegments(rng.Segments))
+	}
+	return opts
+}
+
+// startedPaused сообщает, запущена ли текущая задача с WithStartPaused.
+func (m *Manager) startedPaused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.job != nil && m.job.params.StartPaused
+}
+
+// Continue продолжает остановленную или завершённую задачу с сохранённой позиции:
+// запускает pending-диапазон, перематывает к последнему шагу и возобновляет проигрывание.
+// В отличие от StartPending, ошибка перемотки возвращается вызывающему.
+func (m *Manager) Continue(ctx context.Context) error {
+	m.mu.Lock()
+	stashed := m.pending.rangeSet && m.pending.seekSet
+	rng := m.pending.rng
+	seekTs := m.pending.seekTs
+	m.mu.Unlock()
+	if !stashed {
+		return errNoStashedPos
+	}
+	if err := m.Start(ctx, rng.From, rng.To, rng.Step, rng.Speed, rng.Window, rng.SaveOutput, pendingStartOptions(rng)...); err != nil {
+		return err
+	}
+	if err := m.Seek(seekTs, false); err != nil {
+		return fmt.Errorf("continue: seek to %s: %w", seekTs.Format(time.RFC3339), err)
+	}
+	return m.Resume()
+}
+
 // SetRange сохраняет диапазон/параметры без старта.
-func (m *Manager) SetRange(from, to time.Time, step time.Duration, speed float64, window time.Duration, saveOutput bool) {
+func (m *Manager) SetRange(from, to time.Time, step time.Duration, speed float64, window time.Duration, saveOutput bool, opts ...StartOption) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	save := m.defaults.saveAllowed && saveOutput
 	m.pending.rangeSet = true
 	m.pending.rng = replay.Params{
-		Sensors:    append([]int64(nil), m.sensors...),
-		From:       from,
-		To:         to,
-		Step:       step,
-		Speed:      speed,
-		Window:     window,
-		BatchSize:  m.defaults.batchSize,
-		SaveOutput: save,
+		Sensors:       append([]int64(nil), m.sensors...),
+		From:          from,
+		To:            to,
+		Step:          step,
+		Speed:         speed,
+		Window:        window,
+		SeekWindow:    m.defaults.seekWindow,
+		BatchSize:     m.defaults.batchSize,
+		SaveOutput:    save,
+		BestEffort:    m.defaults.bestEffort,
+		InclusiveEnd:  m.defaults.inclusive,
+		StepAggregate: m.defaults.aggregate,
+		NoPace:        m.defaults.noPace,
+		StartDelay:    m.defaults.startDelay,
+		PrimeOnStart:  m.defaults.primeSM,
+		ActiveHours:   m.defaults.activeHours,
+	}
+	for _, opt := range opts {
+		opt(&m.pending.rng)
 	}
 }
 
@@ -318,11 +819,17 @@ func (m *Manager) SetPendingSeek(ts time.Time) {
 }
 
 // Start запускает новую задачу. Разрешён только один одновременный запуск.
-func (m *Manager) Start(_ context.Context, from, to time.Time, step time.Duration, speed float64, window time.Duration, saveOutput bool) error {
+func (m *Manager) Start(ctx context.Context, from, to time.Time, step time.Duration, speed float64, window time.Duration, saveOutput bool, opts ...StartOption) error {
+	if err := replay.ValidateStep(from, to, step); err != nil {
+		return err
+	}
+	if err := m.checkStrictStart(ctx, to); err != nil {
+		return err
+	}
 	m.mu.Lock()
 	if m.job != nil && (m.job.status == "running" || m.job.status == "paused" || m.job.status == "stopping") {
 		m.mu.Unlock()
-		return fmt.Errorf("job is already active")
+		return errJobActive
 	}
 
 	if speed <= 0 {
@@ -332,24 +839,50 @@ func (m *Manager) Start(_ context.Context, from, to time.Time, step time.Duratio
 		}
 	}
 	if window <= 0 {
-		window = m.defaults.window
-		if window <= 0 {
-			window = 5 * time.Minute
-		}
+		window = m.defaults.window // 0 — автоподбор окна хранилищем
 	}
 	save := m.defaults.saveAllowed && saveOutput
 
 	ctrlCh := make(chan replay.Command, 16)
 	params := replay.Params{
-		Sensors:    append([]int64(nil), m.sensors...),
-		From:       from,
-		To:         to,
-		Step:       step,
-		Window:     window,
-		Speed:      speed,
-		BatchSize:  m.defaults.batchSize,
-		SaveOutput: save,
+		Sensors:       append([]int64(nil), m.sensors...),
+		From:          from,
+		To:            to,
+		Step:          step,
+		Window:        window,
+		SeekWindow:    m.defaults.seekWindow,
+		Speed:         speed,
+		BatchSize:     m.defaults.batchSize,
+		SaveOutput:    save,
+		PauseAt:       m.pending.pauseAt,
+		BestEffort:    m.defaults.bestEffort,
+		InclusiveEnd:  m.defaults.inclusive,
+		StepAggregate: m.defaults.aggregate,
+		NoPace:        m.defaults.noPace,
+		StartDelay:    m.defaults.startDelay,
+		PrimeOnStart:  m.defaults.primeSM,
+		ActiveHours:   m.defaults.activeHours,
+	}
+	for _, opt := range opts {
+		opt(&params)
+	}
+	aggregate, err := replay.ParseStepAggregate(string(params.StepAggregate))
+	if err != nil {
+		m.mu.Unlock()
+		return err
 	}
+	params.StepAggregate = aggregate
+	params.Warmup = m.warmSeedLocked(params)
+	if params.Warmup != nil {
+		log.Printf("[manager] warm start at %s: %d sensors from the previous job", params.From.Format(time.RFC3339), len(params.Warmup))
+	}
+	service := m.service
+	output, err := m.outputLocked(params.Output)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	service.Output = output
 
 	var streamReset map[int64]SensorInfo
 	streamer := m.streamer
@@ -363,11 +896,17 @@ func (m *Manager) Start(_ context.Context, from, to time.Time, step time.Duratio
 	// Держим задачу на фоновом контексте, чтобы она не завершалась сразу после ответа HTTP-хендлера.
 	jobCtx, cancel := context.WithCancel(context.Background())
 	m.jobCancel = cancel
+	status := "running"
+	if params.StartPaused {
+		status = "paused"
+	}
 	j := &job{
 		params:    params,
-		status:    "running",
+		status:    status,
 		startedAt: time.Now(),
 		commands:  ctrlCh,
+		done:      make(chan struct{}),
+		warmStart: params.Warmup != nil,
 	}
 	m.job = j
 	// очищаем pending после старта
@@ -375,11 +914,12 @@ func (m *Manager) Start(_ context.Context, from, to time.Time, step time.Duratio
 	m.mu.Unlock()
 
 	if streamer != nil {
+		streamer.SetWorkingSensors(params.Sensors)
 		streamer.Reset(streamReset)
 	}
 
 	go func() {
-		err := m.service.RunWithControl(jobCtx, params, replay.Control{
+		err := service.RunWithControl(jobCtx, params, replay.Control{
 			Commands: ctrlCh,
 			OnStep: func(info replay.StepInfo) {
 				logDebugf("[event] step=%d ts=%s updates=%d", info.StepID, info.StepTs.Format(time.RFC3339), info.UpdatesCount)
@@ -390,17 +930,67 @@ func (m *Manager) Start(_ context.Context, from, to time.Time, step time.Duratio
 				}
 				m.job.stepID = info.StepID
 				m.job.lastTs = info.StepTs
+				m.job.segment = info.Segment
 				m.job.updatesSent += int64(info.UpdatesCount)
+				if info.BatchesFailed > 0 {
+					m.job.sendErrors += int64(info.BatchesFailed)
+					m.job.lastSendErr = info.SendErr.Error()
+				}
+				m.job.violations += int64(len(info.Violations))
 			},
 			OnUpdates: func(info replay.StepInfo, updates []sharedmem.SensorUpdate) {
 				if m.streamer == nil {
 					return
 				}
 				m.streamer.Publish(info, updates)
+				if len(info.Violations) > 0 {
+					m.streamer.Alert(info)
+				}
+			},
+			OnWarmup: func(info replay.StepInfo, updates []sharedmem.SensorUpdate) {
+				if m.streamer == nil {
+					return
+				}
+				m.streamer.InitialSnapshot(info, updates)
+			},
+			OnCache: func(stats replay.CacheStats) {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				if m.job == j {
+					j.cache = stats
+				}
+			},
+			OnAutoPause: func(info replay.StepInfo) {
+				logDebugf("[event] play-until reached step=%d ts=%s", info.StepID, info.StepTs.Format(time.RFC3339))
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				if m.job != j {
+					return
+				}
+				j.status = "paused"
+				j.autoPaused = true
+			},
+			OnSkip: func(from, to time.Time) {
+				logDebugf("[event] skip inactive hours %s → %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+				m.mu.Lock()
+				if m.job == j {
+					j.skipped++
+					j.skippedTime += to.Sub(from)
+				}
+				m.mu.Unlock()
+				if m.streamer != nil {
+					m.streamer.Skip(from, to)
+				}
+			},
+			OnFinish: func(info replay.StepInfo, state []storage.SensorEvent) {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				if m.warmStart {
+					m.warm = &warmState{ts: info.StepTs, sensors: sortedSensors(params.Sensors), events: state}
+				}
 			},
 		})
 		m.mu.Lock()
-		defer m.mu.Unlock()
 		if m.job != nil {
 			m.job.finishedAt = time.Now()
 			switch {
@@ -425,11 +1015,36 @@ func (m *Manager) Start(_ context.Context, from, to time.Time, step time.Duratio
 			}
 			m.pending.seekSet = true
 		}
+		// done шлём, только если задачу не сменил новый старт: иначе клиенты получили бы чужой конец потока.
+		current := m.job == j
+		last := replay.StepInfo{StepID: j.stepID, StepTs: j.lastTs}
+		m.mu.Unlock()
 		log.Printf("[manager] RunWithControl finished err=%v", err)
+		if streamer != nil && current {
+			reason := DoneCompleted
+			switch {
+			case errors.Is(err, replay.ErrStopped{}), errors.Is(err, context.Canceled):
+				reason, err = DoneStopped, nil
+			case err != nil:
+				reason = DoneFailed
+			}
+			streamer.Done(last, reason, err)
+		}
+		close(j.done)
 	}()
 	return nil
 }
 
+// Done возвращает канал, который закрывается по завершении текущей задачи (nil — задачи нет).
+func (m *Manager) Done() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.job == nil {
+		return nil
+	}
+	return m.job.done
+}
+
 // Pause ставит задачу на паузу.
 func (m *Manager) Pause() error {
 	if err := m.sendCommand(replay.Command{Type: replay.CommandPause}); err != nil {
@@ -441,10 +1056,50 @@ func (m *Manager) Pause() error {
 
 // Resume возобновляет задачу.
 func (m *Manager) Resume() error {
+	m.clearAutoPaused()
 	if err := m.sendCommand(replay.Command{Type: replay.CommandResume}); err != nil {
 		return err
 	}
-	m.setStatus("running")
+	m.setRunning()
+	return nil
+}
+
+// PlayUntil проигрывает до момента ts и ставит задачу на паузу.
+// Активная задача продолжает с текущей позиции, без неё запускается отложенный диапазон.
+func (m *Manager) PlayUntil(ctx context.Context, ts time.Time) error {
+	m.mu.Lock()
+	active := m.job != nil && (m.job.status == "running" || m.job.status == "paused")
+	var from, to time.Time
+	switch {
+	case active:
+		from, to = m.job.params.From, m.job.params.To
+	case m.pending.rangeSet:
+		from, to = m.pending.rng.From, m.pending.rng.To
+	default:
+		m.mu.Unlock()
+		return fmt.Errorf("pending %w", errRangeNotSet)
+	}
+	if ts.Before(from) || ts.After(to) {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: target %s is outside range %s-%s", errPlayUntilRange, ts.Format(time.RFC3339), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	if !active {
+		m.pending.pauseAt = ts
+		m.mu.Unlock()
+		if err := m.StartPending(ctx); err != nil {
+			m.mu.Lock()
+			m.pending.pauseAt = time.Time{}
+			m.mu.Unlock()
+			return err
+		}
+		return nil
+	}
+	m.job.autoPaused = false
+	m.mu.Unlock()
+	if err := m.sendCommand(replay.Command{Type: replay.CommandPlayUntil, TS: ts}); err != nil {
+		return err
+	}
+	m.setRunning()
 	return nil
 }
 
@@ -453,7 +1108,7 @@ func (m *Manager) SetSaveOutput(save bool) error {
 	m.mu.Lock()
 	if m.job == nil || m.job.commands == nil {
 		m.mu.Unlock()
-		return fmt.Errorf("no active job")
+		return errNoActiveJob
 	}
 	m.job.params.SaveOutput = save
 	m.mu.Unlock()
@@ -477,6 +1132,27 @@ func (m *Manager) Sensors() []SensorInfo {
 	return list
 }
 
+// pageSensors отбирает датчики, имя которых содержит q (без учёта регистра), и возвращает
+// страницу [offset, offset+limit) и общее число подходящих. limit <= 0 — до конца списка.
+func pageSensors(list []SensorInfo, q string, offset, limit int) ([]SensorInfo, int) {
+	if q = strings.ToLower(strings.TrimSpace(q)); q != "" {
+		filtered := make([]SensorInfo, 0, len(list))
+		for _, info := range list {
+			if strings.Contains(strings.ToLower(info.Name), q) {
+				filtered = append(filtered, info)
+			}
+		}
+		list = filtered
+	}
+	total := len(list)
+	offset = min(max(offset, 0), total)
+	end := total
+	if limit > 0 {
+		end = min(offset+limit, total)
+	}
+	return list[offset:end], total
+}
+
 // WorkingSensors возвращает копию текущего рабочего списка хешей датчиков.
 func (m *Manager) WorkingSensors() []int64 {
 	m.mu.Lock()
@@ -491,7 +1167,6 @@ func (m *Manager) WorkingSensors() []int64 {
 // Возвращает количество принятых и отклонённых хешей.
 func (m *Manager) SetWorkingSensors(hashes []int64) (int, int, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 
 	seen := make(map[int64]struct{})
 	accepted := make([]int64, 0, len(hashes))
@@ -508,13 +1183,27 @@ func (m *Manager) SetWorkingSensors(hashes []int64) (int, int, error) {
 		accepted = append(accepted, hash)
 	}
 	if len(accepted) == 0 {
-		return 0, rejected, fmt.Errorf("no valid sensors")
+		m.mu.Unlock()
+		return 0, rejected, errNoValidSensors
 	}
+	m.mu.Unlock()
+	m.applyWorking(accepted)
+	return len(accepted), rejected, nil
+}
+
+// applyWorking сохраняет рабочий список и передаёт его стримеру
+// (вне блокировки m.mu, чтобы не пересекаться с controlStatus).
+func (m *Manager) applyWorking(accepted []int64) {
+	m.mu.Lock()
 	m.sensors = accepted
 	if m.pending.rangeSet {
 		m.pending.rng.Sensors = append([]int64(nil), accepted...)
 	}
-	return len(accepted), rejected, nil
+	streamer := m.streamer
+	m.mu.Unlock()
+	if streamer != nil {
+		streamer.SetWorkingSensors(accepted)
+	}
 }
 
 // WorkingSensorNames возвращает имена текущих рабочих датчиков.
@@ -530,27 +1219,121 @@ func (m *Manager) WorkingSensorNames() []string {
 	return names
 }
 
-// SetWorkingSensorsByNames устанавливает рабочий список датчиков по именам.
-// Возвращает количество принятых, отклонённых имён и срез отклонённых имён.
-func (m *Manager) SetWorkingSensorsByNames(names []string) (int, []string, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// SetWorkingSensorsByGroups устанавливает рабочий список из всех датчиков указанных групп
+// (без учёта регистра). Датчики без группы не выбираются ни одной группой.
+// Возвращает количество принятых датчиков и группы, в которых не нашлось ни одного датчика.
+func (m *Manager) SetWorkingSensorsByGroups(groups []string) (int, []string, error) {
+	matched := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		matched[strings.ToLower(strings.TrimSpace(group))] = false
+	}
+	hashes := make([]int64, 0)
+	for _, info := range m.Sensors() {
+		key := strings.ToLower(info.Group)
+		if _, ok := matched[key]; !ok || key == "" {
+			continue
+		}
+		matched[key] = true
+		hashes = append(hashes, info.Hash)
+	}
+	rejected := make([]string, 0)
+	for _, group := range groups {
+		if !matched[strings.ToLower(strings.TrimSpace(group))] {
+			rejected = append(rejected, group)
+		}
+	}
+	if len(hashes) == 0 {
+		return 0, rejected, fmt.Errorf("no sensors in groups %v", groups)
+	}
+	accepted, _, err := m.SetWorkingSensors(hashes)
+	return accepted, rejected, err
+}
 
-	// Построим обратный индекс name → hash
-	nameToHash := make(map[string]int64, len(m.sensorInfo))
-	for hash, info := range m.sensorInfo {
-		nameToHash[info.Name] = hash
+// SetWorkingSensorsByIOTypes сужает текущий рабочий список до датчиков с указанными iotype
+// (без учёта регистра; iotype берётся из SensorInfo). Сочетается с выбором по группам и именам:
+// сначала выбирается набор, затем он фильтруется по типу. Возвращает количество оставшихся
+// датчиков и iotype, которых в рабочем списке нет; если не осталось ни одного — рабочий список не меняется.
+func (m *Manager) SetWorkingSensorsByIOTypes(iotypes []string) (int, []string, error) {
+	matched := make(map[string]bool, len(iotypes))
+	for _, iotype := range iotypes {
+		matched[strings.ToUpper(strings.TrimSpace(iotype))] = false
+	}
+	m.mu.Lock()
+	hashes := make([]int64, 0, len(m.sensors))
+	for _, hash := range m.sensors {
+		key := strings.ToUpper(m.sensorInfo[hash].IOType)
+		if _, ok := matched[key]; !ok || key == "" {
+			continue
+		}
+		matched[key] = true
+		hashes = append(hashes, hash)
 	}
+	m.mu.Unlock()
+	rejected := make([]string, 0)
+	for _, iotype := range iotypes {
+		if !matched[strings.ToUpper(strings.TrimSpace(iotype))] {
+			rejected = append(rejected, iotype)
+		}
+	}
+	if len(hashes) == 0 {
+		return 0, rejected, fmt.Errorf("no working sensors with iotypes %v", iotypes)
+	}
+	accepted, _, err := m.SetWorkingSensors(hashes)
+	return accepted, rejected, err
+}
+
+// SetWorkingSensorsByNames устанавливает рабочий список датчиков по именам; элемент может быть
+// и hash или ID из конфига (как в lookupSensor). Возвращает количество принятых датчиков
+// и нераспознанные элементы; если не принят ни один — errNoValidSensors.
+func (m *Manager) SetWorkingSensorsByNames(names []string) (int, []string, error) {
+	m.mu.Lock()
 
 	seen := make(map[int64]struct{})
 	accepted := make([]int64, 0, len(names))
 	rejected := make([]string, 0)
 	for _, name := range names {
-		hash, ok := nameToHash[name]
+		info, ok := m.lookupSensor(strings.TrimSpace(name))
 		if !ok {
 			rejected = append(rejected, name)
 			continue
 		}
+		if _, dup := seen[info.Hash]; dup {
+			continue
+		}
+		seen[info.Hash] = struct{}{}
+		accepted = append(accepted, info.Hash)
+	}
+	if len(accepted) == 0 {
+		m.mu.Unlock()
+		return 0, rejected, errNoValidSensors
+	}
+	m.mu.Unlock()
+	m.applyWorking(accepted)
+	return len(accepted), rejected, nil
+}
+
+// SetWorkingSensorsByConfigIDs устанавливает рабочий список по ID датчиков из конфига
+// (SensorInfo.ConfigID, взятый из реестра). ID, которым не сопоставлен ни один датчик,
+// отклоняются. Возвращает количество принятых датчиков и отклонённые ID;
+// если не принят ни один — errNoValidSensors.
+func (m *Manager) SetWorkingSensorsByConfigIDs(ids []int64) (int, []int64, error) {
+	m.mu.Lock()
+
+	byID := make(map[int64]int64, len(m.sensorInfo))
+	for hash, info := range m.sensorInfo {
+		if info.ConfigID != nil {
+			byID[*info.ConfigID] = hash
+		}
+	}
+	seen := make(map[int64]struct{})
+	accepted := make([]int64, 0, len(ids))
+	rejected := make([]int64, 0)
+	for _, id := range ids {
+		hash, ok := byID[id]
+		if !ok {
+			rejected = append(rejected, id)
+			continue
+		}
 		if _, dup := seen[hash]; dup {
 			continue
 		}
@@ -558,12 +1341,11 @@ func (m *Manager) SetWorkingSensorsByNames(names []string) (int, []string, error
 		accepted = append(accepted, hash)
 	}
 	if len(accepted) == 0 {
-		return 0, rejected, fmt.Errorf("no valid sensors")
-	}
-	m.sensors = accepted
-	if m.pending.rangeSet {
-		m.pending.rng.Sensors = append([]int64(nil), accepted...)
+		m.mu.Unlock()
+		return 0, rejected, errNoValidSensors
 	}
+	m.mu.Unlock()
+	m.applyWorking(accepted)
 	return len(accepted), rejected, nil
 }
 
@@ -605,25 +1387,27 @@ func (m *Manager) Stop() error {
 	return nil
 }
 
-// StepForward выполняет один шаг вперёд из паузы.
-func (m *Manager) StepForward() error {
-	if handled := m.stepPendingWithoutJob(true); handled {
+// StepForward выполняет count шагов вперёд из паузы (count < 1 — один шаг).
+// Один шаг проигрывается как обычно; несколько — переход к цели через seek без промежуточных
+// отправок, apply=true отправляет в SM итоговое состояние.
+func (m *Manager) StepForward(count int, apply bool) error {
+	if handled := m.stepPendingWithoutJob(true, count); handled {
 		return nil
 	}
-	if err := m.sendCommand(replay.Command{Type: replay.CommandStepForward}); err != nil {
+	if err := m.sendCommand(replay.Command{Type: replay.CommandStepForward, Count: count, Apply: apply}); err != nil {
 		return err
 	}
-	// После единичного шага остаёмся в paused, чтобы пользователь мог двигаться дальше вручную.
+	// После шага остаёмся в paused, чтобы пользователь мог двигаться дальше вручную.
 	m.setStatus("paused")
 	return nil
 }
 
-// StepBackward выполняет один шаг назад из паузы (без промежуточных отправок).
-func (m *Manager) StepBackward(apply bool) error {
-	if handled := m.stepPendingWithoutJob(false); handled {
+// StepBackward выполняет count шагов назад из паузы (count < 1 — один шаг, без промежуточных отправок).
+func (m *Manager) StepBackward(count int, apply bool) error {
+	if handled := m.stepPendingWithoutJob(false, count); handled {
 		return nil
 	}
-	if err := m.sendCommand(replay.Command{Type: replay.CommandStepBackward, Apply: apply}); err != nil {
+	if err := m.sendCommand(replay.Command{Type: replay.CommandStepBackward, Count: count, Apply: apply}); err != nil {
 		return err
 	}
 	m.setStatus("paused")
@@ -632,7 +1416,30 @@ func (m *Manager) StepBackward(apply bool) error {
 
 // Seek перематывает к конкретному моменту. apply=true отправляет финальное состояние в SM.
 func (m *Manager) Seek(ts time.Time, apply bool) error {
-	if err := m.sendCommand(replay.Command{Type: replay.CommandSeek, TS: ts, Apply: apply}); err != nil {
+	return m.seek(replay.Command{Type: replay.CommandSeek, TS: ts, Apply: apply})
+}
+
+// SeekPreview перематывает к ts без отправки в SM и возвращает восстановленное состояние
+// (по именам датчиков, в том же виде, что и SnapshotBatch).
+func (m *Manager) SeekPreview(ts time.Time) (SnapshotValues, error) {
+	preview := make(chan replay.StateSnapshot, 1)
+	if err := m.seek(replay.Command{Type: replay.CommandSeek, TS: ts, Preview: preview}); err != nil {
+		return SnapshotValues{}, err
+	}
+	select {
+	case snap := <-preview:
+		return SnapshotValues{TS: snap.StepTs, Values: m.namedValues(snap.Values)}, nil
+	default:
+		return SnapshotValues{}, errPreviewNoState
+	}
+}
+
+func (m *Manager) seek(cmd replay.Command) error {
+	ts := cmd.TS
+	if err := m.seekSegment(ts); err != nil {
+		return err
+	}
+	if err := m.sendCommand(cmd); err != nil {
 		return err
 	}
 	m.mu.Lock()
@@ -648,6 +1455,178 @@ func (m *Manager) Seek(ts time.Time, apply bool) error {
 	return nil
 }
 
+// seekSegment перед seek плейлиста переходит к сегменту, содержащему ts: цикл перематывает
+// только в пределах текущего сегмента. Момент в разрыве между сегментами — ошибка.
+func (m *Manager) seekSegment(ts time.Time) error {
+	m.mu.Lock()
+	if m.job == nil || len(m.job.params.Segments) == 0 {
+		m.mu.Unlock()
+		return nil
+	}
+	segments, current := m.job.params.Segments, m.job.segment
+	m.mu.Unlock()
+	index := segmentAt(segments, ts, current)
+	if index < 0 {
+		return fmt.Errorf("%w: %s", errSeekInGap, ts.Format(time.RFC3339))
+	}
+	if index == current {
+		return nil
+	}
+	return m.JumpSegment(index)
+}
+
+// segmentAt возвращает сегмент, содержащий ts (на общей границе — current), или -1.
+func segmentAt(segments []replay.Segment, ts time.Time, current int) int {
+	found := -1
+	for i, seg := range segments {
+		if ts.Before(seg.From) || ts.After(seg.To) {
+			continue
+		}
+		if i == current {
+			return i
+		}
+		if found < 0 {
+			found = i
+		}
+	}
+	return found
+}
+
+// JumpSegment переходит к началу сегмента index плейлиста (нумерация с 0); задача встаёт на паузу.
+func (m *Manager) JumpSegment(index int) error {
+	m.mu.Lock()
+	if m.job == nil {
+		m.mu.Unlock()
+		return errNoActiveJob
+	}
+	segments := m.job.params.Segments
+	m.mu.Unlock()
+	if len(segments) == 0 {
+		return errNotPlaylist
+	}
+	if index < 0 || index >= len(segments) {
+		return fmt.Errorf("segment index %d is outside the playlist of %d segments", index, len(segments))
+	}
+	if err := m.sendCommand(replay.Command{Type: replay.CommandSegment, Index: index}); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	if m.job != nil {
+		m.job.segment = index
+		m.job.lastTs = segments[index].From
+		m.job.autoPaused = false
+		m.job.status = "paused"
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+// SeekStep переходит к шагу с номером stepID (нумерация с 1, как в Status.StepID).
+// Возвращает момент шага и при ошибке Seek (errNoActiveJob, errJobFinished) — по нему
+// вызывающий может отложить seek.
+func (m *Manager) SeekStep(stepID int64, apply bool) (time.Time, error) {
+	ts, err := m.StepTimestamp(stepID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ts, m.Seek(ts, apply)
+}
+
+// SeekPercent переходит к моменту на percent процентов диапазона [From, To] (см. PercentTimestamp).
+// Момент возвращается, как в SeekStep.
+func (m *Manager) SeekPercent(percent float64, apply bool) (time.Time, error) {
+	ts, err := m.PercentTimestamp(percent)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ts, m.Seek(ts, apply)
+}
+
+// PercentTimestamp переводит процент диапазона активной задачи или отложенного диапазона в метку
+// времени: percent ограничивается [0, 100], результат округляется до ближайшего шага и не выходит за To.
+// У плейлиста процент берётся от общего числа шагов: разрывы между сегментами не учитываются.
+func (m *Manager) PercentTimestamp(percent float64) (time.Time, error) {
+	if math.IsNaN(percent) {
+		return time.Time{}, fmt.Errorf("percent must be a number")
+	}
+	params, err := m.seekParams()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if params.Step <= 0 || !params.To.After(params.From) {
+		return time.Time{}, fmt.Errorf("invalid range for percent seek")
+	}
+	percent = min(max(percent, 0), 100)
+	if len(params.Segments) > 0 {
+		steps := math.Round(float64(totalSteps(params)-1) * percent / 100)
+		return stepTimestamp(params, int64(steps)+1)
+	}
+	offset := float64(params.To.Sub(params.From)) * percent / 100
+	steps := math.Round(offset / float64(params.Step))
+	ts := params.From.Add(time.Duration(steps) * params.Step)
+	if ts.After(params.To) {
+		ts = params.To
+	}
+	return ts, nil
+}
+
+// seekParams возвращает параметры активной задачи или, если её нет, отложенного диапазона.
+func (m *Manager) seekParams() (replay.Params, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case m.job != nil:
+		return m.job.params, nil
+	case m.pending.rangeSet:
+		return m.pending.rng, nil
+	default:
+		return replay.Params{}, errRangeNotSet
+	}
+}
+
+// StepTimestamp переводит номер шага в метку времени по параметрам активной задачи
+// или отложенного диапазона: шаг 1 соответствует From, шаг N — From+(N-1)*Step.
+// У плейлиста нумерация сквозная, как в Status.StepID: шаги идут по сегментам, минуя разрывы.
+func (m *Manager) StepTimestamp(stepID int64) (time.Time, error) {
+	params, err := m.seekParams()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return stepTimestamp(params, stepID)
+}
+
+func stepTimestamp(params replay.Params, stepID int64) (time.Time, error) {
+	if params.Step <= 0 || !params.To.After(params.From) {
+		return time.Time{}, fmt.Errorf("invalid range for step seek")
+	}
+	total := totalSteps(params)
+	if stepID < 1 || stepID > total {
+		return time.Time{}, fmt.Errorf("%w: %d not in [1, %d]", errStepOutOfRange, stepID, total)
+	}
+	if len(params.Segments) > 0 {
+		bases, _ := replay.PlaylistSteps(params.Segments, params.Step, params.InclusiveEnd)
+		i := sort.Search(len(bases), func(i int) bool { return bases[i] >= stepID }) - 1
+		return params.Segments[i].From.Add(time.Duration(stepID-bases[i]-1) * params.Step), nil
+	}
+	return params.From.Add(time.Duration(stepID-1) * params.Step), nil
+}
+
+// totalSteps возвращает количество шагов в диапазоне [From, To) с шагом Step
+// (или [From, To] при InclusiveEnd — тогда To, попавший на сетку шагов, тоже шаг),
+// у плейлиста — сумму шагов сегментов.
+func totalSteps(params replay.Params) int64 {
+	if len(params.Segments) > 0 {
+		_, total := replay.PlaylistSteps(params.Segments, params.Step, params.InclusiveEnd)
+		return total
+	}
+	span := params.To.Sub(params.From)
+	total := int64(span / params.Step)
+	if span%params.Step != 0 || params.InclusiveEnd {
+		total++
+	}
+	return total
+}
+
 // Apply отправляет текущее состояние в SM одним шагом.
 func (m *Manager) Apply() error { return m.sendCommand(replay.Command{Type: replay.CommandApply}) }
 
@@ -664,15 +1643,24 @@ func (m *Manager) Status() Status {
 		return Status{Status: st, Pending: pending, SaveAllowed: m.defaults.saveAllowed}
 	}
 	st := Status{
-		Status:      m.job.status,
-		Params:      m.job.params,
-		StartedAt:   m.job.startedAt,
-		FinishedAt:  m.job.finishedAt,
-		StepID:      m.job.stepID,
-		LastTS:      m.job.lastTs,
-		UpdatesSent: m.job.updatesSent,
-		Pending:     m.pendingStateLocked(),
-		SaveAllowed: m.defaults.saveAllowed,
+		Status:          m.job.status,
+		Params:          m.job.params,
+		StartedAt:       m.job.startedAt,
+		FinishedAt:      m.job.finishedAt,
+		StepID:          m.job.stepID,
+		LastTS:          m.job.lastTs,
+		UpdatesSent:     m.job.updatesSent,
+		SendErrors:      m.job.sendErrors,
+		LastSendError:   m.job.lastSendErr,
+		LimitViolations: m.job.violations,
+		Cache:           m.job.cache,
+		WarmStart:       m.job.warmStart,
+		SkippedSpans:    m.job.skipped,
+		SkippedSec:      m.job.skippedTime.Seconds(),
+		SegmentIndex:    m.job.segment,
+		SegmentCount:    len(m.job.params.Segments),
+		Pending:         m.pendingStateLocked(),
+		SaveAllowed:     m.defaults.saveAllowed,
 	}
 	if m.job.err != nil {
 		st.Error = m.job.err.Error()
@@ -705,26 +1693,215 @@ func (m *Manager) pendingStateLocked() Pending {
 }
 
 // Snapshot рассчитывает состояние на момент ts, без отправки в SM.
-func (m *Manager) Snapshot(ctx context.Context, ts time.Time) (replay.StateSnapshot, error) {
+// maxStale > 0 исключает значения без событий в окне [ts-maxStale, ts] (они попадают в Stale).
+func (m *Manager) Snapshot(ctx context.Context, ts time.Time, sensors []int64, maxStale time.Duration) (replay.StateSnapshot, error) {
 	params := replay.Params{
-		Sensors: m.sensors,
-		From:    ts,
-		To:      ts,
-		Step:    time.Second,
-		Window:  m.defaults.window,
+		Sensors:      m.sensorsOr(sensors),
+		From:         ts,
+		To:           ts,
+		Step:         time.Second,
+		Window:       m.defaults.window,
+		MaxStaleness: maxStale,
 	}
 	return replay.BuildState(ctx, m.service.Storage, params, ts)
 }
 
+// maxSnapshotBatch ограничивает число меток времени в одном запросе SnapshotBatch.
+const maxSnapshotBatch = 1000
+
+// SnapshotBatch рассчитывает состояния на несколько отсортированных моментов времени за один проход.
+// Значения возвращаются по именам датчиков в том же виде, что и в WebSocket: калибровка, округление, порог дискретных.
+// sensors (не пусто) заменяет рабочий список на этот запрос; maxStale > 0 переносит устаревшие
+// значения из Values в Stale.
+func (m *Manager) SnapshotBatch(ctx context.Context, timestamps []time.Time, sensors []int64, maxStale time.Duration) ([]SnapshotValues, error) {
+	if len(timestamps) == 0 {
+		return nil, fmt.Errorf("timestamps are empty")
+	}
+	if len(timestamps) > maxSnapshotBatch {
+		return nil, fmt.Errorf("too many timestamps: %d (max %d)", len(timestamps), maxSnapshotBatch)
+	}
+	sensors = m.sensorsOr(sensors)
+
+	snaps, err := replay.BuildStates(ctx, m.service.Storage, sensors, m.defaults.window, maxStale, timestamps)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]SnapshotValues, 0, len(snaps))
+	for _, snap := range snaps {
+		out = append(out, SnapshotValues{TS: snap.StepTs, Values: m.namedValues(snap.Values), Stale: m.StaleNames(snap.Stale)})
+	}
+	return out, nil
+}
+
+// StaleNames возвращает отсортированные имена устаревших датчиков снимка (nil — таких нет).
+func (m *Manager) StaleNames(stale map[int64]bool) []string {
+	if len(stale) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(stale))
+	for hash := range stale {
+		name := strconv.FormatInt(hash, 10)
+		if info, ok := m.sensorInfo[hash]; ok && info.Name != "" {
+			name = info.Name
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// namedValues переводит значения hash → value в name → value в том виде, в каком они уходят
+// в WebSocket и SM (см. replay.Service.FormatValue).
+func (m *Manager) namedValues(raw map[int64]float64) map[string]float64 {
+	values := make(map[string]float64, len(raw))
+	for hash, v := range raw {
+		name := strconv.FormatInt(hash, 10)
+		if info, ok := m.sensorInfo[hash]; ok && info.Name != "" {
+			name = info.Name
+		}
+		values[name] = m.service.FormatValue(hash, v)
+	}
+	return values
+}
+
+// Ограничения на количество точек в SensorHistory.
+const (
+	defaultHistoryLimit = 1000
+	maxHistoryLimit     = 10000
+)
+
+// SensorHistory возвращает сырые записи одного датчика в окне [from, to].
+// Датчик задаётся именем, hash-идентификатором или ID из конфига. changesOnly оставляет только
+// записи, где значение изменилось (хранилище должно поддерживать ChangeHistoryStorage).
+func (m *Manager) SensorHistory(ctx context.Context, idOrName string, from, to time.Time, limit int, changesOnly bool) (SensorHistory, error) {
+	store, ok := m.service.Storage.(storage.EventHistoryStorage)
+	if !ok {
+		return SensorHistory{}, errNoEventHistory
+	}
+	changes, ok := m.service.Storage.(storage.ChangeHistoryStorage)
+	if changesOnly && !ok {
+		return SensorHistory{}, errNoChangeHistory
+	}
+	info, ok := m.lookupSensor(idOrName)
+	if !ok {
+		return SensorHistory{}, fmt.Errorf("%w: %s", errSensorNotFound, idOrName)
+	}
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+	// Запрашиваем на одну запись больше, чтобы определить усечение.
+	var events []storage.SensorEvent
+	var err error
+	if changesOnly {
+		events, err = changes.ChangesFor(ctx, info.Hash, from, to, limit+1)
+	} else {
+		events, err = store.EventsFor(ctx, info.Hash, from, to, limit+1)
+	}
+	if err != nil {
+		return SensorHistory{}, err
+	}
+	truncated := len(events) > limit
+	if truncated {
+		events = events[:limit]
+	}
+	points := make([]HistoryPoint, 0, len(events))
+	for _, ev := range events {
+		points = append(points, HistoryPoint{TS: ev.Timestamp, Value: ev.Value})
+	}
+	return SensorHistory{
+		ID:        info.ID,
+		Name:      info.Name,
+		Points:    points,
+		Truncated: truncated,
+	}, nil
+}
+
+// lookupSensor ищет датчик по имени, затем по hash и по ID из конфига.
+func (m *Manager) lookupSensor(idOrName string) (SensorInfo, bool) {
+	for _, info := range m.sensorInfo {
+		if info.Name == idOrName {
+			return info, true
+		}
+	}
+	id, err := strconv.ParseInt(idOrName, 10, 64)
+	if err != nil {
+		return SensorInfo{}, false
+	}
+	if info, ok := m.sensorInfo[id]; ok {
+		return info, true
+	}
+	for _, info := range m.sensorInfo {
+		if info.ConfigID != nil && *info.ConfigID == id {
+			return info, true
+		}
+	}
+	return SensorInfo{}, false
+}
+
+// RuntimeDefaults возвращает параметры, с которыми запущен менеджер.
+func (m *Manager) RuntimeDefaults() RuntimeDefaults {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return RuntimeDefaults{
+		Speed:             m.defaults.speed,
+		Window:            m.defaults.window.String(),
+		SeekWindow:        m.defaults.seekWindow.String(),
+		BatchSize:         m.defaults.batchSize,
+		SaveAllowed:       m.defaults.saveAllowed,
+		SaveOutput:        m.defaults.saveOutput,
+		BestEffort:        m.defaults.bestEffort,
+		InclusiveEnd:      m.defaults.inclusive,
+		StepAggregate:     string(m.defaults.aggregate),
+		NoPace:            m.defaults.noPace,
+		StartDelay:        m.defaults.startDelay.String(),
+		PrimeOnStart:      m.defaults.primeSM,
+		ActiveHours:       m.defaults.activeHours,
+		Outputs:           m.outputNamesLocked(),
+		ControlTimeoutSec: int64(m.controlTimeout.Seconds()),
+		CommandTimeoutSec: int64(m.commandTimeout.Seconds()),
+	}
+}
+
+// Readiness проверяет доступность хранилища и получателя (SM), если они это поддерживают.
+func (m *Manager) Readiness(ctx context.Context) Readiness {
+	res := Readiness{Status: "ok", Checks: make(map[string]ReadinessCheck, 2)}
+	if p, ok := m.service.Storage.(storage.Pinger); ok {
+		res.add("storage", p.Ping(ctx))
+	} else {
+		res.Checks["storage"] = ReadinessCheck{Status: "skipped"}
+	}
+	if p, ok := m.service.Output.(sharedmem.Pinger); ok {
+		res.add("output", p.Ping(ctx))
+	} else {
+		res.Checks["output"] = ReadinessCheck{Status: "skipped"}
+	}
+	return res
+}
+
+// Preflight проверяет готовность к воспроизведению рабочего набора датчиков без отправки в SM.
+// Нулевые границы берутся из pending-диапазона (если задан), иначе проверяется весь архив.
+func (m *Manager) Preflight(ctx context.Context, from, to time.Time) replay.Preflight {
+	m.mu.Lock()
+	sensors := append([]int64(nil), m.sensors...)
+	if from.IsZero() && to.IsZero() && m.pending.rangeSet {
+		from, to = m.pending.rng.From, m.pending.rng.To
+	}
+	m.mu.Unlock()
+	return m.service.Preflight(ctx, sensors, from, to)
+}
+
 // stepPendingWithoutJob двигает pending.seekTs, если задачи нет (idle/done) и задан диапазон.
-func (m *Manager) stepPendingWithoutJob(forward bool) bool {
+func (m *Manager) stepPendingWithoutJob(forward bool, count int) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	return m.stepPendingLocked(forward)
+	return m.stepPendingLocked(forward, count)
 }
 
-// stepPendingLocked двигает pending.seekTs, ожидая, что m.mu уже удержан.
-func (m *Manager) stepPendingLocked(forward bool) bool {
+// stepPendingLocked двигает pending.seekTs на count шагов, ожидая, что m.mu уже удержан.
+func (m *Manager) stepPendingLocked(forward bool, count int) bool {
 	if m.job != nil && m.job.status != "done" && m.job.status != "failed" {
 		return false
 	}
@@ -739,18 +1916,9 @@ func (m *Manager) stepPendingLocked(forward bool) bool {
 	if cur.IsZero() {
 		cur = m.pending.rng.From
 	}
-	var next time.Time
-	if forward {
-		next = cur.Add(step)
-		if next.After(m.pending.rng.To) {
-			next = m.pending.rng.To
-		}
-	} else {
-		next = cur.Add(-step)
-		if next.Before(m.pending.rng.From) {
-			next = m.pending.rng.From
-		}
-	}
+	rng := m.pending.rng
+	rng.Step = step
+	next := replay.StepTarget(rng, cur, count, forward)
 	m.pending.seekSet = true
 	m.pending.seekTs = next
 	// статус оставляем как есть (обычно idle/done), чтобы UI видел pending seek.
@@ -769,23 +1937,74 @@ func (m *Manager) Range(ctx context.Context) (time.Time, time.Time, int64, error
 func (m *Manager) RangeWithUnknown(ctx context.Context) (time.Time, time.Time, int64, int64, error) {
 	// Доступный диапазон считаем по всему объёму истории, без учёта текущего pending-диапазона,
 	// чтобы кнопка «установить доступный диапазон» всегда возвращала реальные границы данных.
-	return m.RangeWithUnknownBounds(ctx, time.Time{}, time.Time{})
+	return m.RangeWithUnknownBounds(ctx, time.Time{}, time.Time{}, nil)
 }
 
 // RangeWithUnknownBounds считает unknown в указанном окне [from,to]. Если не поддерживается — unknown=0.
-func (m *Manager) RangeWithUnknownBounds(ctx context.Context, from, to time.Time) (time.Time, time.Time, int64, int64, error) {
+// sensors переопределяет рабочий список на один вызов (nil — рабочий список).
+// Пустое окно — не ошибка: нулевые границы и count=0. Пустой список датчиков — errNoValidSensors,
+// сбой хранилища (нет таблицы, нет соединения) оборачивается в errRangeStorage.
+func (m *Manager) RangeWithUnknownBounds(ctx context.Context, from, to time.Time, sensors []int64) (time.Time, time.Time, int64, int64, error) {
+	sensors = m.sensorsOr(sensors)
+	if len(sensors) == 0 {
+		return time.Time{}, time.Time{}, 0, 0, errNoValidSensors
+	}
+	var (
+		min, max       time.Time
+		count, unknown int64
+		err            error
+	)
 	if ua, ok := m.service.Storage.(storage.UnknownAwareStorage); ok {
-		return ua.RangeWithUnknown(ctx, m.sensors, from, to)
+		min, max, count, unknown, err = ua.RangeWithUnknown(ctx, sensors, from, to)
+	} else {
+		min, max, count, err = m.service.Storage.Range(ctx, sensors, from, to)
+	}
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, 0, fmt.Errorf("%w: %w", errRangeStorage, err)
 	}
-	min, max, count, err := m.service.Storage.Range(ctx, m.sensors, from, to)
-	return min, max, count, 0, err
+	return min, max, count, unknown, nil
 }
 
-func (m *Manager) SensorsCount(ctx context.Context, from, to time.Time) (int64, error) {
-	_, _, count, err := m.service.Storage.Range(ctx, m.sensors, from, to)
+// SensorsCount возвращает число датчиков с данными в окне [from,to]; sensors — как в RangeWithUnknownBounds.
+func (m *Manager) SensorsCount(ctx context.Context, from, to time.Time, sensors []int64) (int64, error) {
+	_, _, count, err := m.service.Storage.Range(ctx, m.sensorsOr(sensors), from, to)
 	return count, err
 }
 
+// sensorsOr возвращает переопределённый список датчиков или, если он пуст, копию рабочего списка.
+func (m *Manager) sensorsOr(override []int64) []int64 {
+	if len(override) > 0 {
+		return override
+	}
+	return m.WorkingSensors()
+}
+
+// ResolveSensors переводит имена, хеши или ID из конфига в хеши известных датчиков.
+// Возвращает принятые хеши (без повторов) и нераспознанные элементы; если не принят ни один — ошибка.
+func (m *Manager) ResolveSensors(items []string) ([]int64, []string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[int64]struct{}, len(items))
+	hashes := make([]int64, 0, len(items))
+	rejected := make([]string, 0)
+	for _, item := range items {
+		info, ok := m.lookupSensor(strings.TrimSpace(item))
+		if !ok {
+			rejected = append(rejected, item)
+			continue
+		}
+		if _, dup := seen[info.Hash]; dup {
+			continue
+		}
+		seen[info.Hash] = struct{}{}
+		hashes = append(hashes, info.Hash)
+	}
+	if len(hashes) == 0 {
+		return nil, rejected, errNoValidSensors
+	}
+	return hashes, rejected, nil
+}
+
 type Status struct {
 	Status      string        `json:"status"`
 	Params      replay.Params `json:"params"`
@@ -794,9 +2013,26 @@ type Status struct {
 	StepID      int64         `json:"step_id"`
 	LastTS      time.Time     `json:"last_ts"`
 	UpdatesSent int64         `json:"updates_sent"`
-	Error       string        `json:"error,omitempty"`
-	Pending     Pending       `json:"pending,omitempty"`
-	SaveAllowed bool          `json:"save_allowed"`
+	// SendErrors — число батчей, не принятых SM; LastSendError — текст последней такой ошибки.
+	SendErrors    int64  `json:"send_errors"`
+	LastSendError string `json:"last_send_error,omitempty"`
+	// LimitViolations — число обновлений со значением за границами min/max из конфига.
+	LimitViolations int64   `json:"limit_violations"`
+	Error           string  `json:"error,omitempty"`
+	Pending         Pending `json:"pending,omitempty"`
+	SaveAllowed     bool    `json:"save_allowed"`
+	// Cache — счётчики кэша состояний задачи: дешёвые seek попадают в exact_hits/le_hits,
+	// дорогие восстановления по истории — в rebuilds.
+	Cache replay.CacheStats `json:"cache"`
+	// WarmStart — начальное состояние взято из предыдущей задачи (--warm-start), без Warmup из БД.
+	WarmStart bool `json:"warm_start,omitempty"`
+	// SkippedSpans/SkippedSec — пропущенные промежутки вне суточного окна (--active-hours)
+	// и их суммарная длительность по времени истории.
+	SkippedSpans int64   `json:"skipped_spans,omitempty"`
+	SkippedSec   float64 `json:"skipped_sec,omitempty"`
+	// SegmentIndex/SegmentCount — текущий сегмент и число сегментов плейлиста (/api/v2/job/playlist).
+	SegmentIndex int `json:"segment_index"`
+	SegmentCount int `json:"segment_count,omitempty"`
 }
 
 type StateMeta struct {
@@ -806,6 +2042,74 @@ type StateMeta struct {
 	UpdatesSent int64     `json:"updates_sent"`
 }
 
+// RuntimeDefaults — параметры воспроизведения по умолчанию и таймауты управления.
+type RuntimeDefaults struct {
+	Speed        float64 `json:"speed"`
+	Window       string  `json:"window"`
+	SeekWindow   string  `json:"seek_window"`
+	BatchSize    int     `json:"batch_size"`
+	SaveAllowed  bool    `json:"save_allowed"`
+	SaveOutput   bool    `json:"save_output"`
+	BestEffort   bool    `json:"best_effort"`
+	InclusiveEnd bool    `json:"inclusive_end"`
+	// StepAggregate — значение шага при нескольких событиях в нём: last, avg, min или max.
+	StepAggregate string `json:"step_aggregate"`
+	// NoPace — шаги идут без пауз между ними, speed игнорируется.
+	NoPace bool `json:"no_pace"`
+	// StartDelay — выдержка перед первым шагом задачи после начального снимка.
+	StartDelay string `json:"start_delay"`
+	// PrimeOnStart — задача до первого шага отправляет в SM полный снимок исходного состояния.
+	PrimeOnStart bool `json:"prime_on_start"`
+	// ActiveHours — суточное окно воспроизведения ("08:00-18:00 mon,tue"), null — непрерывно.
+	ActiveHours *replay.ActiveHours `json:"active_hours"`
+	// Outputs — имена клиентов вывода, которые можно выбрать полем output задачи.
+	Outputs           []string `json:"outputs"`
+	ControlTimeoutSec int64    `json:"control_timeout_sec"`
+	CommandTimeoutSec int64    `json:"command_timeout_sec"`
+}
+
+// SnapshotValues — состояние датчиков (имя → значение) на момент TS.
+type SnapshotValues struct {
+	TS     time.Time          `json:"ts"`
+	Values map[string]float64 `json:"values"`
+	Stale  []string           `json:"stale,omitempty"` // датчики без событий в окне max_staleness
+}
+
+// Readiness — результат readiness-проверки зависимостей.
+type Readiness struct {
+	Status string                    `json:"status"`
+	Checks map[string]ReadinessCheck `json:"checks"`
+}
+
+func (r *Readiness) add(name string, err error) {
+	if err != nil {
+		r.Status = "fail"
+		r.Checks[name] = ReadinessCheck{Status: "fail", Error: err.Error()}
+		return
+	}
+	r.Checks[name] = ReadinessCheck{Status: "ok"}
+}
+
+// ReadinessCheck — состояние одной зависимости: ok, fail или skipped (проверка не поддерживается).
+type ReadinessCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HistoryPoint — одна сохранённая запись истории датчика.
+type HistoryPoint struct {
+	TS    time.Time `json:"ts"`
+	Value float64   `json:"value"`
+}
+
+// SensorHistory — сырые записи датчика; Truncated=true, если упёрлись в limit.
+type SensorHistory struct {
+	ID        int64          `json:"id"`
+	Name      string         `json:"name"`
+	Points    []HistoryPoint `json:"points"`
+	Truncated bool           `json:"truncated"`
+}
+
 // Pending описывает отложенные параметры диапазона/seek.
 type Pending struct {
 	RangeSet bool          `json:"range_set"`
@@ -820,7 +2124,7 @@ func (m *Manager) sendCommand(cmd replay.Command) error {
 	if m.job == nil || m.job.status == "done" || m.job.status == "failed" || m.job.commands == nil {
 		if isStep {
 			forward := cmd.Type == replay.CommandStepForward
-			handled := m.stepPendingLocked(forward)
+			handled := m.stepPendingLocked(forward, cmd.Count)
 			if handled {
 				m.mu.Unlock()
 				return nil
@@ -828,10 +2132,10 @@ func (m *Manager) sendCommand(cmd replay.Command) error {
 		}
 		m.mu.Unlock()
 		if m.job == nil {
-			return fmt.Errorf("no active job")
+			return errNoActiveJob
 		}
 		if m.job.status == "done" || m.job.status == "failed" {
-			return fmt.Errorf("job is already finished")
+			return errJobFinished
 		}
 		return fmt.Errorf("job is not controllable")
 	}
@@ -848,14 +2152,48 @@ func (m *Manager) sendCommand(cmd replay.Command) error {
 		m.mu.Unlock()
 		return fmt.Errorf("failed to enqueue command")
 	}
+	timeout := m.commandTimeout
+	if cmd.Type == replay.CommandSeek || cmd.Type == replay.CommandStepBackward || cmd.Count > 1 {
+		timeout *= seekTimeoutFactor
+	}
 	m.mu.Unlock()
-	select {
-	case err := <-resp:
-		logDebugf("[command] result %v err=%v", cmd.Type, err)
-		return err
-	case <-time.After(30 * time.Second):
-		logDebugf("[command] timeout %v", cmd.Type)
-		return fmt.Errorf("command timeout")
+
+	started := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	slow := time.NewTimer(slowCommandThreshold)
+	defer slow.Stop()
+	for {
+		select {
+		case err := <-resp:
+			if elapsed := time.Since(started); elapsed >= slowCommandThreshold {
+				log.Printf("[command] %v completed slowly in %s (err=%v)", cmd.Type, elapsed.Round(time.Millisecond), err)
+			}
+			logDebugf("[command] result %v err=%v", cmd.Type, err)
+			return err
+		case <-slow.C:
+			log.Printf("[command] %v still in progress after %s (timeout %s)", cmd.Type, slowCommandThreshold, timeout)
+		case <-deadline.C:
+			log.Printf("[command] timeout %v after %s", cmd.Type, timeout)
+			return fmt.Errorf("%w after %s", errCommandTimeout, timeout)
+		}
+	}
+}
+
+// setRunning переводит задачу в running, если цикл уже не встал на паузу по play-until.
+func (m *Manager) setRunning() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.job != nil && !m.job.autoPaused {
+		m.job.status = "running"
+	}
+}
+
+func (m *Manager) clearAutoPaused() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.job != nil {
+		m.job.autoPaused = false
 	}
 }
 
@@ -2,8 +2,11 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
+	"math"
+	"reflect"
 	"strings"
 	"sync"
 	"testing"
@@ -11,7 +14,9 @@ import (
 
 	"github.com/pv/uniset-timemachine-go/internal/replay"
 	"github.com/pv/uniset-timemachine-go/internal/sharedmem"
+	"github.com/pv/uniset-timemachine-go/internal/storage"
 	"github.com/pv/uniset-timemachine-go/internal/storage/memstore"
+	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
 func newTestManager(t *testing.T) *Manager {
@@ -25,7 +30,7 @@ func newTestManager(t *testing.T) *Manager {
 		Storage: store,
 		Output:  &sharedmem.StdoutClient{Writer: io.Discard},
 	}
-	return NewManager(svc, []int64{1, 2}, nil, 1000, step, 8, nil, true, false, 0)
+	return NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1000, Window: step, BatchSize: 8, SaveAllowed: true})
 }
 
 func TestManagerStartConflictAndStop(t *testing.T) {
@@ -121,7 +126,7 @@ func TestManagerPlaybackFlowScenario(t *testing.T) {
 		Storage: store,
 		Output:  &sharedmem.StdoutClient{Writer: io.Discard},
 	}
-	mgr := NewManager(svc, []int64{1}, nil, 2.0, step, 8, nil, true, false, 0)
+	mgr := NewManager(svc, []int64{1}, nil, nil, ManagerOptions{Speed: 2.0, Window: step, BatchSize: 8, SaveAllowed: true})
 
 	mgr.SetRange(from, to, step, 2.0, time.Second, true)
 	seekStart := from.Add(2 * step)
@@ -169,7 +174,7 @@ func TestManagerPlaybackFlowScenario(t *testing.T) {
 	}
 	waitManagerStatus(t, mgr, []string{"paused"}, 2*time.Second)
 
-	if err := mgr.StepBackward(false); err != nil {
+	if err := mgr.StepBackward(1, false); err != nil {
 		t.Fatalf("StepBackward error: %v", err)
 	}
 	waitManagerStatus(t, mgr, []string{"paused"}, 2*time.Second)
@@ -222,7 +227,7 @@ func TestManagerSeekApplyPaused(t *testing.T) {
 	var capClient captureClient
 	client := &capClient
 	svc := replay.Service{Storage: store, Output: client}
-	mgr := NewManager(svc, []int64{1}, nil, 1, step, 8, nil, true, false, 0)
+	mgr := NewManager(svc, []int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: step, BatchSize: 8, SaveAllowed: true})
 
 	if err := mgr.Start(context.Background(), from, to, step, 1, step, true); err != nil {
 		t.Fatalf("start: %v", err)
@@ -243,11 +248,684 @@ func TestManagerSeekApplyPaused(t *testing.T) {
 	_ = mgr.Stop()
 }
 
+func TestManagerSeekPreview(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	step := time.Second
+	to := from.Add(5 * time.Second)
+
+	store := memstore.NewExampleStore([]int64{1}, from, to, step)
+	var capClient captureClient
+	svc := replay.Service{Storage: store, Output: &capClient}
+	mgr := NewManager(svc, []int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: step, BatchSize: 8, SaveAllowed: true})
+
+	if _, err := mgr.SeekPreview(from); !errors.Is(err, errNoActiveJob) {
+		t.Fatalf("preview without job err = %v, want no active job", err)
+	}
+
+	if err := mgr.Start(context.Background(), from, to, step, 1, step, true); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"running"}, 2*time.Second)
+	if err := mgr.Pause(); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"paused"}, 2*time.Second)
+	sent := len(capClient.Payloads())
+
+	target := from.Add(2 * step)
+	snap, err := mgr.SeekPreview(target)
+	if err != nil {
+		t.Fatalf("seek preview: %v", err)
+	}
+	// memstore: значение = id%100 + секунда метки времени.
+	if !snap.TS.Equal(target) || snap.Values["hash1"] != 3 {
+		t.Fatalf("preview = %+v, want ts=%s hash1=3", snap, target)
+	}
+	if got := len(capClient.Payloads()); got != sent {
+		t.Fatalf("preview must not send to SM: payloads %d -> %d", sent, got)
+	}
+	_ = mgr.Stop()
+}
+
+func TestManagerSeekStep(t *testing.T) {
+	mgr := newTestManager(t)
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(5 * time.Second)
+
+	if _, err := mgr.StepTimestamp(1); err == nil {
+		t.Fatalf("expected error without range")
+	}
+	mgr.SetRange(from, to, time.Second, 1, time.Second, true)
+	ts, err := mgr.StepTimestamp(3)
+	if err != nil {
+		t.Fatalf("StepTimestamp: %v", err)
+	}
+	if want := from.Add(2 * time.Second); !ts.Equal(want) {
+		t.Fatalf("StepTimestamp(3) = %s, want %s", ts, want)
+	}
+	for _, bad := range []int64{0, -1, 6} {
+		if _, err := mgr.StepTimestamp(bad); !errors.Is(err, errStepOutOfRange) {
+			t.Fatalf("expected out of range error for step %d", bad)
+		}
+	}
+
+	// С inclusive_end шаг ровно в To существует и доступен как последний.
+	mgr.SetRange(from, to, time.Second, 1, time.Second, true, WithInclusiveEnd(true))
+	if ts, err := mgr.StepTimestamp(6); err != nil || !ts.Equal(to) {
+		t.Fatalf("inclusive StepTimestamp(6) = %s, %v, want %s", ts, err, to)
+	}
+	if _, err := mgr.StepTimestamp(7); err == nil {
+		t.Fatalf("expected out of range error for step 7 with inclusive end")
+	}
+	mgr.SetRange(from, to, time.Second, 1, time.Second, true)
+
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1, time.Second, false); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"running"}, 2*time.Second)
+	if err := mgr.Pause(); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"paused"}, 2*time.Second)
+	target := from.Add(3 * time.Second)
+	if ts, err := mgr.SeekStep(4, false); err != nil || !ts.Equal(target) {
+		t.Fatalf("SeekStep = %s, %v, want %s", ts, err, target)
+	}
+	waitForCond(t, time.Second, func() bool { return approxTime(mgr.Status().LastTS, target, time.Second) })
+	if _, err := mgr.SeekStep(99, false); !errors.Is(err, errStepOutOfRange) {
+		t.Fatalf("SeekStep(99) err = %v, want %v", err, errStepOutOfRange)
+	}
+	_ = mgr.Stop()
+}
+
+func TestManagerSeekPercent(t *testing.T) {
+	mgr := newTestManager(t)
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(10 * time.Second)
+
+	if _, err := mgr.PercentTimestamp(50); err == nil {
+		t.Fatalf("expected error without range")
+	}
+	mgr.SetRange(from, to, time.Second, 1, time.Second, true)
+	cases := map[float64]time.Time{
+		0:    from,
+		45:   from.Add(5 * time.Second), // 4.5s → ближайший шаг 5s
+		44.9: from.Add(4 * time.Second),
+		100:  to,
+		-10:  from,
+		250:  to,
+	}
+	for percent, want := range cases {
+		ts, err := mgr.PercentTimestamp(percent)
+		if err != nil || !ts.Equal(want) {
+			t.Fatalf("PercentTimestamp(%v) = %s, %v, want %s", percent, ts, err, want)
+		}
+	}
+	if _, err := mgr.PercentTimestamp(math.NaN()); err == nil {
+		t.Fatalf("expected error for NaN percent")
+	}
+	// To вне сетки шагов: ближайший шаг за To ограничивается To.
+	mgr.SetRange(from, from.Add(9500*time.Millisecond), time.Second, 1, time.Second, true)
+	if ts, _ := mgr.PercentTimestamp(100); !ts.Equal(from.Add(9500 * time.Millisecond)) {
+		t.Fatalf("PercentTimestamp(100) off-grid = %s, want To", ts)
+	}
+
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1, time.Second, false); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"running"}, 2*time.Second)
+	target := from.Add(7 * time.Second)
+	if ts, err := mgr.SeekPercent(70, false); err != nil || !ts.Equal(target) {
+		t.Fatalf("SeekPercent = %s, %v, want %s", ts, err, target)
+	}
+	waitForCond(t, time.Second, func() bool { return approxTime(mgr.Status().LastTS, target, time.Second) })
+	_ = mgr.Stop()
+}
+
+func TestManagerSnapshotValuesMatchStream(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(5 * time.Second)
+	registry := config.NewSensorRegistry()
+	for _, name := range []string{"Level_AS", "Pump_S"} {
+		if err := registry.Add(config.NewSensorKey(name, nil)); err != nil {
+			t.Fatalf("registry add: %v", err)
+		}
+	}
+	cfg := &config.Config{
+		SensorMeta: map[string]config.SensorMeta{
+			"Level_AS": {IOType: "AI", Calibration: &config.Calibration{Scale: 1.0 / 3}},
+			"Pump_S":   {IOType: "DI"},
+		},
+		Registry: registry,
+	}
+	level, pump := config.HashForName("Level_AS"), config.HashForName("Pump_S")
+	capture := &sharedmem.CaptureClient{}
+	svc := replay.Service{
+		Storage:           memstore.NewExampleStore([]int64{level, pump}, from, to, time.Second),
+		Output:            capture,
+		Calibration:       cfg.Calibrations(),
+		ValueRound:        2,
+		Discrete:          cfg.DiscreteSensors(),
+		DiscreteThreshold: 0.5,
+	}
+	mgr := NewManager(svc, nil, cfg, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+	target := from.Add(2 * time.Second)
+
+	// Значения снимка — те же, что уходят в SM и WebSocket на этом шаге.
+	want := map[string]float64{
+		"Level_AS": svc.FormatValue(level, float64(level%100)+2),
+		"Pump_S":   1,
+	}
+	if v := want["Level_AS"]; v != math.Round(v*100)/100 {
+		t.Fatalf("formatted value %v is not rounded", v)
+	}
+	snaps, err := mgr.SnapshotBatch(context.Background(), []time.Time{target}, nil, 0)
+	if err != nil {
+		t.Fatalf("SnapshotBatch: %v", err)
+	}
+	if !reflect.DeepEqual(snaps[0].Values, want) {
+		t.Fatalf("batch values = %v, want %v", snaps[0].Values, want)
+	}
+
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1, time.Second, false, WithStartPaused(true)); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"paused"}, 2*time.Second)
+	preview, err := mgr.SeekPreview(target)
+	if err != nil {
+		t.Fatalf("SeekPreview: %v", err)
+	}
+	if !reflect.DeepEqual(preview.Values, want) {
+		t.Fatalf("preview values = %v, want %v", preview.Values, want)
+	}
+	_ = mgr.Stop()
+}
+
+func TestManagerSnapshotBatch(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(5 * time.Second)
+	registry := config.NewSensorRegistry()
+	if err := registry.Add(config.NewSensorKey("Level_AS", nil)); err != nil {
+		t.Fatalf("registry add: %v", err)
+	}
+	cfg := &config.Config{
+		SensorMeta: map[string]config.SensorMeta{
+			"Level_AS": {IOType: "AI", Calibration: &config.Calibration{Scale: 2, Offset: 1}},
+		},
+		Registry: registry,
+	}
+	hash := config.HashForName("Level_AS")
+
+	store := memstore.NewExampleStore([]int64{hash}, from, to, time.Second)
+	svc := replay.Service{
+		Storage:     store,
+		Output:      &sharedmem.StdoutClient{Writer: io.Discard},
+		Calibration: cfg.Calibrations(),
+	}
+	mgr := NewManager(svc, []int64{hash}, cfg, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+
+	snaps, err := mgr.SnapshotBatch(context.Background(), []time.Time{from, from.Add(2 * time.Second)}, nil, 0)
+	if err != nil {
+		t.Fatalf("SnapshotBatch: %v", err)
+	}
+	if len(snaps) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(snaps))
+	}
+	v, ok := snaps[1].Values["Level_AS"]
+	if !ok {
+		t.Fatalf("values must be keyed by sensor name: %+v", snaps[1].Values)
+	}
+	raw := float64(hash%100) + 2
+	if v != raw*2+1 {
+		t.Fatalf("calibrated value = %v, want %v", v, raw*2+1)
+	}
+
+	// история заканчивается на to: через 10 с значение уже устарело для окна 2 с
+	snaps, err = mgr.SnapshotBatch(context.Background(), []time.Time{from.Add(2 * time.Second), from.Add(10 * time.Second)}, nil, 2*time.Second)
+	if err != nil {
+		t.Fatalf("SnapshotBatch with staleness: %v", err)
+	}
+	if _, ok := snaps[0].Values["Level_AS"]; !ok || snaps[0].Stale != nil {
+		t.Fatalf("fresh value must be kept: %+v", snaps[0])
+	}
+	if _, ok := snaps[1].Values["Level_AS"]; ok || len(snaps[1].Stale) != 1 || snaps[1].Stale[0] != "Level_AS" {
+		t.Fatalf("stale value must be flagged: %+v", snaps[1])
+	}
+}
+
+func TestManagerSetWorkingSensorsByGroups(t *testing.T) {
+	registry := config.NewSensorRegistry()
+	meta := map[string]config.SensorMeta{
+		"Pump1_S":  {Group: "Pumps"},
+		"Pump2_S":  {Group: "Pumps"},
+		"Valve1_S": {Group: "Valves"},
+		"Misc_S":   {},
+	}
+	for name := range meta {
+		if err := registry.Add(config.NewSensorKey(name, nil)); err != nil {
+			t.Fatalf("registry add: %v", err)
+		}
+	}
+	cfg := &config.Config{SensorMeta: meta, Registry: registry}
+	mgr := NewManager(replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}}, nil, cfg, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+
+	accepted, rejected, err := mgr.SetWorkingSensorsByGroups([]string{"pumps", "Unknown"})
+	if err != nil {
+		t.Fatalf("SetWorkingSensorsByGroups: %v", err)
+	}
+	if accepted != 2 || len(rejected) != 1 || rejected[0] != "Unknown" {
+		t.Fatalf("accepted=%d rejected=%v, want 2 and [Unknown]", accepted, rejected)
+	}
+	names := mgr.WorkingSensorNames()
+	if len(names) != 2 || names[0] != "Pump1_S" || names[1] != "Pump2_S" {
+		t.Fatalf("working sensors = %v", names)
+	}
+	if _, _, err := mgr.SetWorkingSensorsByGroups([]string{""}); err == nil {
+		t.Fatalf("sensors without group must not be selected by empty group")
+	}
+	if len(mgr.WorkingSensorNames()) != 2 {
+		t.Fatalf("failed selection must keep the working set")
+	}
+}
+
+func TestManagerSetWorkingSensorsByIOTypes(t *testing.T) {
+	registry := config.NewSensorRegistry()
+	meta := map[string]config.SensorMeta{
+		"Pump1_Run":   {Group: "Pumps", IOType: "di"},
+		"Pump1_Cmd":   {Group: "Pumps", IOType: "DO"},
+		"Pump1_Level": {Group: "Pumps", IOType: "AI"},
+		"DI_Door":     {Group: "Doors"}, // iotype по префиксу имени
+		"Temp1":       {},               // без префикса — AI
+	}
+	for name := range meta {
+		if err := registry.Add(config.NewSensorKey(name, nil)); err != nil {
+			t.Fatalf("registry add: %v", err)
+		}
+	}
+	cfg := &config.Config{SensorMeta: meta, Registry: registry}
+	mgr := NewManager(replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}}, nil, cfg, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+
+	if info, ok := mgr.lookupSensor("DI_Door"); !ok || info.IOType != "DI" {
+		t.Fatalf("DI_Door iotype = %q, want guessed DI", info.IOType)
+	}
+	if info, _ := mgr.lookupSensor("Pump1_Run"); info.IOType != "DI" {
+		t.Fatalf("Pump1_Run iotype = %q, want normalized DI", info.IOType)
+	}
+
+	accepted, rejected, err := mgr.SetWorkingSensorsByIOTypes([]string{"DI", "do"})
+	if err != nil || accepted != 3 || len(rejected) != 0 {
+		t.Fatalf("by iotypes: accepted=%d rejected=%v err=%v, want 3", accepted, rejected, err)
+	}
+
+	// Сочетание с группами: сначала группа, затем фильтр по типу.
+	if _, _, err := mgr.SetWorkingSensorsByGroups([]string{"Pumps"}); err != nil {
+		t.Fatalf("by groups: %v", err)
+	}
+	accepted, rejected, err = mgr.SetWorkingSensorsByIOTypes([]string{"DI", "AO"})
+	if err != nil || accepted != 1 || len(rejected) != 1 || rejected[0] != "AO" {
+		t.Fatalf("pumps by DI: accepted=%d rejected=%v err=%v, want 1 and [AO]", accepted, rejected, err)
+	}
+	if names := mgr.WorkingSensorNames(); len(names) != 1 || names[0] != "Pump1_Run" {
+		t.Fatalf("working sensors = %v, want [Pump1_Run]", names)
+	}
+	if _, _, err := mgr.SetWorkingSensorsByIOTypes([]string{"AI"}); err == nil {
+		t.Fatalf("filter without matches must fail")
+	}
+	if names := mgr.WorkingSensorNames(); len(names) != 1 {
+		t.Fatalf("failed filter must keep the working set, got %v", names)
+	}
+}
+
+func TestManagerSetWorkingSensorsByConfigIDs(t *testing.T) {
+	registry := config.NewSensorRegistry()
+	ids := map[string]int64{"Level_AS": 10, "Pump1_S": 11, "Valve1_S": 12}
+	for name, id := range ids {
+		id := id
+		if err := registry.Add(config.NewSensorKey(name, &id)); err != nil {
+			t.Fatalf("registry add: %v", err)
+		}
+	}
+	cfg := &config.Config{Registry: registry}
+	mgr := NewManager(replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}}, nil, cfg, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+
+	accepted, rejected, err := mgr.SetWorkingSensorsByConfigIDs([]int64{12, 10, 99, 12})
+	if err != nil || accepted != 2 || len(rejected) != 1 || rejected[0] != 99 {
+		t.Fatalf("by ids: accepted=%d rejected=%v err=%v, want 2 and [99]", accepted, rejected, err)
+	}
+	if names := mgr.WorkingSensorNames(); len(names) != 2 || names[0] != "Valve1_S" || names[1] != "Level_AS" {
+		t.Fatalf("working sensors = %v, want [Valve1_S Level_AS]", names)
+	}
+	// Hash датчика — не ID из конфига.
+	hash := config.NewSensorKey("Pump1_S", nil).Hash
+	if _, rejected, err := mgr.SetWorkingSensorsByConfigIDs([]int64{hash}); !errors.Is(err, errNoValidSensors) || len(rejected) != 1 {
+		t.Fatalf("hash as id: rejected=%v err=%v, want errNoValidSensors", rejected, err)
+	}
+	if len(mgr.WorkingSensorNames()) != 2 {
+		t.Fatalf("failed selection must keep the working set")
+	}
+}
+
+// warmupStorage отдаёт заданные значения warmup.
+type warmupStorage struct {
+	apiTestStorage
+	events []storage.SensorEvent
+}
+
+func (s *warmupStorage) Warmup(context.Context, []int64, time.Time) ([]storage.SensorEvent, error) {
+	return s.events, nil
+}
+
+func TestManagerStrictStartRequiresData(t *testing.T) {
+	registry := config.NewSensorRegistry()
+	for _, name := range []string{"Level_AS", "Pump1_S"} {
+		if err := registry.Add(config.NewSensorKey(name, nil)); err != nil {
+			t.Fatalf("registry add: %v", err)
+		}
+	}
+	level, _ := registry.ByName("Level_AS")
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := &warmupStorage{events: []storage.SensorEvent{{SensorID: level.Hash, Timestamp: from.Add(-time.Minute), Value: 1}}}
+	mgr := NewManager(replay.Service{Storage: store, Output: &apiTestClient{}}, nil, &config.Config{Registry: registry}, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+
+	// Все датчики есть в конфиге — без requireData старт разрешён.
+	mgr.SetStrictStart(true, false)
+	if err := mgr.checkStrictStart(context.Background(), from.Add(time.Hour)); err != nil {
+		t.Fatalf("strict check without data requirement: %v", err)
+	}
+
+	mgr.SetStrictStart(true, true)
+	err := mgr.Start(context.Background(), from, from.Add(time.Hour), time.Second, 1, 0, false)
+	var strict *strictStartError
+	if !errors.As(err, &strict) || strict.count != 1 || strict.sensors[0] != "Pump1_S" {
+		t.Fatalf("Start err = %v, want strict error for Pump1_S", err)
+	}
+	if mgr.Status().Status == "running" {
+		t.Fatalf("job must not start")
+	}
+}
+
+func TestManagerDefaultSet(t *testing.T) {
+	registry := config.NewSensorRegistry()
+	for _, name := range []string{"Pump1_S", "Pump2_S", "Valve1_S"} {
+		if err := registry.Add(config.NewSensorKey(name, nil)); err != nil {
+			t.Fatalf("registry add: %v", err)
+		}
+	}
+	cfg := &config.Config{Registry: registry, Sets: map[string][]string{"Pumps": {"Pump1_S", "Pump2_S"}}}
+	newMgr := func(set string) *Manager {
+		return NewManager(replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}}, nil, cfg, nil, ManagerOptions{DefaultSet: set, Speed: 1, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+	}
+
+	mgr := newMgr("Pumps")
+	if names := mgr.WorkingSensorNames(); len(names) != 2 || names[0] != "Pump1_S" || names[1] != "Pump2_S" {
+		t.Fatalf("initial working sensors = %v, want the Pumps set", names)
+	}
+	if len(mgr.SensorsInfo()) != 3 {
+		t.Fatalf("sensor dictionary must stay complete, got %d", len(mgr.SensorsInfo()))
+	}
+	if _, _, err := mgr.SetWorkingSensorsByNames([]string{"Valve1_S"}); err != nil {
+		t.Fatalf("SetWorkingSensorsByNames: %v", err)
+	}
+	mgr.Reset()
+	if names := mgr.WorkingSensorNames(); len(names) != 2 {
+		t.Fatalf("working sensors after reset = %v, want the Pumps set", names)
+	}
+
+	for _, set := range []string{"", "ALL", "Unknown"} {
+		if names := newMgr(set).WorkingSensorNames(); len(names) != 3 {
+			t.Fatalf("default set %q: working sensors = %v, want all", set, names)
+		}
+	}
+}
+
+func TestManagerCommandTimeout(t *testing.T) {
+	m := NewManager(
+		replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}},
+		[]int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 16, SaveAllowed: true, CommandTimeout: 20 * time.Millisecond},
+	)
+	// Задача, цикл которой не читает команды.
+	m.job = &job{status: "running", commands: make(chan replay.Command, 4)}
+
+	start := time.Now()
+	err := m.Pause()
+	if !errors.Is(err, errCommandTimeout) {
+		t.Fatalf("pause err = %v, want command timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("pause waited %s, want ~20ms", elapsed)
+	}
+
+	start = time.Now()
+	if err := m.Seek(time.Now(), false); err == nil {
+		t.Fatalf("seek must time out")
+	}
+	if elapsed := time.Since(start); elapsed < 4*20*time.Millisecond {
+		t.Fatalf("seek waited %s, want extended timeout", elapsed)
+	}
+
+	if d := NewManager(replay.Service{}, nil, nil, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 1}).commandTimeout; d != defaultCommandTimeout {
+		t.Fatalf("default command timeout = %s, want %s", d, defaultCommandTimeout)
+	}
+}
+
+func TestManagerWorkingSetFiltersStreamer(t *testing.T) {
+	streamer := NewStateStreamer(time.Hour)
+	m := NewManager(
+		replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}},
+		[]int64{1, 2, 3}, nil, streamer, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 16, SaveAllowed: true},
+	)
+	if msg := streamer.snapshotMessage(nil); msg.WorkingCount != 3 || len(msg.Updates) != 3 {
+		t.Fatalf("initial snapshot working_count=%d rows=%d, want 3", msg.WorkingCount, len(msg.Updates))
+	}
+
+	if _, _, err := m.SetWorkingSensors([]int64{2}); err != nil {
+		t.Fatalf("SetWorkingSensors: %v", err)
+	}
+	streamer.Publish(replay.StepInfo{StepID: 1}, []sharedmem.SensorUpdate{{Hash: 1, Value: 10}, {Hash: 2, Value: 20}})
+	msg := streamer.snapshotMessage(nil)
+	if msg.WorkingCount != 1 || len(msg.Updates) != 1 || msg.Updates[0].Name != "hash2" || msg.Updates[0].Value != 20 {
+		t.Fatalf("filtered snapshot = %+v", msg)
+	}
+	streamer.mu.RLock()
+	_, leaked := streamer.batchRows["hash1"]
+	streamer.mu.RUnlock()
+	if leaked {
+		t.Fatalf("update of non-working sensor must not be published")
+	}
+
+	m.Reset()
+	if msg := streamer.snapshotMessage(nil); msg.WorkingCount != 3 {
+		t.Fatalf("after reset working_count=%d, want 3", msg.WorkingCount)
+	}
+}
+
+func TestStreamerBatchMaxFlushesEarly(t *testing.T) {
+	streamer := NewStateStreamer(time.Hour)
+	streamer.SetBatchMax(2)
+	client := &wsClient{send: make(chan []byte, 8)}
+	streamer.clients[client] = struct{}{}
+
+	streamer.Publish(replay.StepInfo{StepID: 1}, []sharedmem.SensorUpdate{{Hash: 1, Value: 1}})
+	if len(client.send) != 0 {
+		t.Fatalf("batch below limit must wait for timer")
+	}
+	streamer.Publish(replay.StepInfo{StepID: 2}, []sharedmem.SensorUpdate{{Hash: 2, Value: 2}, {Hash: 3, Value: 3}, {Hash: 4, Value: 4}})
+	if len(client.send) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(client.send))
+	}
+	names := map[string]bool{}
+	for i := 1; i <= 2; i++ {
+		var msg wsMessage
+		if err := json.Unmarshal(<-client.send, &msg); err != nil {
+			t.Fatalf("decode frame: %v", err)
+		}
+		if msg.Type != "updates" || msg.StepID != 2 || msg.BatchID != i || msg.BatchTotal != 2 || len(msg.U) != 2 {
+			t.Fatalf("frame %d = %+v", i, msg)
+		}
+		for name := range msg.U {
+			names[name] = true
+		}
+	}
+	if len(names) != 4 {
+		t.Fatalf("expected 4 distinct sensors across frames, got %v", names)
+	}
+	streamer.mu.RLock()
+	pending, timer := len(streamer.batchRows), streamer.batchTimer
+	streamer.mu.RUnlock()
+	if pending != 0 || timer != nil {
+		t.Fatalf("batch must be reset after early flush")
+	}
+}
+
+func TestStreamerClientView(t *testing.T) {
+	streamer := NewStateStreamer(time.Hour)
+	streamer.Reset(map[int64]SensorInfo{1: {Hash: 1, Name: "a"}, 2: {Hash: 2, Name: "b"}, 3: {Hash: 3, Name: "c"}})
+	all := &wsClient{send: make(chan []byte, 8)}
+	viewer := &wsClient{send: make(chan []byte, 8), view: map[int64]struct{}{2: {}}}
+	streamer.clients[all] = struct{}{}
+	streamer.clients[viewer] = struct{}{}
+
+	// working_count — размер рабочего набора, а не отфильтрованных строк.
+	if msg := streamer.snapshotMessage(viewer.view); msg.WorkingCount != 3 || len(msg.Updates) != 1 || msg.Updates[0].Name != "b" {
+		t.Fatalf("viewer snapshot = %+v", msg)
+	}
+	streamer.Publish(replay.StepInfo{StepID: 1}, []sharedmem.SensorUpdate{{Hash: 1, Value: 1}, {Hash: 3, Value: 3}})
+	streamer.flushBatch()
+	if len(all.send) != 1 || len(viewer.send) != 0 {
+		t.Fatalf("frames all=%d viewer=%d, want 1/0", len(all.send), len(viewer.send))
+	}
+	<-all.send
+
+	streamer.Publish(replay.StepInfo{StepID: 2}, []sharedmem.SensorUpdate{{Hash: 1, Value: 10}, {Hash: 2, Value: 20}})
+	streamer.flushBatch()
+	var full, filtered wsMessage
+	if err := json.Unmarshal(<-all.send, &full); err != nil || len(full.U) != 2 {
+		t.Fatalf("unfiltered updates = %+v %v", full, err)
+	}
+	if err := json.Unmarshal(<-viewer.send, &filtered); err != nil || len(filtered.U) != 1 || filtered.StepID != 2 {
+		t.Fatalf("filtered updates = %+v %v", filtered, err)
+	}
+	if _, ok := filtered.U["b"]; !ok {
+		t.Fatalf("filtered updates must contain only b: %+v", filtered.U)
+	}
+}
+
+func TestStreamerAlert(t *testing.T) {
+	streamer := NewStateStreamer(time.Hour)
+	client := &wsClient{send: make(chan []byte, 8)}
+	streamer.clients[client] = struct{}{}
+	step := replay.StepInfo{
+		StepID:     3,
+		StepTs:     time.Date(2024, 6, 1, 0, 0, 2, 0, time.UTC),
+		Violations: []replay.LimitViolation{{Hash: 7, Value: 0, Limit: 5, Bound: "min"}},
+	}
+
+	streamer.Publish(step, []sharedmem.SensorUpdate{{Hash: 7, Value: 0}})
+	streamer.Alert(step)
+	if len(client.send) != 0 {
+		t.Fatalf("alerts are disabled by default")
+	}
+
+	streamer.SetAlerts(true)
+	streamer.Alert(step)
+	if len(client.send) != 2 {
+		t.Fatalf("expected updates + alert frames, got %d", len(client.send))
+	}
+	var updates, alert wsMessage
+	if err := json.Unmarshal(<-client.send, &updates); err != nil || updates.Type != "updates" {
+		t.Fatalf("pending batch must be flushed before alert: %+v %v", updates, err)
+	}
+	raw := <-client.send
+	if err := json.Unmarshal(raw, &alert); err != nil {
+		t.Fatalf("decode alert: %v", err)
+	}
+	if alert.Type != "alert" || alert.ID != 7 || alert.Name != "hash7" || alert.Bound != "min" || alert.StepID != 3 {
+		t.Fatalf("alert = %+v", alert)
+	}
+	// нулевое значение не должно теряться из-за omitempty
+	if alert.Value == nil || *alert.Value != 0 || alert.Limit == nil || *alert.Limit != 5 {
+		t.Fatalf("alert value/limit = %s", raw)
+	}
+}
+
+func TestStreamerInitialSnapshot(t *testing.T) {
+	streamer := NewStateStreamer(time.Hour)
+	streamer.Reset(map[int64]SensorInfo{1: {Hash: 1, Name: "a"}, 2: {Hash: 2, Name: "b"}, 3: {Hash: 3, Name: "c"}})
+	streamer.SetWorkingSensors([]int64{1, 2})
+	client := &wsClient{send: make(chan []byte, 8)}
+	streamer.clients[client] = struct{}{}
+	ts := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	streamer.InitialSnapshot(replay.StepInfo{StepTs: ts}, []sharedmem.SensorUpdate{{Hash: 1, Value: 5}, {Hash: 2, NoData: true}, {Hash: 3, Value: 7}})
+	if len(client.send) != 1 {
+		t.Fatalf("expected one snapshot frame, got %d", len(client.send))
+	}
+	var msg wsMessage
+	if err := json.Unmarshal(<-client.send, &msg); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if msg.Type != "snapshot" || msg.WorkingCount != 2 || len(msg.Updates) != 2 || msg.StepTs != formatTime(ts) {
+		t.Fatalf("snapshot = %+v", msg)
+	}
+	if a, b := msg.Updates[0], msg.Updates[1]; a.Name != "a" || !a.HasValue || a.Value != 5 || b.Name != "b" || b.HasValue {
+		t.Fatalf("snapshot rows = %+v", msg.Updates)
+	}
+}
+
+func TestManagerEmitsDoneMessage(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Second)
+	streamer := NewStateStreamer(time.Hour)
+	client := &wsClient{send: make(chan []byte, 64)}
+	streamer.clients[client] = struct{}{}
+	svc := replay.Service{
+		Storage: memstore.NewExampleStore([]int64{1, 2}, from, to, time.Second),
+		Output:  &sharedmem.StdoutClient{Writer: io.Discard},
+	}
+	mgr := NewManager(svc, []int64{1, 2}, nil, streamer, ManagerOptions{Speed: 1000, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+
+	waitDone := func() wsMessage {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case data := <-client.send:
+				var msg wsMessage
+				if err := json.Unmarshal(data, &msg); err != nil {
+					t.Fatalf("decode frame: %v", err)
+				}
+				if msg.Type == "done" {
+					return msg
+				}
+			case <-deadline:
+				t.Fatalf("done message was not sent")
+			}
+		}
+	}
+
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1000, time.Second, false); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if msg := waitDone(); msg.Reason != DoneCompleted || msg.StepID != 3 || msg.Error != "" {
+		t.Fatalf("completed done = %+v", msg)
+	}
+
+	if err := mgr.Start(context.Background(), from, to, time.Second, 0.001, time.Second, false); err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	if err := mgr.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if msg := waitDone(); msg.Reason != DoneStopped {
+		t.Fatalf("stopped done = %+v", msg)
+	}
+}
+
 func TestManagerControlRequireClaimKeepAlive(t *testing.T) {
 	timeout := 200 * time.Millisecond
 	m := NewManager(
 		replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}},
-		[]int64{1}, nil, 1, time.Second, 16, nil, true, false, timeout,
+		[]int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 16, SaveAllowed: true, ControlTimeout: timeout},
 	)
 
 	// Пустой токен.
@@ -300,7 +978,7 @@ func TestManagerStepBackwardApplyPaused(t *testing.T) {
 	var capClient captureClient
 	client := &capClient
 	svc := replay.Service{Storage: store, Output: client}
-	mgr := NewManager(svc, []int64{1}, nil, 1, step, 8, nil, true, false, 0)
+	mgr := NewManager(svc, []int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: step, BatchSize: 8, SaveAllowed: true})
 
 	if err := mgr.Start(context.Background(), from, to, step, 1, step, true); err != nil {
 		t.Fatalf("start: %v", err)
@@ -312,7 +990,7 @@ func TestManagerStepBackwardApplyPaused(t *testing.T) {
 	}
 	waitManagerStatus(t, mgr, []string{"paused"}, 2*time.Second)
 	before := mgr.Status().LastTS
-	if err := mgr.StepBackward(true); err != nil {
+	if err := mgr.StepBackward(1, true); err != nil {
 		t.Fatalf("step back apply: %v", err)
 	}
 	waitForCond(t, 2*time.Second, func() bool {
@@ -325,6 +1003,45 @@ func TestManagerStepBackwardApplyPaused(t *testing.T) {
 	_ = mgr.Stop()
 }
 
+func TestManagerStepByCount(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	step := time.Second
+	to := from.Add(time.Minute)
+
+	store := memstore.NewExampleStore([]int64{1}, from, to, step)
+	svc := replay.Service{Storage: store, Output: &captureClient{}}
+	mgr := NewManager(svc, []int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: step, BatchSize: 8, SaveAllowed: true})
+
+	if err := mgr.Start(context.Background(), from, to, step, 1, step, true); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"running"}, 2*time.Second)
+	if err := mgr.Pause(); err != nil {
+		t.Fatalf("pause: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"paused"}, 2*time.Second)
+
+	if err := mgr.Seek(from.Add(20*step), false); err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	if err := mgr.StepForward(10, false); err != nil {
+		t.Fatalf("step forward 10: %v", err)
+	}
+	waitForCond(t, 2*time.Second, func() bool { return mgr.Status().LastTS.Equal(from.Add(30 * step)) })
+	if err := mgr.StepBackward(5, false); err != nil {
+		t.Fatalf("step backward 5: %v", err)
+	}
+	waitForCond(t, 2*time.Second, func() bool { return mgr.Status().LastTS.Equal(from.Add(25 * step)) })
+	if err := mgr.StepBackward(1000, false); err != nil {
+		t.Fatalf("step backward past from: %v", err)
+	}
+	waitForCond(t, 2*time.Second, func() bool { return mgr.Status().LastTS.Equal(from) })
+	if st := mgr.Status(); st.Status != "paused" {
+		t.Fatalf("status after steps = %q, want paused", st.Status)
+	}
+	_ = mgr.Stop()
+}
+
 func TestManagerStopFromPausedAndRunning(t *testing.T) {
 	mgr := newTestManager(t)
 	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
@@ -384,7 +1101,7 @@ func TestManagerDefaultsApplied(t *testing.T) {
 		Storage: store,
 		Output:  &sharedmem.StdoutClient{Writer: io.Discard},
 	}
-	mgr := NewManager(svc, []int64{1}, nil, 0, 0, 4, nil, false, false, 0)
+	mgr := NewManager(svc, []int64{1}, nil, nil, ManagerOptions{BatchSize: 4})
 	if err := mgr.Start(context.Background(), from, to, step, 0, 0, true); err != nil {
 		t.Fatalf("start with defaults: %v", err)
 	}
@@ -392,7 +1109,8 @@ func TestManagerDefaultsApplied(t *testing.T) {
 	if st.Params.SaveOutput {
 		t.Fatalf("save_output should be false when saveAllowed is false")
 	}
-	if st.Params.Step != step || st.Params.Window <= 0 || st.Params.Speed <= 0 {
+	// window 0 без значения по умолчанию остаётся 0 — автоподбор окна хранилищем.
+	if st.Params.Step != step || st.Params.Window != 0 || st.Params.Speed <= 0 {
 		t.Fatalf("defaults not applied: %#v", st.Params)
 	}
 	_ = mgr.Stop()
@@ -456,6 +1174,430 @@ func TestManagerStartPendingWithSeekResume(t *testing.T) {
 	_ = mgr.Stop()
 }
 
+func TestManagerStartPendingPaused(t *testing.T) {
+	mgr := newTestManager(t)
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(4 * time.Second)
+	seekTs := from.Add(2 * time.Second)
+
+	mgr.SetRange(from, to, time.Second, 1000, time.Second, false)
+	mgr.SetPendingSeek(seekTs)
+	if err := mgr.StartPending(context.Background(), WithStartPaused(true)); err != nil {
+		t.Fatalf("StartPending: %v", err)
+	}
+	waitForCond(t, 2*time.Second, func() bool { return mgr.Status().LastTS.Equal(seekTs) })
+	time.Sleep(50 * time.Millisecond)
+	if st := mgr.Status(); st.Status != "paused" || !st.LastTS.Equal(seekTs) {
+		t.Fatalf("status = %s at %s, want paused at %s", st.Status, st.LastTS, seekTs)
+	}
+	if err := mgr.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 2*time.Second)
+}
+
+func TestManagerPlayUntil(t *testing.T) {
+	mgr := newTestManager(t)
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(5 * time.Second)
+
+	mgr.SetRange(from, to, time.Second, 1000, time.Second, false)
+	if err := mgr.PlayUntil(context.Background(), to.Add(time.Minute)); !errors.Is(err, errPlayUntilRange) {
+		t.Fatalf("expected out-of-range error, got %v", err)
+	}
+	if err := mgr.PlayUntil(context.Background(), from.Add(2*time.Second)); err != nil {
+		t.Fatalf("PlayUntil from pending: %v", err)
+	}
+	waitForCond(t, 2*time.Second, func() bool { return mgr.Status().Status == "paused" })
+	if got := mgr.Status().LastTS; !got.Equal(from.Add(2 * time.Second)) {
+		t.Fatalf("paused at %s, want %s", got, from.Add(2*time.Second))
+	}
+
+	// Из паузы активной задачи — до следующей цели.
+	if err := mgr.PlayUntil(context.Background(), from.Add(4*time.Second)); err != nil {
+		t.Fatalf("PlayUntil on active job: %v", err)
+	}
+	waitForCond(t, 2*time.Second, func() bool {
+		st := mgr.Status()
+		return st.Status == "paused" && st.LastTS.Equal(from.Add(4*time.Second))
+	})
+	_ = mgr.Stop()
+}
+
+func TestManagerCacheStats(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(30 * time.Second)
+	svc := replay.Service{
+		Storage: memstore.NewExampleStore([]int64{1, 2}, from, to, time.Second),
+		Output:  &sharedmem.StdoutClient{Writer: io.Discard},
+	}
+	mgr := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1000, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+
+	mgr.SetRange(from, to, time.Second, 1000, time.Second, false)
+	if err := mgr.PlayUntil(context.Background(), from.Add(25*time.Second)); err != nil {
+		t.Fatalf("PlayUntil: %v", err)
+	}
+	waitForCond(t, 2*time.Second, func() bool { return mgr.Status().Status == "paused" })
+
+	// В кэше последние 16 шагов: 20s — точное попадание, 20.5s — догон от 20s, 2s — восстановление по истории.
+	for _, target := range []time.Duration{20 * time.Second, 20*time.Second + 500*time.Millisecond, 2 * time.Second} {
+		if err := mgr.Seek(from.Add(target), false); err != nil {
+			t.Fatalf("seek %s: %v", target, err)
+		}
+	}
+	cache := mgr.Status().Cache
+	if cache.ExactHits != 1 || cache.LEHits != 1 || cache.Rebuilds != 1 || cache.Limit != 16 || cache.Entries != 16 {
+		t.Fatalf("cache stats = %+v, want 1 exact, 1 le, 1 rebuild, 16/16 entries", cache)
+	}
+	_ = mgr.Stop()
+}
+
+func TestManagerContinueFromStashedPosition(t *testing.T) {
+	mgr := newTestManager(t)
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(5 * time.Second)
+
+	if err := mgr.Continue(context.Background()); !errors.Is(err, errNoStashedPos) {
+		t.Fatalf("expected no stashed position error, got %v", err)
+	}
+
+	mgr.SetRange(from, to, time.Second, 1000, time.Second, false)
+	if err := mgr.PlayUntil(context.Background(), from.Add(2*time.Second)); err != nil {
+		t.Fatalf("PlayUntil: %v", err)
+	}
+	waitForCond(t, 2*time.Second, func() bool { return mgr.Status().Status == "paused" })
+	if err := mgr.Stop(); err != nil && err.Error() != (replay.ErrStopped{}).Error() {
+		t.Fatalf("stop returned error: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 2*time.Second)
+	if pending := mgr.PendingState(); !pending.SeekSet || !pending.SeekTS.Equal(from.Add(2*time.Second)) {
+		t.Fatalf("stashed position = %+v, want seek to %s", pending, from.Add(2*time.Second))
+	}
+
+	if err := mgr.Continue(context.Background()); err != nil {
+		t.Fatalf("Continue: %v", err)
+	}
+	if last := mgr.Status().LastTS; last.Before(from.Add(2 * time.Second)) {
+		t.Fatalf("continue restarted from %s, want stashed position", last)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 2*time.Second)
+}
+func TestManagerContinuePlaylist(t *testing.T) {
+	mgr := newTestManager(t)
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	segments := []replay.Segment{
+		{From: from, To: from.Add(10 * time.Second)},
+		{From: from.Add(time.Minute), To: from.Add(time.Minute + 10*time.Second)},
+	}
+	// Сквозные шаги: 1..10 — первый сегмент, 11..20 — второй, разрыв шагов не имеет.
+	mgr.SetRange(from, segments[1].To, time.Second, 1, time.Second, false, WithSegments(segments))
+	for step, want := range map[int64]time.Time{1: from, 10: from.Add(9 * time.Second), 11: segments[1].From, 20: from.Add(time.Minute + 9*time.Second)} {
+		if ts, err := mgr.StepTimestamp(step); err != nil || !ts.Equal(want) {
+			t.Fatalf("StepTimestamp(%d) = %s, %v, want %s", step, ts, err, want)
+		}
+	}
+	if _, err := mgr.StepTimestamp(21); !errors.Is(err, errStepOutOfRange) {
+		t.Fatalf("StepTimestamp(21) err = %v, want %v", err, errStepOutOfRange)
+	}
+	if ts, err := mgr.PercentTimestamp(100); err != nil || !ts.Equal(from.Add(time.Minute+9*time.Second)) {
+		t.Fatalf("PercentTimestamp(100) = %s, %v, want the last step", ts, err)
+	}
+
+	if err := mgr.StartPending(context.Background(), WithStartPaused(true)); err != nil {
+		t.Fatalf("StartPending: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"paused"}, 2*time.Second)
+	if st := mgr.Status(); st.SegmentCount != 2 {
+		t.Fatalf("pending playlist started with %d segments, want 2", st.SegmentCount)
+	}
+	// Seek по номеру шага переходит во второй сегмент.
+	target := from.Add(time.Minute + 3*time.Second)
+	if ts, err := mgr.SeekStep(14, false); err != nil || !ts.Equal(target) {
+		t.Fatalf("SeekStep(14) = %s, %v, want %s", ts, err, target)
+	}
+	if st := mgr.Status(); st.SegmentIndex != 1 || !st.LastTS.Equal(target) {
+		t.Fatalf("after SeekStep segment=%d last_ts=%s, want 1 at %s", st.SegmentIndex, st.LastTS, target)
+	}
+	if err := mgr.Seek(from.Add(30*time.Second), false); !errors.Is(err, errSeekInGap) {
+		t.Fatalf("seek into the gap err = %v, want %v", err, errSeekInGap)
+	}
+	if err := mgr.Stop(); err != nil && err.Error() != (replay.ErrStopped{}).Error() {
+		t.Fatalf("stop returned error: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 2*time.Second)
+
+	// Continue поднимает тот же плейлист и возвращается во второй сегмент, а не в разрыв.
+	if err := mgr.Continue(context.Background()); err != nil {
+		t.Fatalf("Continue: %v", err)
+	}
+	st := mgr.Status()
+	if st.SegmentCount != 2 || st.SegmentIndex != 1 || st.LastTS.Before(target) || st.StepID < 14 {
+		t.Fatalf("continue = segments %d index %d last_ts %s step %d, want segment 1 from %s (step 14)", st.SegmentCount, st.SegmentIndex, st.LastTS, st.StepID, target)
+	}
+	_ = mgr.Stop()
+}
+
+func TestManagerBestEffortSendCountsErrors(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Second)
+	svc := replay.Service{
+		Storage: memstore.NewExampleStore([]int64{1, 2}, from, to, time.Second),
+		Output:  &failingClientForManagerTest{},
+	}
+	mgr := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1000, Window: time.Second, BatchSize: 1, SaveAllowed: true})
+
+	// Без best-effort первая же ошибка отправки завершает задачу.
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1000, time.Second, true); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if st := waitManagerStatus(t, mgr, []string{"done", "failed"}, 5*time.Second); st != "failed" {
+		t.Fatalf("fail-fast status = %s, want failed", st)
+	}
+
+	mgr.SetBestEffort(true)
+
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1000, time.Second, true); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if st := waitManagerStatus(t, mgr, []string{"done", "failed"}, 5*time.Second); st != "done" {
+		t.Fatalf("status = %s, want done (error %q)", st, mgr.Status().Error)
+	}
+	st := mgr.Status()
+	// 3 шага по 2 датчика, батчи по одному обновлению: каждый отклонён SM.
+	if st.SendErrors != 6 || st.LastSendError != "sm unavailable" {
+		t.Fatalf("send errors = %d (%q), want 6", st.SendErrors, st.LastSendError)
+	}
+}
+
+func TestManagerOutputSelection(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Second)
+	client := &captureClientForManagerTest{}
+	svc := replay.Service{
+		Storage: memstore.NewExampleStore([]int64{1, 2}, from, to, time.Second),
+		Output:  client,
+	}
+	mgr := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1000, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+
+	// ui_only: задача идёт с save_output, но в SM ничего не уходит.
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1000, time.Second, true, WithOutput(OutputUIOnly)); err != nil {
+		t.Fatalf("start ui_only: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 5*time.Second)
+	if st := mgr.Status(); st.StepID == 0 || st.Params.Output != OutputUIOnly {
+		t.Fatalf("ui_only status = %+v, want steps and output ui_only", st)
+	}
+	if got := len(client.Payloads()); got != 0 {
+		t.Fatalf("ui_only sent %d payloads to SM, want 0", got)
+	}
+
+	// Выбор сохраняется в отложенном диапазоне и действует при StartPending.
+	mgr.SetRange(from, to, time.Second, 1000, time.Second, true, WithOutput(OutputSM))
+	if err := mgr.StartPending(context.Background()); err != nil {
+		t.Fatalf("start pending sm: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 5*time.Second)
+	if len(client.Payloads()) == 0 {
+		t.Fatalf("sm output: no payloads sent")
+	}
+
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1000, time.Second, true, WithOutput("bogus")); !errors.Is(err, errUnknownOutput) {
+		t.Fatalf("unknown output err = %v, want errUnknownOutput", err)
+	}
+
+	// Без разрешения сохранения клиент SM не регистрируется.
+	noSave := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1000, Window: time.Second, BatchSize: 8})
+	if err := noSave.CheckOutput(OutputSM); !errors.Is(err, errUnknownOutput) {
+		t.Fatalf("sm without save allowed err = %v, want errUnknownOutput", err)
+	}
+	if err := noSave.CheckOutput(OutputUIOnly); err != nil {
+		t.Fatalf("ui_only check: %v", err)
+	}
+}
+
+func TestManagerStepAggregate(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Second)
+	svc := replay.Service{
+		Storage: memstore.NewExampleStore([]int64{1, 2}, from, to, time.Second),
+		Output:  &captureClientForManagerTest{},
+	}
+	mgr := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1000, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+	if got := mgr.RuntimeDefaults().StepAggregate; got != "last" {
+		t.Fatalf("default step aggregate = %q, want last", got)
+	}
+
+	// Значение из запроса нормализуется и сохраняется в отложенном диапазоне.
+	mgr.SetRange(from, to, time.Second, 1000, time.Second, false, WithStepAggregate("AVG"))
+	if err := mgr.StartPending(context.Background()); err != nil {
+		t.Fatalf("start pending: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 5*time.Second)
+	if got := mgr.Status().Params.StepAggregate; got != replay.AggregateAvg {
+		t.Fatalf("params step aggregate = %q, want avg", got)
+	}
+
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1000, time.Second, false, WithStepAggregate("median")); err == nil {
+		t.Fatalf("start with unknown step aggregate: want error")
+	}
+}
+
+func TestManagerDone(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Second)
+	svc := replay.Service{
+		Storage: memstore.NewExampleStore([]int64{1, 2}, from, to, time.Second),
+		Output:  &captureClientForManagerTest{},
+	}
+	mgr := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1000, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+	if mgr.Done() != nil {
+		t.Fatalf("done channel without job: want nil")
+	}
+	if err := mgr.Start(context.Background(), from, to, time.Second, 1000, time.Second, false); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	select {
+	case <-mgr.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("done channel not closed after job finished")
+	}
+	if st := mgr.Status().Status; st != "done" {
+		t.Fatalf("status = %s, want done", st)
+	}
+}
+
+func TestManagerNoPace(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Hour)
+	svc := replay.Service{
+		Storage: memstore.NewExampleStore([]int64{1, 2}, from, to, time.Hour),
+		Output:  &captureClientForManagerTest{},
+	}
+	mgr := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1, Window: time.Hour, BatchSize: 8, SaveAllowed: true})
+	// Шаг в час при скорости 1: задача завершается сразу только без пауз между шагами.
+	mgr.SetRange(from, to, time.Hour, 1, time.Hour, false, WithNoPace(true))
+	if err := mgr.StartPending(context.Background()); err != nil {
+		t.Fatalf("start pending: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 5*time.Second)
+	if st := mgr.Status(); !st.Params.NoPace || st.StepID != 3 {
+		t.Fatalf("status = %+v, want 3 steps with no_pace", st)
+	}
+}
+
+func TestManagerStartDelay(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Hour)
+	svc := replay.Service{
+		Storage: memstore.NewExampleStore([]int64{1, 2}, from, to, time.Hour),
+		Output:  &captureClientForManagerTest{},
+	}
+	mgr := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1, Window: time.Hour, BatchSize: 8, SaveAllowed: true})
+	mgr.SetStartDelay(time.Hour)
+	if d := mgr.RuntimeDefaults().StartDelay; d != "1h0m0s" {
+		t.Fatalf("runtime start_delay = %q, want 1h0m0s", d)
+	}
+	if err := mgr.Start(context.Background(), from, to, time.Hour, 1, time.Hour, false, WithNoPace(true)); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	// Задача ждёт выдержку и не проигрывает шагов, но stop срабатывает сразу.
+	time.Sleep(50 * time.Millisecond)
+	if st := mgr.Status(); st.Params.StartDelay != time.Hour || st.StepID != 0 {
+		t.Fatalf("status = %+v, want no steps during the start delay", st)
+	}
+	if err := mgr.Stop(); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 5*time.Second)
+}
+
+func TestManagerActiveHours(t *testing.T) {
+	monday := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	from, to := monday.Add(17*time.Hour), monday.Add(34*time.Hour)
+	svc := replay.Service{
+		Storage: memstore.NewExampleStore([]int64{1, 2}, from, to, time.Hour),
+		Output:  &captureClientForManagerTest{},
+	}
+	mgr := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1, Window: time.Hour, BatchSize: 8, SaveAllowed: true})
+	hours, err := replay.ParseActiveHours("08:00-18:00", "", nil)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	mgr.SetActiveHours(&hours)
+	if err := mgr.Start(context.Background(), from, to, time.Hour, 1, time.Hour, false, WithNoPace(true)); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitManagerStatus(t, mgr, []string{"done"}, 5*time.Second)
+	st := mgr.Status()
+	if st.SkippedSpans != 1 || st.SkippedSec != (14*time.Hour).Seconds() {
+		t.Fatalf("skipped = %d spans / %vs, want 1 span of 14h", st.SkippedSpans, st.SkippedSec)
+	}
+	if !st.LastTS.Equal(monday.Add(33*time.Hour)) || st.StepID != 17 {
+		t.Fatalf("last step = %d at %s, want step 17 at Tuesday 09:00", st.StepID, st.LastTS)
+	}
+}
+
+// warmupCountingStorage считает запросы Warmup к хранилищу.
+type warmupCountingStorage struct {
+	storage.Storage
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *warmupCountingStorage) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]storage.SensorEvent, error) {
+	s.mu.Lock()
+	s.calls++
+	s.mu.Unlock()
+	return s.Storage.Warmup(ctx, sensors, from)
+}
+
+func (s *warmupCountingStorage) Calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestManagerWarmStart(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := &warmupCountingStorage{Storage: memstore.NewExampleStore([]int64{1, 2}, from, from.Add(10*time.Second), time.Second)}
+	svc := replay.Service{Storage: store, Output: &captureClientForManagerTest{}}
+	mgr := NewManager(svc, []int64{1, 2}, nil, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 8, SaveAllowed: true})
+	mgr.SetWarmStart(true)
+	run := func(start time.Time) Status {
+		t.Helper()
+		if err := mgr.Start(context.Background(), start, start.Add(3*time.Second), time.Second, 1, time.Second, false, WithNoPace(true)); err != nil {
+			t.Fatalf("start at %s: %v", start, err)
+		}
+		waitManagerStatus(t, mgr, []string{"done"}, 5*time.Second)
+		return mgr.Status()
+	}
+
+	if st := run(from); st.WarmStart || store.Calls() != 1 {
+		t.Fatalf("first job: warm_start=%v warmup calls=%d, want cold start", st.WarmStart, store.Calls())
+	}
+	// Следующая задача с последнего шага предыдущей берёт её состояние без запроса к БД.
+	if st := run(from.Add(2 * time.Second)); !st.WarmStart || store.Calls() != 1 {
+		t.Fatalf("continued job: warm_start=%v warmup calls=%d, want warm start", st.WarmStart, store.Calls())
+	}
+	// Другая точка старта — обычный warmup.
+	if st := run(from.Add(5 * time.Second)); st.WarmStart || store.Calls() != 2 {
+		t.Fatalf("job with other from: warm_start=%v warmup calls=%d, want cold start", st.WarmStart, store.Calls())
+	}
+	// Изменённый набор датчиков тоже не подходит.
+	if _, _, err := mgr.SetWorkingSensors([]int64{1}); err != nil {
+		t.Fatalf("set working sensors: %v", err)
+	}
+	if st := run(from.Add(7 * time.Second)); st.WarmStart || store.Calls() != 3 {
+		t.Fatalf("job with other sensors: warm_start=%v warmup calls=%d, want cold start", st.WarmStart, store.Calls())
+	}
+}
+
+type failingClientForManagerTest struct{}
+
+func (failingClientForManagerTest) Send(context.Context, sharedmem.StepPayload) error {
+	return errors.New("sm unavailable")
+}
+
 // captureClientForManagerTest is a local copy to avoid import cycle with http_sqlite_test.
 type captureClientForManagerTest struct {
 	mu       sync.Mutex
@@ -523,11 +1665,64 @@ func normalizeStatus(s string) string {
 	return strings.ToLower(strings.TrimSpace(s))
 }
 
+func TestManagerControlReaperReleasesStaleController(t *testing.T) {
+	timeout := 100 * time.Millisecond
+	m := NewManager(
+		replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}},
+		[]int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 1, SaveAllowed: true, ControlTimeout: timeout},
+	)
+	if st := m.SessionStatus("a"); st.KeepaliveIntervalSec != 1 {
+		t.Fatalf("keepalive interval = %d, want 1 (at least a second)", st.KeepaliveIntervalSec)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.RunControlReaper(ctx)
+
+	if err := m.RequireControl("a"); err != nil {
+		t.Fatalf("RequireControl: %v", err)
+	}
+	if present, _ := m.ControlStatus(); !present {
+		t.Fatalf("controller must be present right after claim")
+	}
+	waitForCond(t, time.Second, func() bool {
+		present, _ := m.ControlStatus()
+		return !present
+	})
+	if st := m.SessionStatus("b"); st.ControllerPresent || !st.CanClaim {
+		t.Fatalf("stale controller must be released: %+v", st)
+	}
+}
+
+func TestManagerIdleStopStopsAbandonedJob(t *testing.T) {
+	timeout := 100 * time.Millisecond
+	m := NewManager(
+		replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}},
+		[]int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 1, SaveAllowed: true, ControlTimeout: timeout},
+	)
+	m.SetIdleStop(true)
+	if err := m.RequireControl("a"); err != nil {
+		t.Fatalf("RequireControl: %v", err)
+	}
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := m.Start(context.Background(), from, from.Add(time.Hour), time.Second, 1, time.Second, false); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	waitManagerStatus(t, m, []string{"running"}, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.RunControlReaper(ctx)
+	waitManagerStatus(t, m, []string{"done"}, 2*time.Second)
+	if present, _ := m.ControlStatus(); present {
+		t.Fatalf("stale controller must be released")
+	}
+}
+
 func TestManagerReleaseControl(t *testing.T) {
 	timeout := 200 * time.Millisecond
 	m := NewManager(
 		replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}},
-		[]int64{1}, nil, 1, time.Second, 16, nil, true, false, timeout,
+		[]int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 16, SaveAllowed: true, ControlTimeout: timeout},
 	)
 
 	tokenA := "controller-a"
@@ -574,7 +1769,7 @@ func TestManagerReleaseControl(t *testing.T) {
 func TestManagerClaimExclusive(t *testing.T) {
 	m := NewManager(
 		replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}},
-		[]int64{1}, nil, 1, time.Second, 16, nil, true, false, 5*time.Second,
+		[]int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 16, SaveAllowed: true, ControlTimeout: 5 * time.Second},
 	)
 	token1 := "first"
 	token2 := "second"
@@ -596,7 +1791,7 @@ func TestSessionStatusAutoAssignFirstController(t *testing.T) {
 	timeout := 500 * time.Millisecond
 	m := NewManager(
 		replay.Service{Storage: &apiTestStorage{}, Output: &apiTestClient{}},
-		[]int64{1}, nil, 1, time.Second, 16, nil, true, false, timeout,
+		[]int64{1}, nil, nil, ManagerOptions{Speed: 1, Window: time.Second, BatchSize: 16, SaveAllowed: true, ControlTimeout: timeout},
 	)
 
 	token1 := "session-1"
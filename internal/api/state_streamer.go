@@ -2,6 +2,8 @@ package api
 
 import (
 	"bufio"
+	"bytes"
+	"compress/flate"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/binary"
@@ -28,13 +30,19 @@ type SensorInfo struct {
 	ConfigID *int64 `json:"config_id,omitempty"` // ID из конфига (если есть)
 	TextName string `json:"textname,omitempty"`
 	IOType   string `json:"iotype,omitempty"`
-	Hash     int64  `json:"-"` // внутренний идентификатор (не передаётся в JSON)
+	Group    string `json:"group,omitempty"`
+	// Calibration — линейное преобразование значения на выходе (nil — без преобразования).
+	Calibration *config.Calibration `json:"calibration,omitempty"`
+	// Limits — границы min/max из конфига (nil — без контроля), см. --ws-alerts.
+	Limits      *config.Limits      `json:"limits,omitempty"`
+	Hash        int64               `json:"-"` // внутренний идентификатор (не передаётся в JSON)
 }
 
 type sensorValue struct {
 	info        SensorInfo
 	value       float64
 	hasValue    bool
+	undefined   bool
 	stepID      int64
 	stepTs      time.Time
 	lastChanged time.Time
@@ -48,7 +56,27 @@ type wsMessage struct {
 	Updates  []wsSensorRow `json:"updates,omitempty"`
 	ControllerPresent bool `json:"controller_present,omitempty"`
 	ControlTimeoutSec int  `json:"control_timeout_sec,omitempty"`
-	// U — компактный формат обновлений: {name: [value, hasValue(0/1)]}
+	// WorkingCount — количество рабочих датчиков (только snapshot).
+	WorkingCount int `json:"working_count,omitempty"`
+	// BatchID/BatchTotal — номер части и число частей, если батч разбит по --ws-batch-max.
+	BatchID    int `json:"batch_id,omitempty"`
+	BatchTotal int `json:"batch_total,omitempty"`
+	// Reason/Error — причина завершения задачи (только done): completed | stopped | failed.
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// SkipTo/SkipToUnix — шаг, с которого продолжится воспроизведение после пропуска
+	// шагов вне --active-hours (только skip; первый пропущенный шаг — в StepTs).
+	SkipTo     string `json:"skip_to,omitempty"`
+	SkipToUnix uint64 `json:"skip_to_unix,omitempty"`
+	// Segment — номер сегмента плейлиста (/api/v2/job/playlist), которому принадлежит шаг (только updates).
+	Segment int `json:"segment_index,omitempty"`
+	// ID/Name/Value/Limit/Bound — выход значения датчика за границу min/max (только alert).
+	ID    int64    `json:"id,omitempty"`
+	Name  string   `json:"name,omitempty"`
+	Value *float64 `json:"value,omitempty"`
+	Limit *float64 `json:"limit,omitempty"`
+	Bound string   `json:"bound,omitempty"`
+	// U — компактный формат обновлений: {name: [value, hasValue(0/1)]}; для неопределённых — [0, 1, 1]
 	U map[string][]float64 `json:"u,omitempty"`
 }
 
@@ -57,8 +85,10 @@ type wsSensorRow struct {
 	ConfigID *int64  `json:"config_id,omitempty"` // ID из конфига (если есть)
 	TextName string  `json:"textname,omitempty"`  // snapshot only
 	IOType   string  `json:"iotype,omitempty"`    // snapshot only
-	Value    float64 `json:"value,omitempty"`
-	HasValue bool    `json:"has_value,omitempty"`
+	Value     float64 `json:"value,omitempty"`
+	HasValue  bool    `json:"has_value,omitempty"`
+	Undefined bool    `json:"undefined,omitempty"` // датчик в неопределённом состоянии
+	hash      int64   // для фильтра клиента (?sensors=), в JSON не передаётся
 }
 
 // StateStreamer копит состояние датчиков и отдаёт изменения через WebSocket.
@@ -66,16 +96,20 @@ type StateStreamer struct {
 	mu      sync.RWMutex
 	sensors map[int64]SensorInfo  // hash → SensorInfo
 	state   map[int64]*sensorValue // hash → value
+	working map[int64]struct{}     // рабочий набор датчиков (nil — все)
 	clients map[*wsClient]struct{}
 	lastID  int64
 	lastTs  time.Time
 
 	batchInterval time.Duration
+	batchMax      int // макс. обновлений в одном сообщении (0 — без ограничения)
 	batchRows     map[string]wsSensorRow // name → row
 	batchStep     replay.StepInfo
 	batchTimer    *time.Timer
 
 	controlStatus func() (bool, int)
+	alerts        bool // рассылать сообщения alert о выходе за границы min/max
+	compress      bool // сжимать кадры permessage-deflate, если клиент его предложил
 }
 
 // NewStateStreamer создаёт пустой стример.
@@ -99,6 +133,57 @@ func (s *StateStreamer) SetControlStatusProvider(fn func() (bool, int)) {
 	s.controlStatus = fn
 }
 
+// SetBatchMax ограничивает число обновлений в одном сообщении updates.
+// При превышении батч отправляется досрочно, не дожидаясь таймера, и делится
+// на части с batch_id/batch_total. 0 — без ограничения.
+func (s *StateStreamer) SetBatchMax(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n < 0 {
+		n = 0
+	}
+	s.batchMax = n
+}
+
+// SetAlerts включает сообщения alert при выходе значений за границы min/max из конфига.
+func (s *StateStreamer) SetAlerts(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.alerts = on
+}
+
+// SetCompression разрешает расширение permessage-deflate (RFC 7692): если клиент
+// предлагает его в Sec-WebSocket-Extensions, исходящие текстовые кадры сжимаются.
+func (s *StateStreamer) SetCompression(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compress = on
+}
+
+// SetWorkingSensors задаёт рабочий набор датчиков: snapshot и обновления
+// содержат только их. Пустой список снимает фильтр.
+func (s *StateStreamer) SetWorkingSensors(hashes []int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(hashes) == 0 {
+		s.working = nil
+		return
+	}
+	s.working = make(map[int64]struct{}, len(hashes))
+	for _, hash := range hashes {
+		s.working[hash] = struct{}{}
+	}
+}
+
+// isWorkingLocked сообщает, входит ли датчик в рабочий набор.
+func (s *StateStreamer) isWorkingLocked(hash int64) bool {
+	if s.working == nil {
+		return true
+	}
+	_, ok := s.working[hash]
+	return ok
+}
+
 // BuildSensorInfo подготавливает карту hash → SensorInfo из конфига.
 // hashes содержит список хешей датчиков (cityhash64(name)).
 func BuildSensorInfo(cfg *config.Config, hashes []int64) map[int64]SensorInfo {
@@ -125,13 +210,28 @@ func BuildSensorInfo(cfg *config.Config, hashes []int64) map[int64]SensorInfo {
 			name = fmt.Sprintf("hash%d", hash)
 		}
 
+		// iotype нужен UI и фильтру рабочего списка для каждого датчика: без него в конфиге — по имени.
+		iotype := strings.ToUpper(strings.TrimSpace(meta.IOType))
+		if iotype == "" {
+			iotype = config.GuessIOType(name)
+		}
+
+		var calib *config.Calibration
+		if meta.Calibration != nil && !meta.IsDiscrete() {
+			c := *meta.Calibration
+			calib = &c
+		}
+
 		infos[hash] = SensorInfo{
-			ID:       hash, // cityhash64(name) для совместимости
-			Name:     name,
-			ConfigID: configID,
-			TextName: meta.TextName,
-			IOType:   meta.IOType,
-			Hash:     hash,
+			ID:          hash, // cityhash64(name) для совместимости
+			Name:        name,
+			ConfigID:    configID,
+			TextName:    meta.TextName,
+			IOType:      iotype,
+			Group:       meta.Group,
+			Calibration: calib,
+			Limits:      meta.Limits,
+			Hash:        hash,
 		}
 	}
 	return infos
@@ -155,6 +255,79 @@ func (s *StateStreamer) Reset(infos map[int64]SensorInfo) {
 	s.broadcastLocked(msg)
 }
 
+// InitialSnapshot принимает состояние после warmup (все рабочие датчики, без значения — NoData)
+// и рассылает полный snapshot, чтобы клиенты получили весь состав датчиков до первых обновлений.
+func (s *StateStreamer) InitialSnapshot(step replay.StepInfo, updates []sharedmem.SensorUpdate) {
+	s.mu.Lock()
+	s.lastID = step.StepID
+	s.lastTs = step.StepTs
+	for _, upd := range updates {
+		if !s.isWorkingLocked(upd.Hash) {
+			continue
+		}
+		info, ok := s.sensors[upd.Hash]
+		if !ok {
+			info = SensorInfo{Hash: upd.Hash, Name: fmt.Sprintf("hash%d", upd.Hash)}
+			s.sensors[upd.Hash] = info
+		}
+		if upd.NoData {
+			delete(s.state, upd.Hash)
+			continue
+		}
+		s.state[upd.Hash] = &sensorValue{
+			info:        info,
+			value:       upd.Value,
+			hasValue:    true,
+			undefined:   upd.Undefined,
+			stepID:      step.StepID,
+			stepTs:      step.StepTs,
+			lastChanged: step.StepTs,
+		}
+	}
+	s.mu.Unlock()
+
+	s.broadcastViews(func(view map[int64]struct{}) []wsMessage {
+		return []wsMessage{s.snapshotMessage(view)}
+	})
+}
+
+// Причины завершения задачи в сообщении done.
+const (
+	DoneCompleted = "completed"
+	DoneStopped   = "stopped"
+	DoneFailed    = "failed"
+)
+
+// Done рассылает терминальное сообщение done: клиенты считают его концом потока.
+// Накопленный батч отправляется раньше, чтобы done шёл после последних обновлений.
+func (s *StateStreamer) Done(step replay.StepInfo, reason string, err error) {
+	s.flushBatch()
+	msg := wsMessage{
+		Type:     "done",
+		StepID:   step.StepID,
+		StepTs:   formatTime(step.StepTs),
+		StepUnix: unixMs(step.StepTs),
+		Reason:   reason,
+	}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	s.broadcastLocked(msg)
+}
+
+// Skip рассылает сообщение skip о пропущенных шагах вне суточного окна: [from, to).
+// Накопленный батч отправляется раньше, чтобы skip шёл после обновлений последнего сыгранного шага.
+func (s *StateStreamer) Skip(from, to time.Time) {
+	s.flushBatch()
+	s.broadcastLocked(wsMessage{
+		Type:       "skip",
+		StepTs:     formatTime(from),
+		StepUnix:   unixMs(from),
+		SkipTo:     formatTime(to),
+		SkipToUnix: unixMs(to),
+	})
+}
+
 // Publish применяет обновления шага и рассылает их по WebSocket.
 func (s *StateStreamer) Publish(step replay.StepInfo, updates []sharedmem.SensorUpdate) {
 	s.mu.Lock()
@@ -163,6 +336,9 @@ func (s *StateStreamer) Publish(step replay.StepInfo, updates []sharedmem.Sensor
 
 	rows := make([]wsSensorRow, 0, len(updates))
 	for _, upd := range updates {
+		if !s.isWorkingLocked(upd.Hash) {
+			continue
+		}
 		info, ok := s.sensors[upd.Hash]
 		if !ok {
 			info = SensorInfo{Hash: upd.Hash, Name: fmt.Sprintf("hash%d", upd.Hash)}
@@ -175,15 +351,18 @@ func (s *StateStreamer) Publish(step replay.StepInfo, updates []sharedmem.Sensor
 		}
 		val.info = info
 		val.value = upd.Value
-		val.hasValue = true
+		val.hasValue = !upd.NoData // маркер «нет данных» (--emit-empty)
+		val.undefined = upd.Undefined
 		val.stepID = step.StepID
 		val.stepTs = step.StepTs
 		val.lastChanged = step.StepTs
 
 		rows = append(rows, wsSensorRow{
-			Name:     info.Name,
-			Value:    upd.Value,
-			HasValue: true,
+			Name:      info.Name,
+			Value:     upd.Value,
+			HasValue:  !upd.NoData,
+			Undefined: upd.Undefined,
+			hash:      upd.Hash,
 		})
 	}
 
@@ -191,28 +370,97 @@ func (s *StateStreamer) Publish(step replay.StepInfo, updates []sharedmem.Sensor
 		s.batchRows[r.Name] = r
 	}
 	s.batchStep = step
+	if s.batchMax > 0 && len(s.batchRows) >= s.batchMax {
+		// досрочная отправка: батч набрал лимит раньше таймера
+		s.mu.Unlock()
+		s.flushBatch()
+		return
+	}
 	if s.batchTimer == nil {
 		s.startBatchTimerLocked()
 	}
 	s.mu.Unlock()
 }
 
+// Alert рассылает по сообщению alert на каждое нарушение границ в шаге (если включено SetAlerts).
+// Накопленный батч отправляется раньше, чтобы alert шёл после обновления с этим значением.
+func (s *StateStreamer) Alert(step replay.StepInfo) {
+	s.mu.RLock()
+	on := s.alerts
+	msgs := make([]wsMessage, 0, len(step.Violations))
+	for _, v := range step.Violations {
+		if !on || !s.isWorkingLocked(v.Hash) {
+			continue
+		}
+		name := fmt.Sprintf("hash%d", v.Hash)
+		if info, ok := s.sensors[v.Hash]; ok {
+			name = info.Name
+		}
+		value, limit := v.Value, v.Limit
+		msgs = append(msgs, wsMessage{
+			Type:     "alert",
+			StepID:   step.StepID,
+			StepTs:   formatTime(step.StepTs),
+			StepUnix: unixMs(step.StepTs),
+			ID:       v.Hash,
+			Name:     name,
+			Value:    &value,
+			Limit:    &limit,
+			Bound:    v.Bound,
+		})
+	}
+	s.mu.RUnlock()
+	if len(msgs) == 0 {
+		return
+	}
+	s.flushBatch()
+	s.broadcastViews(func(view map[int64]struct{}) []wsMessage {
+		if view == nil {
+			return msgs
+		}
+		var out []wsMessage
+		for _, msg := range msgs {
+			if _, ok := view[msg.ID]; ok {
+				out = append(out, msg)
+			}
+		}
+		return out
+	})
+}
+
 // ServeWS обрабатывает подключение клиента WebSocket.
 func (s *StateStreamer) ServeWS(w http.ResponseWriter, r *http.Request) {
+	s.ServeWSView(w, r, nil)
+}
+
+// ServeWSView подключает клиента WebSocket с собственным фильтром датчиков view (?sensors=):
+// snapshot, updates и alert этого клиента содержат только датчики view из рабочего набора.
+// Рабочий набор задачи и отправка в SM не меняются. Пустой view — все рабочие датчики.
+func (s *StateStreamer) ServeWSView(w http.ResponseWriter, r *http.Request, view []int64) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
-	conn, rw, err := websocketUpgrade(w, r)
+	s.mu.RLock()
+	compress := s.compress && offersDeflate(r.Header)
+	s.mu.RUnlock()
+	conn, rw, err := websocketUpgrade(w, r, compress)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	client := newWSClient(conn, rw)
+	client.compress = compress
+	if len(view) > 0 {
+		client.view = make(map[int64]struct{}, len(view))
+		for _, hash := range view {
+			client.view[hash] = struct{}{}
+		}
+	}
 	s.addClient(client)
 
-	if err := client.writeJSON(s.snapshotMessage()); err != nil {
+	if err := client.writeJSON(s.snapshotMessage(client.view)); err != nil {
 		s.removeClient(client)
 		return
 	}
@@ -253,12 +501,23 @@ func (s *StateStreamer) removeClient(c *wsClient) {
 	c.close()
 }
 
-func (s *StateStreamer) snapshotMessage() wsMessage {
+// snapshotMessage собирает snapshot рабочих датчиков; view (не nil) дополнительно сужает его
+// до датчиков фильтра клиента.
+func (s *StateStreamer) snapshotMessage(view map[int64]struct{}) wsMessage {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	rows := make([]wsSensorRow, 0, len(s.sensors))
+	// working — размер рабочего набора: фильтр клиента (view) сужает только rows.
+	working := 0
 	for hash, info := range s.sensors {
+		if !s.isWorkingLocked(hash) {
+			continue
+		}
+		working++
+		if _, ok := view[hash]; view != nil && !ok {
+			continue
+		}
 		val := s.state[hash]
 		row := wsSensorRow{
 			Name:     info.Name,
@@ -269,6 +528,7 @@ func (s *StateStreamer) snapshotMessage() wsMessage {
 		}
 		if val != nil && val.hasValue {
 			row.Value = val.value
+			row.Undefined = val.undefined
 		}
 		rows = append(rows, row)
 	}
@@ -277,11 +537,12 @@ func (s *StateStreamer) snapshotMessage() wsMessage {
 	})
 
 	msg := wsMessage{
-		Type:     "snapshot",
-		StepID:   s.lastID,
-		StepTs:   formatTime(s.lastTs),
-		StepUnix: unixMs(s.lastTs),
-		Updates:  rows,
+		Type:         "snapshot",
+		StepID:       s.lastID,
+		StepTs:       formatTime(s.lastTs),
+		StepUnix:     unixMs(s.lastTs),
+		Updates:      rows,
+		WorkingCount: working,
 	}
 	s.fillControlStatus(&msg)
 	return msg
@@ -296,15 +557,59 @@ func (s *StateStreamer) broadcastLocked(msg wsMessage) {
 		return
 	}
 	for c := range s.clients {
-		select {
-		case c.send <- data:
+		s.sendTo(c, data)
+	}
+}
+
+// broadcastViews рассылает сообщения с датчиками с учётом фильтра клиента (?sensors=):
+// build вызывается один раз для всех клиентов без фильтра и отдельно для каждого клиента
+// с фильтром. Пустой результат клиенту не отправляется.
+func (s *StateStreamer) broadcastViews(build func(view map[int64]struct{}) []wsMessage) {
+	s.mu.RLock()
+	clients := make([]*wsClient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.RUnlock()
+
+	var common [][]byte
+	built := false
+	for _, c := range clients {
+		var payloads [][]byte
+		switch {
+		case c.view != nil:
+			payloads = encodeMessages(build(c.view))
+		case !built:
+			common, built = encodeMessages(build(nil)), true
+			payloads = common
 		default:
-			// Клиент не успевает читать — отрубаем.
-			go s.removeClient(c)
+			payloads = common
+		}
+		for _, data := range payloads {
+			s.sendTo(c, data)
 		}
 	}
 }
 
+func encodeMessages(msgs []wsMessage) [][]byte {
+	out := make([][]byte, 0, len(msgs))
+	for _, msg := range msgs {
+		if data, err := json.Marshal(msg); err == nil {
+			out = append(out, data)
+		}
+	}
+	return out
+}
+
+func (s *StateStreamer) sendTo(c *wsClient, data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		// Клиент не успевает читать — отрубаем.
+		go s.removeClient(c)
+	}
+}
+
 func formatTime(ts time.Time) string {
 	if ts.IsZero() {
 		return ""
@@ -362,40 +667,91 @@ func (s *StateStreamer) flushBatch() {
 	}
 	s.batchTimer = nil
 	controlFn := s.controlStatus
+	batchMax := s.batchMax
 	s.mu.Unlock()
 
-	msg := wsMessage{
-		Type:     "updates",
-		StepID:   step.StepID,
-		StepUnix: unixMs(step.StepTs),
+	if len(rows) == 0 {
+		// Пустые батчи не рассылаем, чтобы не будить клиентов.
+		return
 	}
+
+	var present bool
+	var timeoutSec int
 	if controlFn != nil {
-		present, timeoutSec := controlFn()
-		msg.ControllerPresent = present
-		if timeoutSec > 0 {
-			msg.ControlTimeoutSec = timeoutSec
-		}
+		present, timeoutSec = controlFn()
 	}
 
-	if len(rows) > 0 {
-		msg.U = make(map[string][]float64, len(rows))
+	s.broadcastViews(func(view map[int64]struct{}) []wsMessage {
+		if view == nil {
+			return updatesMessages(step, rows, batchMax, present, timeoutSec)
+		}
+		filtered := make([]wsSensorRow, 0, len(view))
 		for _, r := range rows {
+			if _, ok := view[r.hash]; ok {
+				filtered = append(filtered, r)
+			}
+		}
+		return updatesMessages(step, filtered, batchMax, present, timeoutSec)
+	})
+}
+
+// updatesMessages разбивает строки батча на сообщения updates по batchMax (0 — одним сообщением).
+func updatesMessages(step replay.StepInfo, rows []wsSensorRow, batchMax int, present bool, timeoutSec int) []wsMessage {
+	if len(rows) == 0 {
+		return nil
+	}
+	chunk := len(rows)
+	if batchMax > 0 && batchMax < chunk {
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+		chunk = batchMax
+	}
+	total := (len(rows) + chunk - 1) / chunk
+	msgs := make([]wsMessage, 0, total)
+	for i := 0; i < total; i++ {
+		part := rows[i*chunk : min(len(rows), (i+1)*chunk)]
+		msg := wsMessage{
+			Type:              "updates",
+			StepID:            step.StepID,
+			StepUnix:          unixMs(step.StepTs),
+			ControllerPresent: present,
+			Segment:           step.Segment,
+			U:                 make(map[string][]float64, len(part)),
+		}
+		if timeoutSec > 0 {
+			msg.ControlTimeoutSec = timeoutSec
+		}
+		if total > 1 {
+			msg.BatchID = i + 1
+			msg.BatchTotal = total
+		}
+		for _, r := range part {
 			has := 0.0
 			if r.HasValue {
 				has = 1.0
 			}
+			if r.Undefined {
+				msg.U[r.Name] = []float64{r.Value, has, 1}
+				continue
+			}
 			msg.U[r.Name] = []float64{r.Value, has}
 		}
-		// Только если есть строки — рассылаем. Пустые батчи не трогаем, чтобы не будить клиентов.
-		s.broadcastLocked(msg)
+		msgs = append(msgs, msg)
 	}
+	return msgs
 }
 
 // --- WebSocket utils (минимальная реализация только для server-push) ---
 
 const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
 
-func websocketUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+// wsDeflateExtension — ответ на предложение permessage-deflate. Контекст сжатия не
+// переносится между сообщениями, поэтому каждый кадр распаковывается независимо.
+const wsDeflateExtension = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+
+// deflateTail — хвост пустого блока, который RFC 7692 требует отрезать от сжатого сообщения.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+func websocketUpgrade(w http.ResponseWriter, r *http.Request, compress bool) (net.Conn, *bufio.ReadWriter, error) {
 	if !headerContains(r.Header, "Connection", "Upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
 		return nil, nil, errors.New("upgrade request expected")
 	}
@@ -413,11 +769,17 @@ func websocketUpgrade(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.
 	if err != nil {
 		return nil, nil, err
 	}
+	// Сервер мог выставить дедлайны по ReadTimeout/WriteTimeout — долгоживущему WS они не нужны.
+	_ = conn.SetDeadline(time.Time{})
 	if rw == nil {
 		rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
 	}
 
-	response := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	extensions := ""
+	if compress {
+		extensions = "Sec-WebSocket-Extensions: " + wsDeflateExtension + "\r\n"
+	}
+	response := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n%s\r\n", accept, extensions)
 	if _, err := rw.WriteString(response); err != nil {
 		_ = conn.Close()
 		return nil, nil, err
@@ -445,11 +807,31 @@ func headerContains(h http.Header, name, value string) bool {
 	return false
 }
 
+// offersDeflate сообщает, предложил ли клиент permessage-deflate. Параметры
+// предложения не важны: сервер всегда отвечает без переноса контекста.
+func offersDeflate(h http.Header) bool {
+	for _, v := range h.Values("Sec-WebSocket-Extensions") {
+		for _, offer := range strings.Split(v, ",") {
+			name, _, _ := strings.Cut(offer, ";")
+			if strings.EqualFold(strings.TrimSpace(name), "permessage-deflate") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type wsClient struct {
 	conn net.Conn
 	rw   *bufio.ReadWriter
 	send chan []byte
 	once sync.Once
+
+	view map[int64]struct{} // фильтр датчиков клиента (?sensors=), nil — все рабочие
+
+	compress bool          // согласовано permessage-deflate
+	deflate  *flate.Writer // переиспользуется между кадрами (пишет только один поток)
+	buf      bytes.Buffer
 }
 
 func newWSClient(conn net.Conn, rw *bufio.ReadWriter) *wsClient {
@@ -465,13 +847,13 @@ func (c *wsClient) writeJSON(msg wsMessage) error {
 	if err != nil {
 		return err
 	}
-	return writeTextFrame(c.rw, data)
+	return c.writeMessage(data)
 }
 
 func (c *wsClient) writePump(onClose func()) {
 	defer onClose()
 	for msg := range c.send {
-		if err := writeTextFrame(c.rw, msg); err != nil {
+		if err := c.writeMessage(msg); err != nil {
 			return
 		}
 	}
@@ -484,9 +866,46 @@ func (c *wsClient) close() {
 	})
 }
 
+// writeMessage отправляет текстовое сообщение, сжимая его, если согласовано permessage-deflate.
+func (c *wsClient) writeMessage(payload []byte) error {
+	if !c.compress {
+		return writeTextFrame(c.rw, payload)
+	}
+	compressed, err := c.deflatePayload(payload)
+	if err != nil {
+		return err
+	}
+	return writeFrame(c.rw, 0xC1, compressed) // FIN + RSV1 (сжато) + text frame
+}
+
+// deflatePayload сжимает сообщение по RFC 7692: поток deflate после Flush без хвоста 00 00 ff ff.
+func (c *wsClient) deflatePayload(payload []byte) ([]byte, error) {
+	c.buf.Reset()
+	if c.deflate == nil {
+		fw, err := flate.NewWriter(&c.buf, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		c.deflate = fw
+	} else {
+		c.deflate.Reset(&c.buf)
+	}
+	if _, err := c.deflate.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := c.deflate.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(c.buf.Bytes(), deflateTail), nil
+}
+
 func writeTextFrame(w *bufio.ReadWriter, payload []byte) error {
+	return writeFrame(w, 0x81, payload) // FIN + text frame
+}
+
+func writeFrame(w *bufio.ReadWriter, first byte, payload []byte) error {
 	var header [10]byte
-	header[0] = 0x81 // FIN + text frame
+	header[0] = first
 	var headerLen int
 	switch {
 	case len(payload) < 126:
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval — как часто при рукопожатии проверяются файлы сертификата и ключа.
+const certCheckInterval = 10 * time.Second
+
+// certReloader отдаёт сертификат TLS и перечитывает его, когда файлы изменились
+// (например, после продления certbot), без перезапуска сервера.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // более позднее время изменения из двух файлов
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) stat() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("tls: %w", err)
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls: load %s/%s: %w", r.certFile, r.keyFile, err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.checked = time.Now()
+	return nil
+}
+
+// getCertificate — tls.Config.GetCertificate. Ошибка перечитывания не прерывает работу:
+// остаётся прежний сертификат, а попытка повторяется при следующей проверке.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) < certCheckInterval {
+		return r.cert, nil
+	}
+	r.checked = time.Now()
+	modTime, err := r.stat()
+	if err != nil {
+		log.Printf("[http] tls certificate check: %v", err)
+		return r.cert, nil
+	}
+	if modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	if err := r.load(modTime); err != nil {
+		log.Printf("[http] tls certificate reload: %v; keeping the previous certificate", err)
+		return r.cert, nil
+	}
+	log.Printf("[http] tls certificate reloaded from %s", r.certFile)
+	return r.cert, nil
+}
+
+// SetTLS включает HTTPS (и wss:// для WebSocket) с сертификатом и ключом в PEM.
+// Файлы проверяются не чаще certCheckInterval и перечитываются при изменении.
+func (s *Server) SetTLS(certFile, keyFile string) error {
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("tls: both certificate and key files are required")
+	}
+	certs, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	s.tlsConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.getCertificate,
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package replay
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ActiveHours — суточное окно воспроизведения: шаги вне [Start, End) времени суток или в дни
+// вне Weekdays пропускаются, цикл переходит к началу следующего активного периода.
+type ActiveHours struct {
+	Start time.Duration // начало окна — смещение от полуночи
+	End   time.Duration // конец окна; End < Start — окно через полночь (22:00-06:00), End == Start — весь день
+	// Weekdays — дни, в которые окно открывается: бит 1<<time.Weekday (0 — все дни).
+	// Для окна через полночь день — тот, в который окно началось.
+	Weekdays uint8
+	// Location — пояс, в котором отсчитывается время суток (nil — UTC).
+	Location *time.Location
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseActiveHours разбирает окно "HH:MM-HH:MM" и необязательный список дней:
+// "mon-fri", "sat,sun" или "mon,wed-fri" (пусто — все дни).
+func ParseActiveHours(hours, days string, loc *time.Location) (ActiveHours, error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(hours), "-")
+	if !ok {
+		return ActiveHours{}, fmt.Errorf("active hours %q: want HH:MM-HH:MM", hours)
+	}
+	a := ActiveHours{Location: loc}
+	var err error
+	if a.Start, err = parseTimeOfDay(from); err != nil {
+		return ActiveHours{}, fmt.Errorf("active hours %q: %w", hours, err)
+	}
+	if a.End, err = parseTimeOfDay(to); err != nil {
+		return ActiveHours{}, fmt.Errorf("active hours %q: %w", hours, err)
+	}
+	if a.Weekdays, err = parseWeekdays(days); err != nil {
+		return ActiveHours{}, fmt.Errorf("active days %q: %w", days, err)
+	}
+	return a, nil
+}
+
+// parseTimeOfDay разбирает "HH:MM" (24:00 — конец суток).
+func parseTimeOfDay(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+func parseWeekdays(s string) (uint8, error) {
+	var mask uint8
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(item, "-")
+		first, err := weekdayIndex(from)
+		if err != nil {
+			return 0, err
+		}
+		last := first
+		if isRange {
+			if last, err = weekdayIndex(to); err != nil {
+				return 0, err
+			}
+		}
+		// Диапазон может переходить через воскресенье: fri-mon.
+		for d := first; ; d = (d + 1) % 7 {
+			mask |= 1 << d
+			if d == last {
+				break
+			}
+		}
+	}
+	return mask, nil
+}
+
+func weekdayIndex(name string) (int, error) {
+	for i, n := range weekdayNames {
+		if strings.TrimSpace(name) == n {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q (want %s)", name, strings.Join(weekdayNames, ", "))
+}
+
+// String возвращает окно в виде флагов: "08:00-18:00" и дни через запятую, если заданы.
+func (a ActiveHours) String() string {
+	out := fmt.Sprintf("%s-%s", formatTimeOfDay(a.Start), formatTimeOfDay(a.End))
+	if a.Weekdays != 0 {
+		var days []string
+		for d, name := range weekdayNames {
+			if a.Weekdays&(1<<d) != 0 {
+				days = append(days, name)
+			}
+		}
+		out += " " + strings.Join(days, ",")
+	}
+	return out
+}
+
+// MarshalText отдаёт окно в статусе задачи строкой (см. String).
+func (a ActiveHours) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+func (a ActiveHours) location() *time.Location {
+	if a.Location == nil {
+		return time.UTC
+	}
+	return a.Location
+}
+
+func (a ActiveHours) dayOn(day time.Time) bool {
+	return a.Weekdays == 0 || a.Weekdays&(1<<day.Weekday()) != 0
+}
+
+// Active сообщает, попадает ли момент t в окно.
+func (a ActiveHours) Active(t time.Time) bool {
+	local := t.In(a.location())
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	tod := local.Sub(midnight)
+	switch {
+	case a.Start == a.End:
+		return a.dayOn(midnight)
+	case a.Start < a.End:
+		return a.dayOn(midnight) && tod >= a.Start && tod < a.End
+	default:
+		// Окно через полночь: вечер текущего дня или утро после открытого вчера окна.
+		return (tod >= a.Start && a.dayOn(midnight)) || (tod < a.End && a.dayOn(midnight.AddDate(0, 0, -1)))
+	}
+}
+
+// Next возвращает t, если он в окне, иначе — начало следующего активного периода после t.
+func (a ActiveHours) Next(t time.Time) time.Time {
+	if a.Active(t) {
+		return t
+	}
+	local := t.In(a.location())
+	for d := 0; d <= 7; d++ {
+		day := time.Date(local.Year(), local.Month(), local.Day()+d, 0, 0, 0, 0, local.Location())
+		start := day.Add(a.Start)
+		if a.Start == a.End {
+			start = day
+		}
+		if start.After(t) && a.dayOn(day) {
+			return start.In(t.Location())
+		}
+	}
+	// Недостижимо: хотя бы один день недели в маске есть всегда.
+	return t
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/pv/uniset-timemachine-go/internal/sharedmem"
+	"github.com/pv/uniset-timemachine-go/internal/storage"
 )
 
 // CommandType задаёт тип управляющей команды.
@@ -18,6 +19,9 @@ const (
 	CommandSeek
 	CommandApply
 	CommandSaveOutput
+	CommandPlayUntil
+	// CommandSegment переходит к сегменту плейлиста Command.Index (только при Params.Segments).
+	CommandSegment
 )
 
 // Command передаёт управляющее сообщение в RunWithControl.
@@ -26,7 +30,13 @@ type Command struct {
 	TS         time.Time
 	Apply      bool
 	SaveOutput bool
-	Resp       chan<- error
+	// Count — число шагов для CommandStepForward/CommandStepBackward (0 — один шаг).
+	Count int
+	// Index — номер сегмента плейлиста для CommandSegment (с 0).
+	Index int
+	Resp  chan<- error
+	// Preview получает восстановленное состояние после CommandSeek (если задан).
+	Preview chan<- StateSnapshot
 }
 
 // Control объединяет каналы управления и коллбеки прогресса.
@@ -34,6 +44,20 @@ type Control struct {
 	Commands  <-chan Command
 	OnStep    func(StepInfo)
 	OnUpdates func(StepInfo, []sharedmem.SensorUpdate)
+	// OnAutoPause вызывается, когда цикл встал на паузу, достигнув PauseAt/CommandPlayUntil.
+	OnAutoPause func(StepInfo)
+	// OnWarmup вызывается один раз после Warmup, до первого шага, с полным списком датчиков:
+	// значения после калибровки, для датчиков без начального значения — маркер NoData.
+	OnWarmup func(StepInfo, []sharedmem.SensorUpdate)
+	// OnCache вызывается после каждого восстановления состояния (seek, шаг назад) с накопленными
+	// за запуск счётчиками кэша состояний.
+	OnCache func(CacheStats)
+	// OnFinish вызывается, когда период проигран до конца (не Stop и не ошибка): с последним шагом
+	// и состоянием датчиков на нём. События можно передать в Params.Warmup задачи с From = StepTs.
+	OnFinish func(StepInfo, []storage.SensorEvent)
+	// OnSkip вызывается, когда цикл пропускает шаги вне Params.ActiveHours: from — первый
+	// пропущенный шаг, to — шаг, с которого воспроизведение продолжится (не позже To).
+	OnSkip func(from, to time.Time)
 }
 
 // StepInfo описывает прогресс шага при управляемом проигрывании.
@@ -41,6 +65,23 @@ type StepInfo struct {
 	StepID       int64
 	StepTs       time.Time
 	UpdatesCount int
+	// BatchesSent/BatchesFailed — итог отправки батчей шага в SM (нули, если запись выключена).
+	BatchesSent   int
+	BatchesFailed int
+	// SendErr — последняя ошибка отправки шага; в режиме Params.BestEffort цикл после неё продолжается.
+	SendErr error
+	// Violations — обновления шага, вышедшие за границы Service.Limits.
+	Violations []LimitViolation
+	// Segment — номер сегмента плейлиста (Params.Segments), которому принадлежит шаг; 0 — вне плейлиста.
+	Segment int
+}
+
+// LimitViolation описывает выход значения датчика за границу min/max из конфига.
+type LimitViolation struct {
+	Hash  int64
+	Value float64 // отправленное значение (после калибровки)
+	Limit float64
+	Bound string // min | max
 }
 
 // ErrStopped возвращается при остановке через команду Stop.
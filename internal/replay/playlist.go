@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pv/uniset-timemachine-go/internal/sharedmem"
+	"github.com/pv/uniset-timemachine-go/internal/storage"
+)
+
+// Segment — один период плейлиста (Params.Segments).
+type Segment struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// ValidateSegments проверяет плейлист: у каждого сегмента To позже From, сегменты идут
+// по возрастанию времени и не перекрываются (разрывы между ними допустимы).
+func ValidateSegments(segments []Segment) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("playlist: no segments")
+	}
+	for i, seg := range segments {
+		if !seg.To.After(seg.From) {
+			return fmt.Errorf("playlist: segment %d: invalid period %s → %s", i, seg.From.Format(time.RFC3339), seg.To.Format(time.RFC3339))
+		}
+		if i > 0 && seg.From.Before(segments[i-1].To) {
+			return fmt.Errorf("playlist: segment %d starts at %s before the end of segment %d", i, seg.From.Format(time.RFC3339), i-1)
+		}
+	}
+	return nil
+}
+
+// segmentJump прерывает проигрывание сегмента по CommandSegment; runPlaylist переходит к index.
+type segmentJump struct {
+	index int
+}
+
+func (j segmentJump) Error() string {
+	return fmt.Sprintf("jump to playlist segment %d", j.index)
+}
+
+// checkSegment проверяет номер сегмента в CommandSegment.
+func checkSegment(params Params, index int) error {
+	if params.segmentCount == 0 {
+		return fmt.Errorf("segment: the job is not a playlist")
+	}
+	if index < 0 || index >= params.segmentCount {
+		return fmt.Errorf("segment: index %d is outside the playlist of %d segments", index, params.segmentCount)
+	}
+	return nil
+}
+
+// segmentClient сдвигает StepID отправляемых шагов на начало сегмента в сквозной нумерации.
+type segmentClient struct {
+	sharedmem.Client
+	base int64
+}
+
+func (c segmentClient) Send(ctx context.Context, payload sharedmem.StepPayload) error {
+	payload.StepID += c.base
+	return c.Client.Send(ctx, payload)
+}
+
+// segmentSteps — число шагов сетки в сегменте: [From, To), при inclusiveEnd — [From, To].
+func segmentSteps(seg Segment, step time.Duration, inclusiveEnd bool) int64 {
+	span := seg.To.Sub(seg.From)
+	n := int64(span / step)
+	if span%step != 0 || inclusiveEnd {
+		n++
+	}
+	return n
+}
+
+// PlaylistSteps возвращает номер шага перед началом каждого сегмента в сквозной нумерации
+// (шаг bases[i]+1 — From сегмента i) и общее число шагов плейлиста.
+func PlaylistSteps(segments []Segment, step time.Duration, inclusiveEnd bool) (bases []int64, total int64) {
+	bases = make([]int64, len(segments))
+	for i, seg := range segments {
+		bases[i] = total
+		total += segmentSteps(seg, step, inclusiveEnd)
+	}
+	return bases, total
+}
+
+// runPlaylist проигрывает Params.Segments по очереди. Каждый сегмент — отдельный запуск цикла
+// со своим warmup; номера шагов сдвигаются на число шагов предыдущих сегментов, так что
+// получатели видят сквозной StepID. StartDelay, StartPaused и готовый Warmup относятся
+// к первому сегменту; после перехода по CommandSegment сегмент стартует на паузе, как после seek.
+func (s *Service) runPlaylist(ctx context.Context, params Params, ctrl *Control) error {
+	segments := params.Segments
+	if err := ValidateSegments(segments); err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	if err := checkStep(params.Step); err != nil {
+		return err
+	}
+	if params.Follow {
+		return fmt.Errorf("replay: follow mode is not supported in a playlist")
+	}
+	// Начало каждого сегмента в сквозной нумерации считается по длине предыдущих,
+	// поэтому не зависит от порядка переходов.
+	bases, total := PlaylistSteps(segments, params.Step, params.InclusiveEnd)
+	if err := checkStepCount(total, params.Step); err != nil {
+		return err
+	}
+
+	index := 0
+	jumped := false
+	for index < len(segments) {
+		seg := segments[index]
+		p := params
+		p.Segments = nil
+		p.segmentCount = len(segments)
+		p.From, p.To = seg.From, seg.To
+		if index > 0 || jumped {
+			p.Warmup = nil
+			p.StartDelay = 0
+			p.StartPaused = jumped && ctrl != nil
+		}
+		if p.PauseAt.Before(seg.From) || p.PauseAt.After(seg.To) {
+			p.PauseAt = time.Time{}
+		}
+		svc := *s
+		svc.Output = segmentClient{Client: s.Output, base: bases[index]}
+		log.Printf("[replay] playlist segment %d/%d: %s → %s", index+1, len(segments), seg.From.Format(time.RFC3339), seg.To.Format(time.RFC3339))
+
+		err := svc.run(ctx, p, segmentControl(ctrl, index, bases[index], index == len(segments)-1))
+		var jump segmentJump
+		switch {
+		case errors.As(err, &jump):
+			index, jumped = jump.index, true
+		case err != nil:
+			return err
+		default:
+			index++
+			jumped = false
+		}
+	}
+	return nil
+}
+
+// segmentControl переводит коллбеки сегмента в сквозную нумерацию шагов плейлиста.
+// OnFinish вызывается только для последнего сегмента: весь плейлист проигран до конца.
+func segmentControl(ctrl *Control, index int, base int64, last bool) *Control {
+	if ctrl == nil {
+		return nil
+	}
+	c := *ctrl
+	shift := func(info StepInfo) StepInfo {
+		info.StepID += base
+		info.Segment = index
+		return info
+	}
+	if ctrl.OnStep != nil {
+		c.OnStep = func(info StepInfo) { ctrl.OnStep(shift(info)) }
+	}
+	if ctrl.OnUpdates != nil {
+		c.OnUpdates = func(info StepInfo, updates []sharedmem.SensorUpdate) { ctrl.OnUpdates(shift(info), updates) }
+	}
+	if ctrl.OnAutoPause != nil {
+		c.OnAutoPause = func(info StepInfo) { ctrl.OnAutoPause(shift(info)) }
+	}
+	if ctrl.OnWarmup != nil {
+		c.OnWarmup = func(info StepInfo, updates []sharedmem.SensorUpdate) { ctrl.OnWarmup(shift(info), updates) }
+	}
+	c.OnFinish = nil
+	if last && ctrl.OnFinish != nil {
+		c.OnFinish = func(info StepInfo, state []storage.SensorEvent) { ctrl.OnFinish(shift(info), state) }
+	}
+	return &c
+}
@@ -0,0 +1,76 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pv/uniset-timemachine-go/internal/sharedmem"
+	"github.com/pv/uniset-timemachine-go/internal/storage"
+)
+
+// Preflight — результат проверки перед воспроизведением: хранилище, данные в диапазоне и получатель.
+// Ничего не отправляет в SM.
+type Preflight struct {
+	Status string                    `json:"status"` // ok | fail
+	Checks map[string]PreflightCheck `json:"checks"`
+	// Sensors — выбранные датчики; SensorsWithData — из них с записями в диапазоне;
+	// UnknownSensors — датчики в БД, отсутствующие в конфиге (если хранилище умеет считать).
+	Sensors         int       `json:"sensors"`
+	SensorsWithData int64     `json:"sensors_with_data"`
+	UnknownSensors  int64     `json:"unknown_sensors"`
+	From            time.Time `json:"from"`
+	To              time.Time `json:"to"`
+	DataFrom        time.Time `json:"data_from"`
+	DataTo          time.Time `json:"data_to"`
+}
+
+// PreflightCheck — состояние одной проверки: ok, fail или skipped (проверка не поддерживается).
+type PreflightCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (p *Preflight) add(name string, err error) {
+	if err != nil {
+		p.Status = "fail"
+		p.Checks[name] = PreflightCheck{Status: "fail", Error: err.Error()}
+		return
+	}
+	p.Checks[name] = PreflightCheck{Status: "ok"}
+}
+
+// Preflight проверяет доступность хранилища, наличие данных выбранных датчиков в [from, to]
+// (нулевые границы — весь архив) и доступность получателя.
+func (s *Service) Preflight(ctx context.Context, sensors []int64, from, to time.Time) Preflight {
+	res := Preflight{Status: "ok", Checks: make(map[string]PreflightCheck, 4), Sensors: len(sensors), From: from, To: to}
+
+	if p, ok := s.Storage.(storage.Pinger); ok {
+		res.add("storage", p.Ping(ctx))
+	} else {
+		res.Checks["storage"] = PreflightCheck{Status: "skipped"}
+	}
+
+	if len(sensors) == 0 {
+		res.add("sensors", fmt.Errorf("no sensors selected"))
+	} else {
+		res.add("sensors", nil)
+		var err error
+		if u, ok := s.Storage.(storage.UnknownAwareStorage); ok {
+			res.DataFrom, res.DataTo, res.SensorsWithData, res.UnknownSensors, err = u.RangeWithUnknown(ctx, sensors, from, to)
+		} else {
+			res.DataFrom, res.DataTo, res.SensorsWithData, err = s.Storage.Range(ctx, sensors, from, to)
+		}
+		if err == nil && (res.SensorsWithData == 0 || res.DataFrom.IsZero()) {
+			err = fmt.Errorf("no data for selected sensors in range")
+		}
+		res.add("range", err)
+	}
+
+	if p, ok := s.Output.(sharedmem.Pinger); ok {
+		res.add("output", p.Ping(ctx))
+	} else {
+		res.Checks["output"] = PreflightCheck{Status: "skipped"}
+	}
+	return res
+}
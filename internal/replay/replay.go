@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/pv/uniset-timemachine-go/internal/sharedmem"
 	"github.com/pv/uniset-timemachine-go/internal/storage"
+	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
 // Params описывает настройки воспроизведения.
@@ -20,6 +23,164 @@ type Params struct {
 	Speed      float64
 	BatchSize  int
 	SaveOutput bool `json:"save_output,omitempty"`
+	// Output — имя клиента вывода, выбранного для задачи сервером API (например "sm" или "ui_only").
+	// Сам цикл его не читает: клиент задаётся в Service.Output.
+	Output string `json:"output,omitempty"`
+	// SeekWindow — окно подкачки потока, перезапускаемого при seek и шагах на паузе (0 — как Window).
+	// Меньшее окно сокращает объём чтения на каждый скраб; при resume поток снова открывается с Window.
+	SeekWindow time.Duration `json:"seek_window,omitempty"`
+	// StepAggregate — какое значение шага отправлять, если за шаг у датчика несколько событий
+	// (пусто — AggregateLast). Имеет смысл, только когда Step крупнее разрешения данных.
+	StepAggregate StepAggregate `json:"step_aggregate,omitempty"`
+	// Warmup — готовое состояние датчиков на From (события как у Storage.Warmup) вместо запроса
+	// к хранилищу: тёплый старт задачи, продолжающей предыдущую (см. Control.OnFinish). nil — запрос.
+	Warmup []storage.SensorEvent `json:"-"`
+	// PauseAt — момент, при достижении которого цикл сам встаёт на паузу (нулевое значение — без паузы).
+	PauseAt time.Time `json:"-"`
+	// StartPaused: после warmup цикл встаёт на паузу на From, не выполняя первый шаг, и ждёт команд
+	// (resume, seek, step). Действует только при RunWithControl.
+	StartPaused bool `json:"start_paused,omitempty"`
+	// MaxStaleness — для BuildState: значение датчика без событий в окне [target-MaxStaleness, target]
+	// считается устаревшим и исключается из снимка (0 — без ограничения).
+	MaxStaleness time.Duration `json:"-"`
+	// BestEffort: ошибка отправки батча в SM не останавливает воспроизведение,
+	// а пишется в лог и учитывается в StepInfo (BatchesFailed/SendErr). Отмена контекста прерывает цикл всегда.
+	BestEffort bool `json:"best_effort,omitempty"`
+	// InclusiveEnd включает шаг ровно в To: период [From, To] вместо [From, To) по умолчанию.
+	InclusiveEnd bool `json:"inclusive_end,omitempty"`
+	// NoPace: шаги идут подряд без ожидания Step/Speed между ними (Speed игнорируется) — так быстро,
+	// как позволяют хранилище и вывод. Каждый шаг вместо паузы ждёт, пока поток подкачает его события,
+	// так что результат воспроизводим (выгрузка, эталонные прогоны). Команды и отмена контекста
+	// по-прежнему проверяются между шагами.
+	NoPace bool `json:"no_pace,omitempty"`
+	// StartDelay — выдержка перед первым шагом, чтобы получатели успели подписаться: после warmup
+	// начальное состояние на From отправляется полным снимком (шаг 0, если SaveOutput), затем цикл
+	// ждёт StartDelay и только потом начинает шаги. Команды (stop, pause, seek) и отмена контекста
+	// обрабатываются и во время ожидания.
+	StartDelay time.Duration `json:"start_delay,omitempty"`
+	// Segments — плейлист: периоды проигрываются друг за другом, перед каждым заново читается
+	// состояние на его From (warmup). StepID идёт сквозной нумерацией через все сегменты, номер
+	// сегмента приходит в StepInfo.Segment. From/To задачи — начало первого и конец последнего сегмента;
+	// seek и шаги действуют в пределах текущего сегмента, переход между ними — CommandSegment.
+	Segments []Segment `json:"segments,omitempty"`
+	// segmentCount — число сегментов плейлиста, которому принадлежит этот период (0 — не плейлист).
+	segmentCount int
+	// PrimeOnStart — до первого шага отправить в SM (если SaveOutput) полный снимок состояния
+	// на From: все значения warmup, а с EmitEmpty — и маркеры NoData. Так SM сразу получает
+	// исходное состояние всех датчиков, а не только тех, что изменятся по ходу воспроизведения.
+	// Первый шаг после снимка отправляет лишь изменившееся после From.
+	PrimeOnStart bool `json:"prime_on_start,omitempty"`
+	// ActiveHours ограничивает воспроизведение суточным окном (nil — непрерывно): шаги вне окна
+	// не проигрываются, цикл переходит к первому шагу следующего активного периода, заново получая
+	// состояние Warmup на нём, и сообщает о пропуске в Control.OnSkip. Несовместимо с Follow.
+	ActiveHours *ActiveHours `json:"active_hours,omitempty"`
+	// Follow: по достижении To воспроизведение не завершается, а следует за растущей таблицей —
+	// новые записи опрашиваются каждые FollowPoll, шаг идёт в реальном времени с отставанием
+	// FollowDelay+FollowPoll от текущего момента. Цикл завершается только отменой контекста.
+	Follow      bool          `json:"follow,omitempty"`
+	FollowDelay time.Duration `json:"-"`
+	FollowPoll  time.Duration `json:"-"`
+}
+
+// StepAggregate задаёт, как значения событий внутри шага сводятся к одному значению обновления.
+type StepAggregate string
+
+const (
+	// AggregateLast — последнее значение на границе шага (удержание последнего, по умолчанию).
+	AggregateLast StepAggregate = "last"
+	// AggregateAvg — среднее значений событий шага.
+	AggregateAvg StepAggregate = "avg"
+	// AggregateMin и AggregateMax — минимум и максимум значений событий шага (видны кратковременные пики).
+	AggregateMin StepAggregate = "min"
+	AggregateMax StepAggregate = "max"
+)
+
+// ParseStepAggregate разбирает режим агрегации шага; пустая строка — AggregateLast.
+func ParseStepAggregate(value string) (StepAggregate, error) {
+	switch agg := StepAggregate(strings.ToLower(strings.TrimSpace(value))); agg {
+	case "":
+		return AggregateLast, nil
+	case AggregateLast, AggregateAvg, AggregateMin, AggregateMax:
+		return agg, nil
+	}
+	return "", fmt.Errorf("invalid step aggregate %q: want last, avg, min or max", value)
+}
+
+// InPeriod сообщает, является ли stepTs шагом периода: до To, а при InclusiveEnd — и ровно To.
+func (p Params) InPeriod(stepTs time.Time) bool {
+	return stepTs.Before(p.To) || (p.InclusiveEnd && stepTs.Equal(p.To))
+}
+
+// nextActiveStep возвращает первый шаг сетки From + k*Step не раньше ts, попадающий в ActiveHours.
+// Момент вне периода означает, что активных шагов до конца периода не осталось.
+func (p Params) nextActiveStep(ts time.Time) time.Time {
+	for p.InPeriod(ts) {
+		next := p.ActiveHours.Next(ts)
+		if next.Equal(ts) {
+			return ts
+		}
+		k := (next.Sub(p.From) + p.Step - 1) / p.Step
+		ts = p.From.Add(k * p.Step)
+	}
+	return ts
+}
+
+// seekWindow возвращает окно потока для seek и шагов на паузе: SeekWindow, а если оно не задано — Window.
+func (p Params) seekWindow() time.Duration {
+	if p.SeekWindow > 0 {
+		return p.SeekWindow
+	}
+	return p.Window
+}
+
+// streamTo — граница запроса событий к хранилищу. Stream читает [From, To), поэтому при InclusiveEnd
+// граница сдвигается на шаг, чтобы события ровно в To попали в последний шаг.
+func (p Params) streamTo() time.Time {
+	if p.InclusiveEnd {
+		return p.To.Add(p.Step)
+	}
+	return p.To
+}
+
+// followOptions — параметры опроса хвоста для storage.Follow.
+func (p Params) followOptions() storage.FollowOptions {
+	return storage.FollowOptions{Delay: p.FollowDelay, Poll: p.FollowPoll}
+}
+
+const (
+	// MinStep — наименьший шаг воспроизведения: хранилища отдают время с точностью до микросекунд,
+	// а более мелкий шаг только умножает число пустых итераций.
+	MinStep = time.Millisecond
+	// MaxSteps — предел числа шагов в периоде. Защищает от опечатки вроде --step 1ms на длинном
+	// диапазоне: даже без пауз (--no-pace) 10 млн шагов — это часы работы, больше похоже на зависание.
+	MaxSteps = 10_000_000
+)
+
+// ValidateStep проверяет шаг воспроизведения и число шагов в периоде [from, to]:
+// шаг не меньше MinStep, шагов не больше MaxSteps.
+func ValidateStep(from, to time.Time, step time.Duration) error {
+	if err := checkStep(step); err != nil {
+		return err
+	}
+	// Sub насыщается на ±292 годах, поэтому деление не переполняется при любом периоде.
+	return checkStepCount(int64(to.Sub(from)/step), step)
+}
+
+func checkStep(step time.Duration) error {
+	if step <= 0 {
+		return fmt.Errorf("replay: step must be > 0")
+	}
+	if step < MinStep {
+		return fmt.Errorf("replay: step %s is less than the minimum %s", step, MinStep)
+	}
+	return nil
+}
+
+func checkStepCount(steps int64, step time.Duration) error {
+	if steps > MaxSteps {
+		return fmt.Errorf("replay: %d steps of %s in the period, more than %d allowed; increase the step or shorten the period", steps, step, MaxSteps)
+	}
+	return nil
 }
 
 // Service связывает storage и sharedmem.
@@ -27,6 +188,30 @@ type Service struct {
 	Storage  storage.Storage
 	Output   sharedmem.Client
 	LogCache bool
+	// Calibration задаёт линейное преобразование значений hash → (scale, offset)
+	// перед отправкой в SM и WebSocket. Датчики без записи отправляются как есть.
+	Calibration map[int64]config.Calibration
+	// Limits задаёт границы значений hash → (min, max). Обновления шага за границей попадают
+	// в StepInfo.Violations; отправляемые значения не изменяются.
+	Limits map[int64]config.Limits
+	// Pulses задаёт автосброс импульсных дискретных датчиков hash → (hold, value): значение,
+	// отправленное в шаге и не подтверждённое новым событием за hold, возвращается к value.
+	Pulses map[int64]config.Pulse
+	// EmitEmpty включает маркеры NoData для датчиков без значения в первом шаге и при apply,
+	// чтобы получатели знали полный рабочий набор.
+	EmitEmpty bool
+	// ValueRound — число знаков после запятой, до которого округляются отправляемые значения
+	// (после калибровки; в SM и WebSocket). <= 0 — без округления.
+	ValueRound int
+	// Discrete — дискретные датчики (iotype DI/DO). С DiscreteThreshold > 0 их значения перед
+	// отправкой в SM и WebSocket приводятся к 0/1: не меньше порога — 1, иначе 0.
+	Discrete map[int64]struct{}
+	// DiscreteThreshold — порог приведения дискретных значений к 0/1 (обычно 0.5); <= 0 — без приведения.
+	DiscreteThreshold float64
+	// MaxPendingEvents ограничивает число прочитанных, но ещё не применённых событий.
+	// При достижении лимита цикл перестаёт забирать события из потока, и чтение из storage
+	// блокируется до продвижения шага (0 — без ограничения).
+	MaxPendingEvents int
 }
 
 // Run запускает цикл воспроизведения.
@@ -43,12 +228,25 @@ func (s *Service) run(ctx context.Context, params Params, ctrl *Control) error {
 	if s.Storage == nil || s.Output == nil {
 		return fmt.Errorf("replay: storage and output must be set")
 	}
-	if params.Step <= 0 {
-		return fmt.Errorf("replay: step must be > 0")
+	if len(params.Segments) > 0 {
+		return s.runPlaylist(ctx, params, ctrl)
+	}
+	if err := checkStep(params.Step); err != nil {
+		return err
 	}
 	if !params.To.After(params.From) {
 		return fmt.Errorf("replay: invalid period: %s → %s", params.From, params.To)
 	}
+	if err := checkStepCount(int64(params.To.Sub(params.From)/params.Step), params.Step); err != nil {
+		return err
+	}
+	aggregate, err := ParseStepAggregate(string(params.StepAggregate))
+	if err != nil {
+		return fmt.Errorf("replay: %w", err)
+	}
+	if params.ActiveHours != nil && params.Follow {
+		return fmt.Errorf("replay: active hours are not supported in follow mode")
+	}
 
 	saveOutput := params.SaveOutput
 	state := make(map[int64]*sensorState, len(params.Sensors))
@@ -56,13 +254,21 @@ func (s *Service) run(ctx context.Context, params Params, ctrl *Control) error {
 		state[id] = &sensorState{}
 	}
 
-	warmupEvents, err := s.Storage.Warmup(ctx, params.Sensors, params.From)
-	if err != nil {
-		return fmt.Errorf("replay: warmup: %w", err)
+	warmupEvents := params.Warmup
+	if warmupEvents == nil {
+		if warmupEvents, err = s.Storage.Warmup(ctx, params.Sensors, params.From); err != nil {
+			return fmt.Errorf("replay: warmup: %w", err)
+		}
 	}
 	applyEvents(state, warmupEvents, true)
 	cache := newStateCache(16)
 	cache.add(params.From, 0, state)
+	if ctrl != nil {
+		cache.onStats = ctrl.OnCache
+	}
+	if ctrl != nil && ctrl.OnWarmup != nil {
+		ctrl.OnWarmup(StepInfo{StepTs: params.From}, warmupUpdates(state, s.output()))
+	}
 
 	streamCtx, streamCancel := context.WithCancel(ctx)
 	defer func() {
@@ -70,22 +276,75 @@ func (s *Service) run(ctx context.Context, params Params, ctrl *Control) error {
 			streamCancel()
 		}
 	}()
-	dataCh, errCh := s.Storage.Stream(streamCtx, storage.StreamRequest{
-		Sensors: params.Sensors,
-		From:    params.From,
-		To:      params.To,
-		Window:  params.Window,
-	})
+	dataCh, errCh := s.openStream(streamCtx, params, params.From, params.Window)
 
 	eventCh, streamErr := fanInEvents(streamCtx, dataCh, errCh)
+	// playCh — поток, открытый с окном Window. После seek/шага на паузе поток перезапущен с SeekWindow,
+	// и при продолжении воспроизведения его нужно открыть заново с полным окном: с момента восстановления
+	// состояния или, если по этому потоку уже сыграны шаги, с последнего сыгранного шага (appliedTs).
+	playCh := eventCh
+	appliedCh := eventCh
+	var appliedTs time.Time
 
 	stepTs := params.From
 	var stepID int64
 	pending := make([]storage.SensorEvent, 0, 128)
 	paused := false
 	stepOnce := false
+	pauseAt := params.PauseAt
+	emptySent := false
 
-	for stepTs.Before(params.To) {
+	if params.PrimeOnStart && saveOutput {
+		// Снимок уходит шагом 0, как начальный снимок StartDelay.
+		updates := fullSnapshotUpdates(s, state)
+		info := StepInfo{StepTs: stepTs}
+		if err := s.sendBatches(ctx, params, 0, stepTs, updates, &info); err != nil {
+			return err
+		}
+		if info.BatchesFailed > 0 && ctrl != nil && ctrl.OnStep != nil {
+			ctrl.OnStep(info)
+		}
+		for _, st := range state {
+			st.dirty = false
+		}
+		emptySent = s.EmitEmpty
+		log.Printf("[replay] primed output with %d initial updates", len(updates))
+	}
+
+	if params.StartDelay > 0 {
+		// Снимок забирает флаги изменений: первый шаг отправит только то, что изменилось после From.
+		updates := collectUpdates(state, s.output(), aggregate)
+		if s.EmitEmpty {
+			updates = appendEmpty(updates, state)
+			emptySent = true
+		}
+		if saveOutput {
+			info := StepInfo{StepTs: stepTs, UpdatesCount: len(updates)}
+			if err := s.sendBatches(ctx, params, 0, stepTs, updates, &info); err != nil {
+				return err
+			}
+		}
+		log.Printf("[replay] start delay %s: sent %d initial updates, waiting before the first step", params.StartDelay, len(updates))
+		handle := func() error {
+			if ctrl == nil {
+				return nil
+			}
+			return handleCommands(ctx, s, params, ctrl, &saveOutput, &state, &stepTs, &stepID, &streamCancel, &eventCh, &streamErr, &pending, &paused, &stepOnce, &pauseAt, cache)
+		}
+		if err := waitStartDelay(ctx, params.StartDelay, handle); err != nil {
+			return err
+		}
+	}
+
+	if params.StartPaused && ctrl != nil {
+		paused = true
+		if err := waitWhilePaused(ctx, s, params, ctrl, &saveOutput, &state, &stepTs, &stepID, &streamCancel, &eventCh, &streamErr, &pending, &paused, &stepOnce, &pauseAt, cache); err != nil {
+			return err
+		}
+	}
+
+	// На паузе после последнего шага (play-until до конца диапазона) ждём команд, а не завершаемся.
+	for params.InPeriod(stepTs) || params.Follow || (paused && ctrl != nil) {
 		stepID++
 		select {
 		case <-ctx.Done():
@@ -94,65 +353,75 @@ func (s *Service) run(ctx context.Context, params Params, ctrl *Control) error {
 		}
 
 		if ctrl != nil {
-			if err := handleCommands(ctx, s, params, ctrl, &saveOutput, &state, &stepTs, &stepID, &streamCancel, &eventCh, &streamErr, &pending, &paused, &stepOnce, cache); err != nil {
+			if err := handleCommands(ctx, s, params, ctrl, &saveOutput, &state, &stepTs, &stepID, &streamCancel, &eventCh, &streamErr, &pending, &paused, &stepOnce, &pauseAt, cache); err != nil {
 				return err
 			}
 		}
 
 		if paused {
 			if ctrl != nil {
-				if err := waitWhilePaused(ctx, s, params, ctrl, &saveOutput, &state, &stepTs, &stepID, &streamCancel, &eventCh, &streamErr, &pending, &paused, &stepOnce, cache); err != nil {
+				if err := waitWhilePaused(ctx, s, params, ctrl, &saveOutput, &state, &stepTs, &stepID, &streamCancel, &eventCh, &streamErr, &pending, &paused, &stepOnce, &pauseAt, cache); err != nil {
 					return err
 				}
 			}
 			continue
 		}
+		if params.ActiveHours != nil && !params.ActiveHours.Active(stepTs) {
+			next := params.nextActiveStep(stepTs)
+			if ctrl != nil && ctrl.OnSkip != nil {
+				end := next
+				if end.After(params.To) {
+					end = params.To
+				}
+				ctrl.OnSkip(stepTs, end)
+			}
+			if !params.InPeriod(next) {
+				break
+			}
+			if err := skipInactive(ctx, s, params, next, &state, &stepTs, &stepID, &streamCancel, &eventCh, &streamErr, &pending, cache); err != nil {
+				return err
+			}
+			playCh = eventCh
+		}
+		if eventCh != playCh && !stepOnce && params.seekWindow() != params.Window {
+			from := stepTs
+			if eventCh == appliedCh {
+				from = appliedTs
+			}
+			if err := restartStream(ctx, s, params, from, params.Window, &streamCancel, &eventCh, &streamErr, &pending); err != nil {
+				return err
+			}
+			playCh = eventCh
+		}
 
-		pending, _ = drainEvents(eventCh, pending)
-		pending = applyPending(state, pending, stepTs)
-
-		updates := collectUpdates(state)
-		if len(updates) > 0 {
-			batchSize := params.BatchSize
-			if batchSize <= 0 || batchSize > len(updates) {
-				batchSize = len(updates)
+		if params.NoPace && !params.Follow {
+			if pending, err = drainAndWait(ctx, state, eventCh, pending, stepTs, s.MaxPendingEvents); err != nil {
+				return err
 			}
-			total := (len(updates) + batchSize - 1) / batchSize
-			if saveOutput {
-				for i := 0; i < total; i++ {
-					start := i * batchSize
-					end := start + batchSize
-					if end > len(updates) {
-						end = len(updates)
-					}
-					payload := sharedmem.StepPayload{
-						StepID:     stepID,
-						StepTs:     stepTs.Format(time.RFC3339),
-						BatchID:    i + 1,
-						BatchTotal: total,
-						Updates:    updates[start:end],
-					}
-					if err := s.Output.Send(ctx, payload); err != nil {
-						return err
-					}
-				}
+		} else {
+			pending = drainAndApply(state, eventCh, pending, stepTs, s.MaxPendingEvents)
+		}
+		appliedCh, appliedTs = eventCh, stepTs
+		applyPulses(state, s.Pulses, stepTs)
+
+		updates := collectUpdates(state, s.output(), aggregate)
+		if s.EmitEmpty && !emptySent {
+			updates = appendEmpty(updates, state)
+			emptySent = true
+		}
+		info := StepInfo{StepID: stepID, StepTs: stepTs, UpdatesCount: len(updates), Violations: checkLimits(s.Limits, updates)}
+		if saveOutput {
+			if err := s.sendBatches(ctx, params, stepID, stepTs, updates, &info); err != nil {
+				return err
 			}
 		}
 
 		if ctrl != nil && ctrl.OnUpdates != nil {
-			ctrl.OnUpdates(StepInfo{
-				StepID:       stepID,
-				StepTs:       stepTs,
-				UpdatesCount: len(updates),
-			}, updates)
+			ctrl.OnUpdates(info, updates)
 		}
 
 		if ctrl != nil && ctrl.OnStep != nil {
-			ctrl.OnStep(StepInfo{
-				StepID:       stepID,
-				StepTs:       stepTs,
-				UpdatesCount: len(updates),
-			})
+			ctrl.OnStep(info)
 		}
 		cache.add(stepTs, stepID, state)
 
@@ -160,11 +429,25 @@ func (s *Service) run(ctx context.Context, params Params, ctrl *Control) error {
 			paused = true
 			stepOnce = false
 		}
+		if !pauseAt.IsZero() && !stepTs.Before(pauseAt) {
+			paused = true
+			pauseAt = time.Time{}
+			if ctrl != nil && ctrl.OnAutoPause != nil {
+				ctrl.OnAutoPause(StepInfo{StepID: stepID, StepTs: stepTs, UpdatesCount: len(updates)})
+			}
+		}
 
-		if err := waitNextStep(ctx, params.Step, params.Speed); err != nil {
-			return err
+		if !params.NoPace {
+			if err := waitNextStep(ctx, params.Step, params.Speed); err != nil {
+				return err
+			}
 		}
 		stepTs = stepTs.Add(params.Step)
+		if params.Follow {
+			if err := waitLiveEdge(ctx, params.followOptions(), stepTs); err != nil {
+				return err
+			}
+		}
 
 		select {
 		case err := <-streamErr:
@@ -182,13 +465,108 @@ func (s *Service) run(ctx context.Context, params Params, ctrl *Control) error {
 		}
 	default:
 	}
+	// Состояние на последнем шаге отдаём, только если оно получено проигрыванием этого шага,
+	// а не восстановлено seek без последующих шагов.
+	if ctrl != nil && ctrl.OnFinish != nil && stepID > 0 && eventCh == appliedCh && !appliedTs.IsZero() {
+		// Без пауз между шагами поток мог не успеть отдать все события до последнего шага.
+		if _, err := drainAndWait(ctx, state, eventCh, pending, appliedTs, s.MaxPendingEvents); err != nil {
+			return err
+		}
+		ctrl.OnFinish(StepInfo{StepID: stepID, StepTs: appliedTs}, stateEvents(state))
+	}
 	return nil
 }
 
+// stateEvents возвращает состояние датчиков в виде событий Warmup (сырые значения, до калибровки).
+func stateEvents(state map[int64]*sensorState) []storage.SensorEvent {
+	events := make([]storage.SensorEvent, 0, len(state))
+	for id, st := range state {
+		if !st.hasValue {
+			continue
+		}
+		events = append(events, storage.SensorEvent{SensorID: id, Timestamp: st.ts, Value: st.value, Undefined: st.undefined})
+	}
+	return events
+}
+
 type sensorState struct {
-	value    float64
-	hasValue bool
-	dirty    bool
+	value     float64
+	hasValue  bool
+	undefined bool
+	dirty     bool
+	ts        time.Time // время последнего события
+	// Агрегаты определённых значений событий с прошлого сбора обновлений (Params.StepAggregate).
+	aggN                   int
+	aggSum, aggMin, aggMax float64
+	// held — значение на ts ещё не учтено в агрегатах: оно может быть заменено событием
+	// с тем же временем (см. supersedes) и учитывается при переходе к более позднему событию или в stepValue.
+	held bool
+}
+
+// supersedes сообщает, заменяет ли событие значение датчика. События с одинаковыми
+// (датчик, время) — дубли строк или пересечение окон — хранилище может отдать в любом порядке,
+// поэтому между ними выбирается не последнее, а по правилу storage.Outranks (то же правило
+// применяют Warmup хранилищ). Точные дубли не применяются повторно.
+// Более позднее (или более раннее, как прежде) событие заменяет значение всегда.
+func (st *sensorState) supersedes(ev storage.SensorEvent) bool {
+	if !st.hasValue || !ev.Timestamp.Equal(st.ts) {
+		return true
+	}
+	return storage.Outranks(ev, storage.SensorEvent{Value: st.value, Undefined: st.undefined})
+}
+
+// apply применяет событие к состоянию, если оно не проигрывает значению с тем же временем.
+func (st *sensorState) apply(ev storage.SensorEvent) bool {
+	if !st.supersedes(ev) {
+		return false
+	}
+	if !ev.Timestamp.Equal(st.ts) {
+		st.flush()
+	}
+	st.value = ev.Value
+	st.hasValue = true
+	st.undefined = ev.Undefined
+	st.ts = ev.Timestamp
+	st.held = !ev.Undefined
+	return true
+}
+
+// flush учитывает в агрегатах шага значение, которое уже не может быть заменено.
+func (st *sensorState) flush() {
+	if st.held {
+		st.accumulate(st.value)
+		st.held = false
+	}
+}
+
+// accumulate учитывает значение события в агрегатах шага.
+func (st *sensorState) accumulate(value float64) {
+	if st.aggN == 0 {
+		st.aggSum, st.aggMin, st.aggMax = 0, value, value
+	}
+	st.aggN++
+	st.aggSum += value
+	st.aggMin = min(st.aggMin, value)
+	st.aggMax = max(st.aggMax, value)
+}
+
+// stepValue возвращает значение шага по режиму агрегации и сбрасывает агрегаты.
+// Без событий за шаг значение удерживается (st.value).
+func (st *sensorState) stepValue(agg StepAggregate) float64 {
+	st.flush()
+	value := st.value
+	if st.aggN > 0 {
+		switch agg {
+		case AggregateAvg:
+			value = st.aggSum / float64(st.aggN)
+		case AggregateMin:
+			value = st.aggMin
+		case AggregateMax:
+			value = st.aggMax
+		}
+	}
+	st.aggN = 0
+	return value
 }
 
 type cacheEntry struct {
@@ -200,6 +578,22 @@ type cacheEntry struct {
 type stateCache struct {
 	entries []cacheEntry
 	limit   int
+	stats   CacheStats
+	onStats func(CacheStats) // Control.OnCache: вызывается после каждого восстановления состояния
+}
+
+// CacheStats — счётчики кэша состояний одного запуска: как восстанавливалось состояние
+// при seek и шагах назад.
+type CacheStats struct {
+	// ExactHits — состояние на целевой момент нашлось в кэше и взято как есть.
+	ExactHits int64 `json:"exact_hits"`
+	// LEHits — взято ближайшее более раннее состояние и догнано событиями (fastForwardFromCache).
+	LEHits int64 `json:"le_hits"`
+	// Rebuilds — промах: состояние восстановлено по истории с нуля (rebuildState).
+	Rebuilds int64 `json:"rebuilds"`
+	// Entries и Limit — занятые и доступные записи кэша.
+	Entries int `json:"entries"`
+	Limit   int `json:"limit"`
 }
 
 func newStateCache(limit int) *stateCache {
@@ -220,6 +614,20 @@ func (c *stateCache) add(ts time.Time, stepID int64, src map[int64]*sensorState)
 	}
 }
 
+// record учитывает исход восстановления состояния (один из счётчиков c.stats) и сообщает счётчики в onStats.
+func (c *stateCache) record(counter *int64) {
+	if c == nil {
+		return
+	}
+	*counter++
+	if c.onStats != nil {
+		stats := c.stats
+		stats.Entries = len(c.entries)
+		stats.Limit = c.limit
+		c.onStats(stats)
+	}
+}
+
 func (c *stateCache) get(ts time.Time) (cacheEntry, bool) {
 	if c == nil {
 		return cacheEntry{}, false
@@ -251,11 +659,10 @@ func applyEvents(state map[int64]*sensorState, events []storage.SensorEvent, mar
 			st = &sensorState{}
 			state[ev.SensorID] = st
 		}
-		st.value = ev.Value
-		st.hasValue = true
-		if markDirty {
+		if st.apply(ev) && markDirty {
 			st.dirty = true
 		}
+		st.held = false // начальное состояние не входит в агрегаты шага
 	}
 }
 
@@ -303,8 +710,9 @@ func fanInEvents(ctx context.Context, dataCh <-chan []storage.SensorEvent, errCh
 	return eventCh, streamErr
 }
 
-func drainEvents(eventCh <-chan storage.SensorEvent, pending []storage.SensorEvent) ([]storage.SensorEvent, bool) {
-	for {
+// drainEvents забирает доступные события без ожидания, но не больше limit в pending (0 — без ограничения).
+func drainEvents(eventCh <-chan storage.SensorEvent, pending []storage.SensorEvent, limit int) ([]storage.SensorEvent, bool) {
+	for limit <= 0 || len(pending) < limit {
 		select {
 		case ev, ok := <-eventCh:
 			if !ok {
@@ -315,6 +723,50 @@ func drainEvents(eventCh <-chan storage.SensorEvent, pending []storage.SensorEve
 			return pending, false
 		}
 	}
+	return pending, false
+}
+
+// drainAndApply применяет события до cutoff. Если буфер упёрся в limit, а применение его освободило,
+// добирает следующую порцию, чтобы события текущего шага не откладывались на следующий.
+func drainAndApply(state map[int64]*sensorState, eventCh <-chan storage.SensorEvent, pending []storage.SensorEvent, cutoff time.Time, limit int) []storage.SensorEvent {
+	for {
+		pending, _ = drainEvents(eventCh, pending, limit)
+		full := limit > 0 && len(pending) >= limit
+		before := len(pending)
+		pending = applyPending(state, pending, cutoff)
+		if !full || len(pending) == before {
+			return pending
+		}
+	}
+}
+
+// drainAndWait — drainAndApply для NoPace: без пауз между шагами поток может не успеть подкачать
+// события шага, поэтому чтение ждёт первого события позже cutoff или конца потока. Так состав шага
+// не зависит от скорости хранилища. События потока упорядочены по времени.
+func drainAndWait(ctx context.Context, state map[int64]*sensorState, eventCh <-chan storage.SensorEvent, pending []storage.SensorEvent, cutoff time.Time, limit int) ([]storage.SensorEvent, error) {
+	for {
+		pending = drainAndApply(state, eventCh, pending, cutoff, limit)
+		if len(pending) > 0 {
+			return pending, nil // в pending остались только события после cutoff
+		}
+		select {
+		case <-ctx.Done():
+			return pending, ctx.Err()
+		case ev, ok := <-eventCh:
+			if !ok {
+				return pending, nil
+			}
+			pending = append(pending, ev)
+		}
+	}
+}
+
+// pendingRecv возвращает канал событий, пока в pending есть место, иначе nil (чтение приостановлено).
+func pendingRecv(eventCh <-chan storage.SensorEvent, pending []storage.SensorEvent, limit int) <-chan storage.SensorEvent {
+	if limit > 0 && len(pending) >= limit {
+		return nil
+	}
+	return eventCh
 }
 
 func applyPending(state map[int64]*sensorState, pending []storage.SensorEvent, cutoff time.Time) []storage.SensorEvent {
@@ -326,9 +778,9 @@ func applyPending(state map[int64]*sensorState, pending []storage.SensorEvent, c
 			st = &sensorState{}
 			state[ev.SensorID] = st
 		}
-		st.value = ev.Value
-		st.hasValue = true
-		st.dirty = true
+		if st.apply(ev) {
+			st.dirty = true
+		}
 		idx++
 	}
 	if idx == 0 {
@@ -338,20 +790,188 @@ func applyPending(state map[int64]*sensorState, pending []storage.SensorEvent, c
 	return pending[:len(pending)-idx]
 }
 
-func collectUpdates(state map[int64]*sensorState) []sharedmem.SensorUpdate {
+// applyPulses возвращает импульсные датчики к значению покоя, если с последнего события прошло
+// не меньше hold. Значение, ещё не отправленное в шаге (dirty), сбрасывается не раньше следующего шага,
+// чтобы короткий импульс не потерялся внутри одного шага.
+func applyPulses(state map[int64]*sensorState, pulses map[int64]config.Pulse, stepTs time.Time) {
+	for hash, p := range pulses {
+		st := state[hash]
+		if st == nil || !st.hasValue || st.undefined || st.dirty || st.value == p.Value {
+			continue
+		}
+		if stepTs.Sub(st.ts) >= p.Hold {
+			st.value = p.Value
+			st.dirty = true
+		}
+	}
+}
+
+// collectUpdates собирает обновления изменившихся датчиков; значение шага выбирается по agg.
+func collectUpdates(state map[int64]*sensorState, out outputFormat, agg StepAggregate) []sharedmem.SensorUpdate {
 	updates := make([]sharedmem.SensorUpdate, 0)
 	for hash, st := range state {
 		if st.dirty && st.hasValue {
-			updates = append(updates, sharedmem.SensorUpdate{
-				Hash:  hash,
-				Value: st.value,
-			})
+			value := st.stepValue(agg)
+			upd := out.update(hash, st)
+			if !upd.Undefined {
+				upd.Value = out.value(hash, value)
+			}
+			updates = append(updates, upd)
 			st.dirty = false
 		}
 	}
 	return updates
 }
 
+// checkLimits отбирает обновления со значением за границами limits; значения не изменяются.
+func checkLimits(limits map[int64]config.Limits, updates []sharedmem.SensorUpdate) []LimitViolation {
+	if len(limits) == 0 {
+		return nil
+	}
+	var violations []LimitViolation
+	for _, upd := range updates {
+		if upd.Undefined || upd.NoData {
+			continue
+		}
+		l, ok := limits[upd.Hash]
+		if !ok {
+			continue
+		}
+		if limit, bound, ok := l.Violated(upd.Value); ok {
+			violations = append(violations, LimitViolation{Hash: upd.Hash, Value: upd.Value, Limit: limit, Bound: bound})
+		}
+	}
+	return violations
+}
+
+// appendEmpty добавляет маркеры NoData для датчиков, у которых ещё нет значения.
+func appendEmpty(updates []sharedmem.SensorUpdate, state map[int64]*sensorState) []sharedmem.SensorUpdate {
+	for hash, st := range state {
+		if !st.hasValue {
+			updates = append(updates, sharedmem.SensorUpdate{Hash: hash, NoData: true})
+		}
+	}
+	return updates
+}
+
+// warmupUpdates перечисляет все датчики состояния: с начальным значением — как обычное
+// обновление, без него — маркером NoData. Флаги dirty не сбрасываются.
+func warmupUpdates(state map[int64]*sensorState, out outputFormat) []sharedmem.SensorUpdate {
+	updates := make([]sharedmem.SensorUpdate, 0, len(state))
+	for hash, st := range state {
+		if st.hasValue {
+			updates = append(updates, out.update(hash, st))
+		} else {
+			updates = append(updates, sharedmem.SensorUpdate{Hash: hash, NoData: true})
+		}
+	}
+	return updates
+}
+
+// outputFormat — преобразование значений перед отправкой: калибровка, округление
+// и приведение дискретных датчиков к 0/1.
+type outputFormat struct {
+	calib     map[int64]config.Calibration
+	round     int // знаков после запятой (<= 0 — без округления)
+	discrete  map[int64]struct{}
+	threshold float64 // порог дискретных значений (<= 0 — без приведения)
+}
+
+func (s *Service) output() outputFormat {
+	return outputFormat{calib: s.Calibration, round: s.ValueRound, discrete: s.Discrete, threshold: s.DiscreteThreshold}
+}
+
+// FormatValue приводит значение датчика к виду, в котором оно уходит в SM и WebSocket:
+// порог дискретных датчиков, калибровка и округление (DiscreteThreshold, Calibration, ValueRound).
+func (s *Service) FormatValue(hash int64, value float64) float64 {
+	return s.output().value(hash, value)
+}
+
+// update формирует обновление для отправки: неопределённые датчики передаются без значения.
+func (o outputFormat) update(hash int64, st *sensorState) sharedmem.SensorUpdate {
+	if st.undefined {
+		return sharedmem.SensorUpdate{Hash: hash, Undefined: true}
+	}
+	return sharedmem.SensorUpdate{Hash: hash, Value: o.value(hash, st.value)}
+}
+
+// value применяет калибровку датчика (если задана) и округление к значению перед отправкой;
+// значение дискретного датчика при заданном пороге становится строго 0 или 1.
+func (o outputFormat) value(hash int64, value float64) float64 {
+	if o.threshold > 0 {
+		if _, ok := o.discrete[hash]; ok {
+			if value >= o.threshold {
+				return 1
+			}
+			return 0
+		}
+	}
+	if c, ok := o.calib[hash]; ok {
+		value = c.Apply(value)
+	}
+	if o.round > 0 {
+		p := math.Pow10(o.round)
+		value = math.Round(value*p) / p
+	}
+	return value
+}
+
+// openStream запускает чтение событий с from до конца периода окнами window,
+// а в режиме Follow — и дальше, по хвосту таблицы.
+func (s *Service) openStream(ctx context.Context, params Params, from time.Time, window time.Duration) (<-chan []storage.SensorEvent, <-chan error) {
+	req := storage.StreamRequest{
+		Sensors: params.Sensors,
+		From:    from,
+		To:      params.streamTo(),
+		Window:  window,
+	}
+	if params.Follow {
+		return storage.Follow(ctx, s.Storage, req, params.followOptions())
+	}
+	return s.Storage.Stream(ctx, req)
+}
+
+// waitLiveEdge в режиме Follow придерживает шаг stepTs, пока опрос хвоста не дойдёт до него,
+// так что после догоняющего воспроизведения шаги идут в реальном времени.
+func waitLiveEdge(ctx context.Context, opts storage.FollowOptions, stepTs time.Time) error {
+	wait := stepTs.Sub(opts.Edge())
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// startDelayPoll — период проверки команд управления во время StartDelay.
+const startDelayPoll = 20 * time.Millisecond
+
+// waitStartDelay выдерживает delay перед первым шагом. Каждые startDelayPoll вызывается handle
+// (обработка команд управления); его ошибка, в том числе ErrStopped, прерывает ожидание.
+func waitStartDelay(ctx context.Context, delay time.Duration, handle func() error) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	ticker := time.NewTicker(startDelayPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return handle()
+		case <-ticker.C:
+			if err := handle(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
 func waitNextStep(ctx context.Context, step time.Duration, speed float64) error {
 	if step <= 0 {
 		return nil
@@ -373,6 +993,35 @@ func waitNextStep(ctx context.Context, step time.Duration, speed float64) error
 	}
 }
 
+// StepTarget — момент на count шагов (не меньше одного) вперёд или назад от stepTs,
+// ограниченный диапазоном [From, To]. Шаги не проигрываются: цель восстанавливается как при seek.
+func StepTarget(params Params, stepTs time.Time, count int, forward bool) time.Time {
+	count = max(count, 1)
+	if params.Step > 0 {
+		// больше шагов, чем во всём диапазоне, — сразу граница (и без переполнения Duration)
+		count = min(count, int(params.To.Sub(params.From)/params.Step)+1)
+		// count*Step за пределами Duration — заведомо дальше любой границы
+		if time.Duration(count) > time.Duration(math.MaxInt64)/params.Step {
+			if forward {
+				return params.To
+			}
+			return params.From
+		}
+	}
+	delta := time.Duration(count) * params.Step
+	if !forward {
+		delta = -delta
+	}
+	target := stepTs.Add(delta)
+	if target.Before(params.From) {
+		return params.From
+	}
+	if target.After(params.To) {
+		return params.To
+	}
+	return target
+}
+
 func handleCommands(
 	ctx context.Context,
 	s *Service,
@@ -388,6 +1037,7 @@ func handleCommands(
 	pending *[]storage.SensorEvent,
 	paused *bool,
 	stepOnce *bool,
+	pauseAt *time.Time,
 	cache *stateCache,
 ) error {
 	for {
@@ -406,14 +1056,20 @@ func handleCommands(
 				*paused = false
 			case CommandStop:
 				respErr = ErrStopped{}
-			case CommandStepForward:
-				*stepOnce = true
+			case CommandPlayUntil:
+				if cmd.TS.Before(params.From) || cmd.TS.After(params.To) {
+					respErr = fmt.Errorf("play-until: target %s is outside range %s-%s", cmd.TS, params.From, params.To)
+					break
+				}
+				*pauseAt = cmd.TS
 				*paused = false
-			case CommandStepBackward:
-				target := (*stepTs).Add(-params.Step)
-				if target.Before(params.From) {
-					target = params.From
+			case CommandStepForward, CommandStepBackward:
+				if cmd.Type == CommandStepForward && cmd.Count <= 1 {
+					*stepOnce = true
+					*paused = false
+					break
 				}
+				target := StepTarget(params, *stepTs, cmd.Count, cmd.Type == CommandStepForward)
 				if err := restoreState(ctx, s, params, target, state, stepTs, stepID, streamCancel, eventCh, streamErr, pending, cache); err != nil {
 					respErr = err
 					break
@@ -421,7 +1077,7 @@ func handleCommands(
 				notifyOnStep(ctrl, *stepID, *stepTs, 0)
 				*paused = true
 				if cmd.Apply {
-					if err := sendFullSnapshot(ctx, s, params, *state, stepID, stepTs, *saveOutput); err != nil {
+					if err := sendFullSnapshot(ctx, s, params, ctrl, *state, stepID, stepTs, *saveOutput); err != nil {
 						respErr = err
 					}
 				}
@@ -439,16 +1095,19 @@ func handleCommands(
 					break
 				}
 				notifyOnStep(ctrl, *stepID, *stepTs, 0)
+				sendPreview(cmd, *state, *stepID, *stepTs)
 				*paused = true
 				if cmd.Apply {
-					if err := sendFullSnapshot(ctx, s, params, *state, stepID, stepTs, *saveOutput); err != nil {
+					if err := sendFullSnapshot(ctx, s, params, ctrl, *state, stepID, stepTs, *saveOutput); err != nil {
 						respErr = err
 					}
 				}
 			case CommandSaveOutput:
 				*saveOutput = cmd.SaveOutput
 			case CommandApply:
-				respErr = sendFullSnapshot(ctx, s, params, *state, stepID, stepTs, *saveOutput)
+				respErr = sendFullSnapshot(ctx, s, params, ctrl, *state, stepID, stepTs, *saveOutput)
+			case CommandSegment:
+				respErr = checkSegment(params, cmd.Index)
 			default:
 			}
 			if cmd.Resp != nil {
@@ -460,6 +1119,9 @@ func handleCommands(
 			if respErr != nil {
 				return respErr
 			}
+			if cmd.Type == CommandSegment {
+				return segmentJump{index: cmd.Index}
+			}
 		default:
 			return nil
 		}
@@ -481,6 +1143,7 @@ func waitWhilePaused(
 	pending *[]storage.SensorEvent,
 	paused *bool,
 	stepOnce *bool,
+	pauseAt *time.Time,
 	cache *stateCache,
 ) error {
 	evCh := *eventCh
@@ -495,14 +1158,20 @@ func waitWhilePaused(
 			// already paused
 		case CommandStop:
 			respErr = ErrStopped{}
-		case CommandStepForward:
-			*stepOnce = true
+		case CommandPlayUntil:
+			if cmd.TS.Before(params.From) || cmd.TS.After(params.To) {
+				respErr = fmt.Errorf("play-until: target %s is outside range %s-%s", cmd.TS, params.From, params.To)
+				break
+			}
+			*pauseAt = cmd.TS
 			*paused = false
-		case CommandStepBackward:
-			target := (*stepTs).Add(-params.Step)
-			if target.Before(params.From) {
-				target = params.From
+		case CommandStepForward, CommandStepBackward:
+			if cmd.Type == CommandStepForward && cmd.Count <= 1 {
+				*stepOnce = true
+				*paused = false
+				break
 			}
+			target := StepTarget(params, *stepTs, cmd.Count, cmd.Type == CommandStepForward)
 			if err := restoreState(ctx, s, params, target, state, stepTs, stepID, streamCancel, eventCh, streamErr, pending, cache); err != nil {
 				respErr = err
 				break
@@ -512,7 +1181,7 @@ func waitWhilePaused(
 			notifyOnStep(ctrl, *stepID, *stepTs, 0)
 			*paused = true
 			if cmd.Apply {
-				if err := sendFullSnapshot(ctx, s, params, *state, stepID, stepTs, *saveOutput); err != nil {
+				if err := sendFullSnapshot(ctx, s, params, ctrl, *state, stepID, stepTs, *saveOutput); err != nil {
 					respErr = err
 				}
 			}
@@ -532,16 +1201,19 @@ func waitWhilePaused(
 			evCh = *eventCh
 			errCh = *streamErr
 			notifyOnStep(ctrl, *stepID, *stepTs, 0)
+			sendPreview(cmd, *state, *stepID, *stepTs)
 			*paused = true
 			if cmd.Apply {
-				if err := sendFullSnapshot(ctx, s, params, *state, stepID, stepTs, *saveOutput); err != nil {
+				if err := sendFullSnapshot(ctx, s, params, ctrl, *state, stepID, stepTs, *saveOutput); err != nil {
 					respErr = err
 				}
 			}
 		case CommandSaveOutput:
 			*saveOutput = cmd.SaveOutput
 		case CommandApply:
-			respErr = sendFullSnapshot(ctx, s, params, *state, stepID, stepTs, *saveOutput)
+			respErr = sendFullSnapshot(ctx, s, params, ctrl, *state, stepID, stepTs, *saveOutput)
+		case CommandSegment:
+			respErr = checkSegment(params, cmd.Index)
 		}
 		if cmd.Resp != nil {
 			select {
@@ -549,6 +1221,9 @@ func waitWhilePaused(
 			default:
 			}
 		}
+		if respErr == nil && cmd.Type == CommandSegment {
+			return segmentJump{index: cmd.Index}
+		}
 		return respErr
 	}
 
@@ -562,7 +1237,7 @@ func waitWhilePaused(
 			}
 			evCh = *eventCh
 			errCh = *streamErr
-		case ev, ok := <-evCh:
+		case ev, ok := <-pendingRecv(evCh, *pending, s.MaxPendingEvents):
 			if !ok {
 				evCh = nil
 				continue
@@ -598,7 +1273,7 @@ func rebuildState(
 	if err != nil {
 		return err
 	}
-	*state = snapshotToState(params.Sensors, snapshot.Values)
+	*state = snapshotToState(params.Sensors, snapshot)
 	return nil
 }
 
@@ -621,73 +1296,23 @@ func fastForwardFromCache(
 		return nil
 	}
 
+	curTs := *stepTs
+	for curTs.Before(target) {
+		curTs = curTs.Add(params.Step)
+	}
+
+	// Кэш уже содержит события до stepTs включительно; добираются события (stepTs, curTs].
 	streamCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	fromTs := stepTs.Add(time.Nanosecond)
 	dataCh, errCh := s.Storage.Stream(streamCtx, storage.StreamRequest{
 		Sensors: params.Sensors,
-		From:    fromTs,
-		To:      target,
+		From:    inclusiveTo(*stepTs),
+		To:      inclusiveTo(curTs),
 		Window:  params.Window,
 	})
 	eventCh, streamErr := fanInEvents(streamCtx, dataCh, errCh)
-
-	pending := make([]storage.SensorEvent, 0, 128)
-	// Собираем события до закрытия/до первой порции, без busy-loop.
-	closed := false
-	for !closed && len(pending) == 0 {
-		select {
-		case ev, ok := <-eventCh:
-			if !ok {
-				closed = true
-				eventCh = nil
-				continue
-			}
-			pending = append(pending, ev)
-		case err := <-streamErr:
-			if err != nil {
-				return err
-			}
-			streamErr = nil
-		case <-ctx.Done():
-			return ctx.Err()
-		}
-	}
-	// Дальше добираем всё, что успело прилететь.
-	for {
-		select {
-		case ev, ok := <-eventCh:
-			if !ok {
-				closed = true
-				eventCh = nil
-				continue
-			}
-			pending = append(pending, ev)
-		case err := <-streamErr:
-			if err != nil {
-				return err
-			}
-			streamErr = nil
-		default:
-			goto collected
-		}
-	}
-
-collected:
-
-	curTs := *stepTs
-
-	for curTs.Before(target) {
-		curTs = curTs.Add(params.Step)
-		pending = applyPending(*state, pending, curTs)
-	}
-
-	select {
-	case err := <-streamErr:
-		if err != nil {
-			return err
-		}
-	default:
+	if err := applyStream(ctx, *state, eventCh, streamErr, curTs); err != nil {
+		return err
 	}
 
 	*stepTs = curTs
@@ -695,12 +1320,12 @@ collected:
 	return nil
 }
 
-func snapshotToState(ids []int64, values map[int64]float64) map[int64]*sensorState {
+func snapshotToState(ids []int64, snapshot StateSnapshot) map[int64]*sensorState {
 	newState := make(map[int64]*sensorState, len(ids))
 	for _, id := range ids {
 		newState[id] = &sensorState{}
 	}
-	for id, v := range values {
+	for id, v := range snapshot.Values {
 		st := newState[id]
 		if st == nil {
 			st = &sensorState{}
@@ -708,6 +1333,8 @@ func snapshotToState(ids []int64, values map[int64]float64) map[int64]*sensorSta
 		}
 		st.value = v
 		st.hasValue = true
+		st.undefined = snapshot.Undefined[id]
+		st.ts = snapshot.Updated[id]
 	}
 	return newState
 }
@@ -718,7 +1345,7 @@ func cloneState(src map[int64]*sensorState) map[int64]*sensorState {
 		if st == nil {
 			continue
 		}
-		dst[id] = &sensorState{value: st.value, hasValue: st.hasValue}
+		dst[id] = &sensorState{value: st.value, hasValue: st.hasValue, undefined: st.undefined, ts: st.ts}
 	}
 	return dst
 }
@@ -728,6 +1355,7 @@ func restartStream(
 	s *Service,
 	params Params,
 	from time.Time,
+	window time.Duration,
 	streamCancel *context.CancelFunc,
 	eventCh *<-chan storage.SensorEvent,
 	streamErr *<-chan error,
@@ -741,12 +1369,7 @@ func restartStream(
 	}
 	streamCtx, cancel := context.WithCancel(ctx)
 	*pending = (*pending)[:0]
-	dataCh, errCh := s.Storage.Stream(streamCtx, storage.StreamRequest{
-		Sensors: params.Sensors,
-		From:    from,
-		To:      params.To,
-		Window:  params.Window,
-	})
+	dataCh, errCh := s.openStream(streamCtx, params, from, window)
 	*eventCh, *streamErr = fanInEvents(streamCtx, dataCh, errCh)
 	*pending = make([]storage.SensorEvent, 0, 128)
 	*streamCancel = cancel
@@ -774,6 +1397,7 @@ func restoreState(
 		*state = cloneState(entry.state)
 		*stepTs = entry.ts
 		*stepID = entry.stepID
+		cache.record(&cache.stats.ExactHits)
 	} else if entry, ok := cache.getLE(target); ok {
 		if s != nil && s.LogCache {
 			log.Printf("[replay] cache hit le ts=%s step=%d target=%s", entry.ts.Format(time.RFC3339), entry.stepID, target.Format(time.RFC3339))
@@ -785,6 +1409,7 @@ func restoreState(
 			return err
 		}
 		cache.add(*stepTs, *stepID, *state)
+		cache.record(&cache.stats.LEHits)
 	} else {
 		if s != nil && s.LogCache {
 			log.Printf("[replay] cache miss, rebuild target=%s", target.Format(time.RFC3339))
@@ -799,46 +1424,120 @@ func restoreState(
 			*stepID = int64(target.Sub(params.From)/params.Step) + 1
 		}
 		cache.add(*stepTs, *stepID, *state)
+		cache.record(&cache.stats.Rebuilds)
+	}
+	// Агрегаты шага считаются только по событиям, сыгранным после восстановления.
+	for _, st := range *state {
+		st.aggN = 0
+		st.held = false
 	}
-	if err := restartStream(ctx, s, params, *stepTs, streamCancel, eventCh, streamErr, pending); err != nil {
+	if err := restartStream(ctx, s, params, *stepTs, params.seekWindow(), streamCancel, eventCh, streamErr, pending); err != nil {
 		return err
 	}
 	return nil
 }
-func sendFullSnapshot(ctx context.Context, s *Service, params Params, state map[int64]*sensorState, stepID *int64, stepTs *time.Time, saveOutput bool) error {
+
+// skipInactive переносит цикл через неактивный промежуток ActiveHours на шаг next: состояние
+// собирается заново Warmup на next (события промежутка не проигрываются) и целиком уходит в первый
+// шаг после пропуска, поток перезапускается с next с полным окном Window.
+func skipInactive(
+	ctx context.Context,
+	s *Service,
+	params Params,
+	next time.Time,
+	state *map[int64]*sensorState,
+	stepTs *time.Time,
+	stepID *int64,
+	streamCancel *context.CancelFunc,
+	eventCh *<-chan storage.SensorEvent,
+	streamErr *<-chan error,
+	pending *[]storage.SensorEvent,
+	cache *stateCache,
+) error {
+	warm, err := s.Storage.Warmup(ctx, params.Sensors, next)
+	if err != nil {
+		return fmt.Errorf("replay: warmup: %w", err)
+	}
+	fresh := make(map[int64]*sensorState, len(params.Sensors))
+	for _, id := range params.Sensors {
+		fresh[id] = &sensorState{}
+	}
+	applyEvents(fresh, warm, true)
+	*state = fresh
+	*stepTs = next
+	*stepID = int64(next.Sub(params.From)/params.Step) + 1
+	cache.add(next, *stepID, fresh)
+	return restartStream(ctx, s, params, next, params.Window, streamCancel, eventCh, streamErr, pending)
+}
+
+func sendFullSnapshot(ctx context.Context, s *Service, params Params, ctrl *Control, state map[int64]*sensorState, stepID *int64, stepTs *time.Time, saveOutput bool) error {
+	updates := fullSnapshotUpdates(s, state)
+	if len(updates) == 0 {
+		return nil
+	}
+	*stepID++
+	if !saveOutput {
+		return nil
+	}
+	info := StepInfo{StepID: *stepID, StepTs: *stepTs}
+	if err := s.sendBatches(ctx, params, *stepID, *stepTs, updates, &info); err != nil {
+		return err
+	}
+	// Итог отправки снимка сообщаем без UpdatesCount: это не шаг воспроизведения.
+	if info.BatchesFailed > 0 && ctrl != nil && ctrl.OnStep != nil {
+		ctrl.OnStep(info)
+	}
+	return nil
+}
+
+// fullSnapshotUpdates перечисляет значения всех датчиков состояния (с EmitEmpty — и маркеры NoData).
+func fullSnapshotUpdates(s *Service, state map[int64]*sensorState) []sharedmem.SensorUpdate {
 	updates := make([]sharedmem.SensorUpdate, 0, len(state))
 	for hash, st := range state {
 		if st.hasValue {
-			updates = append(updates, sharedmem.SensorUpdate{Hash: hash, Value: st.value})
+			updates = append(updates, s.output().update(hash, st))
 		}
 	}
+	if s.EmitEmpty {
+		updates = appendEmpty(updates, state)
+	}
+	return updates
+}
+
+// sendBatches отправляет обновления шага батчами и записывает итог в info.
+// Ошибка возвращается только без params.BestEffort; иначе неудачный батч учитывается и отправка продолжается.
+func (s *Service) sendBatches(ctx context.Context, params Params, stepID int64, stepTs time.Time, updates []sharedmem.SensorUpdate, info *StepInfo) error {
 	if len(updates) == 0 {
 		return nil
 	}
-	*stepID++
 	batchSize := params.BatchSize
 	if batchSize <= 0 || batchSize > len(updates) {
 		batchSize = len(updates)
 	}
 	total := (len(updates) + batchSize - 1) / batchSize
-	if saveOutput {
-		for i := 0; i < total; i++ {
-			start := i * batchSize
-			end := start + batchSize
-			if end > len(updates) {
-				end = len(updates)
-			}
-			payload := sharedmem.StepPayload{
-				StepID:     *stepID,
-				StepTs:     stepTs.Format(time.RFC3339),
-				BatchID:    i + 1,
-				BatchTotal: total,
-				Updates:    updates[start:end],
-			}
-			if err := s.Output.Send(ctx, payload); err != nil {
+	for i := 0; i < total; i++ {
+		start := i * batchSize
+		end := start + batchSize
+		if end > len(updates) {
+			end = len(updates)
+		}
+		payload := sharedmem.StepPayload{
+			StepID:     stepID,
+			StepTs:     stepTs.Format(time.RFC3339),
+			BatchID:    i + 1,
+			BatchTotal: total,
+			Updates:    updates[start:end],
+		}
+		if err := s.Output.Send(ctx, payload); err != nil {
+			if !params.BestEffort || ctx.Err() != nil {
 				return err
 			}
+			log.Printf("[replay] step=%d batch %d/%d send failed: %v", stepID, i+1, total, err)
+			info.BatchesFailed++
+			info.SendErr = err
+			continue
 		}
+		info.BatchesSent++
 	}
 	return nil
 }
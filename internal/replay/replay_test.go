@@ -2,13 +2,20 @@ package replay
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/pv/uniset-timemachine-go/internal/sharedmem"
 	"github.com/pv/uniset-timemachine-go/internal/storage"
+	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
 type fakeStorage struct {
@@ -259,6 +266,82 @@ func TestRunWithControlStepBackwardApply(t *testing.T) {
 	}
 }
 
+func TestStepTarget(t *testing.T) {
+	from := time.Date(2025, 11, 21, 0, 0, 0, 0, time.UTC)
+	params := Params{From: from, To: from.Add(time.Minute), Step: time.Second}
+	cur := from.Add(30 * time.Second)
+	cases := []struct {
+		count   int
+		forward bool
+		want    time.Time
+	}{
+		{0, false, cur.Add(-time.Second)},
+		{1, true, cur.Add(time.Second)},
+		{10, false, cur.Add(-10 * time.Second)},
+		{10, true, cur.Add(10 * time.Second)},
+		{50, false, from},
+		{50, true, params.To},
+		{math.MaxInt, true, params.To},
+		{math.MaxInt, false, from},
+	}
+	for _, tc := range cases {
+		if got := StepTarget(params, cur, tc.count, tc.forward); !got.Equal(tc.want) {
+			t.Fatalf("StepTarget(count=%d, forward=%t) = %s, want %s", tc.count, tc.forward, got, tc.want)
+		}
+	}
+
+	// Огромный диапазон с крупным шагом: count*Step не помещается в Duration.
+	century := 100 * 365 * 24 * time.Hour
+	huge := Params{From: from, To: from.AddDate(250, 0, 0), Step: century}
+	if got := StepTarget(huge, from, 3, true); !got.Equal(huge.To) {
+		t.Fatalf("StepTarget on a huge range = %s, want %s", got, huge.To)
+	}
+	if got := StepTarget(huge, huge.To, 3, false); !got.Equal(from) {
+		t.Fatalf("StepTarget back on a huge range = %s, want %s", got, from)
+	}
+}
+
+func TestValidateStep(t *testing.T) {
+	from := time.Date(2025, 11, 21, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		to   time.Time
+		step time.Duration
+		ok   bool
+	}{
+		{from.Add(time.Hour), time.Second, true},
+		{from.Add(time.Hour), 0, false},
+		{from.Add(time.Hour), -time.Second, false},
+		{from.Add(time.Hour), time.Nanosecond, false},
+		{from.Add(time.Hour), MinStep, true},
+		// год по 1ms — 3.15e10 шагов, больше MaxSteps
+		{from.AddDate(1, 0, 0), time.Millisecond, false},
+		{from.AddDate(1, 0, 0), time.Minute, true},
+		// граница: ровно MaxSteps шагов допустимо, на один больше — нет
+		{from.Add(MaxSteps * time.Second), time.Second, true},
+		{from.Add((MaxSteps + 1) * time.Second), time.Second, false},
+		// разница больше 292 лет насыщается, а не переполняется
+		{from.AddDate(1000, 0, 0), time.Millisecond, false},
+		{from.AddDate(1000, 0, 0), 24 * time.Hour, true},
+	}
+	for _, tc := range cases {
+		if err := ValidateStep(from, tc.to, tc.step); (err == nil) != tc.ok {
+			t.Errorf("ValidateStep(%s, step %s) = %v, want ok=%t", tc.to.Sub(from), tc.step, err, tc.ok)
+		}
+	}
+
+	// Цикл отказывает сразу, а не крутит миллиарды пустых шагов.
+	svc := Service{Storage: &controlStorage{}, Output: &fakeClient{}}
+	for _, p := range []Params{
+		{Sensors: []int64{1}, From: from, To: from.Add(time.Second), Step: time.Microsecond},
+		{Sensors: []int64{1}, From: from, To: from.AddDate(5, 0, 0), Step: time.Millisecond},
+		{Sensors: []int64{1}, Step: time.Millisecond, Segments: []Segment{{From: from, To: from.AddDate(5, 0, 0)}}},
+	} {
+		if err := svc.Run(context.Background(), p); err == nil || !strings.Contains(err.Error(), "step") {
+			t.Fatalf("Run(step %s, %s) = %v, want a step error", p.Step, p.To.Sub(p.From), err)
+		}
+	}
+}
+
 func TestRunWithControlSeekApply(t *testing.T) {
 	from := time.Date(2025, 11, 21, 0, 0, 0, 0, time.UTC)
 	target := from.Add(2 * time.Second)
@@ -320,7 +403,8 @@ func TestRunWithControlSeekApply(t *testing.T) {
 	if snap.StepTs != target.Format(time.RFC3339) {
 		t.Fatalf("snapshot ts mismatch: %s", snap.StepTs)
 	}
-	if len(snap.Updates) != 1 || snap.Updates[0].Value != 1 {
+	// Событие ровно в момент seek входит в состояние, как и на шаге проигрывания.
+	if len(snap.Updates) != 1 || snap.Updates[0].Value != 2 {
 		t.Fatalf("snapshot updates mismatch: %+v", snap.Updates)
 	}
 }
@@ -400,6 +484,130 @@ func TestRunWithControlSaveOutputToggle(t *testing.T) {
 	}
 }
 
+func TestRunWithControlPauseAt(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &controlStorage{
+		events: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start, Value: 10},
+		},
+	}
+	cmdCh := make(chan Command, 2)
+	pauseCh := make(chan StepInfo, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+
+	svc := Service{Storage: st, Output: &fakeClient{}}
+	params := Params{
+		Sensors: []int64{1},
+		From:    start,
+		To:      start.Add(10 * time.Second),
+		Step:    time.Second,
+		Window:  time.Second,
+		Speed:   1000,
+		PauseAt: start.Add(3 * time.Second),
+	}
+
+	go func() {
+		done <- svc.RunWithControl(ctx, params, Control{
+			Commands: cmdCh,
+			OnAutoPause: func(info StepInfo) {
+				pauseCh <- info
+			},
+		})
+	}()
+
+	waitPause := func(want time.Time) {
+		t.Helper()
+		select {
+		case info := <-pauseCh:
+			if !info.StepTs.Equal(want) {
+				t.Fatalf("paused at %s, want %s", info.StepTs, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timeout waiting for pause at %s", want)
+		}
+	}
+
+	waitPause(start.Add(3 * time.Second))
+	// Цель вне диапазона отклоняется.
+	resp := make(chan error, 1)
+	cmdCh <- Command{Type: CommandPlayUntil, TS: start.Add(time.Minute), Resp: resp}
+	select {
+	case err := <-resp:
+		if err == nil {
+			t.Fatalf("expected out-of-range error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting for play-until response")
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("run did not finish after rejected command")
+	}
+}
+
+func TestRunWithControlPlayUntilFromPause(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &controlStorage{
+		events: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start, Value: 10},
+		},
+	}
+	cmdCh := make(chan Command, 2)
+	pauseCh := make(chan StepInfo, 2)
+	stepCh := make(chan StepInfo, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+
+	svc := Service{Storage: st, Output: &fakeClient{}}
+	params := Params{
+		Sensors: []int64{1},
+		From:    start,
+		To:      start.Add(10 * time.Second),
+		Step:    time.Second,
+		Window:  time.Second,
+		Speed:   1000,
+	}
+
+	cmdCh <- Command{Type: CommandPause}
+	go func() {
+		done <- svc.RunWithControl(ctx, params, Control{
+			Commands: cmdCh,
+			OnStep: func(info StepInfo) {
+				stepCh <- info
+			},
+			OnAutoPause: func(info StepInfo) {
+				pauseCh <- info
+			},
+		})
+	}()
+
+	cmdCh <- Command{Type: CommandPlayUntil, TS: start.Add(4 * time.Second)}
+	select {
+	case info := <-pauseCh:
+		if !info.StepTs.Equal(start.Add(4 * time.Second)) {
+			t.Fatalf("paused at %s, want %s", info.StepTs, start.Add(4*time.Second))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timeout waiting for auto pause")
+	}
+	// После достижения цели шаги не идут, пока не придёт resume.
+	time.Sleep(50 * time.Millisecond)
+	if n := len(stepCh); n != 5 {
+		t.Fatalf("expected 5 steps before pause, got %d", n)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("run did not finish after cancel")
+	}
+}
+
 func TestRunWithControlApplyRespectsSaveOutput(t *testing.T) {
 	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
 	st := &controlStorage{
@@ -519,3 +727,1506 @@ func TestStateCacheFastForward(t *testing.T) {
 		t.Fatalf("state value after restore = %v, want 14", val)
 	}
 }
+
+// floodStorage отдаёт огромное окно событий и считает, сколько из них забрал читатель.
+type floodStorage struct {
+	start  time.Time
+	total  int
+	batch  int
+	pushed atomic.Int64
+}
+
+func (f *floodStorage) Warmup(context.Context, []int64, time.Time) ([]storage.SensorEvent, error) {
+	return nil, nil
+}
+
+func (f *floodStorage) Stream(ctx context.Context, _ storage.StreamRequest) (<-chan []storage.SensorEvent, <-chan error) {
+	dataCh := make(chan []storage.SensorEvent)
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(dataCh)
+		defer close(errCh)
+		for i := 0; i < f.total; i += f.batch {
+			batch := make([]storage.SensorEvent, 0, f.batch)
+			for j := i; j < i+f.batch && j < f.total; j++ {
+				batch = append(batch, storage.SensorEvent{SensorID: 1, Timestamp: f.start.Add(time.Duration(j) * time.Millisecond), Value: float64(j)})
+			}
+			select {
+			case dataCh <- batch:
+				f.pushed.Add(int64(len(batch)))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return dataCh, errCh
+}
+
+func (f *floodStorage) Range(context.Context, []int64, time.Time, time.Time) (time.Time, time.Time, int64, error) {
+	return time.Time{}, time.Time{}, 0, nil
+}
+
+func TestServiceRunCapsPendingEvents(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	const limit = 2000
+	// События начинаются через 10 минут после from: за время теста ни одно не применяется.
+	store := &floodStorage{start: start.Add(10 * time.Minute), total: 500000, batch: 100}
+	svc := Service{Storage: store, Output: &fakeClient{}, MaxPendingEvents: limit}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.Run(ctx, Params{
+			Sensors:   []int64{1},
+			From:      start,
+			To:        start.Add(time.Hour),
+			Step:      20 * time.Millisecond,
+			Window:    time.Hour,
+			Speed:     1,
+			BatchSize: 10,
+		})
+	}()
+
+	// Весь поток опережает курсор на 10 минут: без лимита читатель забрал бы все 500k событий.
+	time.Sleep(300 * time.Millisecond)
+	pushed := store.pushed.Load()
+	cancel()
+	<-done
+
+	// pending + буфер fan-in (1024) + порция, которую fan-in держит в руках.
+	if maxPushed := int64(limit + 1024 + 2*store.batch); pushed > maxPushed {
+		t.Fatalf("storage pushed %d events, want <= %d", pushed, maxPushed)
+	}
+	if pushed < limit {
+		t.Fatalf("storage pushed %d events, expected the buffer to fill up to %d", pushed, limit)
+	}
+}
+
+func TestDrainAndApplyPastLimit(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	eventCh := make(chan storage.SensorEvent, 64)
+	for i := 0; i < 50; i++ {
+		eventCh <- storage.SensorEvent{SensorID: 1, Timestamp: start.Add(time.Duration(i) * time.Millisecond), Value: float64(i)}
+	}
+	eventCh <- storage.SensorEvent{SensorID: 1, Timestamp: start.Add(time.Minute), Value: 100}
+	state := map[int64]*sensorState{1: {}}
+
+	// Лимит меньше числа событий шага: все события до cutoff должны примениться в этом же шаге.
+	pending := drainAndApply(state, eventCh, nil, start.Add(time.Second), 8)
+	if state[1].value != 49 {
+		t.Fatalf("value = %v, want 49", state[1].value)
+	}
+	if len(pending) != 1 || pending[0].Value != 100 {
+		t.Fatalf("pending = %+v, want only the future event", pending)
+	}
+}
+
+func TestApplyPendingSameTimestampDuplicates(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	ts := start.Add(500 * time.Millisecond)
+	dups := []storage.SensorEvent{
+		{SensorID: 1, Timestamp: ts, Value: 3},
+		{SensorID: 1, Timestamp: ts, Value: 7},
+		{SensorID: 1, Timestamp: ts, Value: 7},
+		{SensorID: 1, Timestamp: ts, Undefined: true},
+		{SensorID: 1, Timestamp: ts, Value: 5},
+	}
+	// Любой порядок дублей даёт одно и то же: определённое значение, из определённых — большее.
+	orders := [][]int{{0, 1, 2, 3, 4}, {4, 3, 2, 1, 0}, {3, 0, 4, 1, 2}}
+	for _, order := range orders {
+		state := map[int64]*sensorState{}
+		pending := make([]storage.SensorEvent, 0, len(order))
+		for _, i := range order {
+			pending = append(pending, dups[i])
+		}
+		applyPending(state, pending, start.Add(time.Second))
+		st := state[1]
+		if st.value != 7 || st.undefined {
+			t.Fatalf("order %v: value = %v undefined=%v, want 7", order, st.value, st.undefined)
+		}
+		// Дубли одного момента — одно значение в агрегатах шага.
+		if got := st.stepValue(AggregateAvg); got != 7 {
+			t.Fatalf("order %v: avg = %v, want 7", order, got)
+		}
+	}
+
+	// Дубль, пришедший после границы шага (пересечение окон): меньшее значение не заменяет отправленное.
+	state := map[int64]*sensorState{}
+	applyPending(state, []storage.SensorEvent{{SensorID: 1, Timestamp: ts, Value: 7}}, start.Add(time.Second))
+	state[1].dirty = false
+	applyPending(state, []storage.SensorEvent{{SensorID: 1, Timestamp: ts, Value: 3}}, start.Add(2*time.Second))
+	if st := state[1]; st.value != 7 || st.dirty {
+		t.Fatalf("late duplicate: value = %v dirty=%v, want 7 and not dirty", st.value, st.dirty)
+	}
+	// Более позднее событие заменяет значение как обычно, даже меньшее.
+	applyPending(state, []storage.SensorEvent{{SensorID: 1, Timestamp: ts.Add(time.Second), Value: 1}}, start.Add(2*time.Second))
+	if st := state[1]; st.value != 1 || !st.dirty {
+		t.Fatalf("newer event: value = %v dirty=%v, want 1 and dirty", st.value, st.dirty)
+	}
+}
+
+// rejectClient отклоняет батчи с заданным BatchID, остальные принимает.
+type rejectClient struct {
+	fakeClient
+	rejectBatch int
+}
+
+func (c *rejectClient) Send(ctx context.Context, payload sharedmem.StepPayload) error {
+	if payload.BatchID == c.rejectBatch {
+		return errors.New("sm rejected batch")
+	}
+	return c.fakeClient.Send(ctx, payload)
+}
+
+func TestServiceRunReportsSendOutcome(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 100},
+			{SensorID: 2, Timestamp: start.Add(-time.Second), Value: 200},
+		},
+	}
+	params := Params{
+		Sensors:    []int64{1, 2},
+		From:       start,
+		To:         start.Add(2 * time.Second),
+		Step:       time.Second,
+		Window:     time.Minute,
+		Speed:      100,
+		BatchSize:  1,
+		SaveOutput: true,
+	}
+
+	strict := Service{Storage: st, Output: &rejectClient{rejectBatch: 2}}
+	if err := strict.Run(context.Background(), params); err == nil || err.Error() != "sm rejected batch" {
+		t.Fatalf("strict run error = %v, want send error", err)
+	}
+
+	client := &rejectClient{rejectBatch: 2}
+	svc := Service{Storage: st, Output: client}
+	params.BestEffort = true
+	var steps []StepInfo
+	err := svc.RunWithControl(context.Background(), params, Control{
+		Commands: make(chan Command),
+		OnStep:   func(info StepInfo) { steps = append(steps, info) },
+	})
+	if err != nil {
+		t.Fatalf("best-effort run failed: %v", err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("steps = %d, want 2", len(steps))
+	}
+	first := steps[0]
+	if first.BatchesSent != 1 || first.BatchesFailed != 1 || first.SendErr == nil {
+		t.Fatalf("first step outcome = %+v, want 1 sent / 1 failed", first)
+	}
+	if steps[1].BatchesSent != 0 || steps[1].BatchesFailed != 0 || steps[1].SendErr != nil {
+		t.Fatalf("second step outcome = %+v, want nothing sent", steps[1])
+	}
+	if len(client.payloads) != 1 {
+		t.Fatalf("accepted payloads = %d, want 1", len(client.payloads))
+	}
+}
+
+// cancelClient отклоняет каждую отправку и отменяет контекст на заданном вызове.
+type cancelClient struct {
+	cancel  context.CancelFunc
+	cancelN int
+	calls   int
+}
+
+func (c *cancelClient) Send(context.Context, sharedmem.StepPayload) error {
+	c.calls++
+	if c.calls == c.cancelN {
+		c.cancel()
+		return context.Canceled
+	}
+	return errors.New("sm rejected batch")
+}
+
+func TestServiceRunBestEffortStopsOnCancel(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 1},
+			{SensorID: 2, Timestamp: start.Add(-time.Second), Value: 2},
+			{SensorID: 3, Timestamp: start.Add(-time.Second), Value: 3},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &cancelClient{cancel: cancel, cancelN: 2}
+	svc := Service{Storage: st, Output: client}
+	err := svc.Run(ctx, Params{
+		Sensors:    []int64{1, 2, 3},
+		From:       start,
+		To:         start.Add(time.Hour),
+		Step:       time.Second,
+		Window:     time.Minute,
+		Speed:      1,
+		BatchSize:  1,
+		SaveOutput: true,
+		BestEffort: true,
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("run error = %v, want context.Canceled", err)
+	}
+	// Первый отказ пропущен, на отмене оставшийся батч шага уже не отправляется.
+	if client.calls != 2 {
+		t.Fatalf("send calls = %d, want 2", client.calls)
+	}
+}
+
+func TestServiceRunAppliesCalibration(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 100},
+			{SensorID: 2, Timestamp: start.Add(-time.Second), Value: 7},
+		},
+	}
+	client := &fakeClient{}
+	svc := Service{
+		Storage: st,
+		Output:  client,
+		Calibration: map[int64]config.Calibration{
+			1: {Scale: 0.5, Offset: 1},
+		},
+	}
+	params := Params{
+		Sensors:    []int64{1, 2},
+		From:       start,
+		To:         start.Add(time.Second),
+		Step:       time.Second,
+		Speed:      1000,
+		SaveOutput: true,
+	}
+	if err := svc.Run(context.Background(), params); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if len(client.payloads) != 1 {
+		t.Fatalf("expected 1 payload, got %d", len(client.payloads))
+	}
+	got := make(map[int64]float64)
+	for _, upd := range client.payloads[0].Updates {
+		got[upd.Hash] = upd.Value
+	}
+	if got[1] != 51 {
+		t.Fatalf("calibrated value = %v, want 51", got[1])
+	}
+	if got[2] != 7 {
+		t.Fatalf("uncalibrated value = %v, want 7", got[2])
+	}
+}
+
+func TestServiceRunRoundsValues(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 1.23456},
+			{SensorID: 2, Timestamp: start.Add(-time.Second), Value: 10.004},
+		},
+	}
+	client := &fakeClient{}
+	maxLimit := 10.0
+	svc := Service{
+		Storage:     st,
+		Output:      client,
+		Calibration: map[int64]config.Calibration{1: {Scale: 2}},
+		Limits:      map[int64]config.Limits{2: {Max: &maxLimit}},
+		ValueRound:  2,
+	}
+	params := Params{
+		Sensors:    []int64{1, 2},
+		From:       start,
+		To:         start.Add(time.Second),
+		Step:       time.Second,
+		Speed:      1000,
+		SaveOutput: true,
+	}
+	streamed := make(map[int64]float64)
+	var violations []LimitViolation
+	err := svc.RunWithControl(context.Background(), params, Control{
+		OnUpdates: func(info StepInfo, updates []sharedmem.SensorUpdate) {
+			for _, upd := range updates {
+				streamed[upd.Hash] = upd.Value
+			}
+			violations = append(violations, info.Violations...)
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunWithControl returned error: %v", err)
+	}
+	if len(client.payloads) != 1 {
+		t.Fatalf("expected 1 payload, got %d", len(client.payloads))
+	}
+	sent := make(map[int64]float64)
+	for _, upd := range client.payloads[0].Updates {
+		sent[upd.Hash] = upd.Value
+	}
+	// Округление после калибровки: 1.23456*2 = 2.46912 → 2.47.
+	if sent[1] != 2.47 || sent[2] != 10 {
+		t.Fatalf("sent values = %v, want rounded", sent)
+	}
+	if streamed[1] != sent[1] || streamed[2] != sent[2] {
+		t.Fatalf("streamed values = %v, want the same as sent %v", streamed, sent)
+	}
+	// Границы проверяются по отправленному (округлённому) значению: 10.004 → 10 не выходит за max=10.
+	if len(violations) != 0 {
+		t.Fatalf("violations = %+v, want none for the rounded value", violations)
+	}
+}
+
+func TestServiceRunReportsLimitViolations(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 100},
+			{SensorID: 2, Timestamp: start.Add(-time.Second), Value: 7},
+		},
+		batches: [][]storage.SensorEvent{
+			{{SensorID: 1, Timestamp: start.Add(time.Second), Value: 10}},
+		},
+	}
+	maxLimit, minLimit := 40.0, 10.0
+	client := &fakeClient{}
+	svc := Service{
+		Storage:     st,
+		Output:      client,
+		Calibration: map[int64]config.Calibration{1: {Scale: 0.5, Offset: 1}},
+		Limits: map[int64]config.Limits{
+			1: {Min: &minLimit, Max: &maxLimit},
+			2: {Max: &maxLimit},
+		},
+	}
+	var steps []StepInfo
+	err := svc.RunWithControl(context.Background(), Params{
+		Sensors:    []int64{1, 2},
+		From:       start,
+		To:         start.Add(2 * time.Second),
+		Step:       time.Second,
+		Speed:      1000,
+		SaveOutput: true,
+	}, Control{OnStep: func(info StepInfo) { steps = append(steps, info) }})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("steps = %d, want 2", len(steps))
+	}
+	// Граница сравнивается с отправленным (калиброванным) значением: 100*0.5+1 = 51 > 40, 10*0.5+1 = 6 < 10.
+	want := [][]LimitViolation{
+		{{Hash: 1, Value: 51, Limit: 40, Bound: "max"}},
+		{{Hash: 1, Value: 6, Limit: 10, Bound: "min"}},
+	}
+	for i, info := range steps {
+		if !reflect.DeepEqual(info.Violations, want[i]) {
+			t.Fatalf("step %d violations = %+v, want %+v", i+1, info.Violations, want[i])
+		}
+	}
+	if got := client.payloads[0].Updates; len(got) != 2 {
+		t.Fatalf("violations must not drop updates: %+v", got)
+	}
+}
+
+func TestServiceRunPulseAutoReset(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Minute), Value: 0},
+		},
+		batches: [][]storage.SensorEvent{{
+			{SensorID: 1, Timestamp: start.Add(time.Second), Value: 1},
+			{SensorID: 2, Timestamp: start.Add(time.Second), Value: 1},
+			{SensorID: 3, Timestamp: start.Add(time.Second), Value: 1},
+		}},
+	}
+	svc := Service{
+		Storage: st,
+		Output:  &fakeClient{},
+		Pulses: map[int64]config.Pulse{
+			1: {Hold: 2 * time.Second},
+			// Hold короче шага: импульс всё равно попадает в шаг, сброс — в следующем.
+			2: {Hold: 500 * time.Millisecond, Value: 5},
+		},
+	}
+	var steps []map[int64]float64
+	err := svc.RunWithControl(context.Background(), Params{
+		Sensors: []int64{1, 2, 3},
+		From:    start,
+		To:      start.Add(5 * time.Second),
+		Step:    time.Second,
+		Speed:   1000,
+	}, Control{OnUpdates: func(_ StepInfo, updates []sharedmem.SensorUpdate) {
+		step := make(map[int64]float64)
+		for _, upd := range updates {
+			step[upd.Hash] = upd.Value
+		}
+		steps = append(steps, step)
+	}})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := []map[int64]float64{
+		{1: 0},
+		{1: 1, 2: 1, 3: 1},
+		{2: 5},
+		{1: 0},
+		{},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Fatalf("step updates = %v, want %v", steps, want)
+	}
+}
+
+func TestServiceRunPulseHoldSurvivesSeek(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &controlStorage{
+		warmup: []storage.SensorEvent{{SensorID: 1, Timestamp: from.Add(-time.Minute), Value: 0}},
+		events: []storage.SensorEvent{{SensorID: 1, Timestamp: from.Add(time.Second), Value: 1}},
+	}
+	client := &fakeClient{}
+	svc := Service{Storage: st, Output: client, Pulses: map[int64]config.Pulse{1: {Hold: 10 * time.Second}}}
+	commands := make(chan Command, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.RunWithControl(context.Background(), Params{
+			Sensors:     []int64{1},
+			From:        from,
+			To:          from.Add(8 * time.Second),
+			Step:        time.Second,
+			Window:      time.Minute,
+			Speed:       1000,
+			SaveOutput:  true,
+			StartPaused: true,
+		}, Control{Commands: commands})
+	}()
+
+	// Seek в середину hold: импульс с 1s держится до 11s, позже конца периода.
+	// Второй seek восстанавливает состояние из кэша, записанного первым.
+	resp := make(chan error, 1)
+	commands <- Command{Type: CommandSeek, TS: from.Add(3 * time.Second), Resp: resp}
+	if err := <-resp; err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	preview := make(chan StateSnapshot, 1)
+	commands <- Command{Type: CommandSeek, TS: from.Add(4 * time.Second), Preview: preview, Resp: resp}
+	if err := <-resp; err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	if snap := <-preview; snap.Values[1] != 1 || !snap.Updated[1].Equal(from.Add(time.Second)) {
+		t.Fatalf("preview = %+v, want 1 set at 1s", snap)
+	}
+	commands <- Command{Type: CommandResume}
+	if err := <-done; err != nil {
+		t.Fatalf("RunWithControl: %v", err)
+	}
+	for _, p := range client.payloads {
+		for _, u := range p.Updates {
+			if u.Value != 1 {
+				t.Fatalf("step %d: pulse reset to %v before hold elapsed", p.StepID, u.Value)
+			}
+		}
+	}
+}
+
+func TestServiceRunPropagatesUndefined(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Undefined: true},
+			{SensorID: 2, Timestamp: start.Add(-time.Second), Value: 7},
+		},
+		batches: [][]storage.SensorEvent{
+			{{SensorID: 1, Timestamp: start.Add(time.Second), Value: 5}},
+		},
+	}
+	client := &fakeClient{}
+	svc := Service{
+		Storage:     st,
+		Output:      client,
+		Calibration: map[int64]config.Calibration{1: {Scale: 2}},
+	}
+	params := Params{
+		Sensors:    []int64{1, 2},
+		From:       start,
+		To:         start.Add(2 * time.Second),
+		Step:       time.Second,
+		Speed:      1000,
+		SaveOutput: true,
+	}
+	if err := svc.Run(context.Background(), params); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if len(client.payloads) != 2 {
+		t.Fatalf("expected 2 payloads, got %d", len(client.payloads))
+	}
+	for _, upd := range client.payloads[0].Updates {
+		if want := upd.Hash == 1; upd.Undefined != want {
+			t.Fatalf("step 1 sensor %d undefined = %v, want %v", upd.Hash, upd.Undefined, want)
+		}
+	}
+	upd := client.payloads[1].Updates
+	if len(upd) != 1 || upd[0].Hash != 1 || upd[0].Undefined || upd[0].Value != 10 {
+		t.Fatalf("step 2 expected defined calibrated value, got %#v", upd)
+	}
+}
+
+func TestServiceRunStepAggregate(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var events []storage.SensorEvent
+	for i, v := range []float64{2, 8, 4, 6, 1} {
+		events = append(events, storage.SensorEvent{SensorID: 1, Timestamp: start.Add(time.Duration(i+1) * time.Second), Value: v})
+	}
+	cases := map[StepAggregate]float64{"": 1, AggregateLast: 1, AggregateAvg: 4.2, AggregateMin: 1, AggregateMax: 8}
+	for agg, want := range cases {
+		client := &fakeClient{}
+		svc := Service{Storage: &controlStorage{events: events}, Output: client}
+		err := svc.Run(context.Background(), Params{
+			Sensors:       []int64{1},
+			From:          start,
+			To:            start.Add(6 * time.Second),
+			Step:          5 * time.Second,
+			Speed:         1000,
+			BatchSize:     10,
+			SaveOutput:    true,
+			StepAggregate: agg,
+		})
+		if err != nil {
+			t.Fatalf("%q: Run returned error: %v", agg, err)
+		}
+		if len(client.payloads) != 1 || len(client.payloads[0].Updates) != 1 {
+			t.Fatalf("%q: payloads = %+v, want one update", agg, client.payloads)
+		}
+		if got := client.payloads[0].Updates[0].Value; math.Abs(got-want) > 1e-9 {
+			t.Fatalf("%q: step value = %v, want %v", agg, got, want)
+		}
+	}
+
+	if _, err := ParseStepAggregate("median"); err == nil {
+		t.Fatalf("expected error for unknown aggregate")
+	}
+	err := (&Service{Storage: &controlStorage{}, Output: &fakeClient{}}).Run(context.Background(), Params{
+		Sensors: []int64{1}, From: start, To: start.Add(time.Second), Step: time.Second, StepAggregate: "median",
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid step aggregate") {
+		t.Fatalf("Run with unknown aggregate err = %v", err)
+	}
+}
+
+func TestServiceRunNoPace(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	events := []storage.SensorEvent{{SensorID: 1, Timestamp: start.Add(time.Hour), Value: 5}}
+	client := &fakeClient{}
+	svc := Service{Storage: &controlStorage{events: events}, Output: client}
+	// Шаг в час при скорости 1: без NoPace прогон занял бы часы.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := svc.Run(ctx, Params{
+		Sensors:    []int64{1},
+		From:       start,
+		To:         start.Add(3 * time.Hour),
+		Step:       time.Hour,
+		Speed:      1,
+		SaveOutput: true,
+		NoPace:     true,
+	})
+	if err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if len(client.payloads) != 1 || client.payloads[0].StepID != 2 {
+		t.Fatalf("payloads = %+v, want one update at step 2", client.payloads)
+	}
+}
+
+func TestServiceRunNoPaceHonorsStop(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	svc := Service{Storage: &controlStorage{}, Output: &fakeClient{}}
+	cmdCh := make(chan Command, 1)
+	var steps int64
+	err := svc.RunWithControl(context.Background(), Params{
+		Sensors: []int64{1},
+		From:    start,
+		To:      start.Add(time.Hour),
+		Step:    time.Second,
+		Speed:   1,
+		NoPace:  true,
+	}, Control{
+		Commands: cmdCh,
+		OnStep: func(info StepInfo) {
+			steps = info.StepID
+			if info.StepID == 3 {
+				cmdCh <- Command{Type: CommandStop}
+			}
+		},
+	})
+	if !errors.Is(err, ErrStopped{}) {
+		t.Fatalf("RunWithControl err = %v, want ErrStopped", err)
+	}
+	if steps != 3 {
+		t.Fatalf("steps played = %d, want stop right after step 3", steps)
+	}
+}
+
+func TestServiceRunStartDelay(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := &controlStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 100},
+			{SensorID: 2, Timestamp: start.Add(-time.Second), Value: 5},
+		},
+		events: []storage.SensorEvent{{SensorID: 1, Timestamp: start.Add(500 * time.Millisecond), Value: 101}},
+	}
+	client := &fakeClient{}
+	svc := Service{Storage: store, Output: client}
+	params := Params{
+		Sensors: []int64{1, 2}, From: start, To: start.Add(2 * time.Second), Step: time.Second,
+		NoPace: true, SaveOutput: true, StartDelay: 100 * time.Millisecond,
+	}
+	began := time.Now()
+	if err := svc.Run(context.Background(), params); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if elapsed := time.Since(began); elapsed < params.StartDelay {
+		t.Fatalf("run took %s, want at least the start delay %s", elapsed, params.StartDelay)
+	}
+	if len(client.payloads) != 2 {
+		t.Fatalf("payloads = %+v, want initial snapshot and one step", client.payloads)
+	}
+	if snap := client.payloads[0]; snap.StepID != 0 || len(snap.Updates) != 2 {
+		t.Fatalf("initial snapshot = %+v, want step 0 with both sensors", snap)
+	}
+	if step := client.payloads[1]; step.StepID != 2 || len(step.Updates) != 1 || step.Updates[0].Value != 101 {
+		t.Fatalf("step payload = %+v, want only the change of sensor 1 at step 2", step)
+	}
+
+	// Stop во время выдержки завершает задачу, не дожидаясь её конца.
+	cmdCh := make(chan Command, 1)
+	cmdCh <- Command{Type: CommandStop}
+	params.StartDelay = time.Hour
+	began = time.Now()
+	err := svc.RunWithControl(context.Background(), params, Control{Commands: cmdCh})
+	if !errors.Is(err, ErrStopped{}) {
+		t.Fatalf("RunWithControl err = %v, want ErrStopped", err)
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Fatalf("stop during start delay took %s", elapsed)
+	}
+}
+
+func TestServiceRunPrimeOnStart(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := &controlStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 100},
+			{SensorID: 2, Timestamp: start.Add(-time.Second), Value: 5},
+		},
+		events: []storage.SensorEvent{{SensorID: 1, Timestamp: start.Add(500 * time.Millisecond), Value: 101}},
+	}
+	client := &fakeClient{}
+	svc := Service{Storage: store, Output: client, EmitEmpty: true}
+	params := Params{
+		Sensors: []int64{1, 2, 3}, From: start, To: start.Add(2 * time.Second), Step: time.Second,
+		NoPace: true, SaveOutput: true, PrimeOnStart: true,
+	}
+	if err := svc.Run(context.Background(), params); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(client.payloads) != 2 {
+		t.Fatalf("payloads = %+v, want the snapshot and one step", client.payloads)
+	}
+	snap := client.payloads[0]
+	if snap.StepID != 0 || len(snap.Updates) != 3 {
+		t.Fatalf("snapshot = %+v, want step 0 with all three sensors", snap)
+	}
+	for _, upd := range snap.Updates {
+		if upd.Hash == 3 && !upd.NoData {
+			t.Fatalf("sensor without warmup must be sent as NoData: %+v", upd)
+		}
+	}
+	if step := client.payloads[1]; step.StepID != 2 || len(step.Updates) != 1 || step.Updates[0].Value != 101 {
+		t.Fatalf("step payload = %+v, want only the change of sensor 1", step)
+	}
+
+	// Без SaveOutput снимок не отправляется.
+	client.payloads = nil
+	params.SaveOutput = false
+	if err := svc.Run(context.Background(), params); err != nil {
+		t.Fatalf("run without save: %v", err)
+	}
+	if len(client.payloads) != 0 {
+		t.Fatalf("payloads without save = %+v", client.payloads)
+	}
+}
+
+func TestServiceRunPlaylist(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	store := &controlStorage{events: []storage.SensorEvent{
+		{SensorID: 1, Timestamp: start.Add(500 * time.Millisecond), Value: 1},
+		{SensorID: 1, Timestamp: start.Add(1500 * time.Millisecond), Value: 2},
+		{SensorID: 1, Timestamp: start.Add(5 * time.Second), Value: 99}, // в разрыве между сегментами
+		{SensorID: 1, Timestamp: start.Add(10500 * time.Millisecond), Value: 5},
+	}}
+	client := &fakeClient{}
+	svc := Service{Storage: store, Output: client}
+	params := Params{
+		Sensors: []int64{1}, From: start, To: start.Add(12 * time.Second), Step: time.Second,
+		NoPace: true, SaveOutput: true,
+		Segments: []Segment{
+			{From: start, To: start.Add(3 * time.Second)},
+			{From: start.Add(10 * time.Second), To: start.Add(12 * time.Second)},
+		},
+	}
+	var steps []StepInfo
+	err := svc.RunWithControl(context.Background(), params, Control{
+		OnStep: func(info StepInfo) { steps = append(steps, info) },
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var got []string
+	for _, info := range steps {
+		got = append(got, fmt.Sprintf("%d/%d@%s", info.StepID, info.Segment, info.StepTs.Sub(start)))
+	}
+	want := "1/0@0s 2/0@1s 3/0@2s 4/1@10s 5/1@11s"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("steps = %v, want %s", got, want)
+	}
+	var sent []string
+	for _, p := range client.payloads {
+		for _, upd := range p.Updates {
+			sent = append(sent, fmt.Sprintf("%d=%g", p.StepID, upd.Value))
+		}
+	}
+	if strings.Join(sent, " ") != "2=1 3=2 5=5" {
+		t.Fatalf("payloads = %v, want step ids continued across segments", sent)
+	}
+
+	// Переход к другому сегменту из паузы: сегмент стартует на паузе на своём From.
+	steps = nil
+	params.StartPaused = true
+	commands := make(chan Command, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.RunWithControl(context.Background(), params, Control{
+			Commands: commands,
+			OnStep:   func(info StepInfo) { steps = append(steps, info) },
+		})
+	}()
+	resp := make(chan error, 1)
+	commands <- Command{Type: CommandSegment, Index: 1, Resp: resp}
+	if err := <-resp; err != nil {
+		t.Fatalf("jump: %v", err)
+	}
+	commands <- Command{Type: CommandPause, Resp: resp}
+	if err := <-resp; err != nil {
+		t.Fatalf("pause after jump: %v", err)
+	}
+	if len(steps) != 0 {
+		t.Fatalf("steps before resume = %+v, want none", steps)
+	}
+	commands <- Command{Type: CommandResume}
+	if err := <-done; err != nil {
+		t.Fatalf("run with jump: %v", err)
+	}
+	if len(steps) != 2 || steps[0].StepID != 4 || steps[0].Segment != 1 || !steps[0].StepTs.Equal(start.Add(10*time.Second)) {
+		t.Fatalf("steps after jump = %+v, want segment 1 from step 4", steps)
+	}
+
+	if err := ValidateSegments([]Segment{
+		{From: start, To: start.Add(3 * time.Second)},
+		{From: start.Add(2 * time.Second), To: start.Add(4 * time.Second)},
+	}); err == nil {
+		t.Fatalf("overlapping segments must be rejected")
+	}
+}
+
+func TestServiceRunDiscreteThreshold(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	// Зашумлённый дискретный датчик 1 и аналоговый 2 с теми же значениями.
+	var events []storage.SensorEvent
+	for i, v := range []float64{0.9999, 0.0001, 1.0, 0.4999} {
+		ts := start.Add(time.Duration(i)*time.Second + 500*time.Millisecond)
+		events = append(events,
+			storage.SensorEvent{SensorID: 1, Timestamp: ts, Value: v},
+			storage.SensorEvent{SensorID: 2, Timestamp: ts, Value: v})
+	}
+	client := &fakeClient{}
+	svc := Service{
+		Storage:           &controlStorage{warmup: []storage.SensorEvent{{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 0.51}}, events: events},
+		Output:            client,
+		Discrete:          map[int64]struct{}{1: {}},
+		DiscreteThreshold: 0.5,
+	}
+	params := Params{Sensors: []int64{1, 2}, From: start, To: start.Add(5 * time.Second), Step: time.Second, NoPace: true, SaveOutput: true}
+	var warmup []sharedmem.SensorUpdate
+	if err := svc.RunWithControl(context.Background(), params, Control{
+		OnWarmup: func(_ StepInfo, updates []sharedmem.SensorUpdate) { warmup = updates },
+	}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	for _, upd := range warmup {
+		if upd.Hash == 1 && upd.Value != 1 {
+			t.Fatalf("warmup value of the discrete sensor = %g, want 1", upd.Value)
+		}
+	}
+	got := map[int64][]float64{}
+	for _, p := range client.payloads {
+		for _, upd := range p.Updates {
+			got[upd.Hash] = append(got[upd.Hash], upd.Value)
+		}
+	}
+	// Первый шаг отправляет значение из warmup (0.51 → 1), дальше — изменения.
+	if fmt.Sprint(got[1]) != "[1 1 0 1 0]" {
+		t.Fatalf("discrete values = %v, want clean 0/1", got[1])
+	}
+	if fmt.Sprint(got[2]) != "[0.9999 0.0001 1 0.4999]" {
+		t.Fatalf("analog values = %v, want them unchanged", got[2])
+	}
+}
+
+func TestParseActiveHours(t *testing.T) {
+	a, err := ParseActiveHours("08:00-18:00", "mon-fri", nil)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if a.String() != "08:00-18:00 mon,tue,wed,thu,fri" {
+		t.Fatalf("String = %q", a.String())
+	}
+	monday := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		at   time.Time
+		want bool
+		next time.Time
+	}{
+		{monday.Add(8 * time.Hour), true, monday.Add(8 * time.Hour)},
+		{monday.Add(18 * time.Hour), false, monday.Add(32 * time.Hour)},
+		{monday.Add(4*24*time.Hour + 19*time.Hour), false, monday.Add(7*24*time.Hour + 8*time.Hour)}, // пятница вечером → понедельник
+		{monday.Add(5*24*time.Hour + 10*time.Hour), false, monday.Add(7*24*time.Hour + 8*time.Hour)}, // суббота
+	}
+	for _, tc := range cases {
+		if got := a.Active(tc.at); got != tc.want {
+			t.Fatalf("Active(%s) = %v, want %v", tc.at, got, tc.want)
+		}
+		if got := a.Next(tc.at); !got.Equal(tc.next) {
+			t.Fatalf("Next(%s) = %s, want %s", tc.at, got, tc.next)
+		}
+	}
+
+	// Окно через полночь: день — тот, в который окно открылось.
+	night, err := ParseActiveHours("22:00-06:00", "fri", nil)
+	if err != nil {
+		t.Fatalf("parse night: %v", err)
+	}
+	friday := monday.AddDate(0, 0, 4)
+	if !night.Active(friday.Add(23*time.Hour)) || !night.Active(friday.Add(29*time.Hour)) || night.Active(friday.Add(5*time.Hour)) {
+		t.Fatalf("overnight window must cover Friday 22:00 - Saturday 06:00 only")
+	}
+
+	for _, bad := range [][2]string{{"8-18", ""}, {"08:00-25:00", ""}, {"08:00", ""}, {"08:00-18:00", "funday"}} {
+		if _, err := ParseActiveHours(bad[0], bad[1], nil); err == nil {
+			t.Fatalf("ParseActiveHours(%q, %q) must fail", bad[0], bad[1])
+		}
+	}
+}
+
+func TestServiceRunActiveHours(t *testing.T) {
+	monday := time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)
+	hours, err := ParseActiveHours("08:00-18:00", "", nil)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	store := &warmupCounter{controlStorage: &controlStorage{
+		warmup: []storage.SensorEvent{{SensorID: 1, Timestamp: monday, Value: 1}},
+		events: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: monday.Add(20 * time.Hour), Value: 9}, // ночью: не проигрывается
+			{SensorID: 1, Timestamp: monday.Add(32*time.Hour + 30*time.Minute), Value: 2},
+		},
+	}}
+	client := &fakeClient{}
+	var skips [][2]time.Time
+	svc := Service{Storage: store, Output: client}
+	err = svc.RunWithControl(context.Background(), Params{
+		Sensors: []int64{1}, From: monday.Add(17 * time.Hour), To: monday.Add(34 * time.Hour), Step: time.Hour,
+		NoPace: true, SaveOutput: true, ActiveHours: &hours,
+	}, Control{OnSkip: func(from, to time.Time) { skips = append(skips, [2]time.Time{from, to}) }})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(skips) != 1 || !skips[0][0].Equal(monday.Add(18*time.Hour)) || !skips[0][1].Equal(monday.Add(32*time.Hour)) {
+		t.Fatalf("skips = %v, want one span 18:00 → next day 08:00", skips)
+	}
+	if store.calls != 2 {
+		t.Fatalf("warmup calls = %d, want initial and after the skip", store.calls)
+	}
+	var got []string
+	for _, p := range client.payloads {
+		for _, u := range p.Updates {
+			got = append(got, fmt.Sprintf("%d:%s=%g", p.StepID, p.StepTs, u.Value))
+		}
+	}
+	want := []string{"1:2024-06-03T17:00:00Z=1", "16:2024-06-04T08:00:00Z=1", "17:2024-06-04T09:00:00Z=2"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("payloads = %v, want %v", got, want)
+	}
+}
+
+// warmupCounter считает запросы Warmup.
+type warmupCounter struct {
+	*controlStorage
+	calls int
+}
+
+func (w *warmupCounter) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]storage.SensorEvent, error) {
+	w.calls++
+	return w.controlStorage.Warmup(ctx, sensors, from)
+}
+
+// noWarmupStorage отказывает в Warmup: тёплый старт не должен обращаться к хранилищу.
+type noWarmupStorage struct{ *controlStorage }
+
+func (noWarmupStorage) Warmup(context.Context, []int64, time.Time) ([]storage.SensorEvent, error) {
+	return nil, errors.New("warmup must not be queried")
+}
+
+func TestServiceRunWarmStart(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	events := []storage.SensorEvent{
+		{SensorID: 1, Timestamp: start.Add(500 * time.Millisecond), Value: 1},
+		{SensorID: 1, Timestamp: start.Add(1500 * time.Millisecond), Value: 2},
+		{SensorID: 1, Timestamp: start.Add(2500 * time.Millisecond), Value: 3},
+	}
+	store := &controlStorage{events: events}
+	var final StepInfo
+	var seed []storage.SensorEvent
+	svc := Service{Storage: store, Output: &fakeClient{}}
+	err := svc.RunWithControl(context.Background(), Params{
+		Sensors: []int64{1}, From: start, To: start.Add(3 * time.Second), Step: time.Second, NoPace: true, SaveOutput: true,
+	}, Control{OnFinish: func(info StepInfo, state []storage.SensorEvent) {
+		final, seed = info, state
+	}})
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if !final.StepTs.Equal(start.Add(2*time.Second)) || final.StepID != 3 {
+		t.Fatalf("final step = %+v, want step 3 at +2s", final)
+	}
+	if len(seed) != 1 || seed[0].Value != 2 || !seed[0].Timestamp.Equal(events[1].Timestamp) {
+		t.Fatalf("final state = %+v, want sensor 1 = 2", seed)
+	}
+
+	client := &fakeClient{}
+	svc = Service{Storage: noWarmupStorage{store}, Output: client}
+	err = svc.Run(context.Background(), Params{
+		Sensors: []int64{1}, From: final.StepTs, To: start.Add(4 * time.Second), Step: time.Second, NoPace: true, SaveOutput: true, Warmup: seed,
+	})
+	if err != nil {
+		t.Fatalf("warm start run: %v", err)
+	}
+	var got []float64
+	for _, p := range client.payloads {
+		for _, upd := range p.Updates {
+			got = append(got, upd.Value)
+		}
+	}
+	if len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Fatalf("warm start values = %v, want [2 3]", got)
+	}
+}
+
+func TestServiceRunEmitEmpty(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 3},
+		},
+	}
+	client := &fakeClient{}
+	svc := Service{Storage: st, Output: client, EmitEmpty: true}
+	params := Params{
+		Sensors:    []int64{1, 2},
+		From:       start,
+		To:         start.Add(2 * time.Second),
+		Step:       time.Second,
+		Speed:      1000,
+		SaveOutput: true,
+	}
+	if err := svc.Run(context.Background(), params); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if len(client.payloads) != 1 {
+		t.Fatalf("expected markers only in the first step, got %d payloads", len(client.payloads))
+	}
+	got := make(map[int64]sharedmem.SensorUpdate)
+	for _, upd := range client.payloads[0].Updates {
+		got[upd.Hash] = upd
+	}
+	if len(got) != 2 || got[1].NoData || got[1].Value != 3 || !got[2].NoData {
+		t.Fatalf("unexpected first step updates: %#v", client.payloads[0].Updates)
+	}
+}
+
+func TestRunWithControlOnWarmup(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 3},
+		},
+		batches: [][]storage.SensorEvent{
+			{{SensorID: 2, Timestamp: start.Add(time.Second), Value: 20}},
+		},
+	}
+	svc := Service{Storage: st, Output: &fakeClient{}}
+	params := Params{
+		Sensors: []int64{1, 2},
+		From:    start,
+		To:      start.Add(2 * time.Second),
+		Step:    time.Second,
+		Speed:   1000,
+	}
+	var calls []string
+	var warm []sharedmem.SensorUpdate
+	var warmInfo StepInfo
+	err := svc.RunWithControl(context.Background(), params, Control{
+		OnWarmup: func(info StepInfo, updates []sharedmem.SensorUpdate) {
+			calls = append(calls, "warmup")
+			warmInfo, warm = info, updates
+		},
+		OnUpdates: func(StepInfo, []sharedmem.SensorUpdate) {
+			calls = append(calls, "updates")
+		},
+	})
+	if err != nil {
+		t.Fatalf("RunWithControl returned error: %v", err)
+	}
+	if len(calls) < 2 || calls[0] != "warmup" || calls[1] != "updates" {
+		t.Fatalf("callbacks = %v, want a single warmup before the first step", calls)
+	}
+	got := make(map[int64]sharedmem.SensorUpdate)
+	for _, upd := range warm {
+		got[upd.Hash] = upd
+	}
+	if len(got) != 2 || got[1].NoData || got[1].Value != 3 || !got[2].NoData {
+		t.Fatalf("warmup updates = %#v", warm)
+	}
+	if warmInfo.StepID != 0 || !warmInfo.StepTs.Equal(start) {
+		t.Fatalf("warmup step = %+v", warmInfo)
+	}
+}
+
+func TestRunWithControlStartPaused(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		batches: [][]storage.SensorEvent{
+			{{SensorID: 1, Timestamp: start, Value: 5}},
+		},
+	}
+	svc := Service{Storage: st, Output: &fakeClient{}}
+	params := Params{
+		Sensors:     []int64{1},
+		From:        start,
+		To:          start.Add(2 * time.Second),
+		Step:        time.Second,
+		Speed:       1000,
+		StartPaused: true,
+	}
+	commands := make(chan Command, 1)
+	var steps atomic.Int64
+	var first StepInfo
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.RunWithControl(context.Background(), params, Control{
+			Commands: commands,
+			OnStep: func(info StepInfo) {
+				if steps.Add(1) == 1 {
+					first = info
+				}
+			},
+		})
+	}()
+
+	resp := make(chan error, 1)
+	commands <- Command{Type: CommandPause, Resp: resp}
+	if err := <-resp; err != nil {
+		t.Fatalf("pause while start-paused: %v", err)
+	}
+	if n := steps.Load(); n != 0 {
+		t.Fatalf("steps before resume = %d, want 0", n)
+	}
+	commands <- Command{Type: CommandResume}
+	if err := <-done; err != nil {
+		t.Fatalf("RunWithControl returned error: %v", err)
+	}
+	if n := steps.Load(); n != 2 {
+		t.Fatalf("steps after resume = %d, want 2", n)
+	}
+	if first.StepID != 1 || !first.StepTs.Equal(start) {
+		t.Fatalf("first step = %+v, want step 1 at from", first)
+	}
+}
+
+// windowStorage запоминает окно и начало каждого запроса Stream.
+type windowStorage struct {
+	controlStorage
+	mu   sync.Mutex
+	reqs []storage.StreamRequest
+}
+
+func (s *windowStorage) Stream(ctx context.Context, req storage.StreamRequest) (<-chan []storage.SensorEvent, <-chan error) {
+	s.mu.Lock()
+	s.reqs = append(s.reqs, req)
+	s.mu.Unlock()
+	return s.controlStorage.Stream(ctx, req)
+}
+
+func (s *windowStorage) requests() []storage.StreamRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]storage.StreamRequest(nil), s.reqs...)
+}
+
+func TestRunWithControlSeekWindow(t *testing.T) {
+	from := time.Date(2025, 11, 21, 0, 0, 0, 0, time.UTC)
+	target := from.Add(2 * time.Second)
+	st := &windowStorage{controlStorage: controlStorage{
+		events: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: from.Add(time.Second), Value: 1},
+			{SensorID: 1, Timestamp: from.Add(3 * time.Second), Value: 3},
+		},
+	}}
+	client := &fakeClient{}
+	svc := Service{Storage: st, Output: client}
+	params := Params{
+		Sensors:     []int64{1},
+		From:        from,
+		To:          from.Add(5 * time.Second),
+		Step:        time.Second,
+		Window:      time.Minute,
+		SeekWindow:  time.Second,
+		Speed:       1000,
+		BatchSize:   10,
+		SaveOutput:  true,
+		StartPaused: true,
+	}
+	commands := make(chan Command, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.RunWithControl(context.Background(), params, Control{Commands: commands})
+	}()
+
+	resp := make(chan error, 1)
+	commands <- Command{Type: CommandSeek, TS: target, Resp: resp}
+	if err := <-resp; err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	var seekReq *storage.StreamRequest
+	for _, req := range st.requests() {
+		if req.From.Equal(target) {
+			req := req
+			seekReq = &req
+		}
+	}
+	if seekReq == nil || seekReq.Window != params.SeekWindow {
+		t.Fatalf("stream after seek = %+v, want window %s from %s", seekReq, params.SeekWindow, target)
+	}
+
+	commands <- Command{Type: CommandResume}
+	if err := <-done; err != nil {
+		t.Fatalf("RunWithControl returned error: %v", err)
+	}
+	reqs := st.requests()
+	last := reqs[len(reqs)-1]
+	if last.Window != params.Window || !last.From.Equal(target) {
+		t.Fatalf("stream after resume = %+v, want window %s from %s", last, params.Window, target)
+	}
+	var values []float64
+	for _, p := range client.payloads {
+		for _, u := range p.Updates {
+			values = append(values, u.Value)
+		}
+	}
+	if !reflect.DeepEqual(values, []float64{1, 3}) {
+		t.Fatalf("values after resume = %v, want [1 3]", values)
+	}
+}
+
+func TestBuildStatesSinglePass(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 1},
+		},
+		batches: [][]storage.SensorEvent{
+			{
+				{SensorID: 1, Timestamp: start.Add(time.Second), Value: 2},
+				{SensorID: 2, Timestamp: start.Add(2 * time.Second), Value: 20},
+			},
+			{
+				{SensorID: 1, Timestamp: start.Add(4 * time.Second), Value: 4},
+				{SensorID: 1, Timestamp: start.Add(9 * time.Second), Value: 9},
+			},
+		},
+	}
+	targets := []time.Time{start, start.Add(2 * time.Second), start.Add(2 * time.Second), start.Add(5 * time.Second)}
+	snaps, err := BuildStates(context.Background(), st, []int64{1, 2}, time.Minute, 0, targets)
+	if err != nil {
+		t.Fatalf("BuildStates: %v", err)
+	}
+	if len(snaps) != len(targets) {
+		t.Fatalf("expected %d snapshots, got %d", len(targets), len(snaps))
+	}
+	want := []map[int64]float64{
+		{1: 1},
+		{1: 2, 2: 20},
+		{1: 2, 2: 20},
+		{1: 4, 2: 20},
+	}
+	for i, snap := range snaps {
+		if !snap.StepTs.Equal(targets[i]) {
+			t.Fatalf("snapshot %d ts = %s, want %s", i, snap.StepTs, targets[i])
+		}
+		if !reflect.DeepEqual(snap.Values, want[i]) {
+			t.Fatalf("snapshot %d values = %v, want %v", i, snap.Values, want[i])
+		}
+	}
+
+	if _, err := BuildStates(context.Background(), st, []int64{1}, time.Minute, 0, []time.Time{start.Add(time.Second), start}); err == nil {
+		t.Fatalf("expected error for unsorted targets")
+	}
+}
+
+func TestBuildStatesIncludesLastTarget(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(10 * time.Second)
+	st := &controlStorage{
+		warmup: []storage.SensorEvent{{SensorID: 1, Timestamp: start, Value: 0}},
+		events: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start, Value: 0},
+			{SensorID: 1, Timestamp: start.Add(5 * time.Second), Value: 5},
+			{SensorID: 2, Timestamp: start.Add(5 * time.Second), Value: 1},
+			{SensorID: 1, Timestamp: end, Value: 10},
+			{SensorID: 2, Timestamp: end, Undefined: true},
+		},
+	}
+	snaps, err := BuildStates(context.Background(), st, []int64{1, 2}, time.Minute, 0, []time.Time{start.Add(5 * time.Second), end})
+	if err != nil {
+		t.Fatalf("BuildStates: %v", err)
+	}
+	if snaps[0].Values[1] != 5 || snaps[0].Undefined[2] {
+		t.Fatalf("intermediate snapshot = %+v", snaps[0])
+	}
+	// Событие ровно в последней метке учитывается так же, как в промежуточных.
+	if snaps[1].Values[1] != 10 || !snaps[1].Undefined[2] {
+		t.Fatalf("last snapshot = %+v, want 10 and sensor 2 undefined", snaps[1])
+	}
+
+	single, err := BuildState(context.Background(), st, Params{Sensors: []int64{1, 2}, From: start, To: end, Step: time.Second, Window: time.Minute}, end)
+	if err != nil {
+		t.Fatalf("BuildState: %v", err)
+	}
+	if single.Values[1] != 10 || !single.Undefined[2] || !single.Updated[1].Equal(end) {
+		t.Fatalf("BuildState = %+v, want the same as the batch", single)
+	}
+}
+
+func TestBuildStatesMaxStaleness(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	st := &fakeStorage{
+		warmup: []storage.SensorEvent{
+			{SensorID: 1, Timestamp: start.Add(-time.Second), Value: 1},
+		},
+		batches: [][]storage.SensorEvent{
+			{
+				{SensorID: 1, Timestamp: start.Add(time.Second), Value: 2},
+				{SensorID: 2, Timestamp: start.Add(2 * time.Second), Value: 20},
+			},
+			{
+				{SensorID: 1, Timestamp: start.Add(4 * time.Second), Value: 4},
+				{SensorID: 1, Timestamp: start.Add(9 * time.Second), Value: 9},
+			},
+		},
+	}
+	targets := []time.Time{start.Add(2 * time.Second), start.Add(5 * time.Second)}
+	snaps, err := BuildStates(context.Background(), st, []int64{1, 2}, time.Minute, 2*time.Second, targets)
+	if err != nil {
+		t.Fatalf("BuildStates: %v", err)
+	}
+	if !reflect.DeepEqual(snaps[0].Values, map[int64]float64{1: 2, 2: 20}) || snaps[0].Stale != nil {
+		t.Fatalf("fresh snapshot: values=%v stale=%v", snaps[0].Values, snaps[0].Stale)
+	}
+	if !reflect.DeepEqual(snaps[1].Values, map[int64]float64{1: 4}) || !reflect.DeepEqual(snaps[1].Stale, map[int64]bool{2: true}) {
+		t.Fatalf("stale snapshot: values=%v stale=%v", snaps[1].Values, snaps[1].Stale)
+	}
+}
+
+func TestPreflightReportsMissingData(t *testing.T) {
+	client := &fakeClient{}
+	svc := Service{Storage: &fakeStorage{}, Output: client}
+	res := svc.Preflight(context.Background(), []int64{1, 2}, time.Time{}, time.Time{})
+	if res.Status != "fail" || res.Checks["range"].Status != "fail" || res.Checks["sensors"].Status != "ok" {
+		t.Fatalf("preflight without data = %+v", res)
+	}
+	if res.Checks["storage"].Status != "skipped" || res.Checks["output"].Status != "skipped" {
+		t.Fatalf("checks without Ping must be skipped: %+v", res.Checks)
+	}
+
+	res = svc.Preflight(context.Background(), nil, time.Time{}, time.Time{})
+	if res.Checks["sensors"].Status != "fail" {
+		t.Fatalf("empty sensor set must fail: %+v", res.Checks)
+	}
+	if len(client.payloads) != 0 {
+		t.Fatalf("preflight must not send payloads")
+	}
+}
+
+// liveStorage — дописываемая таблица для режима Follow.
+type liveStorage struct {
+	controlStorage
+	mu sync.Mutex
+}
+
+func (s *liveStorage) add(ev storage.SensorEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, ev)
+}
+
+func (s *liveStorage) Stream(ctx context.Context, req storage.StreamRequest) (<-chan []storage.SensorEvent, <-chan error) {
+	s.mu.Lock()
+	snapshot := &controlStorage{events: append([]storage.SensorEvent(nil), s.events...)}
+	s.mu.Unlock()
+	return snapshot.Stream(ctx, req)
+}
+
+// stepsClient передаёт отправленные шаги в канал.
+type stepsClient struct {
+	steps chan sharedmem.StepPayload
+}
+
+func (c *stepsClient) Send(_ context.Context, payload sharedmem.StepPayload) error {
+	c.steps <- payload
+	return nil
+}
+
+func TestServiceRunFollowsLiveTail(t *testing.T) {
+	step := 50 * time.Millisecond
+	from := time.Now().Add(-time.Second).Truncate(step)
+	st := &liveStorage{}
+	st.add(storage.SensorEvent{SensorID: 1, Timestamp: from.Add(step), Value: 1})
+	client := &stepsClient{steps: make(chan sharedmem.StepPayload, 1024)}
+	svc := Service{Storage: st, Output: client}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- svc.Run(ctx, Params{
+			Sensors:    []int64{1, 2},
+			From:       from,
+			To:         time.Now(),
+			Step:       step,
+			Speed:      1000,
+			BatchSize:  10,
+			SaveOutput: true,
+			Follow:     true,
+			FollowPoll: 10 * time.Millisecond,
+		})
+	}()
+
+	waitValue := func(hash int64, value float64) sharedmem.StepPayload {
+		t.Helper()
+		deadline := time.After(3 * time.Second)
+		for {
+			select {
+			case p := <-client.steps:
+				ts, err := time.Parse(time.RFC3339Nano, p.StepTs)
+				if err != nil {
+					t.Fatalf("parse step ts %q: %v", p.StepTs, err)
+				}
+				if ts.After(time.Now()) {
+					t.Fatalf("step %s is ahead of real time", p.StepTs)
+				}
+				for _, upd := range p.Updates {
+					if upd.Hash == hash && upd.Value == value {
+						return p
+					}
+				}
+			case err := <-done:
+				t.Fatalf("follow run stopped: %v", err)
+			case <-deadline:
+				t.Fatalf("timeout waiting for sensor %d = %v", hash, value)
+			}
+		}
+	}
+
+	waitValue(1, 1)
+	// Запись, появившаяся после старта и после исходного To.
+	st.add(storage.SensorEvent{SensorID: 2, Timestamp: time.Now().Add(100 * time.Millisecond), Value: 7})
+	waitValue(2, 7)
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run returned %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("follow run did not stop on cancel")
+	}
+}
+
+func TestServiceRunInclusiveEnd(t *testing.T) {
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(3 * time.Second)
+	st := &controlStorage{events: []storage.SensorEvent{
+		{SensorID: 1, Timestamp: from.Add(time.Second), Value: 1},
+		{SensorID: 1, Timestamp: to, Value: 3},
+	}}
+
+	run := func(inclusive bool) []sharedmem.StepPayload {
+		t.Helper()
+		client := &fakeClient{}
+		svc := Service{Storage: st, Output: client}
+		err := svc.Run(context.Background(), Params{
+			Sensors:      []int64{1},
+			From:         from,
+			To:           to,
+			Step:         time.Second,
+			Speed:        1000,
+			BatchSize:    10,
+			SaveOutput:   true,
+			InclusiveEnd: inclusive,
+		})
+		if err != nil {
+			t.Fatalf("Run(inclusive=%v) returned error: %v", inclusive, err)
+		}
+		return client.payloads
+	}
+
+	exclusive := run(false)
+	if n := len(exclusive); n != 1 {
+		t.Fatalf("exclusive end: %d payloads, want only the step with value 1", n)
+	}
+	if last := exclusive[len(exclusive)-1].StepTs; last != from.Add(time.Second).Format(time.RFC3339) {
+		t.Fatalf("exclusive end: last step %s", last)
+	}
+
+	inclusive := run(true)
+	if n := len(inclusive); n != 2 {
+		t.Fatalf("inclusive end: %d payloads, want 2", n)
+	}
+	final := inclusive[len(inclusive)-1]
+	if final.StepTs != to.Format(time.RFC3339) || final.StepID != 4 {
+		t.Fatalf("inclusive end: final step %d at %s, want 4 at %s", final.StepID, final.StepTs, to.Format(time.RFC3339))
+	}
+	if len(final.Updates) != 1 || final.Updates[0].Value != 3 {
+		t.Fatalf("inclusive end: final updates %+v, want value 3 from the event at To", final.Updates)
+	}
+
+	if p := (Params{From: from, To: to, InclusiveEnd: true}); !p.InPeriod(to) || p.InPeriod(to.Add(time.Nanosecond)) {
+		t.Fatalf("InPeriod must include To only with InclusiveEnd")
+	}
+	if (Params{From: from, To: to}).InPeriod(to) {
+		t.Fatalf("InPeriod must exclude To by default")
+	}
+}
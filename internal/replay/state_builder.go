@@ -13,6 +13,12 @@ type StateSnapshot struct {
 	StepID int64
 	StepTs time.Time
 	Values map[int64]float64 // hash (cityhash64(name)) → значение
+	// Undefined — датчики в неопределённом состоянии (только true, nil — все определены).
+	Undefined map[int64]bool
+	// Stale — датчики, исключённые из Values: последнее событие старше MaxStaleness (nil — таких нет).
+	Stale map[int64]bool
+	// Updated — время последнего события датчиков из Values (для hold импульсов и MaxStaleness после seek).
+	Updated map[int64]time.Time
 }
 
 // BuildState рассчитывает состояние датчиков на указанный момент времени, не выполняя отправку.
@@ -20,66 +26,208 @@ func BuildState(ctx context.Context, store storage.Storage, params Params, targe
 	if !params.To.IsZero() && target.After(params.To) {
 		return StateSnapshot{}, fmt.Errorf("replay: target %s is after params.To %s", target, params.To)
 	}
-	if params.Step <= 0 {
-		return StateSnapshot{}, fmt.Errorf("replay: step must be > 0")
+	if err := checkStep(params.Step); err != nil {
+		return StateSnapshot{}, err
 	}
 	state := make(map[int64]*sensorState, len(params.Sensors))
 	for _, id := range params.Sensors {
 		state[id] = &sensorState{}
 	}
 
-	warm, err := store.Warmup(ctx, params.Sensors, target)
+	// Состояние считается так же, как при проигрывании: значения на начало периода и все события
+	// до target включительно. Поток дочитывается до конца, поэтому результат не зависит от скорости хранилища.
+	from := params.From
+	if target.Before(from) {
+		from = target
+	}
+	warm, err := store.Warmup(ctx, params.Sensors, from)
 	if err != nil {
 		return StateSnapshot{}, fmt.Errorf("replay: warmup: %w", err)
 	}
 	applyEvents(state, warm, true)
 
-	req := storage.StreamRequest{
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dataCh, errCh := store.Stream(streamCtx, storage.StreamRequest{
 		Sensors: params.Sensors,
-		From:    params.From,
-		To:      target,
+		From:    from,
+		To:      inclusiveTo(target),
 		Window:  params.Window,
+	})
+	eventCh, streamErr := fanInEvents(streamCtx, dataCh, errCh)
+	if err := applyStream(ctx, state, eventCh, streamErr, target); err != nil {
+		return StateSnapshot{}, err
 	}
-	dataCh, errCh := store.Stream(ctx, req)
-	eventCh, streamErr := fanInEvents(ctx, dataCh, errCh)
 
-	stepTs := params.From
-	var stepID int64
-	pending := make([]storage.SensorEvent, 0, 128)
+	snap := snapshotOf(state, target)
+	snap.StepID = int64(target.Sub(from)/params.Step) + 1
+	dropStale(&snap, state, params.MaxStaleness)
+	return snap, nil
+}
 
-	for !stepTs.After(target) {
-		stepID++
-		if ctx.Err() != nil {
-			return StateSnapshot{}, ctx.Err()
+// BuildStates рассчитывает состояния на несколько моментов времени за один проход по истории.
+// Метки должны идти по неубыванию: выполняется один Warmup на первую метку и один Stream
+// до последней, снимки фиксируются по мере продвижения по событиям.
+// maxStale > 0 исключает из снимка значения, не обновлявшиеся дольше maxStale (см. StateSnapshot.Stale).
+func BuildStates(ctx context.Context, store storage.Storage, sensors []int64, window, maxStale time.Duration, targets []time.Time) ([]StateSnapshot, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	for i := 1; i < len(targets); i++ {
+		if targets[i].Before(targets[i-1]) {
+			return nil, fmt.Errorf("replay: targets must be sorted ascending (%s before %s)", targets[i], targets[i-1])
 		}
+	}
+	first, last := targets[0], targets[len(targets)-1]
+
+	state := make(map[int64]*sensorState, len(sensors))
+	for _, id := range sensors {
+		state[id] = &sensorState{}
+	}
+	warm, err := store.Warmup(ctx, sensors, first)
+	if err != nil {
+		return nil, fmt.Errorf("replay: warmup: %w", err)
+	}
+	applyEvents(state, warm, false)
 
-		pending, _ = drainEvents(eventCh, pending)
-		pending = applyPending(state, pending, stepTs)
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dataCh, errCh := store.Stream(streamCtx, storage.StreamRequest{
+		Sensors: sensors,
+		From:    first,
+		To:      inclusiveTo(last),
+		Window:  window,
+	})
+	eventCh, streamErr := fanInEvents(streamCtx, dataCh, errCh)
 
-		if stepTs.Equal(target) {
+	result := make([]StateSnapshot, 0, len(targets))
+	idx := 0
+	for ev := range eventCh {
+		for idx < len(targets) && ev.Timestamp.After(targets[idx]) {
+			result = append(result, staleSnapshotOf(state, targets[idx], maxStale))
+			idx++
+		}
+		if idx == len(targets) {
 			break
 		}
-		stepTs = stepTs.Add(params.Step)
+		st := state[ev.SensorID]
+		if st == nil {
+			st = &sensorState{}
+			state[ev.SensorID] = st
+		}
+		st.apply(ev)
+	}
+	cancel()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if idx < len(targets) {
+		select {
+		case err := <-streamErr:
+			if err != nil {
+				return nil, err
+			}
+		default:
+		}
 	}
+	for ; idx < len(targets); idx++ {
+		result = append(result, staleSnapshotOf(state, targets[idx], maxStale))
+	}
+	return result, nil
+}
 
-	select {
-	case err := <-streamErr:
-		if err != nil {
-			return StateSnapshot{}, err
+// inclusiveTo — граница StreamRequest.To, при которой в поток попадают события ровно в t:
+// To не включается, а время в хранилищах записано с точностью до микросекунды.
+// Более поздние события внутри этой микросекунды отсекаются по времени при применении.
+func inclusiveTo(t time.Time) time.Time {
+	return t.Add(time.Microsecond)
+}
+
+// applyStream применяет события потока не позже cutoff и дочитывает поток до конца: в отличие
+// от drainEvents результат не зависит от того, как быстро хранилище отдаёт события.
+func applyStream(ctx context.Context, state map[int64]*sensorState, eventCh <-chan storage.SensorEvent, streamErr <-chan error, cutoff time.Time) error {
+	for {
+		select {
+		case ev, ok := <-eventCh:
+			if !ok {
+				select {
+				case err := <-streamErr:
+					return err
+				default:
+				}
+				return ctx.Err()
+			}
+			if ev.Timestamp.After(cutoff) {
+				continue
+			}
+			st := state[ev.SensorID]
+			if st == nil {
+				st = &sensorState{}
+				state[ev.SensorID] = st
+			}
+			if st.apply(ev) {
+				st.dirty = true
+			}
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-	default:
 	}
+}
+
+func staleSnapshotOf(state map[int64]*sensorState, ts time.Time, maxStale time.Duration) StateSnapshot {
+	snap := snapshotOf(state, ts)
+	dropStale(&snap, state, maxStale)
+	return snap
+}
 
-	values := make(map[int64]float64, len(state))
+// dropStale убирает из снимка значения, последнее событие которых раньше snap.StepTs-maxStale.
+// Окно применяется только здесь, при построении снимка: запросы к хранилищу (Warmup, Stream)
+// maxStale не получают и отдают последние значения как обычно.
+func dropStale(snap *StateSnapshot, state map[int64]*sensorState, maxStale time.Duration) {
+	if maxStale <= 0 {
+		return
+	}
+	cutoff := snap.StepTs.Add(-maxStale)
+	for id := range snap.Values {
+		if st := state[id]; st != nil && !st.ts.Before(cutoff) {
+			continue
+		}
+		delete(snap.Values, id)
+		delete(snap.Undefined, id)
+		if snap.Stale == nil {
+			snap.Stale = make(map[int64]bool)
+		}
+		snap.Stale[id] = true
+	}
+}
+
+func snapshotOf(state map[int64]*sensorState, ts time.Time) StateSnapshot {
+	snap := StateSnapshot{StepTs: ts, Values: make(map[int64]float64, len(state)), Updated: make(map[int64]time.Time, len(state))}
 	for id, st := range state {
-		if st.hasValue {
-			values[id] = st.value
+		if !st.hasValue {
+			continue
+		}
+		snap.Values[id] = st.value
+		snap.Updated[id] = st.ts
+		if st.undefined {
+			if snap.Undefined == nil {
+				snap.Undefined = make(map[int64]bool)
+			}
+			snap.Undefined[id] = true
 		}
 	}
+	return snap
+}
 
-	return StateSnapshot{
-		StepID: stepID,
-		StepTs: stepTs,
-		Values: values,
-	}, nil
+// sendPreview передаёт копию состояния в cmd.Preview (если канал задан и готов принять).
+func sendPreview(cmd Command, state map[int64]*sensorState, stepID int64, ts time.Time) {
+	if cmd.Preview == nil {
+		return
+	}
+	snap := snapshotOf(state, ts)
+	snap.StepID = stepID
+	select {
+	case cmd.Preview <- snap:
+	default:
+	}
 }
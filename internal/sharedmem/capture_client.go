@@ -0,0 +1,80 @@
+package sharedmem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// CaptureClient запоминает все payload воспроизведения, чтобы записать их в эталонный
+// («golden») файл или сравнить с ним. Обновления внутри payload упорядочиваются по Hash:
+// порядок обхода состояния датчиков в replay не фиксирован. Для воспроизводимой разбивки
+// шага на батчи запуск должен идти без ограничения размера батча.
+type CaptureClient struct {
+	mu       sync.Mutex
+	payloads []StepPayload
+}
+
+// Send сохраняет копию payload с отсортированными обновлениями.
+func (c *CaptureClient) Send(_ context.Context, payload StepPayload) error {
+	updates := append([]SensorUpdate(nil), payload.Updates...)
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Hash < updates[j].Hash })
+	payload.Updates = updates
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payloads = append(c.payloads, payload)
+	return nil
+}
+
+// Ping реализует Pinger: payload пишутся в память, клиент всегда готов.
+func (c *CaptureClient) Ping(context.Context) error { return nil }
+
+// Payloads возвращает записанные payload в порядке отправки.
+func (c *CaptureClient) Payloads() []StepPayload {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]StepPayload(nil), c.payloads...)
+}
+
+// Golden возвращает запись в формате JSONL (как --stdout-format jsonl, без имён датчиков) —
+// содержимое эталонного файла.
+func (c *CaptureClient) Golden() ([]byte, error) {
+	format := StdoutClient{Format: FormatJSONL}
+	var buf bytes.Buffer
+	for _, payload := range c.Payloads() {
+		line, err := format.formatJSONL(payload)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+	}
+	return buf.Bytes(), nil
+}
+
+// CheckGolden сравнивает запись с эталоном построчно; ошибка называет первую различающуюся строку.
+func (c *CaptureClient) CheckGolden(golden []byte) error {
+	got, err := c.Golden()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(got, golden) {
+		return nil
+	}
+	gotLines := bytes.SplitAfter(got, []byte("\n"))
+	wantLines := bytes.SplitAfter(golden, []byte("\n"))
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var g, w []byte
+		if i < len(gotLines) {
+			g = bytes.TrimSuffix(gotLines[i], []byte("\n"))
+		}
+		if i < len(wantLines) {
+			w = bytes.TrimSuffix(wantLines[i], []byte("\n"))
+		}
+		if !bytes.Equal(g, w) {
+			return fmt.Errorf("golden mismatch at line %d:\n  got:  %s\n  want: %s", i+1, g, w)
+		}
+	}
+	return fmt.Errorf("golden mismatch: trailing newline differs")
+}
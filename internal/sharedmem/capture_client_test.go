@@ -0,0 +1,47 @@
+package sharedmem
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCaptureClientGolden(t *testing.T) {
+	client := &CaptureClient{}
+	payloads := []StepPayload{
+		{StepID: 1, StepTs: "2024-06-01T00:00:00Z", BatchID: 1, BatchTotal: 1, Updates: []SensorUpdate{{Hash: 7, Value: 2}, {Hash: 3, Value: 1.5}}},
+		{StepID: 2, StepTs: "2024-06-01T00:00:01Z", BatchID: 1, BatchTotal: 1, Updates: []SensorUpdate{{Hash: 3, Undefined: true}}},
+	}
+	for _, p := range payloads {
+		if err := client.Send(context.Background(), p); err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	// Исходный payload не меняется: сортируется копия обновлений.
+	if payloads[0].Updates[0].Hash != 7 {
+		t.Fatalf("payload updates were reordered in place")
+	}
+	golden, err := client.Golden()
+	if err != nil {
+		t.Fatalf("golden: %v", err)
+	}
+	want := `{"step_id":1,"ts":"2024-06-01T00:00:00Z","updates":[{"id":3,"value":1.5},{"id":7,"value":2}]}
+{"step_id":2,"ts":"2024-06-01T00:00:01Z","updates":[{"id":3,"value":0,"undefined":true}]}
+`
+	if string(golden) != want {
+		t.Fatalf("golden = %q, want %q", golden, want)
+	}
+	if err := client.CheckGolden([]byte(want)); err != nil {
+		t.Fatalf("check same golden: %v", err)
+	}
+
+	changed := strings.Replace(want, `"value":2`, `"value":3`, 1)
+	err = client.CheckGolden([]byte(changed))
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("check changed golden err = %v, want mismatch at line 1", err)
+	}
+	err = client.CheckGolden([]byte(want[:strings.Index(want, "\n")+1]))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("check short golden err = %v, want mismatch at line 2", err)
+	}
+}
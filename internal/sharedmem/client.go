@@ -2,16 +2,20 @@ package sharedmem
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
 // SensorUpdate описывает новое значение датчика, подготовленное к публикации.
 type SensorUpdate struct {
-	Hash  int64   // cityhash64(name) - основной идентификатор
-	Value float64
+	Hash      int64 // cityhash64(name) - основной идентификатор
+	Value     float64
+	Undefined bool // датчик в неопределённом состоянии: отправляется запросом setUndefined, Value игнорируется
+	NoData    bool // маркер «нет данных» для выбранного датчика без значений (--emit-empty), в SM не отправляется
 }
 
 // StepPayload — одна пачка изменений для конкретного шага.
@@ -28,19 +32,110 @@ type Client interface {
 	Send(ctx context.Context, payload StepPayload) error
 }
 
+// Pinger опционально проверяет доступность получателя (для readiness-проверок).
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Форматы вывода StdoutClient.
+const (
+	FormatText  = "text"  // строка на batch для чтения человеком
+	FormatJSONL = "jsonl" // JSON-объект на строку, для обработки jq и другими утилитами
+)
+
 // StdoutClient — временная заглушка, печатающая payload в writer.
+// Безопасен для одновременных Send: каждая строка пишется в writer целиком одним вызовом Write.
 type StdoutClient struct {
-	Writer io.Writer
+	Writer   io.Writer
+	Format   string                 // FormatText (по умолчанию) или FormatJSONL
+	Registry *config.SensorRegistry // для имён датчиков в JSONL (nil — без имён)
+
+	mu sync.Mutex // сериализует запись в Writer
+}
+
+// jsonlStep — строка вывода в формате JSONL.
+type jsonlStep struct {
+	StepID     int64         `json:"step_id"`
+	TS         string        `json:"ts"`
+	BatchID    int           `json:"batch_id,omitempty"`
+	BatchTotal int           `json:"batch_total,omitempty"`
+	Updates    []jsonlUpdate `json:"updates"`
+}
+
+type jsonlUpdate struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name,omitempty"`
+	Value     float64 `json:"value"`
+	Undefined bool    `json:"undefined,omitempty"`
+	NoData    bool    `json:"no_data,omitempty"`
 }
 
 func (c *StdoutClient) Send(_ context.Context, payload StepPayload) error {
 	if c.Writer == nil {
 		return fmt.Errorf("stdout client: writer is not set")
 	}
-	_, err := fmt.Fprintf(c.Writer, "STEP %d (%s) batch %d/%d: %+v\n", payload.StepID, payload.StepTs, payload.BatchID, payload.BatchTotal, payload.Updates)
+	var line []byte
+	switch c.Format {
+	case "", FormatText:
+		line = fmt.Appendf(nil, "STEP %d (%s) batch %d/%d: %+v\n", payload.StepID, payload.StepTs, payload.BatchID, payload.BatchTotal, payload.Updates)
+	case FormatJSONL:
+		var err error
+		if line, err = c.formatJSONL(payload); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("stdout client: unknown format %q (expected text|jsonl)", c.Format)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.Writer.Write(line)
 	return err
 }
 
+// Ping реализует Pinger: stdout проверять не нужно, достаточно заданного writer.
+func (c *StdoutClient) Ping(context.Context) error {
+	if c.Writer == nil {
+		return fmt.Errorf("stdout client: writer is not set")
+	}
+	return nil
+}
+
+// formatJSONL формирует payload одной JSON-строкой. Batch указывается, только если шаг разбит на части.
+func (c *StdoutClient) formatJSONL(payload StepPayload) ([]byte, error) {
+	line := jsonlStep{
+		StepID:  payload.StepID,
+		TS:      payload.StepTs,
+		Updates: make([]jsonlUpdate, 0, len(payload.Updates)),
+	}
+	if payload.BatchTotal > 1 {
+		line.BatchID = payload.BatchID
+		line.BatchTotal = payload.BatchTotal
+	}
+	for _, upd := range payload.Updates {
+		item := jsonlUpdate{ID: upd.Hash, Value: upd.Value, Undefined: upd.Undefined, NoData: upd.NoData}
+		if c.Registry != nil {
+			if key, ok := c.Registry.ByHash(upd.Hash); ok {
+				item.Name = key.Name
+			}
+		}
+		line.Updates = append(line.Updates, item)
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return nil, fmt.Errorf("stdout client: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// DiscardClient принимает payload и ничего не отправляет: задача идёт только ради
+// трансляции шагов в UI, а SM не трогается.
+type DiscardClient struct{}
+
+func (DiscardClient) Send(context.Context, StepPayload) error { return nil }
+
+// Ping реализует Pinger: получателя нет, клиент всегда готов.
+func (DiscardClient) Ping(context.Context) error { return nil }
+
 // ParamFormatter позволяет переопределить имя параметра для датчика.
 // Получает hash и registry для определения формата: ID из конфига или name.
 type ParamFormatter func(hash int64, registry *config.SensorRegistry) string
@@ -3,7 +3,15 @@ package sharedmem
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
 func TestStdoutClientRequiresWriter(t *testing.T) {
@@ -12,6 +20,22 @@ func TestStdoutClientRequiresWriter(t *testing.T) {
 	if err == nil {
 		t.Fatalf("expected error when writer is nil")
 	}
+	if err := client.Ping(context.Background()); err == nil {
+		t.Fatalf("expected ping error when writer is nil")
+	}
+}
+
+func TestLocalClientsReady(t *testing.T) {
+	// Локальные клиенты отвечают на readiness сами, а не пропускают проверку.
+	for _, c := range []Client{&StdoutClient{Writer: &bytes.Buffer{}}, DiscardClient{}, &CaptureClient{}} {
+		p, ok := c.(Pinger)
+		if !ok {
+			t.Fatalf("%T does not implement Pinger", c)
+		}
+		if err := p.Ping(context.Background()); err != nil {
+			t.Fatalf("%T ping: %v", c, err)
+		}
+	}
 }
 
 func TestStdoutClientWritesPayload(t *testing.T) {
@@ -37,3 +61,114 @@ func TestBuildSetQueryEmptyUpdates(t *testing.T) {
 		t.Fatalf("expected error for empty updates")
 	}
 }
+
+func TestStdoutClientJSONL(t *testing.T) {
+	var buf bytes.Buffer
+	reg := config.NewSensorRegistry()
+	key := config.NewSensorKey("Sensor1_S", nil)
+	if err := reg.Add(key); err != nil {
+		t.Fatalf("registry add: %v", err)
+	}
+	hash := key.Hash
+	client := &StdoutClient{Writer: &buf, Format: FormatJSONL, Registry: reg}
+	payload := StepPayload{
+		StepID:     3,
+		StepTs:     "2024-06-01T00:00:02Z",
+		BatchID:    1,
+		BatchTotal: 1,
+		Updates:    []SensorUpdate{{Hash: hash, Value: 1.5}, {Hash: 7, NoData: true}},
+	}
+	if err := client.Send(context.Background(), payload); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	line := buf.String()
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+		t.Fatalf("expected exactly one JSON line, got %q", line)
+	}
+	var got jsonlStep
+	if err := json.Unmarshal([]byte(line), &got); err != nil {
+		t.Fatalf("invalid JSON line: %v", err)
+	}
+	want := jsonlStep{
+		StepID:  3,
+		TS:      "2024-06-01T00:00:02Z",
+		Updates: []jsonlUpdate{{ID: hash, Name: "Sensor1_S", Value: 1.5}, {ID: 7, NoData: true}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("JSONL = %+v, want %+v", got, want)
+	}
+
+	client.Format = "xml"
+	if err := client.Send(context.Background(), payload); err == nil {
+		t.Fatalf("expected error for unknown format")
+	}
+}
+
+// byteWriter пишет по одному байту с переключением горутин, чтобы несериализованные
+// записи гарантированно перемешивались.
+type byteWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *byteWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		w.mu.Lock()
+		w.buf.WriteByte(b)
+		w.mu.Unlock()
+		runtime.Gosched()
+	}
+	return len(p), nil
+}
+
+func TestStdoutClientConcurrentSendKeepsLines(t *testing.T) {
+	const senders, perSender = 8, 50
+	for _, format := range []string{FormatText, FormatJSONL} {
+		w := &byteWriter{}
+		client := &StdoutClient{Writer: w, Format: format}
+		var wg sync.WaitGroup
+		for g := 0; g < senders; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < perSender; i++ {
+					payload := StepPayload{
+						StepID:  int64(g*perSender + i),
+						StepTs:  "2024-06-01T00:00:00Z",
+						Updates: []SensorUpdate{{Hash: int64(g), Value: float64(i)}, {Hash: 100 + int64(g), Value: 0.5}},
+					}
+					if err := client.Send(context.Background(), payload); err != nil {
+						t.Errorf("Send returned error: %v", err)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		lines := strings.Split(strings.TrimSuffix(w.buf.String(), "\n"), "\n")
+		if len(lines) != senders*perSender {
+			t.Fatalf("%s: lines = %d, want %d", format, len(lines), senders*perSender)
+		}
+		seen := make(map[int64]bool, len(lines))
+		for _, line := range lines {
+			var stepID int64
+			if format == FormatJSONL {
+				var step jsonlStep
+				if err := json.Unmarshal([]byte(line), &step); err != nil || len(step.Updates) != 2 {
+					t.Fatalf("%s: broken line %q: %v", format, line, err)
+				}
+				stepID = step.StepID
+			} else {
+				var ts string
+				if _, err := fmt.Sscanf(line, "STEP %d (%s", &stepID, &ts); err != nil || !strings.HasSuffix(line, "}]") || strings.Count(line, "STEP") != 1 {
+					t.Fatalf("%s: broken line %q: %v", format, line, err)
+				}
+			}
+			seen[stepID] = true
+		}
+		if len(seen) != senders*perSender {
+			t.Fatalf("%s: distinct steps = %d, want %d", format, len(seen), senders*perSender)
+		}
+	}
+}
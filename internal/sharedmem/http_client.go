@@ -15,10 +15,20 @@ import (
 	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
-// HTTPClient отправляет изменения датчиков в SharedMemory HTTP API (/set).
+// Подпути SharedMemory HTTP API относительно BaseURL (раскладка api/v01/SharedMemory).
+const (
+	DefaultSetPath = "/set"
+	DefaultGetPath = "/get"
+)
+
+// HTTPClient отправляет изменения датчиков в SharedMemory HTTP API (/set, /setUndefined).
 type HTTPClient struct {
-	BaseURL        string
-	Supplier       string
+	BaseURL  string
+	Supplier string
+	// SetPath/GetPath — подпути set и get относительно BaseURL (пусто — DefaultSetPath/DefaultGetPath),
+	// для версий SM с другой раскладкой API. Неопределённые значения уходят на SetPath + "Undefined".
+	SetPath        string
+	GetPath        string
 	HTTP           *http.Client
 	Logger         *log.Logger
 	ParamFormatter ParamFormatter
@@ -58,6 +68,36 @@ func (c *HTTPClient) Send(ctx context.Context, payload StepPayload) error {
 	return c.set(ctx, payload.Updates, batch)
 }
 
+// Ping проверяет, что SharedMemory HTTP API отвечает (любой ответ кроме 5xx).
+func (c *HTTPClient) Ping(ctx context.Context) error {
+	if c.BaseURL == "" {
+		return fmt.Errorf("http client: BaseURL is empty")
+	}
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL, nil)
+	if err != nil {
+		return fmt.Errorf("http client: new request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("http client: ping: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("http client: ping: %s", resp.Status)
+	}
+	return nil
+}
+
 func (c *HTTPClient) enqueue(ctx context.Context, updates []SensorUpdate, batchSize int) error {
 	c.startWorkers.Do(func() {
 		size := c.QueueSize
@@ -114,7 +154,63 @@ func (c *HTTPClient) set(ctx context.Context, updates []SensorUpdate, batchSize
 		httpClient = http.DefaultClient
 	}
 
-	endpoint, err := joinURL(c.BaseURL, "/set")
+	defined, undefined := splitUndefined(updates)
+	setPath := c.setPath()
+	if err := c.sendChunks(ctx, httpClient, setPath, defined, batchSize, buildSetQuery); err != nil {
+		return err
+	}
+	return c.sendChunks(ctx, httpClient, setPath+"Undefined", undefined, batchSize, buildUndefinedQuery)
+}
+
+func (c *HTTPClient) setPath() string {
+	if c.SetPath == "" {
+		return DefaultSetPath
+	}
+	return c.SetPath
+}
+
+// GetEndpoint возвращает URL запроса get (BaseURL + GetPath) без параметров.
+func (c *HTTPClient) GetEndpoint() (string, error) {
+	path := c.GetPath
+	if path == "" {
+		path = DefaultGetPath
+	}
+	return joinURL(c.BaseURL, path)
+}
+
+// splitUndefined разделяет обновления на обычные и неопределённые (порядок сохраняется).
+// Маркеры NoData в SM не передаются: у SM нет понятия «нет данных».
+func splitUndefined(updates []SensorUpdate) (defined, undefined []SensorUpdate) {
+	plain := true
+	for _, upd := range updates {
+		if upd.Undefined || upd.NoData {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return updates, nil
+	}
+	defined = make([]SensorUpdate, 0, len(updates))
+	for _, upd := range updates {
+		switch {
+		case upd.NoData:
+		case upd.Undefined:
+			undefined = append(undefined, upd)
+		default:
+			defined = append(defined, upd)
+		}
+	}
+	return defined, undefined
+}
+
+type queryBuilder func(supplier string, updates []SensorUpdate, formatter ParamFormatter, registry *config.SensorRegistry) (string, error)
+
+func (c *HTTPClient) sendChunks(ctx context.Context, httpClient *http.Client, path string, updates []SensorUpdate, batchSize int, build queryBuilder) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	endpoint, err := joinURL(c.BaseURL, path)
 	if err != nil {
 		return err
 	}
@@ -123,11 +219,11 @@ func (c *HTTPClient) set(ctx context.Context, updates []SensorUpdate, batchSize
 	}
 	for i := 0; i < len(updates); i += batchSize {
 		chunk := updates[i:min(i+batchSize, len(updates))]
-		rawQuery, err := buildSetQuery(c.Supplier, chunk, c.ParamFormatter, c.Registry)
+		rawQuery, err := build(c.Supplier, chunk, c.ParamFormatter, c.Registry)
 		if err != nil {
 			return err
 		}
-		if err := c.sendWithRetry(ctx, httpClient, endpoint, rawQuery); err != nil {
+		if err := c.sendWithRetry(ctx, httpClient, path, endpoint, rawQuery); err != nil {
 			if c.Logger != nil {
 				preview := rawQuery
 				if len(preview) > 100 {
@@ -165,6 +261,17 @@ func DefaultParamFormatter(prefix string) ParamFormatter {
 }
 
 func buildSetQuery(supplier string, updates []SensorUpdate, formatter ParamFormatter, registry *config.SensorRegistry) (string, error) {
+	return buildQuery(supplier, updates, formatter, registry, func(upd SensorUpdate) string {
+		return strconv.FormatFloat(upd.Value, 'f', -1, 64)
+	})
+}
+
+// buildUndefinedQuery формирует запрос /setUndefined: для каждого датчика передаётся 1 (undefined=true).
+func buildUndefinedQuery(supplier string, updates []SensorUpdate, formatter ParamFormatter, registry *config.SensorRegistry) (string, error) {
+	return buildQuery(supplier, updates, formatter, registry, func(SensorUpdate) string { return "1" })
+}
+
+func buildQuery(supplier string, updates []SensorUpdate, formatter ParamFormatter, registry *config.SensorRegistry, valueOf func(SensorUpdate) string) (string, error) {
 	if len(updates) == 0 {
 		return "", fmt.Errorf("http client: no updates to send")
 	}
@@ -194,8 +301,7 @@ func buildSetQuery(supplier string, updates []SensorUpdate, formatter ParamForma
 		if key == "" {
 			return "", fmt.Errorf("http client: empty parameter name for sensor hash %d", upd.Hash)
 		}
-		value := strconv.FormatFloat(upd.Value, 'f', -1, 64)
-		writeParam(key, value)
+		writeParam(key, valueOf(upd))
 	}
 	return b.String(), nil
 }
@@ -212,7 +318,7 @@ func joinURL(base, path string) (string, error) {
 	return joined, nil
 }
 
-func (c *HTTPClient) sendWithRetry(ctx context.Context, httpClient *http.Client, endpoint, rawQuery string) error {
+func (c *HTTPClient) sendWithRetry(ctx context.Context, httpClient *http.Client, path, endpoint, rawQuery string) error {
 	attempts := c.Retry + 1
 	if attempts < 1 {
 		attempts = 1
@@ -255,8 +361,8 @@ func (c *HTTPClient) sendWithRetry(ctx context.Context, httpClient *http.Client,
 			if c.totalCalls > 0 {
 				avg = time.Duration(int64(c.totalDuration) / c.totalCalls)
 			}
-			c.Logger.Printf("SM /set %s -> %s (%s, avg %s over %d calls)",
-				req.URL.String(), resp.Status, elapsed, avg, c.totalCalls)
+			c.Logger.Printf("SM %s %s -> %s (%s, avg %s over %d calls)",
+				path, req.URL.String(), resp.Status, elapsed, avg, c.totalCalls)
 			c.mu.Unlock()
 		}
 
@@ -265,7 +371,7 @@ func (c *HTTPClient) sendWithRetry(ctx context.Context, httpClient *http.Client,
 			if c.Logger != nil {
 				c.Logger.Printf("SM error body: %s", strings.TrimSpace(string(body)))
 			}
-			lastErr = fmt.Errorf("http client: /set failed: status=%s body=%s", resp.Status, strings.TrimSpace(string(body)))
+			lastErr = fmt.Errorf("http client: %s failed: status=%s body=%s", path, resp.Status, strings.TrimSpace(string(body)))
 			time.Sleep(backoffDelay(i))
 			continue
 		}
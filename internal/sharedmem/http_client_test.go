@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"reflect"
 	"strconv"
 	"strings"
 	"testing"
@@ -273,3 +274,111 @@ func TestHTTPClientWorkerQueue(t *testing.T) {
 		}
 	}
 }
+
+func TestHTTPClientPing(t *testing.T) {
+	status := http.StatusOK
+	client := &HTTPClient{
+		BaseURL: "http://example.com/api/v01/SharedMemory",
+		HTTP: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				if req.URL.Path != "/api/v01/SharedMemory" {
+					t.Fatalf("unexpected ping path: %s", req.URL.Path)
+				}
+				return &http.Response{
+					StatusCode: status,
+					Status:     http.StatusText(status),
+					Body:       io.NopCloser(strings.NewReader("")),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping returned error: %v", err)
+	}
+	status = http.StatusNotFound
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping must accept non-5xx responses, got %v", err)
+	}
+	status = http.StatusBadGateway
+	if err := client.Ping(context.Background()); err == nil {
+		t.Fatalf("Ping must fail on 5xx")
+	}
+	if err := (&HTTPClient{}).Ping(context.Background()); err == nil {
+		t.Fatalf("Ping must fail without BaseURL")
+	}
+}
+
+func TestHTTPClientSendsUndefinedSeparately(t *testing.T) {
+	queries := make(map[string]string)
+	client := &HTTPClient{
+		BaseURL: "http://example.com",
+		HTTP: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				queries[req.URL.Path] = req.URL.RawQuery
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Status:     http.StatusText(http.StatusOK),
+					Body:       io.NopCloser(strings.NewReader("ok")),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+
+	payload := StepPayload{
+		StepID: 1,
+		Updates: []SensorUpdate{
+			{Hash: 42, Value: 10.5},
+			{Hash: 77, Undefined: true},
+			{Hash: 99, NoData: true},
+		},
+	}
+	if err := client.Send(context.Background(), payload); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	if q := queries["/set"]; q != "id42=10.5" {
+		t.Fatalf("unexpected /set query: %q", q)
+	}
+	if q := queries["/setUndefined"]; q != "id77=1" {
+		t.Fatalf("unexpected /setUndefined query: %q", q)
+	}
+}
+
+func TestHTTPClientCustomPaths(t *testing.T) {
+	var paths []string
+	client := &HTTPClient{
+		BaseURL: "http://example.com/api/v2/SharedMemory",
+		SetPath: "/sensors/set",
+		GetPath: "/sensors/get",
+		HTTP: &http.Client{
+			Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				paths = append(paths, req.URL.Path)
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Status:     http.StatusText(http.StatusOK),
+					Body:       io.NopCloser(strings.NewReader("ok")),
+					Header:     make(http.Header),
+					Request:    req,
+				}, nil
+			}),
+		},
+	}
+	payload := StepPayload{Updates: []SensorUpdate{{Hash: 1, Value: 1}, {Hash: 2, Undefined: true}}}
+	if err := client.Send(context.Background(), payload); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	want := []string{"/api/v2/SharedMemory/sensors/set", "/api/v2/SharedMemory/sensors/setUndefined"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("paths = %v, want %v", paths, want)
+	}
+	if got, err := client.GetEndpoint(); err != nil || got != "http://example.com/api/v2/SharedMemory/sensors/get" {
+		t.Fatalf("get endpoint = %q %v", got, err)
+	}
+	defaults := &HTTPClient{BaseURL: "http://example.com/api/v01/SharedMemory/"}
+	if got, err := defaults.GetEndpoint(); err != nil || got != "http://example.com/api/v01/SharedMemory/get" {
+		t.Fatalf("default get endpoint = %q %v", got, err)
+	}
+}
@@ -0,0 +1,92 @@
+package sharedmem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSimulatedDrop возвращается SimClient при имитации потери отправки.
+var ErrSimulatedDrop = errors.New("simulated drop")
+
+// SimClient оборачивает Client и имитирует сетевые условия: задержку и потерю отправок.
+// Предназначен для проверки поведения получателей, в рабочем режиме не используется.
+type SimClient struct {
+	Next       Client
+	LatencyMin time.Duration
+	LatencyMax time.Duration // больше LatencyMin — случайная задержка в [LatencyMin, LatencyMax]
+	DropRate   float64       // вероятность потери отправки, [0, 1]
+	Rand       *rand.Rand    // источник случайности (nil — от текущего времени)
+
+	mu sync.Mutex
+}
+
+// Send выдерживает задержку и либо передаёт payload дальше, либо теряет его с ошибкой ErrSimulatedDrop.
+func (c *SimClient) Send(ctx context.Context, payload StepPayload) error {
+	if c.Next == nil {
+		return fmt.Errorf("sim client: next client is nil")
+	}
+	delay, drop := c.roll()
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if drop {
+		return fmt.Errorf("sim client: step %d batch %d/%d: %w", payload.StepID, payload.BatchID, payload.BatchTotal, ErrSimulatedDrop)
+	}
+	return c.Next.Send(ctx, payload)
+}
+
+// Ping проксирует проверку доступности обёрнутому клиенту.
+func (c *SimClient) Ping(ctx context.Context) error {
+	if p, ok := c.Next.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *SimClient) roll() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Rand == nil {
+		c.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	delay := c.LatencyMin
+	if c.LatencyMax > c.LatencyMin {
+		delay += time.Duration(c.Rand.Int63n(int64(c.LatencyMax-c.LatencyMin) + 1))
+	}
+	drop := c.DropRate > 0 && c.Rand.Float64() < c.DropRate
+	return delay, drop
+}
+
+// ParseLatency разбирает задержку вида "50ms" (фиксированная) или "20ms-80ms" (случайная в диапазоне).
+func ParseLatency(raw string) (time.Duration, time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return 0, 0, nil
+	}
+	minRaw, maxRaw, isRange := strings.Cut(raw, "-")
+	min, err := time.ParseDuration(strings.TrimSpace(minRaw))
+	if err != nil {
+		return 0, 0, fmt.Errorf("sim latency %q: %w", raw, err)
+	}
+	max := min
+	if isRange {
+		if max, err = time.ParseDuration(strings.TrimSpace(maxRaw)); err != nil {
+			return 0, 0, fmt.Errorf("sim latency %q: %w", raw, err)
+		}
+	}
+	if min < 0 || max < min {
+		return 0, 0, fmt.Errorf("sim latency %q: want 0 <= min <= max", raw)
+	}
+	return min, max, nil
+}
@@ -0,0 +1,68 @@
+package sharedmem
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type countingClient struct {
+	sent int
+}
+
+func (c *countingClient) Send(context.Context, StepPayload) error {
+	c.sent++
+	return nil
+}
+
+func TestSimClientDropAndLatency(t *testing.T) {
+	next := &countingClient{}
+	client := &SimClient{Next: next, LatencyMin: 5 * time.Millisecond, LatencyMax: 5 * time.Millisecond, DropRate: 1}
+	started := time.Now()
+	err := client.Send(context.Background(), StepPayload{StepID: 1, BatchID: 1, BatchTotal: 1})
+	if !errors.Is(err, ErrSimulatedDrop) {
+		t.Fatalf("expected simulated drop, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 5*time.Millisecond {
+		t.Fatalf("latency was not applied: %s", elapsed)
+	}
+	if next.sent != 0 {
+		t.Fatalf("dropped payload reached next client")
+	}
+
+	client = &SimClient{Next: next, DropRate: 0.5, Rand: rand.New(rand.NewSource(1))}
+	dropped := 0
+	for i := 0; i < 1000; i++ {
+		if err := client.Send(context.Background(), StepPayload{StepID: int64(i)}); err != nil {
+			dropped++
+		}
+	}
+	if dropped < 400 || dropped > 600 || next.sent != 1000-dropped {
+		t.Fatalf("unexpected drop ratio: dropped=%d sent=%d", dropped, next.sent)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client = &SimClient{Next: next, LatencyMin: time.Hour}
+	if err := client.Send(ctx, StepPayload{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancel during latency, got %v", err)
+	}
+}
+
+func TestParseLatency(t *testing.T) {
+	min, max, err := ParseLatency("50ms")
+	if err != nil || min != 50*time.Millisecond || max != min {
+		t.Fatalf("fixed latency: %s %s %v", min, max, err)
+	}
+	min, max, err = ParseLatency("20ms-80ms")
+	if err != nil || min != 20*time.Millisecond || max != 80*time.Millisecond {
+		t.Fatalf("range latency: %s %s %v", min, max, err)
+	}
+	for _, bad := range []string{"abc", "80ms-20ms", "10ms-x"} {
+		if _, _, err := ParseLatency(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
@@ -2,16 +2,18 @@ package clickhouse
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	ch "github.com/ClickHouse/clickhouse-go/v2"
-	"github.com/aviddiviner/go-murmur"
-	"github.com/go-faster/city"
 
 	"github.com/pv/uniset-timemachine-go/internal/storage"
+	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
 // Resolver для совместимости со старым кодом (работа через name)
@@ -24,6 +26,51 @@ type Config struct {
 	DSN      string
 	Table    string
 	Resolver Resolver
+
+	// Hasher — алгоритм hash(name), которым записаны name_hid/uniset_hid и считаются hash датчиков
+	// в режиме name (nil — config.DefaultHasher). Должен совпадать с реестром конфига.
+	Hasher config.Hasher
+
+	// Параметры драйвера. Нулевые значения означают «оставить как в DSN».
+	// Размер пула не настраивается: фильтр датчиков живёт во временной таблице соединения,
+	// поэтому пул всегда из одного соединения (см. applyOptions).
+	DialTimeout time.Duration // таймаут установки соединения
+	Compression string        // none | lz4 | zstd | gzip
+	Settings    map[string]any
+
+	// WarmupLookback ограничивает поиск значений для Warmup окном [from-lookback, from] (0 — без ограничения).
+	WarmupLookback time.Duration
+
+	// UndefinedColumn — колонка с признаком неопределённого состояния (ненулевое значение — undefined).
+	UndefinedColumn string
+
+	// ValueColumn — колонка, которая читается как значение датчика (пусто — value).
+	// Наличие колонки проверяется по system.columns при подключении.
+	ValueColumn string
+
+	// WindowTargetRows — целевое число строк за окно при автоподборе (Window == 0); 0 — storage.DefaultWindowTargetRows.
+	WindowTargetRows int
+
+	// MaxRowsPerWindow — предел строк одного запроса окна Stream: окно, в котором строк больше,
+	// делится по времени на части (см. storage.ReadCapped); 0 — без ограничения.
+	MaxRowsPerWindow int
+
+	// SourceTimeZone — часовой пояс, в котором записаны значения колонки timestamp типа DateTime без зоны
+	// (nil или UTC — значения читаются как есть). Для колонки с явной зоной (DateTime('Europe/Moscow')) не применяется.
+	SourceTimeZone *time.Location
+
+	// StreamRetries — число повторов запроса окна Stream при временных ошибках (0 — без повторов).
+	StreamRetries int
+	// ConnectRetries/ConnectBackoff — ожидание сервера при подключении в New (см. storage.ConnectRetry).
+	ConnectRetries int
+	ConnectBackoff time.Duration
+
+	// HashMode принудительно задаёт режим хешей: uniset_hid, name_hid или name (пусто или auto —
+	// автоопределение по колонкам таблицы). Наличие колонки режима проверяется при подключении.
+	HashMode string
+
+	// FilterTable — имя временной таблицы фильтра датчиков (пусто — storage.FilterTableDefault).
+	FilterTable string
 }
 
 // hashMode определяет режим работы с хешами в ClickHouse.
@@ -35,14 +82,75 @@ const (
 	hashModeUnisetHID                 // работа через uniset_hid (MurmurHash2)
 )
 
-type Store struct {
-	conn     ch.Conn
-	table    string
-	resolver Resolver
-	mode     hashMode // режим работы с хешами
+// HashModeAuto — значение Config.HashMode для автоопределения режима.
+const HashModeAuto = "auto"
+
+// String возвращает имя режима; оно же — имя колонки, по которой режим ищет датчики.
+func (m hashMode) String() string {
+	switch m {
+	case hashModeUnisetHID:
+		return "uniset_hid"
+	case hashModeNameHID:
+		return "name_hid"
+	default:
+		return "name"
+	}
 }
 
-const filterTable = "tm_sensors"
+// chooseHashMode выбирает режим хешей. forced (uniset_hid | name_hid | name) принимается, только если
+// в таблице есть одноимённая колонка; пусто или auto — автоопределение с приоритетом
+// uniset_hid (MurmurHash2) > name_hid (CityHash64) > name (String). has сообщает о наличии колонки.
+func chooseHashMode(forced string, has func(column string) bool) (hashMode, error) {
+	modes := []hashMode{hashModeUnisetHID, hashModeNameHID, hashModeName}
+	if forced == "" || forced == HashModeAuto {
+		for _, m := range modes[:2] {
+			if has(m.String()) {
+				return m, nil
+			}
+		}
+		return hashModeName, nil
+	}
+	for _, m := range modes {
+		if forced != m.String() {
+			continue
+		}
+		if !has(forced) {
+			return 0, fmt.Errorf("hash mode %s: table has no %s column", forced, forced)
+		}
+		return m, nil
+	}
+	return 0, fmt.Errorf("unknown hash mode %q (want auto, uniset_hid, name_hid or name)", forced)
+}
+
+// valueKind определяет, как читать колонку value.
+type valueKind int
+
+const (
+	valueFloat          valueKind = iota // Float64 и прочие числовые типы
+	valueNullableFloat                   // Nullable(Float64): NULL — нет значения
+	valueString                          // String/LowCardinality(String)/Enum: разбор в число
+	valueNullableString                  // Nullable(String)
+)
+
+type Store struct {
+	conn         ch.Conn
+	table        string
+	resolver     Resolver
+	hasher       config.Hasher
+	mode         hashMode // режим работы с хешами
+	valueKind    valueKind
+	valueColumn  string // колонка значения (storage.ValueDefault по умолчанию)
+	lookback     time.Duration
+	undefined    string // выражение признака undefined (пусто — всегда 0)
+	windowTarget int    // целевое число строк за окно при автоподборе
+	maxRows      int    // предел строк одного запроса окна (0 — без ограничения)
+	filterTable  string // временная таблица фильтра датчиков (сессия соединения)
+	retry        storage.StreamRetry
+	serverTZ     *time.Location
+	sourceTZ     *time.Location // пояс wall-clock значений timestamp (nil — без пересчёта)
+
+	badValueOnce sync.Once // предупреждение о нечисловых value выводится один раз
+}
 
 func New(ctx context.Context, cfg Config) (*Store, error) {
 	if cfg.DSN == "" {
@@ -60,15 +168,16 @@ func New(ctx context.Context, cfg Config) (*Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("clickhouse: parse DSN: %w", err)
 	}
-	// Временные таблицы работают в пределах соединения, поэтому держим единичный коннект.
-	opts.MaxOpenConns = 1
-	opts.MaxIdleConns = 1
+	if err := applyOptions(opts, cfg); err != nil {
+		return nil, err
+	}
 
 	conn, err := ch.Open(opts)
 	if err != nil {
 		return nil, fmt.Errorf("clickhouse: open: %w", err)
 	}
-	if err := conn.Ping(ctx); err != nil {
+	retry := storage.ConnectRetry{Retries: cfg.ConnectRetries, Backoff: cfg.ConnectBackoff}
+	if err := retry.Do(ctx, "clickhouse", isRetryable, func() error { return conn.Ping(ctx) }); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("clickhouse: ping: %w", err)
 	}
@@ -85,13 +194,50 @@ func New(ctx context.Context, cfg Config) (*Store, error) {
 		table = fmt.Sprintf("%s.%s", database, table)
 	}
 
-	store := &Store{conn: conn, table: table, resolver: cfg.Resolver}
+	undefined, err := storage.UndefinedExpr(cfg.UndefinedColumn, "toUInt8(ifNull(%s, 0) != 0)")
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("clickhouse: %w", err)
+	}
+	valueColumn, err := storage.ValueColumn(cfg.ValueColumn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("clickhouse: %w", err)
+	}
+
+	filterTable, err := storage.FilterTable(cfg.FilterTable)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("clickhouse: %w", err)
+	}
+
+	hasher := cfg.Hasher
+	if hasher == nil {
+		hasher = config.DefaultHasher
+	}
+
+	store := &Store{conn: conn, table: table, resolver: cfg.Resolver, hasher: hasher, lookback: cfg.WarmupLookback, undefined: undefined, valueColumn: valueColumn, windowTarget: cfg.WindowTargetRows, maxRows: cfg.MaxRowsPerWindow, filterTable: filterTable, retry: storage.StreamRetry{Retries: cfg.StreamRetries}}
 
 	// Определяем режим работы: сначала проверяем uniset_hid, затем name_hid, иначе name
-	store.mode = store.detectHashMode(ctx)
+	store.mode, err = store.detectHashMode(ctx, cfg.HashMode)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("clickhouse: %w", err)
+	}
+	if cfg.HashMode == "" || cfg.HashMode == HashModeAuto {
+		log.Printf("clickhouse: hash mode %s (autodetected)", store.mode)
+	} else {
+		log.Printf("clickhouse: hash mode %s (forced)", store.mode)
+	}
+	if err := store.checkValueColumn(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	store.valueKind = store.detectValueKind(ctx)
 
 	// Check server timezone
 	store.checkTimezone(ctx)
+	store.configureSourceTimezone(ctx, cfg.SourceTimeZone)
 
 	// Проверяем возможность создания временной таблицы фильтра (и тип в зависимости от режима)
 	if err := store.ensureFilterTable(ctx); err != nil {
@@ -105,6 +251,7 @@ func New(ctx context.Context, cfg Config) (*Store, error) {
 // checkTimezone checks the ClickHouse server timezone and logs a warning if not UTC.
 func (s *Store) checkTimezone(ctx context.Context) {
 	var tz string
+	s.serverTZ = time.UTC
 	row := s.conn.QueryRow(ctx, "SELECT timezone()")
 	if err := row.Scan(&tz); err != nil {
 		log.Printf("clickhouse: WARNING: failed to check timezone: %v", err)
@@ -114,35 +261,229 @@ func (s *Store) checkTimezone(ctx context.Context) {
 		log.Printf("clickhouse: timezone is %s (OK)", tz)
 		return
 	}
+	if loc, err := time.LoadLocation(tz); err == nil {
+		s.serverTZ = loc
+	}
 	log.Printf("clickhouse: WARNING: server timezone is %q, expected UTC", tz)
-	log.Printf("clickhouse: timestamps will be interpreted as UTC regardless of server timezone")
+	log.Printf("clickhouse: DateTime without zone is read in server timezone; use --source-timezone if data is written in another zone")
+}
+
+// configureSourceTimezone включает пересчёт timestamp из source, если колонка — DateTime без явной зоны.
+// Зона, заданная в типе колонки, важнее настройки: значения такой колонки уже однозначны.
+func (s *Store) configureSourceTimezone(ctx context.Context, source *time.Location) {
+	if source == nil || source == time.UTC {
+		return
+	}
+	parts := strings.SplitN(s.table, ".", 2)
+	if len(parts) == 2 {
+		var typ string
+		query := `SELECT type FROM system.columns WHERE database = ? AND table = ? AND name = 'timestamp'`
+		if err := s.conn.QueryRow(ctx, query, parts[0], parts[1]).Scan(&typ); err == nil && hasExplicitZone(typ) {
+			log.Printf("clickhouse: timestamp column type %s has explicit zone, source timezone %s ignored", typ, source)
+			return
+		}
+	}
+	s.sourceTZ = source
+	log.Printf("clickhouse: timestamps are interpreted as wall-clock in %s", source)
+}
+
+// hasExplicitZone сообщает, задана ли зона в типе колонки: DateTime('Zone') или DateTime64(3, 'Zone').
+func hasExplicitZone(typ string) bool {
+	return strings.Contains(typ, "'")
+}
+
+// toColumn переводит момент в значение колонки без зоны: wall-clock в sourceTZ, как его прочитает сервер.
+func (s *Store) toColumn(t time.Time) time.Time {
+	if s.sourceTZ == nil || t.IsZero() {
+		return t
+	}
+	return rezone(t.In(s.sourceTZ), s.serverLoc())
+}
+
+// fromColumn переводит прочитанное значение колонки без зоны в момент времени (UTC).
+func (s *Store) fromColumn(ts time.Time) time.Time {
+	if s.sourceTZ == nil || ts.IsZero() {
+		return ts
+	}
+	return rezone(ts.In(s.serverLoc()), s.sourceTZ).UTC()
+}
+
+func (s *Store) serverLoc() *time.Location {
+	if s.serverTZ == nil {
+		return time.UTC
+	}
+	return s.serverTZ
+}
+
+// rezone сохраняет показания часов t, но относит их к поясу loc.
+func rezone(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// detectHashMode определяет режим работы с хешами по колонкам таблицы (см. chooseHashMode).
+// Если колонки проверить нельзя (таблица без базы в имени), принимается forced или режим name.
+func (s *Store) detectHashMode(ctx context.Context, forced string) (hashMode, error) {
+	parts := strings.SplitN(s.table, ".", 2)
+	return chooseHashMode(forced, func(column string) bool {
+		if len(parts) != 2 {
+			return column == forced
+		}
+		query := `SELECT count() FROM system.columns WHERE database = ? AND table = ? AND name = ?`
+		var count uint64
+		return s.conn.QueryRow(ctx, query, parts[0], parts[1], column).Scan(&count) == nil && count > 0
+	})
+}
+
+// HashMode возвращает выбранный режим хешей: uniset_hid, name_hid или name.
+func (s *Store) HashMode() string {
+	return s.mode.String()
 }
 
-// detectHashMode определяет режим работы с хешами.
-// Приоритет: uniset_hid (MurmurHash2) > name_hid (CityHash64) > name (String).
-func (s *Store) detectHashMode(ctx context.Context) hashMode {
+// checkValueColumn проверяет по system.columns, что в таблице есть колонка значения.
+// Колонку по умолчанию не проверяет: без неё таблица не является историей UniSet.
+func (s *Store) checkValueColumn(ctx context.Context) error {
+	if s.valueColumn == storage.ValueDefault {
+		return nil
+	}
 	parts := strings.SplitN(s.table, ".", 2)
 	if len(parts) != 2 {
-		return hashModeName
+		return nil
 	}
-	database, tableName := parts[0], parts[1]
+	rows, err := s.conn.Query(ctx, `SELECT name FROM system.columns WHERE database = ? AND table = ?`, parts[0], parts[1])
+	if err != nil {
+		return fmt.Errorf("clickhouse: table columns: %w", err)
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("clickhouse: table columns: %w", err)
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("clickhouse: table columns: %w", err)
+	}
+	if err := storage.RequireColumn(s.table, s.valueColumn, columns); err != nil {
+		return fmt.Errorf("clickhouse: value column: %w", err)
+	}
+	return nil
+}
 
-	// Проверяем наличие колонки uniset_hid (предпочтительный режим для UniSet совместимости)
-	query := `SELECT count() FROM system.columns WHERE database = ? AND table = ? AND name = 'uniset_hid'`
-	row := s.conn.QueryRow(ctx, query, database, tableName)
-	var count uint64
-	if err := row.Scan(&count); err == nil && count > 0 {
-		return hashModeUnisetHID
+// detectValueKind определяет тип колонки значения по system.columns.
+func (s *Store) detectValueKind(ctx context.Context) valueKind {
+	parts := strings.SplitN(s.table, ".", 2)
+	if len(parts) != 2 {
+		return valueFloat
 	}
+	var typ string
+	query := `SELECT type FROM system.columns WHERE database = ? AND table = ? AND name = ?`
+	if err := s.conn.QueryRow(ctx, query, parts[0], parts[1], s.valueColumn).Scan(&typ); err != nil {
+		return valueFloat
+	}
+	kind := valueKindOf(typ)
+	if kind != valueFloat {
+		log.Printf("clickhouse: %s column type is %s", s.valueColumn, typ)
+	}
+	return kind
+}
 
-	// Проверяем наличие колонки name_hid
-	query = `SELECT count() FROM system.columns WHERE database = ? AND table = ? AND name = 'name_hid'`
-	row = s.conn.QueryRow(ctx, query, database, tableName)
-	if err := row.Scan(&count); err == nil && count > 0 {
-		return hashModeNameHID
+// valueKindOf сопоставляет тип ClickHouse способу чтения value.
+func valueKindOf(typ string) valueKind {
+	typ = strings.TrimSpace(typ)
+	nullable := false
+	if inner, ok := unwrapType(typ, "Nullable"); ok {
+		typ, nullable = inner, true
+	}
+	if inner, ok := unwrapType(typ, "LowCardinality"); ok {
+		typ = inner
+		if inner, ok := unwrapType(typ, "Nullable"); ok {
+			typ, nullable = inner, true
+		}
 	}
+	textual := typ == "String" || strings.HasPrefix(typ, "FixedString(") || strings.HasPrefix(typ, "Enum")
+	switch {
+	case textual && nullable:
+		return valueNullableString
+	case textual:
+		return valueString
+	case nullable:
+		return valueNullableFloat
+	default:
+		return valueFloat
+	}
+}
 
-	return hashModeName
+func unwrapType(typ, wrapper string) (string, bool) {
+	if strings.HasPrefix(typ, wrapper+"(") && strings.HasSuffix(typ, ")") {
+		return typ[len(wrapper)+1 : len(typ)-1], true
+	}
+	return typ, false
+}
+
+// valueDest — приёмник колонки value для rows.Scan в зависимости от её типа.
+type valueDest struct {
+	kind valueKind
+	num  float64
+	nnum *float64
+	str  string
+	nstr *string
+}
+
+func (s *Store) newValueDest() *valueDest {
+	return &valueDest{kind: s.valueKind}
+}
+
+func (d *valueDest) target() any {
+	switch d.kind {
+	case valueNullableFloat:
+		return &d.nnum
+	case valueString:
+		return &d.str
+	case valueNullableString:
+		return &d.nstr
+	default:
+		return &d.num
+	}
+}
+
+// value возвращает прочитанное значение; ok=false — NULL или нечисловая строка (событие пропускается).
+func (s *Store) value(d *valueDest) (float64, bool) {
+	switch d.kind {
+	case valueNullableFloat:
+		if d.nnum == nil {
+			return 0, false
+		}
+		return *d.nnum, true
+	case valueString:
+		return s.parseValue(d.str)
+	case valueNullableString:
+		if d.nstr == nil {
+			return 0, false
+		}
+		return s.parseValue(*d.nstr)
+	default:
+		return d.num, true
+	}
+}
+
+// parseValue разбирает текстовое значение: число или true/false.
+func (s *Store) parseValue(raw string) (float64, bool) {
+	raw = strings.TrimSpace(raw)
+	if v, err := strconv.ParseFloat(raw, 64); err == nil {
+		return v, true
+	}
+	if b, err := strconv.ParseBool(raw); err == nil {
+		if b {
+			return 1, true
+		}
+		return 0, true
+	}
+	s.badValueOnce.Do(func() {
+		log.Printf("clickhouse: WARNING: skipping non-numeric value %q in %s (further values are skipped silently)", raw, s.table)
+	})
+	return 0, false
 }
 
 func (s *Store) Close() {
@@ -151,6 +492,14 @@ func (s *Store) Close() {
 	}
 }
 
+// Ping реализует storage.Pinger.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.conn.Ping(ctx); err != nil {
+		return fmt.Errorf("clickhouse: ping: %w", err)
+	}
+	return nil
+}
+
 func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]storage.SensorEvent, error) {
 	if len(sensors) == 0 {
 		return nil, nil
@@ -160,52 +509,68 @@ func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]
 		return nil, err
 	}
 
+	lookbackCond := ""
+	args := []any{ch.Named("from", s.toColumn(from))}
+	if s.lookback > 0 {
+		lookbackCond = "\n  AND timestamp >= @lookback_from"
+		args = append(args, ch.Named("lookback_from", s.toColumn(from.Add(-s.lookback))))
+	}
+
 	var query string
 	switch s.mode {
 	case hashModeUnisetHID:
-		query = fmt.Sprintf(warmupSQLUnisetHID, s.table, filterTable)
+		query = s.withColumns(fmt.Sprintf(warmupSQLUnisetHID, s.table, s.filterTable, lookbackCond))
 	case hashModeNameHID:
-		query = fmt.Sprintf(warmupSQLNameHID, s.table, filterTable)
+		query = s.withColumns(fmt.Sprintf(warmupSQLNameHID, s.table, s.filterTable, lookbackCond))
 	default:
-		query = fmt.Sprintf(warmupSQLName, s.table, filterTable)
+		query = s.withColumns(fmt.Sprintf(warmupSQLName, s.table, s.filterTable, lookbackCond))
 	}
 
-	rows, err := s.conn.Query(ctx, query, ch.Named("from", from))
+	rows, err := s.conn.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("clickhouse: warmup query: %w", err)
 	}
 	defer rows.Close()
 
 	events := make([]storage.SensorEvent, 0, len(sensors))
+	dest := s.newValueDest()
 	for rows.Next() {
 		var ts time.Time
-		var value float64
 		var hash int64
+		var undef uint8
 
 		switch s.mode {
 		case hashModeUnisetHID:
-			// Читаем uniset_hid и конвертируем обратно в CityHash64 через name
+			// Читаем uniset_hid и конвертируем обратно в hash через name
 			var unisetHID uint32
 			var name string
-			if err := rows.Scan(&unisetHID, &name, &ts, &value); err != nil {
+			if err := rows.Scan(&unisetHID, &name, &ts, dest.target(), &undef); err != nil {
 				return nil, fmt.Errorf("clickhouse: warmup scan: %w", err)
 			}
-			hash = int64(city.Hash64([]byte(name)))
+			hash = s.hasher.Hash64(name)
 		case hashModeNameHID:
 			// Читаем name_hid напрямую
-			if err := rows.Scan(&hash, &ts, &value); err != nil {
+			if err := rows.Scan(&hash, &ts, dest.target(), &undef); err != nil {
 				return nil, fmt.Errorf("clickhouse: warmup scan: %w", err)
 			}
 		default:
-			// Читаем name и конвертируем через cityhash64
+			// Читаем name и конвертируем через hasher
 			var name string
-			if err := rows.Scan(&name, &ts, &value); err != nil {
+			if err := rows.Scan(&name, &ts, dest.target(), &undef); err != nil {
 				return nil, fmt.Errorf("clickhouse: warmup scan: %w", err)
 			}
-			hash = int64(city.Hash64([]byte(name)))
+			hash = s.hasher.Hash64(name)
 		}
 
-		events = append(events, storage.SensorEvent{SensorID: hash, Timestamp: ts, Value: value})
+		if undef != 0 {
+			events = append(events, storage.SensorEvent{SensorID: hash, Timestamp: s.fromColumn(ts), Undefined: true})
+			continue
+		}
+		value, ok := s.value(dest)
+		if !ok {
+			continue
+		}
+		events = append(events, storage.SensorEvent{SensorID: hash, Timestamp: s.fromColumn(ts), Value: value})
 	}
 	return events, rows.Err()
 }
@@ -227,19 +592,22 @@ func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan [
 			return
 		}
 
+		// Window == 0 — автоподбор окна под целевое число строк.
 		window := req.Window
+		var tuner *storage.WindowTuner
 		if window <= 0 {
-			window = defaultWindow
+			tuner = storage.NewWindowTuner(defaultWindow, s.windowTarget)
+			window = tuner.Window()
 		}
 
 		var query string
 		switch s.mode {
 		case hashModeUnisetHID:
-			query = fmt.Sprintf(streamSQLUnisetHID, s.table, filterTable)
+			query = s.withColumns(fmt.Sprintf(streamSQLUnisetHID, s.table, s.filterTable))
 		case hashModeNameHID:
-			query = fmt.Sprintf(streamSQLNameHID, s.table, filterTable)
+			query = s.withColumns(fmt.Sprintf(streamSQLNameHID, s.table, s.filterTable))
 		default:
-			query = fmt.Sprintf(streamSQLName, s.table, filterTable)
+			query = s.withColumns(fmt.Sprintf(streamSQLName, s.table, s.filterTable))
 		}
 
 		cursor := req.From
@@ -249,57 +617,32 @@ func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan [
 				next = req.To
 			}
 
-			rows, err := s.conn.Query(ctx, query, ch.Named("from", cursor), ch.Named("to", next))
-			if err != nil {
-				errCh <- fmt.Errorf("clickhouse: stream query: %w", err)
-				return
-			}
-			batch := make([]storage.SensorEvent, 0, 256)
-			for rows.Next() {
-				var ts time.Time
-				var value float64
-				var hash int64
-
-				switch s.mode {
-				case hashModeUnisetHID:
-					var unisetHID uint32
-					var name string
-					if err := rows.Scan(&unisetHID, &name, &ts, &value); err != nil {
-						rows.Close()
-						errCh <- fmt.Errorf("clickhouse: stream scan: %w", err)
-						return
-					}
-					hash = int64(city.Hash64([]byte(name)))
-				case hashModeNameHID:
-					if err := rows.Scan(&hash, &ts, &value); err != nil {
-						rows.Close()
-						errCh <- fmt.Errorf("clickhouse: stream scan: %w", err)
-						return
+			read := func(from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+				return s.retry.Window(ctx, isRetryable, func(attempt int) ([]storage.SensorEvent, error) {
+					// Временная таблица фильтра живёт в сессии: после переподключения её нужно заполнить заново.
+					if attempt > 0 {
+						if err := s.refreshFilter(ctx, req.Sensors); err != nil {
+							return nil, err
+						}
 					}
-				default:
-					var name string
-					if err := rows.Scan(&name, &ts, &value); err != nil {
-						rows.Close()
-						errCh <- fmt.Errorf("clickhouse: stream scan: %w", err)
-						return
-					}
-					hash = int64(city.Hash64([]byte(name)))
-				}
-
-				batch = append(batch, storage.SensorEvent{SensorID: hash, Timestamp: ts, Value: value})
-			}
-			rows.Close()
-			if err := rows.Err(); err != nil {
-				errCh <- fmt.Errorf("clickhouse: rows err: %w", err)
-				return
+					return s.queryWindow(ctx, query, from, to, limit)
+				})
 			}
-			if len(batch) > 0 {
+			rows, err := storage.ReadCapped(cursor, next, s.maxRows, read, func(batch []storage.SensorEvent) error {
 				select {
 				case <-ctx.Done():
-					errCh <- ctx.Err()
-					return
+					return ctx.Err()
 				case dataCh <- batch:
+					return nil
 				}
+			})
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if tuner != nil {
+				tuner.Observe(next.Sub(cursor), rows)
+				window = tuner.Window()
 			}
 			if !next.After(cursor) {
 				break
@@ -311,6 +654,132 @@ func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan [
 	return dataCh, errCh
 }
 
+// queryWindow читает записи отфильтрованных датчиков в окне [from, to); limit > 0 — не больше limit строк.
+func (s *Store) queryWindow(ctx context.Context, query string, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	rows, err := s.conn.Query(ctx, query, ch.Named("from", s.toColumn(from)), ch.Named("to", s.toColumn(to)))
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse: stream query: %w", err)
+	}
+	defer rows.Close()
+	batch := make([]storage.SensorEvent, 0, 256)
+	dest := s.newValueDest()
+	for (limit <= 0 || len(batch) < limit) && rows.Next() {
+		var ts time.Time
+		var hash int64
+		var undef uint8
+
+		switch s.mode {
+		case hashModeUnisetHID:
+			var unisetHID uint32
+			var name string
+			if err := rows.Scan(&unisetHID, &name, &ts, dest.target(), &undef); err != nil {
+				return nil, fmt.Errorf("clickhouse: stream scan: %w", err)
+			}
+			hash = s.hasher.Hash64(name)
+		case hashModeNameHID:
+			if err := rows.Scan(&hash, &ts, dest.target(), &undef); err != nil {
+				return nil, fmt.Errorf("clickhouse: stream scan: %w", err)
+			}
+		default:
+			var name string
+			if err := rows.Scan(&name, &ts, dest.target(), &undef); err != nil {
+				return nil, fmt.Errorf("clickhouse: stream scan: %w", err)
+			}
+			hash = s.hasher.Hash64(name)
+		}
+
+		if undef != 0 {
+			batch = append(batch, storage.SensorEvent{SensorID: hash, Timestamp: s.fromColumn(ts), Undefined: true})
+			continue
+		}
+		value, ok := s.value(dest)
+		if !ok {
+			continue
+		}
+		batch = append(batch, storage.SensorEvent{SensorID: hash, Timestamp: s.fromColumn(ts), Value: value})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("clickhouse: rows err: %w", err)
+	}
+	return batch, nil
+}
+
+// isRetryable — временные ошибки ClickHouse: обрыв или таймаут сокета, нехватка соединений
+// и превышение числа одновременных запросов. Прочие исключения сервера (SQL, права) не повторяются.
+func isRetryable(err error) bool {
+	var exc *ch.Exception
+	if errors.As(err, &exc) {
+		switch exc.Code {
+		case 3, 32, 202, 209, 210: // UNEXPECTED_END_OF_FILE, ATTEMPT_TO_READ_AFTER_EOF, TOO_MANY_SIMULTANEOUS_QUERIES, SOCKET_TIMEOUT, NETWORK_ERROR
+			return true
+		}
+		return false
+	}
+	return errors.Is(err, ch.ErrAcquireConnTimeout) || storage.IsTransient(err)
+}
+
+// EventsFor реализует EventHistoryStorage: сырые записи одного датчика в окне [from, to].
+func (s *Store) EventsFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	return s.history(ctx, eventsSQL, sensor, from, to, limit)
+}
+
+// ChangesFor реализует ChangeHistoryStorage: только изменения значения одного датчика в окне [from, to].
+func (s *Store) ChangesFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	return s.history(ctx, changesSQL, sensor, from, to, limit)
+}
+
+// history выполняет запрос истории одного датчика (eventsSQL или changesSQL).
+func (s *Store) history(ctx context.Context, sqlTemplate string, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	var column string
+	var key any
+	switch s.mode {
+	case hashModeUnisetHID:
+		names, err := s.hashesToNames([]int64{sensor})
+		if err != nil {
+			return nil, err
+		}
+		column, key = "uniset_hid", s.hasher.Hash32(names[0])
+	case hashModeNameHID:
+		column, key = "name_hid", sensor
+	default:
+		names, err := s.hashesToNames([]int64{sensor})
+		if err != nil {
+			return nil, err
+		}
+		column, key = "name", names[0]
+	}
+
+	query := s.withColumns(fmt.Sprintf(sqlTemplate, s.table, column))
+	if limit > 0 {
+		query += fmt.Sprintf("\nLIMIT %d", limit)
+	}
+	rows, err := s.conn.Query(ctx, query, ch.Named("key", key), ch.Named("from", s.toColumn(from)), ch.Named("to", s.toColumn(to)))
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse: events query: %w", err)
+	}
+	defer rows.Close()
+
+	var events []storage.SensorEvent
+	dest := s.newValueDest()
+	for rows.Next() {
+		var ts time.Time
+		var undef uint8
+		if err := rows.Scan(&ts, dest.target(), &undef); err != nil {
+			return nil, fmt.Errorf("clickhouse: events scan: %w", err)
+		}
+		if undef != 0 {
+			events = append(events, storage.SensorEvent{SensorID: sensor, Timestamp: s.fromColumn(ts), Undefined: true})
+			continue
+		}
+		value, ok := s.value(dest)
+		if !ok {
+			continue
+		}
+		events = append(events, storage.SensorEvent{SensorID: sensor, Timestamp: s.fromColumn(ts), Value: value})
+	}
+	return events, rows.Err()
+}
+
 func (s *Store) Range(ctx context.Context, sensors []int64, from, to time.Time) (time.Time, time.Time, int64, error) {
 	minTs, maxTs, count, _, err := s.RangeWithUnknown(ctx, sensors, from, to)
 	return minTs, maxTs, count, err
@@ -335,7 +804,7 @@ SELECT min(timestamp) AS min_ts,
        count(DISTINCT uniset_hid) AS sensor_count
 FROM %s
 WHERE uniset_hid IN (SELECT uniset_hid FROM %s)
-`, s.table, filterTable)
+`, s.table, s.filterTable)
 	case hashModeNameHID:
 		query = fmt.Sprintf(`
 SELECT min(timestamp) AS min_ts,
@@ -343,7 +812,7 @@ SELECT min(timestamp) AS min_ts,
        count(DISTINCT name_hid) AS sensor_count
 FROM %s
 WHERE name_hid IN (SELECT name_hid FROM %s)
-`, s.table, filterTable)
+`, s.table, s.filterTable)
 	default:
 		query = fmt.Sprintf(`
 SELECT min(timestamp) AS min_ts,
@@ -351,17 +820,17 @@ SELECT min(timestamp) AS min_ts,
        count(DISTINCT name) AS sensor_count
 FROM %s
 WHERE name IN (SELECT name FROM %s)
-`, s.table, filterTable)
+`, s.table, s.filterTable)
 	}
 
 	var args []any
 	if !from.IsZero() {
 		query += "  AND timestamp >= ?\n"
-		args = append(args, from)
+		args = append(args, s.toColumn(from))
 	}
 	if !to.IsZero() {
 		query += "  AND timestamp <= ?\n"
-		args = append(args, to)
+		args = append(args, s.toColumn(to))
 	}
 	row := s.conn.QueryRow(ctx, query, args...)
 	var minTs, maxTs time.Time
@@ -378,11 +847,11 @@ WHERE name IN (SELECT name FROM %s)
 		clauses := make([]string, 0, 2)
 		if !from.IsZero() {
 			clauses = append(clauses, "timestamp >= ?")
-			argsAll = append(argsAll, from)
+			argsAll = append(argsAll, s.toColumn(from))
 		}
 		if !to.IsZero() {
 			clauses = append(clauses, "timestamp <= ?")
-			argsAll = append(argsAll, to)
+			argsAll = append(argsAll, s.toColumn(to))
 		}
 		if len(clauses) > 0 {
 			qAll += " WHERE " + strings.Join(clauses, " AND ")
@@ -395,7 +864,43 @@ WHERE name IN (SELECT name FROM %s)
 		}
 	}
 
-	return minTs, maxTs, int64(count), unknown, nil
+	return s.fromColumn(minTs), s.fromColumn(maxTs), int64(count), unknown, nil
+}
+
+// ListSensors реализует storage.SensorLister: различные имена датчиков (колонка name) в окне
+// [from, to]. Таблица только с name_hid без колонки name имён не хранит — запрос вернёт ошибку.
+func (s *Store) ListSensors(ctx context.Context, from, to time.Time) ([]storage.SensorIdentity, error) {
+	query := fmt.Sprintf("SELECT DISTINCT name FROM %s", s.table)
+	var args []any
+	clauses := make([]string, 0, 2)
+	if !from.IsZero() {
+		clauses = append(clauses, "timestamp >= ?")
+		args = append(args, s.toColumn(from))
+	}
+	if !to.IsZero() {
+		clauses = append(clauses, "timestamp <= ?")
+		args = append(args, s.toColumn(to))
+	}
+	if len(clauses) > 0 {
+		query += " WHERE " + strings.Join(clauses, " AND ")
+	}
+	rows, err := s.conn.Query(ctx, query+" ORDER BY name", args...)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse: list sensors: %w", err)
+	}
+	defer rows.Close()
+	var out []storage.SensorIdentity
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("clickhouse: list sensors scan: %w", err)
+		}
+		out = append(out, storage.SensorIdentity{Name: name})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("clickhouse: list sensors: %w", err)
+	}
+	return out, nil
 }
 
 // hashesToNames конвертирует hashes в names через resolver (для режима без name_hid).
@@ -436,7 +941,7 @@ func (s *Store) refreshFilter(ctx context.Context, hashes []int64) error {
 }
 
 // refreshFilterUnisetHID заполняет фильтр по uniset_hid (MurmurHash2 32-bit).
-// Конвертирует CityHash64 hashes в MurmurHash2 через resolver.
+// Конвертирует hash датчиков в hash32 (по умолчанию MurmurHash2) через resolver и hasher.
 func (s *Store) refreshFilterUnisetHID(ctx context.Context, hashes []int64) error {
 	// Конвертируем hashes в uniset_hid через name
 	names, err := s.hashesToNames(hashes)
@@ -446,16 +951,16 @@ func (s *Store) refreshFilterUnisetHID(ctx context.Context, hashes []int64) erro
 
 	unisetHIDs := make([]uint32, 0, len(names))
 	for _, name := range names {
-		unisetHIDs = append(unisetHIDs, murmur.MurmurHash2([]byte(name), 0))
+		unisetHIDs = append(unisetHIDs, s.hasher.Hash32(name))
 	}
 
-	if err := s.conn.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE IF NOT EXISTS %s (uniset_hid UInt32)", filterTable)); err != nil {
+	if err := s.conn.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE IF NOT EXISTS %s (uniset_hid UInt32)", s.filterTable)); err != nil {
 		return fmt.Errorf("clickhouse: create filter table: %w", err)
 	}
-	if err := s.conn.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", filterTable)); err != nil {
+	if err := s.conn.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", s.filterTable)); err != nil {
 		return fmt.Errorf("clickhouse: truncate filter table: %w", err)
 	}
-	batch, err := s.conn.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s (uniset_hid)", filterTable))
+	batch, err := s.conn.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s (uniset_hid)", s.filterTable))
 	if err != nil {
 		return fmt.Errorf("clickhouse: prepare filter batch: %w", err)
 	}
@@ -472,13 +977,13 @@ func (s *Store) refreshFilterUnisetHID(ctx context.Context, hashes []int64) erro
 
 // refreshFilterNameHID заполняет фильтр по name_hid (Int64).
 func (s *Store) refreshFilterNameHID(ctx context.Context, hashes []int64) error {
-	if err := s.conn.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE IF NOT EXISTS %s (name_hid Int64)", filterTable)); err != nil {
+	if err := s.conn.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE IF NOT EXISTS %s (name_hid Int64)", s.filterTable)); err != nil {
 		return fmt.Errorf("clickhouse: create filter table: %w", err)
 	}
-	if err := s.conn.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", filterTable)); err != nil {
+	if err := s.conn.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", s.filterTable)); err != nil {
 		return fmt.Errorf("clickhouse: truncate filter table: %w", err)
 	}
-	batch, err := s.conn.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s (name_hid)", filterTable))
+	batch, err := s.conn.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s (name_hid)", s.filterTable))
 	if err != nil {
 		return fmt.Errorf("clickhouse: prepare filter batch: %w", err)
 	}
@@ -501,13 +1006,13 @@ func (s *Store) refreshFilterName(ctx context.Context, hashes []int64) error {
 		return err
 	}
 
-	if err := s.conn.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE IF NOT EXISTS %s (name String)", filterTable)); err != nil {
+	if err := s.conn.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE IF NOT EXISTS %s (name String)", s.filterTable)); err != nil {
 		return fmt.Errorf("clickhouse: create filter table: %w", err)
 	}
-	if err := s.conn.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", filterTable)); err != nil {
+	if err := s.conn.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", s.filterTable)); err != nil {
 		return fmt.Errorf("clickhouse: truncate filter table: %w", err)
 	}
-	batch, err := s.conn.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s (name)", filterTable))
+	batch, err := s.conn.PrepareBatch(ctx, fmt.Sprintf("INSERT INTO %s (name)", s.filterTable))
 	if err != nil {
 		return fmt.Errorf("clickhouse: prepare filter batch: %w", err)
 	}
@@ -531,31 +1036,32 @@ func (s *Store) ensureFilterTable(ctx context.Context) error {
 	case hashModeUnisetHID:
 		colDef = "uniset_hid UInt32"
 	}
-	if err := s.conn.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE IF NOT EXISTS %s (%s)", filterTable, colDef)); err != nil {
+	if err := s.conn.Exec(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE IF NOT EXISTS %s (%s)", s.filterTable, colDef)); err != nil {
 		return fmt.Errorf("clickhouse: create temp filter table: %w", err)
 	}
-	if err := s.conn.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", filterTable)); err != nil {
+	if err := s.conn.Exec(ctx, fmt.Sprintf("TRUNCATE TABLE %s", s.filterTable)); err != nil {
 		return fmt.Errorf("clickhouse: truncate temp filter table: %w", err)
 	}
 	return nil
 }
 
 // SQL для режима uniset_hid (UInt32, MurmurHash2)
-// Возвращаем uniset_hid и name для конвертации обратно в CityHash64
+// Возвращаем uniset_hid и name для конвертации обратно в hash
 const warmupSQLUnisetHID = `
 SELECT
     uniset_hid,
     name,
     argMax(timestamp, timestamp) AS ts,
-    argMax(value, timestamp) AS value
+    argMax(value, ` + warmupOrder + `) AS value,
+    argMax(toUInt8(0), ` + warmupOrder + `) AS undefined
 FROM %s
 WHERE uniset_hid IN (SELECT uniset_hid FROM %s)
-  AND timestamp <= @from
+  AND timestamp <= @from%s
 GROUP BY uniset_hid, name;
 `
 
 const streamSQLUnisetHID = `
-SELECT uniset_hid, name, timestamp, value
+SELECT uniset_hid, name, timestamp, value, toUInt8(0) AS undefined
 FROM %s
 WHERE uniset_hid IN (SELECT uniset_hid FROM %s)
   AND timestamp >= @from
@@ -568,15 +1074,16 @@ const warmupSQLNameHID = `
 SELECT
     name_hid,
     argMax(timestamp, timestamp) AS ts,
-    argMax(value, timestamp) AS value
+    argMax(value, ` + warmupOrder + `) AS value,
+    argMax(toUInt8(0), ` + warmupOrder + `) AS undefined
 FROM %s
 WHERE name_hid IN (SELECT name_hid FROM %s)
-  AND timestamp <= @from
+  AND timestamp <= @from%s
 GROUP BY name_hid;
 `
 
 const streamSQLNameHID = `
-SELECT name_hid, timestamp, value
+SELECT name_hid, timestamp, value, toUInt8(0) AS undefined
 FROM %s
 WHERE name_hid IN (SELECT name_hid FROM %s)
   AND timestamp >= @from
@@ -589,15 +1096,16 @@ const warmupSQLName = `
 SELECT
     name,
     argMax(timestamp, timestamp) AS ts,
-    argMax(value, timestamp) AS value
+    argMax(value, ` + warmupOrder + `) AS value,
+    argMax(toUInt8(0), ` + warmupOrder + `) AS undefined
 FROM %s
 WHERE name IN (SELECT name FROM %s)
-  AND timestamp <= @from
+  AND timestamp <= @from%s
 GROUP BY name;
 `
 
 const streamSQLName = `
-SELECT name, timestamp, value
+SELECT name, timestamp, value, toUInt8(0) AS undefined
 FROM %s
 WHERE name IN (SELECT name FROM %s)
   AND timestamp >= @from
@@ -605,6 +1113,62 @@ WHERE name IN (SELECT name FROM %s)
 ORDER BY timestamp, name;
 `
 
+// undefinedPlaceholder — выражение признака undefined в шаблонах запросов по умолчанию.
+const undefinedPlaceholder = "toUInt8(0)"
+
+// warmupOrder — ключ argMax в warmup: последнее время, среди дублей момента — определённое
+// значение, из определённых — большее (как storage.Outranks при применении потока).
+const warmupOrder = "(timestamp, " + undefinedPlaceholder + " = 0, value)"
+
+// Чтение колонки значения в шаблонах запросов по умолчанию: агрегат warmup и выборка stream/events.
+const (
+	valueArgMax = "argMax(value, "
+	valueSelect = ", value, " + undefinedPlaceholder
+)
+
+// withColumns подставляет в запрос колонку значения и выражение колонки undefined, если они заданы.
+func (s *Store) withColumns(query string) string {
+	if s.valueColumn != "" && s.valueColumn != storage.ValueDefault {
+		query = strings.NewReplacer(
+			valueArgMax, "argMax("+s.valueColumn+", ",
+			warmupOrder, "(timestamp, "+undefinedPlaceholder+" = 0, "+s.valueColumn+")",
+			valueSelect, ", "+s.valueColumn+" AS value, "+undefinedPlaceholder,
+		).Replace(query)
+	}
+	if s.undefined == "" {
+		return query
+	}
+	return strings.ReplaceAll(query, undefinedPlaceholder, s.undefined)
+}
+
+// SQL для сырой истории одного датчика (колонка ключа подставляется по режиму).
+const eventsSQL = `
+SELECT timestamp, value, toUInt8(0) AS undefined
+FROM %s
+WHERE %s = @key
+  AND timestamp >= @from
+  AND timestamp <= @to
+ORDER BY timestamp`
+
+// changesSQL — eventsSQL без повторов: первая запись окна и записи, значение или undefined
+// которых отличается от предыдущей.
+const changesSQL = `
+SELECT timestamp,
+       value,
+       undefined
+FROM (
+	SELECT timestamp,
+	       value,
+	       undefined,
+	       row_number() OVER w AS rn,
+	       lagInFrame(value) OVER w AS prev_value,
+	       lagInFrame(undefined) OVER w AS prev_undefined
+	FROM (` + eventsSQL + `)
+	WINDOW w AS (ORDER BY timestamp ROWS BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW)
+)
+WHERE rn = 1 OR value != prev_value OR undefined != prev_undefined
+ORDER BY timestamp`
+
 // IsSource возвращает true, если DSN указывает на ClickHouse.
 // Поддерживается только native протокол (порт 9000):
 // - clickhouse://host:9000/db
@@ -621,6 +1185,78 @@ func IsSource(dsn string) bool {
 		strings.HasPrefix(lower, "ch://")
 }
 
+// applyOptions накладывает параметры из Config поверх опций, разобранных из DSN.
+func applyOptions(opts *ch.Options, cfg Config) error {
+	// Временные таблицы работают в пределах соединения, поэтому держим единичный коннект.
+	opts.MaxOpenConns = 1
+	opts.MaxIdleConns = 1
+
+	if cfg.DialTimeout < 0 {
+		return fmt.Errorf("clickhouse: dial timeout must be >= 0, got %s", cfg.DialTimeout)
+	}
+	if cfg.DialTimeout > 0 {
+		opts.DialTimeout = cfg.DialTimeout
+	}
+
+	if cfg.Compression != "" {
+		method, err := parseCompression(cfg.Compression)
+		if err != nil {
+			return err
+		}
+		if opts.Compression == nil {
+			opts.Compression = &ch.Compression{}
+		}
+		opts.Compression.Method = method
+	}
+
+	if len(cfg.Settings) > 0 {
+		if opts.Settings == nil {
+			opts.Settings = ch.Settings{}
+		}
+		for k, v := range cfg.Settings {
+			opts.Settings[k] = v
+		}
+	}
+	return nil
+}
+
+func parseCompression(name string) (ch.CompressionMethod, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "none":
+		return ch.CompressionNone, nil
+	case "lz4":
+		return ch.CompressionLZ4, nil
+	case "zstd":
+		return ch.CompressionZSTD, nil
+	case "gzip":
+		return ch.CompressionGZIP, nil
+	default:
+		return 0, fmt.Errorf("clickhouse: unknown compression %q (expected none|lz4|zstd|gzip)", name)
+	}
+}
+
+// ParseSettings разбирает строку вида "key=value,key2=value2" в набор настроек сервера.
+func ParseSettings(raw string) (map[string]any, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	out := make(map[string]any)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("clickhouse: invalid setting %q (expected key=value)", part)
+		}
+		out[key] = strings.TrimSpace(value)
+	}
+	return out, nil
+}
+
 // normalizeDSN преобразует пользовательские схемы в формат, понятный драйверу:
 // - ch:// -> clickhouse:// (native protocol, port 9000)
 func normalizeDSN(dsn string) string {
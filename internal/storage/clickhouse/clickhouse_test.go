@@ -3,9 +3,13 @@ package clickhouse
 import (
 	"context"
 	"os"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	ch "github.com/ClickHouse/clickhouse-go/v2"
+
 	"github.com/pv/uniset-timemachine-go/internal/storage"
 )
 
@@ -493,3 +497,298 @@ ORDER BY (timestamp, name)`
 		cleanStore.Close()
 	})
 }
+
+func TestApplyOptionsOverridesDSN(t *testing.T) {
+	opts, err := ch.ParseDSN("clickhouse://localhost:9000/db?dial_timeout=5s&compress=lz4&max_execution_time=10&readonly=1")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	opts.MaxOpenConns = 8
+	cfg := Config{
+		DialTimeout: 15 * time.Second,
+		Compression: "zstd",
+		Settings:    map[string]any{"max_execution_time": "120"},
+	}
+	if err := applyOptions(opts, cfg); err != nil {
+		t.Fatalf("applyOptions: %v", err)
+	}
+	if opts.MaxOpenConns != 1 || opts.MaxIdleConns != 1 {
+		t.Fatalf("pool must stay single-connection, got open=%d idle=%d", opts.MaxOpenConns, opts.MaxIdleConns)
+	}
+	if opts.DialTimeout != 15*time.Second {
+		t.Fatalf("DialTimeout = %s, want 15s", opts.DialTimeout)
+	}
+	if opts.Compression == nil || opts.Compression.Method != ch.CompressionZSTD {
+		t.Fatalf("Compression = %+v, want zstd", opts.Compression)
+	}
+	if got := opts.Settings["max_execution_time"]; got != "120" {
+		t.Fatalf("max_execution_time = %v, want 120", got)
+	}
+	if _, ok := opts.Settings["readonly"]; !ok {
+		t.Fatalf("DSN setting readonly was lost: %+v", opts.Settings)
+	}
+}
+
+func TestApplyOptionsKeepsDSNDefaults(t *testing.T) {
+	opts, err := ch.ParseDSN("clickhouse://localhost:9000/db?dial_timeout=5s&compress=lz4")
+	if err != nil {
+		t.Fatalf("ParseDSN: %v", err)
+	}
+	if err := applyOptions(opts, Config{}); err != nil {
+		t.Fatalf("applyOptions: %v", err)
+	}
+	if opts.DialTimeout != 5*time.Second {
+		t.Fatalf("DialTimeout = %s, want 5s from DSN", opts.DialTimeout)
+	}
+	if opts.Compression == nil || opts.Compression.Method != ch.CompressionLZ4 {
+		t.Fatalf("Compression = %+v, want lz4 from DSN", opts.Compression)
+	}
+}
+
+func TestApplyOptionsInvalidCompression(t *testing.T) {
+	opts := &ch.Options{}
+	if err := applyOptions(opts, Config{Compression: "brotli"}); err == nil {
+		t.Fatalf("expected error for unknown compression")
+	}
+}
+
+func TestParseSettings(t *testing.T) {
+	got, err := ParseSettings(" max_execution_time=60, max_threads = 4 ,")
+	if err != nil {
+		t.Fatalf("ParseSettings: %v", err)
+	}
+	if len(got) != 2 || got["max_execution_time"] != "60" || got["max_threads"] != "4" {
+		t.Fatalf("unexpected settings: %+v", got)
+	}
+	if _, err := ParseSettings("broken"); err == nil {
+		t.Fatalf("expected error for malformed setting")
+	}
+	if got, err := ParseSettings(""); err != nil || got != nil {
+		t.Fatalf("empty input: got %+v, %v", got, err)
+	}
+}
+
+func TestValueKindAndParsing(t *testing.T) {
+	cases := map[string]valueKind{
+		"Float64":                          valueFloat,
+		"Int32":                            valueFloat,
+		"Nullable(Float64)":                valueNullableFloat,
+		"String":                           valueString,
+		"LowCardinality(String)":           valueString,
+		"Enum8('off' = 0, 'on' = 1)":       valueString,
+		"Nullable(String)":                 valueNullableString,
+		"LowCardinality(Nullable(String))": valueNullableString,
+	}
+	for typ, want := range cases {
+		if got := valueKindOf(typ); got != want {
+			t.Fatalf("valueKindOf(%q) = %v, want %v", typ, got, want)
+		}
+	}
+
+	s := &Store{table: "db.t", valueKind: valueNullableFloat}
+	d := s.newValueDest()
+	if _, ok := s.value(d); ok {
+		t.Fatalf("NULL must be reported as no value")
+	}
+	v := 1.5
+	d.nnum = &v
+	if got, ok := s.value(d); !ok || got != 1.5 {
+		t.Fatalf("nullable value = %v,%v", got, ok)
+	}
+
+	s.valueKind = valueString
+	d = s.newValueDest()
+	for raw, want := range map[string]float64{" 42.5 ": 42.5, "true": 1, "false": 0} {
+		d.str = raw
+		if got, ok := s.value(d); !ok || got != want {
+			t.Fatalf("parse %q = %v,%v, want %v", raw, got, ok, want)
+		}
+	}
+	d.str = "ALARM"
+	if _, ok := s.value(d); ok {
+		t.Fatalf("non-numeric string must be skipped")
+	}
+}
+
+func TestChooseHashMode(t *testing.T) {
+	columns := func(names ...string) func(string) bool {
+		return func(column string) bool { return slices.Contains(names, column) }
+	}
+	cases := []struct {
+		forced  string
+		has     func(string) bool
+		want    hashMode
+		wantErr bool
+	}{
+		{"", columns("name", "name_hid", "uniset_hid"), hashModeUnisetHID, false},
+		{"auto", columns("name", "name_hid"), hashModeNameHID, false},
+		{"", columns("name"), hashModeName, false},
+		{"name", columns("name", "uniset_hid"), hashModeName, false},
+		{"name_hid", columns("name", "name_hid", "uniset_hid"), hashModeNameHID, false},
+		{"uniset_hid", columns("name", "name_hid"), 0, true},
+		{"cityhash", columns("name"), 0, true},
+	}
+	for _, tc := range cases {
+		got, err := chooseHashMode(tc.forced, tc.has)
+		if (err != nil) != tc.wantErr || (!tc.wantErr && got != tc.want) {
+			t.Fatalf("chooseHashMode(%q) = %s, %v; want %s (error %v)", tc.forced, got, err, tc.want, tc.wantErr)
+		}
+	}
+	if (&Store{mode: hashModeNameHID}).HashMode() != "name_hid" {
+		t.Fatalf("HashMode must report the mode name")
+	}
+}
+
+func TestWithColumns(t *testing.T) {
+	templates := []string{warmupSQLUnisetHID, warmupSQLNameHID, warmupSQLName, streamSQLUnisetHID, streamSQLNameHID, streamSQLName, eventsSQL, changesSQL}
+	def := &Store{valueColumn: storage.ValueDefault}
+	s := &Store{valueColumn: "raw", undefined: "toUInt8(ifNull(undef, 0) != 0)"}
+	for _, query := range templates {
+		if got := def.withColumns(query); got != query {
+			t.Fatalf("default columns must keep query as is:\n%s", got)
+		}
+		got := s.withColumns(query)
+		if strings.Contains(got, valueArgMax) || strings.Contains(got, ", value, ") || !strings.Contains(got, "raw") || !strings.Contains(got, s.undefined) {
+			t.Fatalf("columns not substituted:\n%s", got)
+		}
+	}
+}
+
+func TestStoreNullableValue_Clickhouse(t *testing.T) {
+	dsn := os.Getenv("TM_CLICKHOUSE_DSN")
+	if dsn == "" {
+		t.Skip("TM_CLICKHOUSE_DSN is not set; skipping ClickHouse integration test")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	resolver := &fakeResolver{
+		hashToName: map[int64]string{60: "Nullable_A"},
+		nameToHash: map[string]int64{"Nullable_A": 60},
+	}
+	setup, err := New(ctx, Config{DSN: dsn, Resolver: resolver})
+	if err != nil {
+		t.Fatalf("clickhouse.New for setup: %v", err)
+	}
+	createTable := `
+CREATE TABLE IF NOT EXISTS tm_test_nullable (
+    timestamp DateTime64(9,'UTC'),
+    value Nullable(Float64),
+    name LowCardinality(String)
+) ENGINE = MergeTree
+ORDER BY (timestamp, name)`
+	if err := setup.conn.Exec(ctx, createTable); err != nil {
+		setup.Close()
+		t.Fatalf("create nullable table: %v", err)
+	}
+	t.Cleanup(func() {
+		ctxCleanup, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = setup.conn.Exec(ctxCleanup, "DROP TABLE IF EXISTS tm_test_nullable")
+		setup.Close()
+	})
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	ten, twelve := 10.0, 12.0
+	batch, err := setup.conn.PrepareBatch(ctx, "INSERT INTO tm_test_nullable (timestamp, value, name)")
+	if err != nil {
+		t.Fatalf("prepare batch: %v", err)
+	}
+	for i, v := range []*float64{&ten, nil, &twelve} {
+		if err := batch.Append(start.Add(time.Duration(i)*time.Second), v, "Nullable_A"); err != nil {
+			t.Fatalf("append row: %v", err)
+		}
+	}
+	if err := batch.Send(); err != nil {
+		t.Fatalf("send batch: %v", err)
+	}
+
+	store, err := New(ctx, Config{DSN: dsn, Resolver: resolver, Table: "tm_test_nullable"})
+	if err != nil {
+		t.Fatalf("clickhouse.New error: %v", err)
+	}
+	defer store.Close()
+	if store.valueKind != valueNullableFloat {
+		t.Fatalf("valueKind = %v, want Nullable(Float64)", store.valueKind)
+	}
+
+	dataCh, errCh := store.Stream(ctx, storage.StreamRequest{
+		Sensors: []int64{60},
+		From:    start,
+		To:      start.Add(5 * time.Second),
+		Window:  time.Minute,
+	})
+	var values []float64
+	for b := range dataCh {
+		for _, ev := range b {
+			values = append(values, ev.Value)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	if len(values) != 2 || values[0] != 10 || values[1] != 12 {
+		t.Fatalf("streamed values = %v, want [10 12] (NULL skipped)", values)
+	}
+
+	events, err := store.Warmup(ctx, []int64{60}, start.Add(1500*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Warmup error: %v", err)
+	}
+	if len(events) != 1 || events[0].Value != 10 {
+		t.Fatalf("warmup = %+v, want last non-NULL value 10", events)
+	}
+}
+
+func TestSourceTimezoneConversion(t *testing.T) {
+	msk, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+	instant := time.Date(2024, 6, 1, 9, 0, 0, 0, time.UTC) // 12:00 по Москве
+
+	// Сервер в UTC, данные записаны как московское wall-clock без зоны.
+	s := &Store{serverTZ: time.UTC, sourceTZ: msk}
+	col := s.toColumn(instant)
+	if want := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC); !col.Equal(want) {
+		t.Fatalf("toColumn = %s, want %s", col, want)
+	}
+	if back := s.fromColumn(col); !back.Equal(instant) || back.Location() != time.UTC {
+		t.Fatalf("fromColumn = %s, want %s", back, instant)
+	}
+
+	// Без source значения проходят как есть.
+	plain := &Store{serverTZ: time.UTC}
+	if got := plain.fromColumn(col); !got.Equal(col) {
+		t.Fatalf("fromColumn without source = %s, want %s", got, col)
+	}
+	if got := s.fromColumn(time.Time{}); !got.IsZero() {
+		t.Fatalf("zero time must stay zero, got %s", got)
+	}
+
+	for typ, want := range map[string]bool{
+		"DateTime":                            false,
+		"DateTime64(3)":                       false,
+		"DateTime('Europe/Moscow')":           true,
+		"DateTime64(6, 'Asia/Yekaterinburg')": true,
+	} {
+		if got := hasExplicitZone(typ); got != want {
+			t.Fatalf("hasExplicitZone(%q) = %v, want %v", typ, got, want)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := map[error]bool{
+		&ch.Exception{Code: 210}: true,  // NETWORK_ERROR
+		&ch.Exception{Code: 209}: true,  // SOCKET_TIMEOUT
+		&ch.Exception{Code: 62}:  false, // SYNTAX_ERROR
+		&ch.Exception{Code: 516}: false, // AUTHENTICATION_FAILED
+		ch.ErrAcquireConnTimeout: true,
+		context.Canceled:         false,
+	}
+	for err, want := range cases {
+		if got := isRetryable(err); got != want {
+			t.Errorf("isRetryable(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,670 @@
+// Package csv читает историю из CSV-выгрузки (ts,name,value или ts,id,value).
+//
+// Файл читается один раз при открытии: события сортируются по времени и индексируются
+// по датчикам, дальше Warmup/Stream/Range работают бинарным поиском по памяти без повторного чтения.
+package csv
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	stdcsv "encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pv/uniset-timemachine-go/internal/storage"
+	"github.com/pv/uniset-timemachine-go/pkg/config"
+)
+
+const (
+	schemePrefix     = "csv:"
+	defaultWindowDur = time.Minute
+)
+
+// Header задаёт, есть ли в файле строка заголовка.
+type Header string
+
+const (
+	HeaderAuto Header = "auto" // заголовок, если в первой строке нет ни времени, ни числа
+	HeaderYes  Header = "yes"
+	HeaderNo   Header = "no"
+)
+
+// SensorType задаёт, что хранится в колонке датчика.
+type SensorType string
+
+const (
+	SensorAuto SensorType = "auto" // по имени колонки (*id*) или по первой строке данных
+	SensorID   SensorType = "id"   // ID из конфига, hash берётся из реестра
+	SensorName SensorType = "name" // имя датчика, hash = cityhash64(name)
+)
+
+// Columns описывает соответствие колонок CSV полям истории.
+// Колонка задаётся именем из заголовка или номером (с 0); пусто — по умолчанию.
+type Columns struct {
+	Timestamp  string
+	Sensor     string
+	Value      string
+	Undefined  string // колонка признака undefined (пусто — все значения определены)
+	SensorType SensorType
+}
+
+// Имена колонок, которые узнаются в заголовке без явного соответствия.
+var (
+	timestampNames = []string{"ts", "timestamp", "time", "date_time", "datetime"}
+	sensorNames    = []string{"name", "sensor", "sensor_name", "id", "sensor_id"}
+	valueNames     = []string{"value", "val"}
+)
+
+// Config задаёт источник и параметры чтения.
+type Config struct {
+	// Source — путь к файлу, допускается форма csv:data.csv?ts=...&sensor=...&value=...
+	// Файл с суффиксом .gz распаковывается на лету.
+	Source string
+	// Columns — соответствие колонок; пустые поля берутся из параметров Source или по заголовку.
+	Columns  Columns
+	Registry *config.SensorRegistry // реестр датчиков для конвертации configID → hash
+	// TimeZone — зона для времени без смещения; nil — UTC.
+	TimeZone *time.Location
+	// WarmupLookback ограничивает поиск значений для Warmup окном [from-lookback, from] (0 — без ограничения).
+	WarmupLookback time.Duration
+}
+
+// Source — разобранный источник: путь к файлу и параметры чтения.
+type Source struct {
+	Path      string
+	Columns   Columns
+	Header    Header
+	Delimiter rune
+}
+
+type Store struct {
+	path           string
+	events         []storage.SensorEvent // все события, по возрастанию времени
+	bySensor       map[int64][]int32     // индексы в events по датчику, по возрастанию времени
+	registry       *config.SensorRegistry
+	warmupLookback time.Duration
+}
+
+// New читает файл целиком и строит индекс по времени и датчикам.
+func New(ctx context.Context, cfg Config) (*Store, error) {
+	opts, err := ParseSource(cfg.Source)
+	if err != nil {
+		return nil, err
+	}
+	opts.Columns = mergeColumns(cfg.Columns, opts.Columns)
+	loc := cfg.TimeZone
+	if loc == nil {
+		loc = time.UTC
+	}
+	events, err := load(ctx, opts, cfg.Registry, loc)
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{
+		path:           opts.Path,
+		events:         events,
+		registry:       cfg.Registry,
+		warmupLookback: cfg.WarmupLookback,
+	}
+	store.buildIndex()
+	return store, nil
+}
+
+func (s *Store) Close() {}
+
+// Ping реализует storage.Pinger: файл загружен при открытии, проверяется только наличие данных.
+func (s *Store) Ping(context.Context) error {
+	if len(s.events) == 0 {
+		return fmt.Errorf("csv: %s has no events", s.path)
+	}
+	return nil
+}
+
+// buildIndex сортирует события по времени (стабильно — порядок строк с одинаковым временем
+// сохраняется) и раскладывает их индексы по датчикам.
+func (s *Store) buildIndex() {
+	sort.SliceStable(s.events, func(i, j int) bool {
+		return s.events[i].Timestamp.Before(s.events[j].Timestamp)
+	})
+	s.bySensor = make(map[int64][]int32)
+	for i, ev := range s.events {
+		s.bySensor[ev.SensorID] = append(s.bySensor[ev.SensorID], int32(i))
+	}
+}
+
+func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]storage.SensorEvent, error) {
+	result := make([]storage.SensorEvent, 0, len(sensors))
+	for _, id := range sensors {
+		idx := s.bySensor[id]
+		// первый индекс с временем после from; нужен предыдущий
+		pos := sort.Search(len(idx), func(i int) bool {
+			return s.events[idx[i]].Timestamp.After(from)
+		})
+		if pos == 0 {
+			continue
+		}
+		ev := s.events[idx[pos-1]]
+		// дубли последнего момента — выбор по storage.Outranks, как при применении потока
+		for i := pos - 2; i >= 0 && s.events[idx[i]].Timestamp.Equal(ev.Timestamp); i-- {
+			if dup := s.events[idx[i]]; storage.Outranks(dup, ev) {
+				ev = dup
+			}
+		}
+		if s.warmupLookback > 0 && ev.Timestamp.Before(from.Add(-s.warmupLookback)) {
+			continue
+		}
+		result = append(result, ev)
+	}
+	return result, ctx.Err()
+}
+
+func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan []storage.SensorEvent, <-chan error) {
+	dataCh := make(chan []storage.SensorEvent)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(dataCh)
+		defer close(errCh)
+		if len(req.Sensors) == 0 {
+			return
+		}
+		set := make(map[int64]struct{}, len(req.Sensors))
+		for _, id := range req.Sensors {
+			set[id] = struct{}{}
+		}
+		window := req.Window
+		if window <= 0 {
+			window = defaultWindowDur
+		}
+
+		i := s.searchFrom(req.From)
+		cursor := req.From
+		for cursor.Before(req.To) && i < len(s.events) {
+			next := cursor.Add(window)
+			if next.After(req.To) {
+				next = req.To
+			}
+			var chunk []storage.SensorEvent
+			for ; i < len(s.events) && s.events[i].Timestamp.Before(next); i++ {
+				if _, ok := set[s.events[i].SensorID]; ok {
+					chunk = append(chunk, s.events[i])
+				}
+			}
+			if len(chunk) > 0 {
+				select {
+				case <-ctx.Done():
+					errCh <- ctx.Err()
+					return
+				case dataCh <- chunk:
+				}
+			}
+			if !next.After(cursor) {
+				break
+			}
+			cursor = next
+			// пропуск пустых окон: следующее окно начинается с ближайшего события
+			if i < len(s.events) && s.events[i].Timestamp.After(cursor) {
+				cursor = s.events[i].Timestamp
+			}
+		}
+	}()
+
+	return dataCh, errCh
+}
+
+func (s *Store) Range(ctx context.Context, sensors []int64, from, to time.Time) (time.Time, time.Time, int64, error) {
+	minTs, maxTs, count, _ := s.rangeOf(sensors, from, to, false)
+	return minTs, maxTs, count, ctx.Err()
+}
+
+// RangeWithUnknown реализует UnknownAwareStorage: дополнительно считает датчики файла вне конфига.
+func (s *Store) RangeWithUnknown(ctx context.Context, sensors []int64, from, to time.Time) (time.Time, time.Time, int64, int64, error) {
+	minTs, maxTs, count, unknown := s.rangeOf(sensors, from, to, s.registry != nil)
+	return minTs, maxTs, count, unknown, ctx.Err()
+}
+
+// rangeOf считает границы и число датчиков с данными в [from, to] (нулевые границы — без ограничения);
+// withUnknown — дополнительно число датчиков файла с данными, не входящих в sensors.
+func (s *Store) rangeOf(sensors []int64, from, to time.Time, withUnknown bool) (time.Time, time.Time, int64, int64) {
+	var minTs, maxTs time.Time
+	var count, unknown int64
+	wanted := make(map[int64]struct{}, len(sensors))
+	for _, id := range sensors {
+		if _, dup := wanted[id]; dup {
+			continue
+		}
+		wanted[id] = struct{}{}
+		first, last, ok := s.sensorBounds(id, from, to)
+		if !ok {
+			continue
+		}
+		count++
+		if minTs.IsZero() || first.Before(minTs) {
+			minTs = first
+		}
+		if last.After(maxTs) {
+			maxTs = last
+		}
+	}
+	if withUnknown {
+		for id := range s.bySensor {
+			if _, ok := wanted[id]; ok {
+				continue
+			}
+			if _, _, ok := s.sensorBounds(id, from, to); ok {
+				unknown++
+			}
+		}
+	}
+	return minTs, maxTs, count, unknown
+}
+
+// sensorBounds возвращает время первого и последнего события датчика в [from, to].
+func (s *Store) sensorBounds(id int64, from, to time.Time) (time.Time, time.Time, bool) {
+	lo, hi := s.sensorSpan(id, from, to)
+	if lo >= hi {
+		return time.Time{}, time.Time{}, false
+	}
+	idx := s.bySensor[id]
+	return s.events[idx[lo]].Timestamp, s.events[idx[hi-1]].Timestamp, true
+}
+
+// sensorSpan — полуинтервал позиций в индексе датчика для событий в [from, to].
+func (s *Store) sensorSpan(id int64, from, to time.Time) (int, int) {
+	idx := s.bySensor[id]
+	lo := 0
+	if !from.IsZero() {
+		lo = sort.Search(len(idx), func(i int) bool { return !s.events[idx[i]].Timestamp.Before(from) })
+	}
+	hi := len(idx)
+	if !to.IsZero() {
+		hi = sort.Search(len(idx), func(i int) bool { return s.events[idx[i]].Timestamp.After(to) })
+	}
+	return lo, hi
+}
+
+// EventsFor реализует EventHistoryStorage: сырые записи одного датчика в окне [from, to].
+func (s *Store) EventsFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	lo, hi := s.sensorSpan(sensor, from, to)
+	if limit > 0 && hi-lo > limit {
+		hi = lo + limit
+	}
+	idx := s.bySensor[sensor]
+	events := make([]storage.SensorEvent, 0, max(hi-lo, 0))
+	for i := lo; i < hi; i++ {
+		events = append(events, s.events[idx[i]])
+	}
+	return events, ctx.Err()
+}
+
+// ChangesFor реализует ChangeHistoryStorage: только изменения значения одного датчика в окне [from, to].
+func (s *Store) ChangesFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	events, err := s.EventsFor(ctx, sensor, from, to, 0)
+	if err != nil {
+		return nil, err
+	}
+	events = storage.ChangesOnly(events)
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// searchFrom — индекс первого события не раньше from.
+func (s *Store) searchFrom(from time.Time) int {
+	return sort.Search(len(s.events), func(i int) bool { return !s.events[i].Timestamp.Before(from) })
+}
+
+// IsSource сообщает, что --db указывает на CSV-файл: csv:data.csv.
+func IsSource(src string) bool {
+	return strings.HasPrefix(strings.ToLower(src), schemePrefix)
+}
+
+// ParseSource разбирает csv:data.csv?ts=time&sensor=name&sensor_type=name&value=val&undefined=undef&header=yes&delimiter=semicolon
+// Незаданные колонки определяются по заголовку или берутся по порядку ts, sensor, value.
+func ParseSource(src string) (Source, error) {
+	raw := src
+	if IsSource(raw) {
+		raw = raw[len(schemePrefix):]
+		raw = strings.TrimPrefix(raw, "//")
+	}
+	path, query, _ := strings.Cut(raw, "?")
+	if path == "" {
+		return Source{}, fmt.Errorf("csv: file path is empty")
+	}
+	opts := Source{Path: path, Header: HeaderAuto, Delimiter: ','}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return Source{}, fmt.Errorf("csv: parse options %q: %w", query, err)
+	}
+	for key, values := range params {
+		value := values[len(values)-1]
+		switch key {
+		case "ts", "timestamp":
+			opts.Columns.Timestamp = value
+		case "sensor":
+			opts.Columns.Sensor = value
+		case "value":
+			opts.Columns.Value = value
+		case "undefined":
+			opts.Columns.Undefined = value
+		case "sensor_type":
+			switch t := SensorType(strings.ToLower(value)); t {
+			case SensorAuto, SensorID, SensorName:
+				opts.Columns.SensorType = t
+			default:
+				return Source{}, fmt.Errorf("csv: sensor_type must be auto, id or name, got %q", value)
+			}
+		case "header":
+			switch h := Header(strings.ToLower(value)); h {
+			case HeaderAuto, HeaderYes, HeaderNo:
+				opts.Header = h
+			default:
+				return Source{}, fmt.Errorf("csv: header must be auto, yes or no, got %q", value)
+			}
+		case "delimiter", "sep":
+			d, err := parseDelimiter(value)
+			if err != nil {
+				return Source{}, err
+			}
+			opts.Delimiter = d
+		default:
+			return Source{}, fmt.Errorf("csv: unknown option %q", key)
+		}
+	}
+	return opts, nil
+}
+
+func parseDelimiter(value string) (rune, error) {
+	switch strings.ToLower(value) {
+	case "tab", `\t`:
+		return '\t', nil
+	case "semicolon":
+		return ';', nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 || runes[0] == '"' || runes[0] == '\n' || runes[0] == '\r' {
+		return 0, fmt.Errorf("csv: delimiter must be a single character, got %q", value)
+	}
+	return runes[0], nil
+}
+
+// mergeColumns накладывает явно заданные в Config колонки поверх разобранных из Source.
+func mergeColumns(explicit, parsed Columns) Columns {
+	if explicit.Timestamp != "" {
+		parsed.Timestamp = explicit.Timestamp
+	}
+	if explicit.Sensor != "" {
+		parsed.Sensor = explicit.Sensor
+	}
+	if explicit.Value != "" {
+		parsed.Value = explicit.Value
+	}
+	if explicit.Undefined != "" {
+		parsed.Undefined = explicit.Undefined
+	}
+	if explicit.SensorType != "" {
+		parsed.SensorType = explicit.SensorType
+	}
+	return parsed
+}
+
+// layout — номера колонок после разбора заголовка.
+type layout struct {
+	ts, sensor, value, undefined int // undefined < 0 — колонки нет
+	byName                       bool
+}
+
+// load читает файл построчно и возвращает события в порядке строк.
+func load(ctx context.Context, opts Source, registry *config.SensorRegistry, loc *time.Location) ([]storage.SensorEvent, error) {
+	f, err := os.Open(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("csv: %w", err)
+	}
+	defer f.Close()
+	var in io.Reader = bufio.NewReaderSize(f, 1<<20)
+	if strings.HasSuffix(strings.ToLower(opts.Path), ".gz") {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, fmt.Errorf("csv: gzip %s: %w", opts.Path, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+	r := stdcsv.NewReader(in)
+	r.Comma = opts.Delimiter
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.ReuseRecord = true
+
+	first, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("csv: %s is empty", opts.Path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("csv: read %s: %w", opts.Path, err)
+	}
+	hasHeader := opts.Header == HeaderYes || (opts.Header == HeaderAuto && looksLikeHeader(first, loc))
+	var header []string
+	if hasHeader {
+		header = slices.Clone(first)
+	}
+
+	var lay *layout
+	var events []storage.SensorEvent
+	add := func(rec []string, line int) error {
+		if lay == nil {
+			l, err := resolveLayout(opts.Columns, header, rec)
+			if err != nil {
+				return err
+			}
+			if !l.byName && registry != nil && !registry.HasIDs() {
+				return fmt.Errorf("csv: config must have sensor IDs (idfromfile != 0) or use sensor_type=name")
+			}
+			lay = &l
+		}
+		ev, ok, err := parseRecord(rec, *lay, registry, loc)
+		if err != nil {
+			return fmt.Errorf("csv: %s line %d: %w", opts.Path, line, err)
+		}
+		if ok {
+			events = append(events, ev)
+		}
+		return nil
+	}
+	if !hasHeader {
+		if err := add(first, 1); err != nil {
+			return nil, err
+		}
+	}
+	for line := 2; ; line++ {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv: read %s: %w", opts.Path, err)
+		}
+		if line%100000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		if err := add(rec, line); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// looksLikeHeader — в строке нет ни одного поля, похожего на время или число.
+func looksLikeHeader(rec []string, loc *time.Location) bool {
+	for _, field := range rec {
+		if _, err := parseTimestamp(field, loc); err == nil {
+			return false
+		}
+		if _, err := strconv.ParseFloat(strings.TrimSpace(field), 64); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveLayout находит номера колонок по заголовку или явному соответствию;
+// sample — первая строка данных, по ней определяется тип датчика в режиме auto.
+func resolveLayout(cols Columns, header []string, sample []string) (layout, error) {
+	var l layout
+	var err error
+	if l.ts, err = columnIndex("ts", cols.Timestamp, header, timestampNames, 0); err != nil {
+		return layout{}, err
+	}
+	if l.sensor, err = columnIndex("sensor", cols.Sensor, header, sensorNames, 1); err != nil {
+		return layout{}, err
+	}
+	if l.value, err = columnIndex("value", cols.Value, header, valueNames, 2); err != nil {
+		return layout{}, err
+	}
+	l.undefined = -1
+	if cols.Undefined != "" {
+		if l.undefined, err = columnIndex("undefined", cols.Undefined, header, nil, -1); err != nil {
+			return layout{}, err
+		}
+	}
+
+	switch cols.SensorType {
+	case SensorName:
+		l.byName = true
+	case SensorID:
+		l.byName = false
+	default:
+		if header != nil && l.sensor < len(header) && strings.Contains(strings.ToLower(header[l.sensor]), "id") {
+			l.byName = false
+		} else if l.sensor < len(sample) {
+			_, err := strconv.ParseInt(strings.TrimSpace(sample[l.sensor]), 10, 64)
+			l.byName = err != nil
+		}
+	}
+	return l, nil
+}
+
+// columnIndex ищет колонку: явное имя из заголовка или номер; без явного — известные имена
+// из заголовка, а без заголовка — позиция fallback.
+func columnIndex(role, spec string, header, known []string, fallback int) (int, error) {
+	if spec != "" {
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), spec) {
+				return i, nil
+			}
+		}
+		if n, err := strconv.Atoi(spec); err == nil && n >= 0 {
+			return n, nil
+		}
+		return 0, fmt.Errorf("csv: %s column %q not found in header", role, spec)
+	}
+	if header == nil {
+		return fallback, nil
+	}
+	for _, want := range known {
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), want) {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("csv: %s column not found in header %v (set it with ?%s=)", role, header, role)
+}
+
+// parseRecord переводит строку в событие; false — пустое значение без признака undefined.
+func parseRecord(rec []string, l layout, registry *config.SensorRegistry, loc *time.Location) (storage.SensorEvent, bool, error) {
+	if need := max(l.ts, l.sensor, l.value, l.undefined); need >= len(rec) {
+		return storage.SensorEvent{}, false, fmt.Errorf("expected at least %d fields, got %d", need+1, len(rec))
+	}
+	ts, err := parseTimestamp(rec[l.ts], loc)
+	if err != nil {
+		return storage.SensorEvent{}, false, err
+	}
+	hash, err := sensorHash(strings.TrimSpace(rec[l.sensor]), l.byName, registry)
+	if err != nil {
+		return storage.SensorEvent{}, false, err
+	}
+	undefined := false
+	if l.undefined >= 0 {
+		undefined = parseFlag(rec[l.undefined])
+	}
+	raw := strings.TrimSpace(rec[l.value])
+	if raw == "" {
+		if !undefined {
+			return storage.SensorEvent{}, false, nil
+		}
+		return storage.SensorEvent{SensorID: hash, Timestamp: ts, Undefined: true}, true, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return storage.SensorEvent{}, false, fmt.Errorf("value %q: %w", raw, err)
+	}
+	return storage.SensorEvent{SensorID: hash, Timestamp: ts, Value: value, Undefined: undefined}, true, nil
+}
+
+// sensorHash переводит значение колонки датчика в hash так же, как остальные хранилища:
+// имя — hash алгоритмом реестра (по умолчанию cityhash64), ID — через реестр конфига.
+func sensorHash(raw string, byName bool, registry *config.SensorRegistry) (int64, error) {
+	if raw == "" {
+		return 0, fmt.Errorf("empty sensor")
+	}
+	if byName {
+		return registry.HashForName(raw), nil
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("sensor id %q: %w", raw, err)
+	}
+	if registry == nil {
+		return id, nil
+	}
+	if key, ok := registry.ByConfigID(id); ok {
+		return key.Hash, nil
+	}
+	return id, nil
+}
+
+func parseFlag(raw string) bool {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "0", "false", "f", "no":
+		return false
+	}
+	return true
+}
+
+// timeLayouts — поддерживаемые форматы времени; без смещения время берётся в зоне источника.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// parseTimestamp разбирает время в одном из timeLayouts или unix-секунды (с дробной частью).
+func parseTimestamp(raw string, loc *time.Location) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	for _, l := range timeLayouts {
+		if ts, err := time.ParseInLocation(l, raw, loc); err == nil {
+			return ts.UTC(), nil
+		}
+	}
+	if sec, err := strconv.ParseFloat(raw, 64); err == nil && sec > 0 && !strings.ContainsAny(raw, "eE") {
+		whole, frac := math.Modf(sec)
+		return time.Unix(int64(whole), int64(math.Round(frac*1e9))).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unsupported timestamp %q", raw)
+}
@@ -0,0 +1,182 @@
+package csv
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pv/uniset-timemachine-go/internal/storage"
+	"github.com/pv/uniset-timemachine-go/pkg/config"
+)
+
+func writeFile(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
+
+func TestParseSource(t *testing.T) {
+	src, err := ParseSource("csv:data.csv")
+	if err != nil || src.Path != "data.csv" || src.Header != HeaderAuto || src.Delimiter != ',' || src.Columns != (Columns{}) {
+		t.Fatalf("defaults: %+v %v", src, err)
+	}
+	src, err = ParseSource("csv:///tmp/h.csv?ts=time&sensor=2&sensor_type=id&value=val&undefined=undef&header=no&delimiter=tab")
+	if err != nil {
+		t.Fatalf("mapping: %v", err)
+	}
+	want := Columns{Timestamp: "time", Sensor: "2", Value: "val", Undefined: "undef", SensorType: SensorID}
+	if src.Path != "/tmp/h.csv" || src.Columns != want || src.Header != HeaderNo || src.Delimiter != '\t' {
+		t.Fatalf("mapping: %+v", src)
+	}
+	for _, bad := range []string{"csv:", "csv:a.csv?foo=1", "csv:a.csv?sensor_type=hash", "csv:a.csv?header=maybe", "csv:a.csv?delimiter=ab"} {
+		if _, err := ParseSource(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+	if !IsSource("CSV:data.csv") || IsSource("data.csv") {
+		t.Fatalf("IsSource mismatch")
+	}
+}
+
+func TestStoreByName(t *testing.T) {
+	path := writeFile(t, "h.csv", `ts,name,value
+2024-06-01T00:00:05Z,Pump1_S,5
+2024-06-01T00:00:01Z,Pump1_S,1
+2024-06-01 00:00:03,Level_AS,30.5
+2024-06-01T00:00:09Z,Pump1_S,9
+# comment
+2024-06-01T00:00:04Z,Other_S,
+`)
+	ctx := context.Background()
+	store, err := New(ctx, Config{Source: "csv:" + path})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	pump, level := config.HashForName("Pump1_S"), config.HashForName("Level_AS")
+	base := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	warm, err := store.Warmup(ctx, []int64{pump, level}, base.Add(4*time.Second))
+	if err != nil || len(warm) != 2 || warm[0].Value != 1 || warm[1].Value != 30.5 {
+		t.Fatalf("warmup: %+v %v", warm, err)
+	}
+
+	minTs, maxTs, count, err := store.Range(ctx, []int64{pump, level}, time.Time{}, time.Time{})
+	if err != nil || !minTs.Equal(base.Add(time.Second)) || !maxTs.Equal(base.Add(9*time.Second)) || count != 2 {
+		t.Fatalf("range: %v %v %d %v", minTs, maxTs, count, err)
+	}
+	if _, _, count, _ := store.Range(ctx, []int64{level}, base.Add(4*time.Second), time.Time{}); count != 0 {
+		t.Fatalf("range must respect from: %d", count)
+	}
+
+	dataCh, errCh := store.Stream(ctx, storage.StreamRequest{
+		Sensors: []int64{pump},
+		From:    base.Add(2 * time.Second),
+		To:      base.Add(time.Hour),
+		Window:  2 * time.Second,
+	})
+	var got []float64
+	for batch := range dataCh {
+		for _, ev := range batch {
+			got = append(got, ev.Value)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("stream: %v", err)
+	}
+	if len(got) != 2 || got[0] != 5 || got[1] != 9 {
+		t.Fatalf("stream values: %v", got)
+	}
+
+	events, err := store.EventsFor(ctx, pump, base, base.Add(5*time.Second), 0)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("events: %+v %v", events, err)
+	}
+	changes, err := store.ChangesFor(ctx, pump, base, base.Add(time.Hour), 1)
+	if err != nil || len(changes) != 1 || changes[0] != events[0] {
+		t.Fatalf("changes: %+v %v", changes, err)
+	}
+}
+
+func TestStoreByIDWithoutHeader(t *testing.T) {
+	id := int64(101)
+	registry := config.NewSensorRegistry()
+	if err := registry.Add(config.NewSensorKey("Pump1_S", &id)); err != nil {
+		t.Fatalf("registry add: %v", err)
+	}
+	var buf []byte
+	path := filepath.Join(t.TempDir(), "h.csv.gz")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	buf = append(buf, "1717200001;101;1;0\n1717200002.5;101;0;1\n1717200003;7;3;0\n"...)
+	if _, err := gz.Write(buf); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	gz.Close()
+	f.Close()
+
+	ctx := context.Background()
+	store, err := New(ctx, Config{Source: "csv:" + path + "?delimiter=semicolon&undefined=3", Registry: registry})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	hash := config.HashForName("Pump1_S")
+	events, err := store.EventsFor(ctx, hash, time.Time{}, time.Time{}, 0)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("events: %+v %v", events, err)
+	}
+	if events[1].Timestamp != time.Unix(1717200002, 5e8).UTC() || !events[1].Undefined {
+		t.Fatalf("fractional unix time and undefined flag: %+v", events[1])
+	}
+	_, _, count, unknown, err := store.RangeWithUnknown(ctx, []int64{hash}, time.Time{}, time.Time{})
+	if err != nil || count != 1 || unknown != 1 {
+		t.Fatalf("range with unknown: %d %d %v", count, unknown, err)
+	}
+}
+
+func TestStoreWarmupSameTimestampDuplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dups.csv")
+	data := "1717200001;101;3;0\n1717200001;101;7;0\n1717200001;101;9;1\n1717200001;101;5;0\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	ctx := context.Background()
+	store, err := New(ctx, Config{Source: "csv:" + path + "?delimiter=semicolon&undefined=3"})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	// Не последняя строка файла, а правило дублей: определённое значение, из определённых — большее.
+	events, err := store.Warmup(ctx, []int64{101}, time.Unix(1717200002, 0))
+	if err != nil || len(events) != 1 || events[0].Value != 7 || events[0].Undefined {
+		t.Fatalf("warmup: %+v %v", events, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	ctx := context.Background()
+	cases := map[string]string{
+		"empty":      "",
+		"bad value":  "2024-06-01T00:00:00Z,A,x\n",
+		"bad ts":     "yesterday,A,1\n",
+		"no column":  "when,who,what\n2024-06-01T00:00:00Z,A,1\n",
+		"short line": "2024-06-01T00:00:00Z,A\n",
+	}
+	for name, body := range cases {
+		path := writeFile(t, "h.csv", body)
+		if _, err := New(ctx, Config{Source: "csv:" + path}); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+	path := writeFile(t, "h.csv", "when,who,what\n2024-06-01T00:00:00Z,A,1\n")
+	if _, err := New(ctx, Config{Source: "csv:" + path + "?ts=when&sensor=who&value=what"}); err != nil {
+		t.Fatalf("explicit mapping: %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build duckdb
+
+package duckdb
+
+// Драйвер DuckDB требует cgo, поэтому подключается только при сборке с -tags duckdb:
+//
+//	go get github.com/marcboeker/go-duckdb
+//	go build -tags duckdb ./cmd/timemachine
+import _ "github.com/marcboeker/go-duckdb"
@@ -0,0 +1,568 @@
+// Package duckdb читает историю из Parquet-файлов (архивов) через DuckDB.
+//
+// Хранилище работает через database/sql с драйвером "duckdb". Драйвер подключается
+// сборкой с тегом duckdb (см. driver.go): он требует cgo и в обычную сборку не входит.
+package duckdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pv/uniset-timemachine-go/internal/storage"
+	"github.com/pv/uniset-timemachine-go/pkg/config"
+)
+
+// driverName — имя драйвера database/sql, под которым регистрируется DuckDB.
+const driverName = "duckdb"
+
+// ErrNoDriver — бинарник собран без тега duckdb, и драйвер DuckDB не зарегистрирован.
+var ErrNoDriver = errors.New("duckdb: built without duckdb tag (rebuild with -tags duckdb)")
+
+// Available сообщает, встроен ли драйвер DuckDB (сборка с -tags duckdb).
+func Available() bool {
+	return slices.Contains(sql.Drivers(), driverName)
+}
+
+const (
+	schemePrefix     = "duckdb:"
+	defaultWindowDur = time.Minute
+)
+
+// Columns описывает соответствие колонок Parquet полям истории.
+type Columns struct {
+	Sensor    string // колонка датчика: ID из конфига или имя (см. SensorByName)
+	Timestamp string // колонка времени (TIMESTAMP/TIMESTAMPTZ)
+	Value     string // колонка значения (приводится к DOUBLE)
+	Undefined string // колонка признака undefined (пусто — все значения определены)
+	// SensorByName — в колонке Sensor хранится имя датчика, hash считается как cityhash64(name).
+	SensorByName bool
+}
+
+// DefaultColumns соответствуют выгрузке main_history: sensor_id, timestamp, value.
+var DefaultColumns = Columns{Sensor: "sensor_id", Timestamp: "timestamp", Value: "value"}
+
+// Config задаёт источник и параметры чтения.
+type Config struct {
+	// Source — путь или glob Parquet-файлов, допускается форма duckdb:/path/*.parquet?sensor=...
+	Source string
+	// Columns — соответствие колонок; пустые поля берутся из параметров Source или DefaultColumns.
+	Columns  Columns
+	Registry *config.SensorRegistry // реестр датчиков для конвертации hash↔configID/name
+	// WarmupLookback ограничивает поиск значений для Warmup окном [from-lookback, from] (0 — без ограничения).
+	WarmupLookback time.Duration
+	// WindowTargetRows — целевое число строк за окно при автоподборе (Window == 0); 0 — storage.DefaultWindowTargetRows.
+	WindowTargetRows int
+	// MaxRowsPerWindow — предел строк одного запроса окна Stream: окно, в котором строк больше,
+	// делится по времени на части (см. storage.ReadCapped); 0 — без ограничения.
+	MaxRowsPerWindow int
+}
+
+type Store struct {
+	db             *sql.DB
+	source         string // выражение read_parquet(...)
+	cols           Columns
+	registry       *config.SensorRegistry
+	warmupLookback time.Duration
+	undefinedExpr  string
+	windowTarget   int
+	maxRows        int // предел строк одного запроса окна (0 — без ограничения)
+}
+
+// New открывает DuckDB в памяти и проверяет, что Parquet-источник читается.
+func New(ctx context.Context, cfg Config) (*Store, error) {
+	glob, cols, err := ParseSource(cfg.Source)
+	if err != nil {
+		return nil, err
+	}
+	cols = mergeColumns(cfg.Columns, cols)
+	for _, c := range []string{cols.Sensor, cols.Timestamp, cols.Value} {
+		if !validIdent(c) {
+			return nil, fmt.Errorf("duckdb: invalid column name %q", c)
+		}
+	}
+	undefinedExpr, err := storage.UndefinedExpr(cols.Undefined, `(COALESCE("%s", 0) != 0)`)
+	if err != nil {
+		return nil, fmt.Errorf("duckdb: %w", err)
+	}
+	if cols.SensorByName && cfg.Registry == nil {
+		return nil, fmt.Errorf("duckdb: sensor names require a sensor config")
+	}
+	if !cols.SensorByName && cfg.Registry != nil && !cfg.Registry.HasIDs() {
+		return nil, fmt.Errorf("duckdb: config must have sensor IDs (idfromfile != 0) or use sensor_type=name")
+	}
+	if !Available() {
+		return nil, ErrNoDriver
+	}
+	db, err := sql.Open(driverName, "")
+	if err != nil {
+		return nil, fmt.Errorf("duckdb: open: %w", err)
+	}
+	store := &Store{
+		db:             db,
+		source:         fmt.Sprintf("read_parquet(%s)", quoteLiteral(glob)),
+		cols:           cols,
+		registry:       cfg.Registry,
+		warmupLookback: cfg.WarmupLookback,
+		undefinedExpr:  undefinedExpr,
+		windowTarget:   cfg.WindowTargetRows,
+		maxRows:        cfg.MaxRowsPerWindow,
+	}
+	if err := store.Ping(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *Store) Close() {
+	if s.db != nil {
+		s.db.Close()
+	}
+}
+
+// Ping реализует storage.Pinger: проверяет, что файлы источника доступны и читаются.
+func (s *Store) Ping(ctx context.Context) error {
+	var n int64
+	if err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM (SELECT 1 FROM %s LIMIT 1)`, s.source)).Scan(&n); err != nil {
+		return fmt.Errorf("duckdb: read %s: %w", s.source, err)
+	}
+	return nil
+}
+
+func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]storage.SensorEvent, error) {
+	if len(sensors) == 0 {
+		return nil, nil
+	}
+	query, args, err := s.warmupQuery(sensors, from)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("duckdb: warmup query: %w", err)
+	}
+	defer rows.Close()
+	events := make([]storage.SensorEvent, 0, len(sensors))
+	for rows.Next() {
+		ev, ok, err := s.scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("duckdb: warmup scan: %w", err)
+		}
+		if ok {
+			events = append(events, ev)
+		}
+	}
+	return events, rows.Err()
+}
+
+func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan []storage.SensorEvent, <-chan error) {
+	dataCh := make(chan []storage.SensorEvent)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(dataCh)
+		defer close(errCh)
+		if len(req.Sensors) == 0 {
+			return
+		}
+		filter, err := s.sensorFilter(req.Sensors)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		query := s.windowQuery(filter)
+
+		// Window == 0 — автоподбор окна под целевое число строк.
+		window := req.Window
+		var tuner *storage.WindowTuner
+		if window <= 0 {
+			tuner = storage.NewWindowTuner(defaultWindowDur, s.windowTarget)
+			window = tuner.Window()
+		}
+
+		cursor := req.From
+		for cursor.Before(req.To) {
+			next := cursor.Add(window)
+			if next.After(req.To) {
+				next = req.To
+			}
+			read := func(from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+				return s.queryWindow(ctx, query, from, to, limit)
+			}
+			rows, err := storage.ReadCapped(cursor, next, s.maxRows, read, func(chunk []storage.SensorEvent) error {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case dataCh <- chunk:
+					return nil
+				}
+			})
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if tuner != nil {
+				tuner.Observe(next.Sub(cursor), rows)
+				window = tuner.Window()
+			}
+			if !next.After(cursor) {
+				break
+			}
+			cursor = next
+		}
+	}()
+
+	return dataCh, errCh
+}
+
+// queryWindow читает записи датчиков фильтра query в окне [from, to); limit > 0 — не больше limit строк.
+func (s *Store) queryWindow(ctx context.Context, query string, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	rows, err := s.db.QueryContext(ctx, query, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("duckdb: window query: %w", err)
+	}
+	defer rows.Close()
+	chunk := make([]storage.SensorEvent, 0, 128)
+	for (limit <= 0 || len(chunk) < limit) && rows.Next() {
+		ev, ok, err := s.scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("duckdb: window scan: %w", err)
+		}
+		if ok {
+			chunk = append(chunk, ev)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("duckdb: rows err: %w", err)
+	}
+	return chunk, nil
+}
+
+func (s *Store) Range(ctx context.Context, sensors []int64, from, to time.Time) (time.Time, time.Time, int64, error) {
+	filter, err := s.sensorFilter(sensors)
+	if err != nil {
+		return time.Time{}, time.Time{}, 0, err
+	}
+	query, args := s.rangeQuery(filter, from, to)
+	var minTs, maxTs sql.NullTime
+	var count int64
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&minTs, &maxTs, &count); err != nil {
+		return time.Time{}, time.Time{}, 0, fmt.Errorf("duckdb: range query: %w", err)
+	}
+	if !minTs.Valid || !maxTs.Valid {
+		return time.Time{}, time.Time{}, 0, nil
+	}
+	return minTs.Time.UTC(), maxTs.Time.UTC(), count, nil
+}
+
+// RangeWithUnknown реализует UnknownAwareStorage: дополнительно считает датчики вне конфига.
+func (s *Store) RangeWithUnknown(ctx context.Context, sensors []int64, from, to time.Time) (time.Time, time.Time, int64, int64, error) {
+	minTs, maxTs, count, err := s.Range(ctx, sensors, from, to)
+	if err != nil || s.registry == nil {
+		return minTs, maxTs, count, 0, err
+	}
+	query, args := s.rangeQuery("TRUE", from, to)
+	var allMin, allMax sql.NullTime
+	var total int64
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&allMin, &allMax, &total); err != nil {
+		return minTs, maxTs, count, 0, fmt.Errorf("duckdb: unknown sensors count: %w", err)
+	}
+	return minTs, maxTs, count, max(total-count, 0), nil
+}
+
+// EventsFor реализует EventHistoryStorage: сырые записи одного датчика в окне [from, to].
+func (s *Store) EventsFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	filter, err := s.sensorFilter([]int64{sensor})
+	if err != nil {
+		return nil, err
+	}
+	query := s.selectEvents() + fmt.Sprintf(` WHERE %s AND %s >= ? AND %s <= ? ORDER BY %s`,
+		filter, quoteIdent(s.cols.Timestamp), quoteIdent(s.cols.Timestamp), quoteIdent(s.cols.Timestamp))
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("duckdb: events query: %w", err)
+	}
+	defer rows.Close()
+	var events []storage.SensorEvent
+	for rows.Next() {
+		ev, ok, err := s.scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("duckdb: events scan: %w", err)
+		}
+		if ok {
+			events = append(events, ev)
+		}
+	}
+	return events, rows.Err()
+}
+
+// ChangesFor реализует ChangeHistoryStorage: только изменения значения одного датчика в окне [from, to].
+func (s *Store) ChangesFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	filter, err := s.sensorFilter([]int64{sensor})
+	if err != nil {
+		return nil, err
+	}
+	ts := quoteIdent(s.cols.Timestamp)
+	query := `SELECT sensor, ts, value, undefined FROM (
+	SELECT *,
+	       ROW_NUMBER() OVER w AS rn,
+	       LAG(value) OVER w AS prev_value,
+	       LAG(undefined) OVER w AS prev_undefined
+	FROM (` + s.selectEvents() + fmt.Sprintf(` WHERE %s AND %s >= ? AND %s <= ?`, filter, ts, ts) + `)
+	WINDOW w AS (ORDER BY ts)
+)
+WHERE rn = 1 OR value IS DISTINCT FROM prev_value OR undefined IS DISTINCT FROM prev_undefined
+ORDER BY ts`
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("duckdb: changes query: %w", err)
+	}
+	defer rows.Close()
+	var events []storage.SensorEvent
+	for rows.Next() {
+		ev, ok, err := s.scanEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("duckdb: changes scan: %w", err)
+		}
+		if ok {
+			events = append(events, ev)
+		}
+	}
+	return events, rows.Err()
+}
+
+// selectEvents — общая часть запросов: датчик, время, значение, признак undefined.
+func (s *Store) selectEvents() string {
+	return fmt.Sprintf(`SELECT %s AS sensor, %s AS ts, CAST(%s AS DOUBLE) AS value, %s AS undefined FROM %s`,
+		quoteIdent(s.cols.Sensor), quoteIdent(s.cols.Timestamp), quoteIdent(s.cols.Value), s.undefined(), s.source)
+}
+
+func (s *Store) undefined() string {
+	if s.undefinedExpr == "" {
+		return "FALSE"
+	}
+	return s.undefinedExpr
+}
+
+// warmupQuery выбирает последнее значение каждого датчика не позже from.
+// Условие на колонку времени передаётся в read_parquet как фильтр (pushdown по статистике row group).
+func (s *Store) warmupQuery(sensors []int64, from time.Time) (string, []any, error) {
+	filter, err := s.sensorFilter(sensors)
+	if err != nil {
+		return "", nil, err
+	}
+	ts := quoteIdent(s.cols.Timestamp)
+	where := fmt.Sprintf("%s AND %s <= ?", filter, ts)
+	args := []any{from.UTC()}
+	if s.warmupLookback > 0 {
+		where += fmt.Sprintf(" AND %s >= ?", ts)
+		args = append(args, from.Add(-s.warmupLookback).UTC())
+	}
+	query := fmt.Sprintf(`SELECT sensor, ts, value, undefined FROM (%s WHERE %s) QUALIFY ROW_NUMBER() OVER (PARTITION BY sensor ORDER BY ts DESC, undefined, value DESC) = 1`,
+		s.selectEvents(), where)
+	return query, args, nil
+}
+
+// windowQuery выбирает события в окне [?, ?) по возрастанию времени.
+func (s *Store) windowQuery(filter string) string {
+	ts := quoteIdent(s.cols.Timestamp)
+	return fmt.Sprintf(`%s WHERE %s AND %s >= ? AND %s < ? ORDER BY ts, sensor`, s.selectEvents(), filter, ts, ts)
+}
+
+func (s *Store) rangeQuery(filter string, from, to time.Time) (string, []any) {
+	ts := quoteIdent(s.cols.Timestamp)
+	where := filter
+	var args []any
+	if !from.IsZero() {
+		where += fmt.Sprintf(" AND %s >= ?", ts)
+		args = append(args, from.UTC())
+	}
+	if !to.IsZero() {
+		where += fmt.Sprintf(" AND %s <= ?", ts)
+		args = append(args, to.UTC())
+	}
+	return fmt.Sprintf(`SELECT MIN(%s), MAX(%s), COUNT(DISTINCT %s) FROM %s WHERE %s`,
+		ts, ts, quoteIdent(s.cols.Sensor), s.source, where), args
+}
+
+// sensorFilter строит условие IN по датчикам: имена или ID из конфига, в зависимости от колонки.
+func (s *Store) sensorFilter(hashes []int64) (string, error) {
+	if len(hashes) == 0 {
+		return "FALSE", nil
+	}
+	items := make([]string, 0, len(hashes))
+	for _, h := range hashes {
+		if s.registry == nil {
+			items = append(items, strconv.FormatInt(h, 10)) // legacy: hash и есть ID
+			continue
+		}
+		key, ok := s.registry.ByHash(h)
+		if !ok {
+			return "", fmt.Errorf("duckdb: sensor hash %d not found in registry", h)
+		}
+		if s.cols.SensorByName {
+			items = append(items, quoteLiteral(key.Name))
+			continue
+		}
+		if key.ID == nil {
+			return "", fmt.Errorf("duckdb: sensor %q has no config ID", key.Name)
+		}
+		items = append(items, strconv.FormatInt(*key.ID, 10))
+	}
+	return fmt.Sprintf("%s IN (%s)", quoteIdent(s.cols.Sensor), strings.Join(items, ", ")), nil
+}
+
+// scanEvent читает строку (sensor, ts, value, undefined); false — значение NULL без признака undefined.
+func (s *Store) scanEvent(rows *sql.Rows) (storage.SensorEvent, bool, error) {
+	var sensor any
+	var ts time.Time
+	var value sql.NullFloat64
+	var undefined bool
+	if err := rows.Scan(&sensor, &ts, &value, &undefined); err != nil {
+		return storage.SensorEvent{}, false, err
+	}
+	hash, err := s.sensorHash(sensor)
+	if err != nil {
+		return storage.SensorEvent{}, false, err
+	}
+	if !value.Valid && !undefined {
+		return storage.SensorEvent{}, false, nil
+	}
+	return storage.SensorEvent{SensorID: hash, Timestamp: ts.UTC(), Value: value.Float64, Undefined: undefined}, true, nil
+}
+
+// sensorHash переводит значение колонки датчика в hash так же, как остальные хранилища:
+// имя — hash алгоритмом реестра (по умолчанию cityhash64), ID — через реестр конфига.
+func (s *Store) sensorHash(raw any) (int64, error) {
+	if s.cols.SensorByName {
+		switch v := raw.(type) {
+		case string:
+			return s.registry.HashForName(v), nil
+		case []byte:
+			return s.registry.HashForName(string(v)), nil
+		default:
+			return 0, fmt.Errorf("sensor name column has type %T", raw)
+		}
+	}
+	var id int64
+	switch v := raw.(type) {
+	case int64:
+		id = v
+	case int32:
+		id = int64(v)
+	case uint32:
+		id = int64(v)
+	case uint64:
+		id = int64(v)
+	case string:
+		parsed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("sensor id %q: %w", v, err)
+		}
+		id = parsed
+	default:
+		return 0, fmt.Errorf("sensor id column has type %T", raw)
+	}
+	if s.registry == nil {
+		return id, nil
+	}
+	if key, ok := s.registry.ByConfigID(id); ok {
+		return key.Hash, nil
+	}
+	return id, nil
+}
+
+// IsSource сообщает, что --db указывает на Parquet через DuckDB: duckdb:/path/*.parquet.
+func IsSource(src string) bool {
+	return strings.HasPrefix(strings.ToLower(src), schemePrefix)
+}
+
+// ParseSource разбирает duckdb:/path/*.parquet?sensor=name&sensor_type=name&ts=ts&value=value&undefined=undef
+// в путь (glob) и соответствие колонок. Незаданные колонки берутся из DefaultColumns.
+func ParseSource(src string) (string, Columns, error) {
+	raw := src
+	if IsSource(raw) {
+		raw = raw[len(schemePrefix):]
+		raw = strings.TrimPrefix(raw, "//")
+	}
+	path, query, _ := strings.Cut(raw, "?")
+	if path == "" {
+		return "", Columns{}, fmt.Errorf("duckdb: parquet path is empty")
+	}
+	cols := DefaultColumns
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", Columns{}, fmt.Errorf("duckdb: parse options %q: %w", query, err)
+	}
+	for key, values := range params {
+		value := values[len(values)-1]
+		switch key {
+		case "sensor":
+			cols.Sensor = value
+		case "ts", "timestamp":
+			cols.Timestamp = value
+		case "value":
+			cols.Value = value
+		case "undefined":
+			cols.Undefined = value
+		case "sensor_type":
+			switch strings.ToLower(value) {
+			case "name":
+				cols.SensorByName = true
+			case "id":
+				cols.SensorByName = false
+			default:
+				return "", Columns{}, fmt.Errorf("duckdb: sensor_type must be id or name, got %q", value)
+			}
+		default:
+			return "", Columns{}, fmt.Errorf("duckdb: unknown option %q", key)
+		}
+	}
+	return path, cols, nil
+}
+
+// mergeColumns накладывает явно заданные в Config колонки поверх разобранных из Source.
+func mergeColumns(explicit, parsed Columns) Columns {
+	if explicit.Sensor != "" {
+		parsed.Sensor = explicit.Sensor
+		parsed.SensorByName = explicit.SensorByName
+	}
+	if explicit.Timestamp != "" {
+		parsed.Timestamp = explicit.Timestamp
+	}
+	if explicit.Value != "" {
+		parsed.Value = explicit.Value
+	}
+	if explicit.Undefined != "" {
+		parsed.Undefined = explicit.Undefined
+	}
+	return parsed
+}
+
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func validIdent(name string) bool {
+	return identRe.MatchString(name)
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteLiteral(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,97 @@
+package duckdb
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pv/uniset-timemachine-go/pkg/config"
+)
+
+func TestParseSource(t *testing.T) {
+	path, cols, err := ParseSource("duckdb:/archive/2024-*.parquet")
+	if err != nil || path != "/archive/2024-*.parquet" || cols != DefaultColumns {
+		t.Fatalf("defaults: %q %+v %v", path, cols, err)
+	}
+	path, cols, err = ParseSource("duckdb:///archive/h.parquet?sensor=name&sensor_type=name&ts=ts&value=val&undefined=undef")
+	if err != nil {
+		t.Fatalf("mapping: %v", err)
+	}
+	want := Columns{Sensor: "name", Timestamp: "ts", Value: "val", Undefined: "undef", SensorByName: true}
+	if path != "/archive/h.parquet" || cols != want {
+		t.Fatalf("mapping: %q %+v", path, cols)
+	}
+	for _, bad := range []string{"duckdb:", "duckdb:/a.parquet?foo=1", "duckdb:/a.parquet?sensor_type=hash"} {
+		if _, _, err := ParseSource(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+	if !IsSource("DuckDB:/a.parquet") || IsSource("/a.parquet") {
+		t.Fatalf("IsSource mismatch")
+	}
+}
+
+func TestSensorMapping(t *testing.T) {
+	id := int64(101)
+	registry := config.NewSensorRegistry()
+	if err := registry.Add(config.NewSensorKey("Pump1_S", &id)); err != nil {
+		t.Fatalf("registry add: %v", err)
+	}
+	hash := config.HashForName("Pump1_S")
+
+	byID := &Store{cols: DefaultColumns, registry: registry, source: "read_parquet('/a.parquet')"}
+	if filter, err := byID.sensorFilter([]int64{hash}); err != nil || filter != `"sensor_id" IN (101)` {
+		t.Fatalf("id filter: %q %v", filter, err)
+	}
+	if got, err := byID.sensorHash(int64(101)); err != nil || got != hash {
+		t.Fatalf("id → hash: %d %v", got, err)
+	}
+
+	byName := &Store{cols: Columns{Sensor: "name", Timestamp: "ts", Value: "value", SensorByName: true}, registry: registry}
+	if filter, err := byName.sensorFilter([]int64{hash}); err != nil || filter != `"name" IN ('Pump1_S')` {
+		t.Fatalf("name filter: %q %v", filter, err)
+	}
+	if got, err := byName.sensorHash("Pump1_S"); err != nil || got != hash {
+		t.Fatalf("name → hash: %d %v", got, err)
+	}
+	if _, err := byName.sensorFilter([]int64{42}); err == nil {
+		t.Fatalf("expected error for unknown hash")
+	}
+}
+
+func TestQueriesFilterByTimestamp(t *testing.T) {
+	s := &Store{cols: DefaultColumns, source: "read_parquet('/a.parquet')", warmupLookback: time.Hour}
+	from := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	query, args, err := s.warmupQuery([]int64{1, 2}, from)
+	if err != nil {
+		t.Fatalf("warmup query: %v", err)
+	}
+	if !strings.Contains(query, `"timestamp" <= ?`) || !strings.Contains(query, `"timestamp" >= ?`) || len(args) != 2 {
+		t.Fatalf("warmup must bound timestamp: %s %v", query, args)
+	}
+	if !strings.Contains(query, `"sensor_id" IN (1, 2)`) || !strings.Contains(query, "read_parquet('/a.parquet')") {
+		t.Fatalf("warmup must read filtered parquet: %s", query)
+	}
+	if q := s.windowQuery("TRUE"); !strings.Contains(q, `"timestamp" >= ? AND "timestamp" < ?`) {
+		t.Fatalf("window must be half-open: %s", q)
+	}
+	if q, args := s.rangeQuery("TRUE", from, time.Time{}); !strings.Contains(q, `"timestamp" >= ?`) || len(args) != 1 {
+		t.Fatalf("range bounds: %s %v", q, args)
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	ctx := context.Background()
+	if _, err := New(ctx, Config{Source: "duckdb:/a.parquet?sensor=bad-name"}); err == nil || !strings.Contains(err.Error(), "invalid column") {
+		t.Fatalf("expected invalid column error, got %v", err)
+	}
+	if _, err := New(ctx, Config{Source: "duckdb:/a.parquet?sensor_type=name"}); err == nil {
+		t.Fatalf("expected error for names without config")
+	}
+	if _, err := New(ctx, Config{Source: "duckdb:/a.parquet"}); !Available() && !errors.Is(err, ErrNoDriver) {
+		t.Fatalf("expected ErrNoDriver without the duckdb tag, got %v", err)
+	}
+}
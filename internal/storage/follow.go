@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultFollowPoll — период опроса хвоста таблицы в режиме Follow.
+const DefaultFollowPoll = time.Second
+
+// FollowOptions задаёт опрос хвоста растущей таблицы.
+type FollowOptions struct {
+	// Delay — отставание от реального времени: запрашиваются только записи старше now-Delay,
+	// чтобы строки, дописываемые с небольшой задержкой, не терялись.
+	Delay time.Duration
+	// Poll — период повторных запросов (0 — DefaultFollowPoll).
+	Poll time.Duration
+	// Now — источник текущего времени (nil — time.Now), нужен для тестов.
+	Now func() time.Time
+}
+
+// Edge возвращает границу, до которой хвост уже опрошен или будет опрошен в ближайший Poll:
+// now-Delay-Poll. Шаги воспроизведения не должны её обгонять.
+func (o FollowOptions) Edge() time.Time {
+	return o.now().Add(-o.Delay - o.poll())
+}
+
+func (o FollowOptions) now() time.Time {
+	if o.Now != nil {
+		return o.Now()
+	}
+	return time.Now()
+}
+
+func (o FollowOptions) poll() time.Duration {
+	if o.Poll > 0 {
+		return o.Poll
+	}
+	return DefaultFollowPoll
+}
+
+// Follow читает период [req.From, req.To) обычным Stream, а затем каждые Poll запрашивает
+// новые записи [курсор, now-Delay) и передаёт их в тот же канал. Stream хранилища по-прежнему
+// работает с фиксированным диапазоном, поэтому подходит любое хранилище. Поток завершается
+// только при отмене ctx или ошибке хранилища.
+func Follow(ctx context.Context, st Storage, req StreamRequest, opts FollowOptions) (<-chan []SensorEvent, <-chan error) {
+	dataCh := make(chan []SensorEvent)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(dataCh)
+		defer close(errCh)
+
+		cursor := req.From
+		if edge := opts.now().Add(-opts.Delay); req.To.After(edge) {
+			req.To = edge
+		}
+		ticker := time.NewTicker(opts.poll())
+		defer ticker.Stop()
+		for {
+			if req.To.After(cursor) {
+				req.From = cursor
+				if err := forwardStream(ctx, st, req, dataCh); err != nil {
+					errCh <- err
+					return
+				}
+				cursor = req.To
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			req.To = opts.now().Add(-opts.Delay)
+		}
+	}()
+
+	return dataCh, errCh
+}
+
+// forwardStream передаёт все пакеты одного Stream в out и возвращает его ошибку.
+func forwardStream(ctx context.Context, st Storage, req StreamRequest, out chan<- []SensorEvent) error {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	data, errs := st.Stream(streamCtx, req)
+	for data != nil || errs != nil {
+		select {
+		case batch, ok := <-data:
+			if !ok {
+				data = nil
+				continue
+			}
+			select {
+			case out <- batch:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pv/uniset-timemachine-go/pkg/config"
+)
+
+// IDMode задаёт, что хранится в колонке sensor_id таблиц SQLite и PostgreSQL.
+type IDMode string
+
+const (
+	// IDModeAuto — режим определяется по выборке sensor_id из таблицы (DetectIDMode).
+	IDModeAuto IDMode = ""
+	// IDModeConfigID — ID датчиков из конфига (таблицы UniSet DBServer).
+	IDModeConfigID IDMode = "configid"
+	// IDModeHash — hash имени датчика (Hasher.Hash64), как во внутренних идентификаторах.
+	IDModeHash IDMode = "hash"
+)
+
+// IDModeSampleSize — сколько строк sensor_id читается из таблицы для автоопределения режима.
+const IDModeSampleSize = 1000
+
+// ParseIDMode разбирает значение --id-mode: auto (или пусто), configid, hash.
+func ParseIDMode(raw string) (IDMode, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "auto":
+		return IDModeAuto, nil
+	case "configid", "config-id", "id":
+		return IDModeConfigID, nil
+	case "hash":
+		return IDModeHash, nil
+	default:
+		return IDModeAuto, fmt.Errorf("invalid id mode %q: want auto, configid or hash", raw)
+	}
+}
+
+// DetectIDMode выбирает режим по выборке значений sensor_id: побеждает тот вид идентификаторов
+// (ID из конфига или hash), которых в выборке больше. Если выборка пуста или ничего не совпало,
+// используется configid, когда у всех датчиков реестра есть ID, иначе hash.
+func DetectIDMode(sample []int64, registry *config.SensorRegistry) IDMode {
+	var byID, byHash int
+	for _, id := range sample {
+		if _, ok := registry.ByConfigID(id); ok {
+			byID++
+		}
+		if _, ok := registry.ByHash(id); ok {
+			byHash++
+		}
+	}
+	switch {
+	case byHash > byID:
+		return IDModeHash
+	case byID > byHash:
+		return IDModeConfigID
+	case registry.HasIDs():
+		return IDModeConfigID
+	default:
+		return IDModeHash
+	}
+}
@@ -2,20 +2,72 @@ package memstore
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pv/uniset-timemachine-go/internal/storage"
 )
 
+// WaveKind задаёт форму генерируемого сигнала.
+type WaveKind string
+
+const (
+	WaveLegacy WaveKind = ""       // исходная формула: id%100 + секунда метки времени
+	WaveConst  WaveKind = "const"  // постоянное значение Min
+	WaveRamp   WaveKind = "ramp"   // пила от Min до Max за период
+	WaveSine   WaveKind = "sine"   // синусоида между Min и Max
+	WaveSquare WaveKind = "square" // меандр Min/Max (половина периода на каждое значение)
+	WaveRandom WaveKind = "random" // псевдослучайные значения в [Min, Max], воспроизводимые по Seed
+)
+
+// DefaultWaveKey — ключ Waveforms для датчиков, iotype которых не описан отдельно.
+const DefaultWaveKey = "default"
+
+// Waveform описывает сигнал для группы датчиков.
+type Waveform struct {
+	Kind WaveKind
+	Min  float64
+	Max  float64
+}
+
+// Config описывает генерируемый набор данных.
+type Config struct {
+	Sensors     []int64
+	From        time.Time
+	To          time.Time
+	Step        time.Duration
+	SensorCount int                 // 0 — все датчики из Sensors, >0 — только первые N
+	IOTypes     map[int64]string    // iotype датчиков (hash → "AI"/"DI"/...)
+	Waveforms   map[string]Waveform // форма сигнала по iotype (в верхнем регистре) или DefaultWaveKey
+	Period      time.Duration       // период ramp/sine/square (0 — 1 минута)
+	Density     float64             // доля шагов, на которых датчик публикует значение (0 — каждый шаг)
+	Seed        int64               // зерно для random и Density
+}
+
 // ExampleStore генерирует детерминированные значения для заданных датчиков.
 type ExampleStore struct {
 	sensors []int64
 	from    time.Time
 	to      time.Time
 	step    time.Duration
+	waves   map[int64]Waveform
+	period  time.Duration
+	density float64
+	seed    uint64
+	limited bool // задан SensorCount: данные только для первых N датчиков
 }
 
+// NewExampleStore создаёт хранилище с исходной формулой значений (обёртка над New).
 func NewExampleStore(sensors []int64, from, to time.Time, step time.Duration) *ExampleStore {
+	return New(Config{Sensors: sensors, From: from, To: to, Step: step})
+}
+
+// New создаёт хранилище по конфигурации генератора.
+func New(cfg Config) *ExampleStore {
+	from, to, step := cfg.From, cfg.To, cfg.Step
 	if from.IsZero() {
 		from = time.Now().Add(-time.Hour)
 	}
@@ -25,21 +77,66 @@ func NewExampleStore(sensors []int64, from, to time.Time, step time.Duration) *E
 	if step <= 0 {
 		step = time.Second
 	}
-	return &ExampleStore{
+	sensors := cfg.Sensors
+	if cfg.SensorCount > 0 && cfg.SensorCount < len(sensors) {
+		sensors = sensors[:cfg.SensorCount]
+	}
+	period := cfg.Period
+	if period <= 0 {
+		period = time.Minute
+	}
+	density := cfg.Density
+	if density <= 0 || density > 1 {
+		density = 1
+	}
+
+	s := &ExampleStore{
 		sensors: append([]int64(nil), sensors...),
 		from:    from,
 		to:      to,
 		step:    step,
+		waves:   make(map[int64]Waveform, len(sensors)),
+		period:  period,
+		density: density,
+		seed:    uint64(cfg.Seed),
+		limited: cfg.SensorCount > 0,
 	}
+	def := cfg.Waveforms[DefaultWaveKey]
+	for _, id := range s.sensors {
+		wave := def
+		if w, ok := cfg.Waveforms[strings.ToUpper(cfg.IOTypes[id])]; ok {
+			wave = w
+		}
+		s.waves[id] = wave
+	}
+	return s
+}
+
+// Sensors возвращает датчики, для которых генерируются данные.
+func (s *ExampleStore) Sensors() []int64 {
+	return append([]int64(nil), s.sensors...)
 }
 
 func (s *ExampleStore) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]storage.SensorEvent, error) {
 	events := make([]storage.SensorEvent, 0, len(sensors))
+	ts := from.Add(-s.step)
 	for _, id := range sensors {
+		wave, ok := s.waves[id]
+		if !ok && s.limited {
+			continue
+		}
+		if wave.Kind == WaveLegacy {
+			events = append(events, storage.SensorEvent{
+				SensorID:  id,
+				Timestamp: ts,
+				Value:     float64(id % 10),
+			})
+			continue
+		}
 		events = append(events, storage.SensorEvent{
 			SensorID:  id,
-			Timestamp: from.Add(-s.step),
-			Value:     float64(id % 10),
+			Timestamp: ts,
+			Value:     s.value(id, wave, ts),
 		})
 	}
 	return events, ctx.Err()
@@ -56,13 +153,18 @@ func (s *ExampleStore) Stream(ctx context.Context, req storage.StreamRequest) (<
 		for ts := s.from; ts.Before(s.to); ts = ts.Add(s.step) {
 			chunk := make([]storage.SensorEvent, 0, len(s.sensors))
 			for _, id := range s.sensors {
-				val := float64(id%100) + float64(ts.Second())
+				if s.density < 1 && unitFloat(mix(s.seed, uint64(id), uint64(ts.UnixNano())^0x9e3779b97f4a7c15)) >= s.density {
+					continue
+				}
 				chunk = append(chunk, storage.SensorEvent{
 					SensorID:  id,
 					Timestamp: ts,
-					Value:     val,
+					Value:     s.value(id, s.waves[id], ts),
 				})
 			}
+			if len(chunk) == 0 {
+				continue
+			}
 			select {
 			case <-ctx.Done():
 				errCh <- ctx.Err()
@@ -78,3 +180,100 @@ func (s *ExampleStore) Stream(ctx context.Context, req storage.StreamRequest) (<
 func (s *ExampleStore) Range(ctx context.Context, sensors []int64, from, to time.Time) (time.Time, time.Time, int64, error) {
 	return s.from, s.to, int64(len(sensors)), nil
 }
+
+// value вычисляет значение датчика в момент ts.
+func (s *ExampleStore) value(id int64, wave Waveform, ts time.Time) float64 {
+	// фаза сдвигается на датчик, чтобы одинаковые сигналы не совпадали
+	offset := time.Duration(uint64(id)%uint64(s.period/time.Millisecond+1)) * time.Millisecond
+	phase := float64((ts.Sub(s.from)+offset)%s.period) / float64(s.period)
+	if phase < 0 {
+		phase += 1
+	}
+	switch wave.Kind {
+	case WaveConst:
+		return wave.Min
+	case WaveRamp:
+		return wave.Min + (wave.Max-wave.Min)*phase
+	case WaveSine:
+		return wave.Min + (wave.Max-wave.Min)*(1+math.Sin(2*math.Pi*phase))/2
+	case WaveSquare:
+		if phase < 0.5 {
+			return wave.Min
+		}
+		return wave.Max
+	case WaveRandom:
+		return wave.Min + (wave.Max-wave.Min)*unitFloat(mix(s.seed, uint64(id), uint64(ts.UnixNano())))
+	default:
+		return float64(id%100) + float64(ts.Second())
+	}
+}
+
+// mix детерминированно перемешивает значения (splitmix64).
+func mix(vals ...uint64) uint64 {
+	var h uint64
+	for _, v := range vals {
+		h += v + 0x9e3779b97f4a7c15
+		h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+		h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+		h ^= h >> 31
+	}
+	return h
+}
+
+// unitFloat переводит хеш в число из [0, 1).
+func unitFloat(h uint64) float64 {
+	return float64(h>>11) / (1 << 53)
+}
+
+// ParseWaveforms разбирает строку вида "AI=sine:0:100,DI=square:0:1,default=ramp".
+// Диапазон min:max необязателен (по умолчанию 0:100, для DI/DO — 0:1).
+func ParseWaveforms(raw string) (map[string]Waveform, error) {
+	result := make(map[string]Waveform)
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, spec, ok := strings.Cut(part, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("memstore: invalid waveform %q (want iotype=kind[:min:max])", part)
+		}
+		if !strings.EqualFold(key, DefaultWaveKey) {
+			key = strings.ToUpper(key)
+		} else {
+			key = DefaultWaveKey
+		}
+		fields := strings.Split(strings.TrimSpace(spec), ":")
+		kind := WaveKind(strings.ToLower(strings.TrimSpace(fields[0])))
+		switch kind {
+		case WaveConst, WaveRamp, WaveSine, WaveSquare, WaveRandom:
+		default:
+			return nil, fmt.Errorf("memstore: unknown waveform %q (want const|ramp|sine|square|random)", fields[0])
+		}
+		wave := Waveform{Kind: kind, Min: 0, Max: 100}
+		if key == "DI" || key == "DO" {
+			wave.Max = 1
+		}
+		switch len(fields) {
+		case 1:
+		case 3:
+			min, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("memstore: invalid min in %q: %w", part, err)
+			}
+			max, err := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("memstore: invalid max in %q: %w", part, err)
+			}
+			if max < min {
+				return nil, fmt.Errorf("memstore: max < min in %q", part)
+			}
+			wave.Min, wave.Max = min, max
+		default:
+			return nil, fmt.Errorf("memstore: invalid waveform %q (want iotype=kind[:min:max])", part)
+		}
+		result[key] = wave
+	}
+	return result, nil
+}
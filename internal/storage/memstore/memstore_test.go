@@ -78,3 +78,80 @@ func TestExampleStoreStreamContextCancel(t *testing.T) {
 		t.Fatalf("expected context.Canceled, got %v", err)
 	}
 }
+
+func TestStoreWaveformsAndDensity(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	waves, err := ParseWaveforms("AI=const:5:5, di=square, default=random:10:20")
+	if err != nil {
+		t.Fatalf("ParseWaveforms returned error: %v", err)
+	}
+	if waves["DI"].Max != 1 || waves[DefaultWaveKey].Kind != WaveRandom {
+		t.Fatalf("unexpected waveforms: %+v", waves)
+	}
+	cfg := Config{
+		Sensors:     []int64{1, 2, 3, 4},
+		From:        start,
+		To:          start.Add(100 * time.Second),
+		Step:        time.Second,
+		SensorCount: 3,
+		IOTypes:     map[int64]string{1: "AI", 2: "DI"},
+		Waveforms:   waves,
+		Period:      10 * time.Second,
+		Density:     0.5,
+		Seed:        7,
+	}
+
+	collect := func() []storage.SensorEvent {
+		dataCh, errCh := New(cfg).Stream(context.Background(), storage.StreamRequest{})
+		var events []storage.SensorEvent
+		for batch := range dataCh {
+			events = append(events, batch...)
+		}
+		if err, ok := <-errCh; ok && err != nil {
+			t.Fatalf("Stream returned error: %v", err)
+		}
+		return events
+	}
+	events := collect()
+	if len(events) == 0 || len(events) >= 300 {
+		t.Fatalf("density 0.5 should thin out 300 events, got %d", len(events))
+	}
+	for _, ev := range events {
+		switch ev.SensorID {
+		case 1:
+			if ev.Value != 5 {
+				t.Fatalf("const sensor value = %v", ev.Value)
+			}
+		case 2:
+			if ev.Value != 0 && ev.Value != 1 {
+				t.Fatalf("square DI value = %v", ev.Value)
+			}
+		case 3:
+			if ev.Value < 10 || ev.Value > 20 {
+				t.Fatalf("random value out of range: %v", ev.Value)
+			}
+		default:
+			t.Fatalf("sensor %d is beyond SensorCount", ev.SensorID)
+		}
+	}
+	again := collect()
+	if len(again) != len(events) || again[len(again)-1] != events[len(events)-1] {
+		t.Fatalf("generator is not reproducible with the same seed")
+	}
+
+	warm, err := New(cfg).Warmup(context.Background(), []int64{1, 4}, start)
+	if err != nil {
+		t.Fatalf("Warmup returned error: %v", err)
+	}
+	if len(warm) != 1 || warm[0].SensorID != 1 || warm[0].Value != 5 {
+		t.Fatalf("unexpected warmup: %+v", warm)
+	}
+}
+
+func TestParseWaveformsErrors(t *testing.T) {
+	for _, raw := range []string{"AI", "AI=triangle", "AI=sine:1", "AI=sine:10:1", "AI=sine:x:1"} {
+		if _, err := ParseWaveforms(raw); err == nil {
+			t.Fatalf("ParseWaveforms(%q) expected error", raw)
+		}
+	}
+}
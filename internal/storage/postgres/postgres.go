@@ -2,12 +2,15 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/pv/uniset-timemachine-go/internal/storage"
@@ -17,16 +20,41 @@ import (
 const defaultWindow = time.Minute
 
 type Config struct {
-	ConnString string
-	MaxConns   int32
-	Registry   *config.SensorRegistry // реестр датчиков для конвертации hash↔configID
+	ConnString   string
+	MaxConns     int32
+	QueryTimeout time.Duration          // statement_timeout для соединений пула (0 — без ограничения)
+	Registry     *config.SensorRegistry // реестр датчиков для конвертации hash↔configID
+	// WarmupLookback ограничивает поиск значений для Warmup окном [from-lookback, from] (0 — без ограничения).
+	WarmupLookback time.Duration
+	// StreamRetries — число повторов запроса окна Stream при временных ошибках (0 — без повторов).
+	StreamRetries int
+	// ConnectRetries/ConnectBackoff — ожидание сервера при подключении в New (см. storage.ConnectRetry).
+	ConnectRetries int
+	ConnectBackoff time.Duration
+	// IDMode — что хранится в sensor_id: ID из конфига или hash (IDModeAuto — определить по таблице).
+	IDMode storage.IDMode
+	// ValueColumn — колонка main_history, которая читается как значение датчика (пусто — value).
+	// Наличие колонки проверяется по information_schema при подключении.
+	ValueColumn string
+	// MaxRowsPerWindow — предел строк одного запроса окна Stream: окно, в котором строк больше,
+	// делится по времени на части (см. storage.ReadCapped); 0 — без ограничения.
+	MaxRowsPerWindow int
 }
 
 type Store struct {
-	pool     *pgxpool.Pool
-	registry *config.SensorRegistry
+	pool         *pgxpool.Pool
+	registry     *config.SensorRegistry
+	queryTimeout time.Duration
+	lookback     time.Duration
+	retry        storage.StreamRetry
+	valueColumn  string         // колонка значения (storage.ValueDefault по умолчанию)
+	idMode       storage.IDMode // содержимое sensor_id при заданном реестре
+	maxRows      int            // предел строк одного запроса окна (0 — без ограничения)
 }
 
+// queryCanceledCode — SQLSTATE, который сервер возвращает при срабатывании statement_timeout.
+const queryCanceledCode = "57014"
+
 // RangeWithUnknown реализует UnknownAwareStorage: считает количество датчиков вне конфигурации
 // в выбранном окне [from,to]. Unknown вычисляется как (все distinct sensor_id в окне) - (известные).
 // Если словаря нет, считаем unknown=0.
@@ -62,7 +90,7 @@ func (s *Store) RangeWithUnknown(ctx context.Context, sensors []int64, from, to
 	queryKnown := "SELECT COUNT(DISTINCT sensor_id) FROM main_history WHERE " + strings.Join(whereKnown, " AND ")
 	var known int64
 	if err := s.pool.QueryRow(ctx, queryKnown, argsKnown...).Scan(&known); err != nil {
-		return minTs, maxTs, 0, 0, fmt.Errorf("postgres: count known sensors: %w", err)
+		return minTs, maxTs, 0, 0, s.wrapQueryErr("count known sensors", err)
 	}
 
 	// Unknown считаем только если есть словарь конфигурации.
@@ -71,47 +99,75 @@ func (s *Store) RangeWithUnknown(ctx context.Context, sensors []int64, from, to
 	}
 
 	// Считаем все distinct sensor_id в окне без фильтра по рабочему списку.
-	whereAll := make([]string, 0, 2)
-	argsAll := []any{}
-	argPosAll := 1
+	whereAll, argsAll := periodWhere(from, to)
+	queryAll := "SELECT COUNT(DISTINCT sensor_id) FROM main_history" + whereAll
+
+	var totalDistinct int64
+	if err := s.pool.QueryRow(ctx, queryAll, argsAll...).Scan(&totalDistinct); err != nil {
+		return minTs, maxTs, known, 0, s.wrapQueryErr("count distinct sensors", err)
+	}
+	unknown := totalDistinct - known
+	if unknown < 0 {
+		unknown = 0
+	}
+	return minTs, maxTs, known, unknown, nil
+}
+
+// periodWhere строит условие " WHERE …" по окну [from, to] для колонок date/time/time_usec
+// (пусто, если обе границы нулевые); параметры нумеруются с $1.
+func periodWhere(from, to time.Time) (string, []any) {
+	var where []string
+	var args []any
+	argPos := 1
 	if !from.IsZero() {
-		whereAll = append(whereAll,
+		where = append(where,
 			fmt.Sprintf("(date > $%d::date OR (date = $%d::date AND (time > $%d::time OR (time = $%d::time AND time_usec >= $%d))))",
-				argPosAll, argPosAll, argPosAll+1, argPosAll+1, argPosAll+2))
-		argsAll = append(argsAll, from.Format("2006-01-02"), from.Format("15:04:05"), from.Nanosecond()/1000)
-		argPosAll += 3
+				argPos, argPos, argPos+1, argPos+1, argPos+2))
+		args = append(args, from.Format("2006-01-02"), from.Format("15:04:05"), from.Nanosecond()/1000)
+		argPos += 3
 	}
 	if !to.IsZero() {
-		whereAll = append(whereAll,
+		where = append(where,
 			fmt.Sprintf("(date < $%d::date OR (date = $%d::date AND (time < $%d::time OR (time = $%d::time AND time_usec <= $%d))))",
-				argPosAll, argPosAll, argPosAll+1, argPosAll+1, argPosAll+2))
-		argsAll = append(argsAll, to.Format("2006-01-02"), to.Format("15:04:05"), to.Nanosecond()/1000)
-		argPosAll += 3
+				argPos, argPos, argPos+1, argPos+1, argPos+2))
+		args = append(args, to.Format("2006-01-02"), to.Format("15:04:05"), to.Nanosecond()/1000)
 	}
-	queryAll := "SELECT COUNT(DISTINCT sensor_id) FROM main_history"
-	if len(whereAll) > 0 {
-		queryAll += " WHERE " + strings.Join(whereAll, " AND ")
+	if len(where) == 0 {
+		return "", nil
 	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
 
-	var totalDistinct int64
-	if err := s.pool.QueryRow(ctx, queryAll, argsAll...).Scan(&totalDistinct); err != nil {
-		return minTs, maxTs, known, 0, fmt.Errorf("postgres: count distinct sensors: %w", err)
+// ListSensors реализует storage.SensorLister: различные sensor_id в окне [from, to] как есть,
+// без сопоставления с конфигом.
+func (s *Store) ListSensors(ctx context.Context, from, to time.Time) ([]storage.SensorIdentity, error) {
+	where, args := periodWhere(from, to)
+	rows, err := s.pool.Query(ctx, "SELECT DISTINCT sensor_id FROM main_history"+where+" ORDER BY sensor_id", args...)
+	if err != nil {
+		return nil, s.wrapQueryErr("list sensors", err)
 	}
-	unknown := totalDistinct - known
-	if unknown < 0 {
-		unknown = 0
+	defer rows.Close()
+	var out []storage.SensorIdentity
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("postgres: list sensors scan: %w", err)
+		}
+		out = append(out, storage.SensorIdentity{ID: id})
 	}
-	return minTs, maxTs, known, unknown, nil
+	if err := rows.Err(); err != nil {
+		return nil, s.wrapQueryErr("list sensors", err)
+	}
+	return out, nil
 }
 
 func New(ctx context.Context, cfg Config) (*Store, error) {
 	if cfg.ConnString == "" {
 		return nil, fmt.Errorf("postgres: connection string is empty")
 	}
-
-	// PostgreSQL требует ID в конфиге для работы с таблицей main_history
-	if cfg.Registry != nil && !cfg.Registry.HasIDs() {
-		return nil, fmt.Errorf("postgres: config must have sensor IDs (idfromfile != 0 for all sensors)")
+	valueColumn, err := storage.ValueColumn(cfg.ValueColumn)
+	if err != nil {
+		return nil, fmt.Errorf("postgres: %w", err)
 	}
 
 	poolCfg, err := pgxpool.ParseConfig(cfg.ConnString)
@@ -121,11 +177,20 @@ func New(ctx context.Context, cfg Config) (*Store, error) {
 	if cfg.MaxConns > 0 {
 		poolCfg.MaxConns = cfg.MaxConns
 	}
+	if err := applyQueryTimeout(poolCfg, cfg.QueryTimeout); err != nil {
+		return nil, err
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
 	if err != nil {
 		return nil, fmt.Errorf("postgres: create pool: %w", err)
 	}
+	// Пул подключается лениво: ждём сервер явным ping, пока он не станет доступен.
+	retry := storage.ConnectRetry{Retries: cfg.ConnectRetries, Backoff: cfg.ConnectBackoff}
+	if err := retry.Do(ctx, "postgres", isRetryable, func() error { return pool.Ping(ctx) }); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("postgres: connect: %w", err)
+	}
 
 	// Check and set timezone to UTC
 	if err := ensureUTCTimezone(ctx, pool); err != nil {
@@ -138,10 +203,167 @@ func New(ctx context.Context, cfg Config) (*Store, error) {
 		return nil, err
 	}
 
-	return &Store{
-		pool:     pool,
-		registry: cfg.Registry,
-	}, nil
+	store := &Store{
+		pool:         pool,
+		registry:     cfg.Registry,
+		queryTimeout: cfg.QueryTimeout,
+		lookback:     cfg.WarmupLookback,
+		retry:        storage.StreamRetry{Retries: cfg.StreamRetries},
+		valueColumn:  valueColumn,
+		maxRows:      cfg.MaxRowsPerWindow,
+	}
+	if err := store.checkValueColumn(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	if err := store.resolveIDMode(ctx, cfg.IDMode); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// checkValueColumn проверяет по information_schema, что в main_history есть колонка значения.
+// Колонку по умолчанию не проверяет: без неё таблица не является историей UniSet.
+func (s *Store) checkValueColumn(ctx context.Context) error {
+	if s.valueColumn == storage.ValueDefault {
+		return nil
+	}
+	rows, err := s.pool.Query(ctx, `SELECT column_name::text FROM information_schema.columns
+WHERE table_name = 'main_history' AND table_schema = ANY(current_schemas(false))`)
+	if err != nil {
+		return s.wrapQueryErr("table columns", err)
+	}
+	columns, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return s.wrapQueryErr("table columns", err)
+	}
+	if err := storage.RequireColumn("main_history", s.valueColumn, columns); err != nil {
+		return fmt.Errorf("postgres: value column: %w", err)
+	}
+	return nil
+}
+
+// withValue подставляет колонку значения вместо выражения по умолчанию.
+func (s *Store) withValue(query string) string {
+	if s.valueColumn == "" || s.valueColumn == storage.ValueDefault {
+		return query
+	}
+	return strings.Replace(query, valueDefault, s.valueColumn+" AS value", 1)
+}
+
+// resolveIDMode выбирает, что хранится в sensor_id: ID из конфига или hash датчика.
+// Без реестра значения используются как есть (legacy-режим).
+func (s *Store) resolveIDMode(ctx context.Context, mode storage.IDMode) error {
+	if s.registry == nil {
+		return nil
+	}
+	if mode == storage.IDModeAuto {
+		rows, err := s.pool.Query(ctx, `SELECT sensor_id FROM main_history LIMIT $1`, storage.IDModeSampleSize)
+		if err != nil {
+			return s.wrapQueryErr("sample sensor ids", err)
+		}
+		sample, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+		if err != nil {
+			return s.wrapQueryErr("sample sensor ids", err)
+		}
+		mode = storage.DetectIDMode(sample, s.registry)
+		log.Printf("[postgres] sensor_id mode: %s (detected from %d rows)", mode, len(sample))
+	}
+	if mode == storage.IDModeConfigID && !s.registry.HasIDs() {
+		return fmt.Errorf("postgres: config must have sensor IDs (idfromfile != 0 for all sensors) unless sensor_id holds hashes (--id-mode hash)")
+	}
+	s.idMode = mode
+	return nil
+}
+
+// applyQueryTimeout задаёт statement_timeout как параметр запуска соединения.
+// Параметр действует только на соединения этого пула, глобальные настройки сервера не меняются.
+func applyQueryTimeout(poolCfg *pgxpool.Config, timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("postgres: query timeout must be >= 0, got %s", timeout)
+	}
+	if timeout == 0 {
+		return nil
+	}
+	ms := timeout.Milliseconds()
+	if ms == 0 {
+		ms = 1
+	}
+	if poolCfg.ConnConfig.RuntimeParams == nil {
+		poolCfg.ConnConfig.RuntimeParams = make(map[string]string)
+	}
+	poolCfg.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(ms, 10)
+	return nil
+}
+
+// wrapQueryErr оборачивает ошибку запроса, явно помечая срабатывание таймаута.
+func (s *Store) wrapQueryErr(op string, err error) error {
+	if isQueryTimeout(err) {
+		if s.queryTimeout > 0 {
+			return fmt.Errorf("postgres: %s: query timeout (%s) exceeded: %w", op, s.queryTimeout, err)
+		}
+		return fmt.Errorf("postgres: %s: query timeout exceeded: %w", op, err)
+	}
+	return fmt.Errorf("postgres: %s: %w", op, err)
+}
+
+// queryWindow читает записи датчиков configIDs в окне [from, to); limit > 0 — не больше limit строк.
+func (s *Store) queryWindow(ctx context.Context, configIDs []int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	rows, err := s.pool.Query(ctx, s.withValue(windowSQL), sensorsAsArray(configIDs),
+		from.Format("2006-01-02"), from.Format("15:04:05"), from.Nanosecond()/1000,
+		to.Format("2006-01-02"), to.Format("15:04:05"), to.Nanosecond()/1000)
+	if err != nil {
+		return nil, s.wrapQueryErr("window query", err)
+	}
+	defer rows.Close()
+
+	chunk := make([]storage.SensorEvent, 0)
+	for (limit <= 0 || len(chunk) < limit) && rows.Next() {
+		var sensorID int64
+		var date time.Time
+		var timeStr string
+		var usec int
+		var value float64
+		if err := rows.Scan(&sensorID, &date, &timeStr, &usec, &value); err != nil {
+			return nil, s.wrapQueryErr("window scan", err)
+		}
+		chunk = append(chunk, storage.SensorEvent{
+			SensorID:  s.configIDToHash(sensorID), // конвертируем в hash
+			Timestamp: combineDateTimeUsec(date, timeStr, usec),
+			Value:     value,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, s.wrapQueryErr("rows err", err)
+	}
+	return chunk, nil
+}
+
+// isRetryable — временные ошибки Postgres: обрыв соединения (класс SQLSTATE 08), остановка
+// сервера (57P01-57P03), нехватка соединений (53300), конфликт сериализации и deadlock.
+// Ошибки SQL, авторизации и statement_timeout повторять бессмысленно.
+func isRetryable(err error) bool {
+	if isQueryTimeout(err) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "57P01", "57P02", "57P03", "53300", "40001", "40P01":
+			return true
+		}
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+	return pgconn.SafeToRetry(err) || storage.IsTransient(err)
+}
+
+func isQueryTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == queryCanceledCode
 }
 
 // ensureUTCTimezone checks the database timezone and sets session timezone to UTC if needed.
@@ -189,9 +411,18 @@ func (s *Store) Close() {
 	}
 }
 
-// hashToConfigIDs конвертирует hashes в configIDs для SQL запросов.
+// Ping реализует storage.Pinger.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.pool.Ping(ctx); err != nil {
+		return s.wrapQueryErr("ping", err)
+	}
+	return nil
+}
+
+// hashToConfigIDs конвертирует hashes в значения sensor_id для SQL запросов:
+// configIDs, а в hash-режиме — сами hashes.
 func (s *Store) hashToConfigIDs(hashes []int64) ([]int64, error) {
-	if s.registry == nil {
+	if s.registry == nil || s.idMode == storage.IDModeHash {
 		return hashes, nil // legacy mode - hashes уже являются configIDs
 	}
 	result := make([]int64, 0, len(hashes))
@@ -208,10 +439,10 @@ func (s *Store) hashToConfigIDs(hashes []int64) ([]int64, error) {
 	return result, nil
 }
 
-// configIDToHash конвертирует configID из результата SQL в hash.
+// configIDToHash конвертирует sensor_id из результата SQL в hash.
 func (s *Store) configIDToHash(configID int64) int64 {
-	if s.registry == nil {
-		return configID // legacy mode
+	if s.registry == nil || s.idMode == storage.IDModeHash {
+		return configID // legacy mode или sensor_id уже hash
 	}
 	if key, ok := s.registry.ByConfigID(configID); ok {
 		return key.Hash
@@ -234,9 +465,16 @@ func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]
 	fromTime := from.Format("15:04:05")
 	fromUsec := from.Nanosecond() / 1000
 
-	rows, err := s.pool.Query(ctx, warmupSQL, sensorsAsArray(configIDs), fromDate, fromTime, fromUsec)
+	query := s.withValue(fmt.Sprintf(warmupSQL, ""))
+	args := []any{sensorsAsArray(configIDs), fromDate, fromTime, fromUsec}
+	if s.lookback > 0 {
+		lower := from.Add(-s.lookback)
+		query = s.withValue(fmt.Sprintf(warmupSQL, warmupLookbackCond))
+		args = append(args, lower.Format("2006-01-02"), lower.Format("15:04:05"), lower.Nanosecond()/1000)
+	}
+	rows, err := s.pool.Query(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("postgres: warmup query: %w", err)
+		return nil, s.wrapQueryErr("warmup query", err)
 	}
 	defer rows.Close()
 
@@ -248,7 +486,7 @@ func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]
 		var usec int
 		var value float64
 		if err := rows.Scan(&sensorID, &date, &timeStr, &usec, &value); err != nil {
-			return nil, fmt.Errorf("postgres: warmup scan: %w", err)
+			return nil, s.wrapQueryErr("warmup scan", err)
 		}
 		result = append(result, storage.SensorEvent{
 			SensorID:  s.configIDToHash(sensorID), // конвертируем в hash
@@ -256,7 +494,10 @@ func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]
 			Value:     value,
 		})
 	}
-	return result, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, s.wrapQueryErr("warmup rows", err)
+	}
+	return result, nil
 }
 
 func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan []storage.SensorEvent, <-chan error) {
@@ -296,52 +537,22 @@ func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan [
 				next = req.To
 			}
 
-			cursorDate := cursor.Format("2006-01-02")
-			cursorTime := cursor.Format("15:04:05")
-			cursorUsec := cursor.Nanosecond() / 1000
-			nextDate := next.Format("2006-01-02")
-			nextTime := next.Format("15:04:05")
-			nextUsec := next.Nanosecond() / 1000
-
-			rows, err := s.pool.Query(ctx, windowSQL, sensorsAsArray(configIDs),
-				cursorDate, cursorTime, cursorUsec,
-				nextDate, nextTime, nextUsec)
-			if err != nil {
-				errCh <- fmt.Errorf("postgres: window query: %w", err)
-				return
-			}
-
-			chunk := make([]storage.SensorEvent, 0)
-			for rows.Next() {
-				var sensorID int64
-				var date time.Time
-				var timeStr string
-				var usec int
-				var value float64
-				if err := rows.Scan(&sensorID, &date, &timeStr, &usec, &value); err != nil {
-					rows.Close()
-					errCh <- fmt.Errorf("postgres: window scan: %w", err)
-					return
-				}
-				chunk = append(chunk, storage.SensorEvent{
-					SensorID:  s.configIDToHash(sensorID), // конвертируем в hash
-					Timestamp: combineDateTimeUsec(date, timeStr, usec),
-					Value:     value,
+			read := func(from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+				return s.retry.Window(ctx, isRetryable, func(int) ([]storage.SensorEvent, error) {
+					return s.queryWindow(ctx, configIDs, from, to, limit)
 				})
 			}
-			rows.Close()
-			if err := rows.Err(); err != nil {
-				errCh <- fmt.Errorf("postgres: rows err: %w", err)
-				return
-			}
-
-			if len(chunk) > 0 {
+			_, err := storage.ReadCapped(cursor, next, s.maxRows, read, func(chunk []storage.SensorEvent) error {
 				select {
 				case <-ctx.Done():
-					errCh <- ctx.Err()
-					return
+					return ctx.Err()
 				case dataCh <- chunk:
+					return nil
 				}
+			})
+			if err != nil {
+				errCh <- err
+				return
 			}
 
 			if next == cursor {
@@ -354,6 +565,56 @@ func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan [
 	return dataCh, errCh
 }
 
+// EventsFor реализует EventHistoryStorage: сырые записи одного датчика в окне [from, to].
+func (s *Store) EventsFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	return s.history(ctx, eventsSQL, sensor, from, to, limit)
+}
+
+// ChangesFor реализует ChangeHistoryStorage: только изменения значения одного датчика в окне [from, to].
+func (s *Store) ChangesFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	return s.history(ctx, changesSQL, sensor, from, to, limit)
+}
+
+// history выполняет запрос истории одного датчика (eventsSQL или changesSQL).
+func (s *Store) history(ctx context.Context, query string, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	configIDs, err := s.hashToConfigIDs([]int64{sensor})
+	if err != nil {
+		return nil, err
+	}
+	var limitArg any // NULL — без ограничения
+	if limit > 0 {
+		limitArg = limit
+	}
+	rows, err := s.pool.Query(ctx, s.withValue(query), configIDs[0],
+		from.Format("2006-01-02"), from.Format("15:04:05"), from.Nanosecond()/1000,
+		to.Format("2006-01-02"), to.Format("15:04:05"), to.Nanosecond()/1000,
+		limitArg)
+	if err != nil {
+		return nil, s.wrapQueryErr("events query", err)
+	}
+	defer rows.Close()
+
+	var events []storage.SensorEvent
+	for rows.Next() {
+		var date time.Time
+		var timeStr string
+		var usec int
+		var value float64
+		if err := rows.Scan(&date, &timeStr, &usec, &value); err != nil {
+			return nil, s.wrapQueryErr("events scan", err)
+		}
+		events = append(events, storage.SensorEvent{
+			SensorID:  sensor,
+			Timestamp: combineDateTimeUsec(date, timeStr, usec),
+			Value:     value,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, s.wrapQueryErr("events rows", err)
+	}
+	return events, nil
+}
+
 func sensorsAsArray(ids []int64) any {
 	return ids
 }
@@ -404,7 +665,7 @@ func (s *Store) Range(ctx context.Context, sensors []int64, from, to time.Time)
 		if err == pgx.ErrNoRows {
 			return time.Time{}, time.Time{}, 0, nil
 		}
-		return time.Time{}, time.Time{}, 0, fmt.Errorf("postgres: range scan: %w", err)
+		return time.Time{}, time.Time{}, 0, s.wrapQueryErr("range scan", err)
 	}
 
 	var minTs, maxTs time.Time
@@ -418,25 +679,32 @@ func (s *Store) Range(ctx context.Context, sensors []int64, from, to time.Time)
 	return minTs, maxTs, count, nil
 }
 
+// valueDefault — выражение значения в запросах, когда колонка значения не переопределена (ValueColumn).
+const valueDefault = storage.ValueDefault + " AS value"
+
 const warmupSQL = `
 SELECT DISTINCT ON (sensor_id)
 	sensor_id,
 	date,
 	time::text,
 	time_usec,
-	value
+	` + valueDefault + `
 FROM main_history
 WHERE sensor_id = ANY($1)
-  AND (date < $2::date OR (date = $2::date AND (time < $3::time OR (time = $3::time AND time_usec <= $4))))
-ORDER BY sensor_id, date DESC, time DESC, time_usec DESC;
+  AND (date < $2::date OR (date = $2::date AND (time < $3::time OR (time = $3::time AND time_usec <= $4))))%s
+ORDER BY sensor_id, date DESC, time DESC, time_usec DESC, value DESC;
 `
 
+// warmupLookbackCond — нижняя граница поиска в warmupSQL при заданном WarmupLookback.
+const warmupLookbackCond = `
+  AND (date > $5::date OR (date = $5::date AND (time > $6::time OR (time = $6::time AND time_usec >= $7))))`
+
 const windowSQL = `
 SELECT sensor_id,
        date,
        time::text,
        time_usec,
-       value
+       ` + valueDefault + `
 FROM main_history
 WHERE sensor_id = ANY($1)
   AND (date > $2::date OR (date = $2::date AND (time > $3::time OR (time = $3::time AND time_usec >= $4))))
@@ -444,6 +712,52 @@ WHERE sensor_id = ANY($1)
 ORDER BY date, time, time_usec, sensor_id;
 `
 
+const eventsSQL = `
+SELECT date,
+       time::text,
+       time_usec,
+       ` + valueDefault + `
+FROM main_history
+WHERE sensor_id = $1
+  AND (date > $2::date OR (date = $2::date AND (time > $3::time OR (time = $3::time AND time_usec >= $4))))
+  AND (date < $5::date OR (date = $5::date AND (time < $6::time OR (time = $6::time AND time_usec <= $7))))
+ORDER BY date, time, time_usec
+LIMIT $8;
+`
+
+// changesSQL — eventsSQL без повторов: первая запись окна и записи, значение которых
+// отличается от предыдущей.
+const changesSQL = `
+WITH events AS (
+	SELECT date,
+	       time,
+	       time_usec,
+	       ` + valueDefault + `
+	FROM main_history
+	WHERE sensor_id = $1
+	  AND (date > $2::date OR (date = $2::date AND (time > $3::time OR (time = $3::time AND time_usec >= $4))))
+	  AND (date < $5::date OR (date = $5::date AND (time < $6::time OR (time = $6::time AND time_usec <= $7))))
+),
+marked AS (
+	SELECT date,
+	       time,
+	       time_usec,
+	       value,
+	       ROW_NUMBER() OVER w AS rn,
+	       LAG(value) OVER w AS prev_value
+	FROM events
+	WINDOW w AS (ORDER BY date, time, time_usec)
+)
+SELECT date,
+       time::text,
+       time_usec,
+       value
+FROM marked
+WHERE rn = 1 OR value IS DISTINCT FROM prev_value
+ORDER BY date, time, time_usec
+LIMIT $8;
+`
+
 const rangeSQL = `
 WITH filtered AS (
 	SELECT date, time, time_usec
@@ -2,11 +2,14 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/pv/uniset-timemachine-go/internal/storage"
@@ -35,6 +38,64 @@ func TestNewErrorsAndHelpers(t *testing.T) {
 	}
 }
 
+func TestWithValue(t *testing.T) {
+	if _, err := New(context.Background(), Config{ConnString: "postgres://localhost/db", ValueColumn: "raw; DROP"}); err == nil || !strings.Contains(err.Error(), "invalid value column") {
+		t.Fatalf("invalid value column err = %v", err)
+	}
+	def := &Store{valueColumn: storage.ValueDefault}
+	raw := &Store{valueColumn: "raw"}
+	for _, query := range []string{warmupSQL, windowSQL, eventsSQL} {
+		if got := def.withValue(query); got != query {
+			t.Fatalf("default value column must keep query as is:\n%s", got)
+		}
+		got := raw.withValue(query)
+		if !strings.Contains(got, "raw AS value") || strings.Contains(got, valueDefault) {
+			t.Fatalf("value column not substituted:\n%s", got)
+		}
+	}
+}
+
+func TestApplyQueryTimeout(t *testing.T) {
+	poolCfg, err := pgxpool.ParseConfig("postgres://user@localhost/db")
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	if err := applyQueryTimeout(poolCfg, 0); err != nil {
+		t.Fatalf("zero timeout: %v", err)
+	}
+	if _, ok := poolCfg.ConnConfig.RuntimeParams["statement_timeout"]; ok {
+		t.Fatalf("statement_timeout must not be set for zero timeout")
+	}
+	if err := applyQueryTimeout(poolCfg, 2500*time.Millisecond); err != nil {
+		t.Fatalf("applyQueryTimeout: %v", err)
+	}
+	if got := poolCfg.ConnConfig.RuntimeParams["statement_timeout"]; got != "2500" {
+		t.Fatalf("statement_timeout = %q, want 2500", got)
+	}
+	if err := applyQueryTimeout(poolCfg, -time.Second); err == nil {
+		t.Fatalf("expected error for negative timeout")
+	}
+}
+
+func TestWrapQueryErrTimeout(t *testing.T) {
+	store := &Store{queryTimeout: 3 * time.Second}
+	pgErr := &pgconn.PgError{Code: queryCanceledCode, Message: "canceling statement due to statement timeout"}
+	err := store.wrapQueryErr("window query", pgErr)
+	if !errors.Is(err, pgErr) {
+		t.Fatalf("wrapped error must unwrap to original: %v", err)
+	}
+	if want := "postgres: window query: query timeout (3s) exceeded"; !strings.HasPrefix(err.Error(), want) {
+		t.Fatalf("error = %q, want prefix %q", err, want)
+	}
+	if err := store.wrapQueryErr("range scan", context.DeadlineExceeded); !strings.Contains(err.Error(), "query timeout") {
+		t.Fatalf("deadline must be reported as timeout: %v", err)
+	}
+	plain := store.wrapQueryErr("range scan", errors.New("boom"))
+	if plain.Error() != "postgres: range scan: boom" {
+		t.Fatalf("unexpected plain error: %v", plain)
+	}
+}
+
 func TestStreamRangeWarmupEmptySensors(t *testing.T) {
 	store := &Store{}
 	// Warmup should short-circuit.
@@ -206,6 +267,87 @@ func TestRangeWithBounds_Postgres(t *testing.T) {
 	}
 }
 
+func TestEventsFor_Postgres(t *testing.T) {
+	dsn := os.Getenv("TM_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TM_POSTGRES_DSN is not set; skipping Postgres integration test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rows := []pgHistoryRow{
+		{sensorID: 50, ts: start, value: 1},
+		{sensorID: 50, ts: start.Add(time.Second), usec: 250000, value: 2},
+		{sensorID: 51, ts: start.Add(2 * time.Second), value: 20},
+		{sensorID: 50, ts: start.Add(3 * time.Second), value: 3},
+	}
+	setupPostgresFixtures(t, ctx, dsn, rows)
+
+	store, err := New(ctx, Config{ConnString: dsn})
+	if err != nil {
+		t.Fatalf("postgres.New error: %v", err)
+	}
+	defer store.Close()
+
+	events, err := store.EventsFor(ctx, 50, start, start.Add(3*time.Second), 0)
+	if err != nil {
+		t.Fatalf("EventsFor returned error: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %#v", len(events), events)
+	}
+	if !events[1].Timestamp.Equal(start.Add(1250 * time.Millisecond)) {
+		t.Fatalf("second event ts mismatch: %s", events[1].Timestamp)
+	}
+	limited, err := store.EventsFor(ctx, 50, start, start.Add(3*time.Second), 1)
+	if err != nil {
+		t.Fatalf("EventsFor with limit returned error: %v", err)
+	}
+	if len(limited) != 1 || limited[0].Value != 1 {
+		t.Fatalf("limited events mismatch: %#v", limited)
+	}
+	changes, err := store.ChangesFor(ctx, 50, start, start.Add(3*time.Second), 0)
+	if err != nil {
+		t.Fatalf("ChangesFor returned error: %v", err)
+	}
+	if len(changes) != 3 || changes[2].Value != 3 {
+		t.Fatalf("changes mismatch: %#v", changes)
+	}
+}
+
+func TestWarmupLookback_Postgres(t *testing.T) {
+	dsn := os.Getenv("TM_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TM_POSTGRES_DSN is not set; skipping Postgres integration test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rows := []pgHistoryRow{
+		{sensorID: 50, ts: start.Add(-2 * time.Hour), value: 1},
+		{sensorID: 51, ts: start.Add(-10 * time.Minute), value: 2},
+	}
+	setupPostgresFixtures(t, ctx, dsn, rows)
+
+	store, err := New(ctx, Config{ConnString: dsn, WarmupLookback: time.Hour})
+	if err != nil {
+		t.Fatalf("postgres.New error: %v", err)
+	}
+	defer store.Close()
+
+	events, err := store.Warmup(ctx, []int64{50, 51}, start)
+	if err != nil {
+		t.Fatalf("Warmup returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].SensorID != 51 {
+		t.Fatalf("lookback warmup expected only sensor 51, got %#v", events)
+	}
+}
+
 // Test Stream context cancellation
 func TestStreamContextCancel_Postgres(t *testing.T) {
 	dsn := os.Getenv("TM_POSTGRES_DSN")
@@ -395,3 +537,20 @@ CREATE TABLE IF NOT EXISTS main_history(
 		pool.Close()
 	})
 }
+
+func TestIsRetryable(t *testing.T) {
+	cases := map[error]bool{
+		&pgconn.PgError{Code: "08006"}:           true,  // connection_failure
+		&pgconn.PgError{Code: "57P01"}:           true,  // admin_shutdown
+		&pgconn.PgError{Code: "40P01"}:           true,  // deadlock_detected
+		&pgconn.PgError{Code: "42601"}:           false, // syntax_error
+		&pgconn.PgError{Code: "28P01"}:           false, // invalid_password
+		&pgconn.PgError{Code: queryCanceledCode}: false, // statement_timeout
+		context.DeadlineExceeded:                 false,
+	}
+	for err, want := range cases {
+		if got := isRetryable(err); got != want {
+			t.Errorf("isRetryable(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DefaultStreamRetries — число повторов запроса окна Stream по умолчанию.
+const DefaultStreamRetries = 3
+
+const (
+	defaultRetryBackoff = 500 * time.Millisecond
+	maxRetryBackoff     = 10 * time.Second
+)
+
+// StreamRetry задаёт повтор запроса одного окна Stream при временных ошибках
+// (обрыв соединения, перезапуск сервера). Курсор при этом не сдвигается:
+// окно запрашивается заново целиком, частично прочитанные строки отбрасываются.
+type StreamRetry struct {
+	// Retries — число повторов после первой неудачи (0 — без повторов).
+	Retries int
+	// Backoff — пауза перед первым повтором, дальше удваивается (0 — 500ms, не более 10s).
+	Backoff time.Duration
+}
+
+// Window выполняет fetch и повторяет его, пока ошибка временная по retryable
+// (nil — IsTransient) и не исчерпаны повторы. attempt — номер попытки с 0.
+// Остальные ошибки возвращаются сразу.
+func (r StreamRetry) Window(ctx context.Context, retryable func(error) bool, fetch func(attempt int) ([]SensorEvent, error)) ([]SensorEvent, error) {
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	backoff := r.Backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		events, err := fetch(attempt)
+		if err == nil {
+			return events, nil
+		}
+		if attempt >= r.Retries || ctx.Err() != nil || !retryable(err) {
+			return nil, err
+		}
+		log.Printf("[storage] stream window failed (attempt %d/%d), retrying in %s: %v", attempt+1, r.Retries+1, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// defaultConnectBackoff — пауза перед первым повтором подключения при старте по умолчанию.
+const defaultConnectBackoff = time.Second
+
+// ConnectRetry задаёт ожидание БД при старте (--db-connect-retries, --db-connect-backoff):
+// в compose/k8s база может подняться позже timemachine.
+type ConnectRetry struct {
+	// Retries — число повторов после первой неудачи (0 — без повторов).
+	Retries int
+	// Backoff — пауза перед первым повтором, дальше удваивается (0 — 1s, не более 10s).
+	Backoff time.Duration
+}
+
+// Do выполняет connect и повторяет его, пока ошибка временная по retryable (nil — IsTransient)
+// и не исчерпаны повторы. Отмена ctx прерывает ожидание; возвращается последняя ошибка подключения.
+func (r ConnectRetry) Do(ctx context.Context, name string, retryable func(error) bool, connect func() error) error {
+	if retryable == nil {
+		retryable = IsTransient
+	}
+	backoff := r.Backoff
+	if backoff <= 0 {
+		backoff = defaultConnectBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		err := connect()
+		if err == nil {
+			return nil
+		}
+		if attempt >= r.Retries || ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		log.Printf("[storage] %s is not available (attempt %d/%d), retrying in %s: %v", name, attempt+1, r.Retries+1, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// IsTransient сообщает, похожа ли ошибка на временный сбой соединения, после которого
+// запрос имеет смысл повторить. Отмена контекста, ошибки SQL и авторизации временными не считаются.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -1,28 +1,74 @@
 package sqlite
 
 import (
+	"compress/gzip"
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "modernc.org/sqlite"
+	sqlitelib "modernc.org/sqlite"
 
 	"github.com/pv/uniset-timemachine-go/internal/storage"
 	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
 const (
-	filterTable      = "tm_sensors"
+	// filterRef — ссылка на таблицу фильтра в запросах; заменяется на имя из Config.FilterTable (withFilter).
+	filterRef        = "temp." + storage.FilterTableDefault
 	defaultWindowDur = time.Minute
+
+	// Первичные коды результата SQLite, при которых запрос можно повторить.
+	sqliteBusy   = 5
+	sqliteLocked = 6
 )
 
 type Config struct {
-	Source   string
-	Pragmas  Pragmas
-	Registry *config.SensorRegistry // реестр датчиков для конвертации hash↔configID
+	Source         string
+	Pragmas        Pragmas
+	Registry       *config.SensorRegistry // реестр датчиков для конвертации hash↔configID
+	WarmupLookback time.Duration          // глубина поиска значений для Warmup (0 — без ограничения)
+	// UndefinedColumn — колонка main_history с признаком неопределённого состояния (ненулевое значение — undefined).
+	// Пусто — все значения считаются определёнными.
+	UndefinedColumn string
+	// ValueColumn — колонка main_history, которая читается как значение датчика (пусто — value).
+	// Наличие колонки проверяется по PRAGMA table_info при открытии.
+	ValueColumn string
+	// WindowTargetRows — целевое число строк за окно при автоподборе (Window == 0); 0 — storage.DefaultWindowTargetRows.
+	WindowTargetRows int
+	// MaxRowsPerWindow — предел строк одного запроса окна Stream: окно, в котором строк больше,
+	// делится по времени на части (см. storage.ReadCapped); 0 — без ограничения.
+	MaxRowsPerWindow int
+	// TimeLayouts — дополнительные форматы колонки timestamp (layout пакета time),
+	// проверяются после встроенных RFC3339Nano, RFC3339 и "2006-01-02 15:04:05".
+	TimeLayouts []string
+	// TimeZone — часовой пояс для меток без зоны (nil — UTC).
+	TimeZone *time.Location
+	// TmpDir — каталог для распаковки архива .gz (пусто — системный временный каталог).
+	TmpDir string
+	// StreamRetries — число повторов запроса окна Stream при временных ошибках (0 — без повторов).
+	StreamRetries int
+	// IDMode — что хранится в sensor_id: ID из конфига или hash (IDModeAuto — определить по таблице).
+	IDMode storage.IDMode
+	// FilterTable — имя временной таблицы фильтра датчиков (пусто — storage.FilterTableDefault).
+	// Таблица создаётся в схеме temp и не пересекается с одноимённой таблицей базы.
+	FilterTable string
+}
+
+// defaultTimeLayouts — встроенные форматы колонки timestamp.
+var defaultTimeLayouts = []string{
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02 15:04:05",
 }
 
 // Pragmas настраивают кеш и режимы SQLite.
@@ -34,10 +80,22 @@ type Pragmas struct {
 }
 
 type Store struct {
-	db         *sql.DB
-	stmtWarmup *sql.Stmt
-	stmtWindow *sql.Stmt
-	registry   *config.SensorRegistry
+	db             *sql.DB
+	stmtWarmup     *sql.Stmt
+	stmtWindow     *sql.Stmt
+	registry       *config.SensorRegistry
+	warmupLookback time.Duration
+	undefinedExpr  string // выражение признака undefined в запросах
+	valueColumn    string // колонка значения (storage.ValueDefault по умолчанию)
+	windowTarget   int    // целевое число строк за окно при автоподборе
+	maxRows        int    // предел строк одного запроса окна (0 — без ограничения)
+	timeLayouts    []string
+	timeZone       *time.Location
+	tmpFile        string // распакованная копия архива, удаляется в Close
+	retry          storage.StreamRetry
+	idMode         storage.IDMode // содержимое sensor_id при заданном реестре
+	filterTable    string         // временная таблица фильтра датчиков (схема temp)
+	timeKey        int64          // номер в timeParsers, если время считает tsMicroFunc (0 — strftime)
 }
 
 // RangeWithUnknown реализует UnknownAwareStorage: дополнительно считает неизвестные датчики в окне.
@@ -53,18 +111,9 @@ func (s *Store) RangeWithUnknown(ctx context.Context, sensors []int64, from, to
 	// Считаем общее число уникальных sensor_id в окне без фильтра и сравниваем с числом известных
 	// (по рабочему списку). Если в истории есть sensor_id, отсутствующие в конфиге, они попадут
 	// в unknown (all - known).
-	args := []interface{}{}
-	var where string
-	if !from.IsZero() {
-		args = append(args, from.Format(time.RFC3339Nano))
-		where += " AND (strftime('%s', timestamp) * 1000000 + COALESCE(time_usec, 0)) >= strftime('%s', ?) * 1000000"
-	}
-	if !to.IsZero() {
-		args = append(args, to.Format(time.RFC3339Nano))
-		where += " AND (strftime('%s', timestamp) * 1000000 + COALESCE(time_usec, 0)) <= strftime('%s', ?) * 1000000"
-	}
+	where, args := periodCond(from, to)
 	var total int64
-	if err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(DISTINCT sensor_id) FROM main_history WHERE 1=1 %s`, where), args...).Scan(&total); err != nil {
+	if err := s.db.QueryRowContext(ctx, s.withTime(`SELECT COUNT(DISTINCT sensor_id) FROM main_history WHERE 1=1`+where), args...).Scan(&total); err != nil {
 		return minTs, maxTs, count, 0, fmt.Errorf("sqlite: unknown sensors count: %w", err)
 	}
 	unknown := total - count
@@ -79,12 +128,24 @@ func New(ctx context.Context, cfg Config) (*Store, error) {
 		return nil, fmt.Errorf("sqlite: database path is empty")
 	}
 
-	// SQLite требует ID в конфиге для работы с таблицей main_history
-	if cfg.Registry != nil && !cfg.Registry.HasIDs() {
-		return nil, fmt.Errorf("sqlite: config must have sensor IDs (idfromfile != 0 for all sensors)")
+	source := cfg.Source
+	var tmpFile string
+	if isGzip(source) {
+		path, err := unpackGzip(source, cfg.TmpDir)
+		if err != nil {
+			return nil, err
+		}
+		source, tmpFile = path, path
 	}
+	opened := false
+	defer func() {
+		// при ошибке открытия распакованная копия не нужна
+		if !opened && tmpFile != "" {
+			os.Remove(tmpFile)
+		}
+	}()
 
-	db, err := sql.Open("sqlite", cfg.Source)
+	db, err := sql.Open("sqlite", source)
 	if err != nil {
 		return nil, fmt.Errorf("sqlite: open: %w", err)
 	}
@@ -96,7 +157,45 @@ func New(ctx context.Context, cfg Config) (*Store, error) {
 		db.Close()
 		return nil, err
 	}
-	store := &Store{db: db, registry: cfg.Registry}
+	undefinedExpr, err := storage.UndefinedExpr(cfg.UndefinedColumn, `(COALESCE("%s", 0) != 0)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	valueColumn, err := storage.ValueColumn(cfg.ValueColumn)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	filterTable, err := storage.FilterTable(cfg.FilterTable)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	store := &Store{
+		db:             db,
+		filterTable:    filterTable,
+		registry:       cfg.Registry,
+		warmupLookback: cfg.WarmupLookback,
+		undefinedExpr:  undefinedExpr,
+		valueColumn:    valueColumn,
+		windowTarget:   cfg.WindowTargetRows,
+		maxRows:        cfg.MaxRowsPerWindow,
+		retry:          storage.StreamRetry{Retries: cfg.StreamRetries},
+		timeLayouts:    append(append([]string(nil), defaultTimeLayouts...), cfg.TimeLayouts...),
+		timeZone:       cfg.TimeZone,
+		tmpFile:        tmpFile,
+	}
+	if store.timeZone == nil {
+		store.timeZone = time.UTC
+	}
+	if len(cfg.TimeLayouts) > 0 || store.timeZone != time.UTC {
+		store.timeKey = timeParserSeq.Add(1)
+	}
+	if err := store.checkValueColumn(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
 	if err := store.ensureFilterTable(ctx); err != nil {
 		db.Close()
 		return nil, err
@@ -105,13 +204,56 @@ func New(ctx context.Context, cfg Config) (*Store, error) {
 		db.Close()
 		return nil, err
 	}
+	if err := store.resolveIDMode(ctx, cfg.IDMode); err != nil {
+		db.Close()
+		return nil, err
+	}
 	if err := store.prepareStatements(ctx); err != nil {
 		db.Close()
 		return nil, err
 	}
+	if store.timeKey != 0 {
+		timeParsers.Store(store.timeKey, store)
+	}
+	opened = true
 	return store, nil
 }
 
+// isGzip сообщает, что источник — сжатый gzip архив базы.
+func isGzip(src string) bool {
+	return strings.HasSuffix(strings.ToLower(src), ".gz")
+}
+
+// unpackGzip распаковывает архив src во временный файл в dir и возвращает его путь.
+// Для больших архивов в dir нужно место под полный размер базы.
+func unpackGzip(src, dir string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("sqlite: open archive: %w", err)
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return "", fmt.Errorf("sqlite: gzip %s: %w", src, err)
+	}
+	defer zr.Close()
+	out, err := os.CreateTemp(dir, "timemachine-*.db")
+	if err != nil {
+		return "", fmt.Errorf("sqlite: create temp file: %w", err)
+	}
+	started := time.Now()
+	n, err := io.Copy(out, zr)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("sqlite: decompress %s: %w", src, err)
+	}
+	log.Printf("[sqlite] decompressed %s to %s (%d MB) in %s", src, out.Name(), n>>20, time.Since(started).Round(time.Millisecond))
+	return out.Name(), nil
+}
+
 func (s *Store) Close() {
 	if s.stmtWarmup != nil {
 		s.stmtWarmup.Close()
@@ -122,11 +264,66 @@ func (s *Store) Close() {
 	if s.db != nil {
 		s.db.Close()
 	}
+	if s.timeKey != 0 {
+		timeParsers.Delete(s.timeKey)
+	}
+	if s.tmpFile != "" {
+		os.Remove(s.tmpFile)
+		s.tmpFile = ""
+	}
 }
 
-// hashToConfigIDs конвертирует hashes в configIDs для SQL запросов.
-func (s *Store) hashToConfigIDs(hashes []int64) ([]int64, error) {
+// Ping реализует storage.Pinger.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("sqlite: ping: %w", err)
+	}
+	return nil
+}
+
+// resolveIDMode выбирает, что хранится в sensor_id: ID из конфига или hash датчика.
+// Без реестра значения используются как есть (legacy-режим).
+func (s *Store) resolveIDMode(ctx context.Context, mode storage.IDMode) error {
 	if s.registry == nil {
+		return nil
+	}
+	if mode == storage.IDModeAuto {
+		rows, err := s.db.QueryContext(ctx, `SELECT sensor_id FROM main_history LIMIT ?`, storage.IDModeSampleSize)
+		if err != nil {
+			return fmt.Errorf("sqlite: sample sensor ids: %w", err)
+		}
+		sample, err := scanSensorIDs(rows)
+		if err != nil {
+			return fmt.Errorf("sqlite: sample sensor ids: %w", err)
+		}
+		mode = storage.DetectIDMode(sample, s.registry)
+		log.Printf("[sqlite] sensor_id mode: %s (detected from %d rows)", mode, len(sample))
+	}
+	if mode == storage.IDModeConfigID && !s.registry.HasIDs() {
+		return fmt.Errorf("sqlite: config must have sensor IDs (idfromfile != 0 for all sensors) unless sensor_id holds hashes (--id-mode hash)")
+	}
+	s.idMode = mode
+	return nil
+}
+
+// scanSensorIDs читает одну колонку sensor_id и закрывает rows.
+func scanSensorIDs(rows *sql.Rows) ([]int64, error) {
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// hashToConfigIDs конвертирует hashes в значения sensor_id для SQL запросов:
+// configIDs, а в hash-режиме — сами hashes.
+func (s *Store) hashToConfigIDs(hashes []int64) ([]int64, error) {
+	if s.registry == nil || s.idMode == storage.IDModeHash {
 		return hashes, nil // legacy mode - hashes уже являются configIDs
 	}
 	result := make([]int64, 0, len(hashes))
@@ -143,10 +340,10 @@ func (s *Store) hashToConfigIDs(hashes []int64) ([]int64, error) {
 	return result, nil
 }
 
-// configIDToHash конвертирует configID из результата SQL в hash.
+// configIDToHash конвертирует sensor_id из результата SQL в hash.
 func (s *Store) configIDToHash(configID int64) int64 {
-	if s.registry == nil {
-		return configID // legacy mode
+	if s.registry == nil || s.idMode == storage.IDModeHash {
+		return configID // legacy mode или sensor_id уже hash
 	}
 	if key, ok := s.registry.ByConfigID(configID); ok {
 		return key.Hash
@@ -160,7 +357,11 @@ func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]
 		return nil, err
 	}
 
-	args := []any{from.UnixMicro()}
+	lower := int64(math.MinInt64)
+	if s.warmupLookback > 0 {
+		lower = from.Add(-s.warmupLookback).UnixMicro()
+	}
+	args := []any{from.UnixMicro(), lower}
 	rows, err := s.stmtWarmup.QueryContext(ctx, args...)
 	if err != nil {
 		return nil, fmt.Errorf("sqlite: warmup query: %w", err)
@@ -173,10 +374,11 @@ func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]
 		var ts string
 		var usec sql.NullInt64
 		var value float64
-		if err := rows.Scan(&sensorID, &ts, &usec, &value); err != nil {
+		var undefined bool
+		if err := rows.Scan(&sensorID, &ts, &usec, &value, &undefined); err != nil {
 			return nil, fmt.Errorf("sqlite: warmup scan: %w", err)
 		}
-		parsed, err := parseTimestamp(ts, usec.Int64)
+		parsed, err := s.parseTimestamp(ts, usec.Int64)
 		if err != nil {
 			return nil, err
 		}
@@ -184,6 +386,7 @@ func (s *Store) Warmup(ctx context.Context, sensors []int64, from time.Time) ([]
 			SensorID:  s.configIDToHash(sensorID), // конвертируем в hash
 			Timestamp: parsed,
 			Value:     value,
+			Undefined: undefined,
 		})
 	}
 	return events, rows.Err()
@@ -203,9 +406,12 @@ func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan [
 			return
 		}
 
+		// Window == 0 — автоподбор окна под целевое число строк.
 		window := req.Window
+		var tuner *storage.WindowTuner
 		if window <= 0 {
-			window = defaultWindowDur
+			tuner = storage.NewWindowTuner(defaultWindowDur, s.windowTarget)
+			window = tuner.Window()
 		}
 
 		cursor := req.From
@@ -215,48 +421,32 @@ func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan [
 				next = req.To
 			}
 
-			rows, err := s.stmtWindow.QueryContext(ctx, cursor.UnixMicro(), next.UnixMicro())
-			if err != nil {
-				errCh <- fmt.Errorf("sqlite: window query: %w", err)
-				return
-			}
-
-			chunk := make([]storage.SensorEvent, 0, 128)
-			for rows.Next() {
-				var sensorID int64
-				var ts string
-				var usec sql.NullInt64
-				var value float64
-				if err := rows.Scan(&sensorID, &ts, &usec, &value); err != nil {
-					rows.Close()
-					errCh <- fmt.Errorf("sqlite: window scan: %w", err)
-					return
-				}
-				parsed, err := parseTimestamp(ts, usec.Int64)
-				if err != nil {
-					rows.Close()
-					errCh <- err
-					return
-				}
-				chunk = append(chunk, storage.SensorEvent{
-					SensorID:  s.configIDToHash(sensorID), // конвертируем в hash
-					Timestamp: parsed,
-					Value:     value,
+			read := func(from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+				return s.retry.Window(ctx, isRetryable, func(attempt int) ([]storage.SensorEvent, error) {
+					// Временная таблица фильтра живёт в соединении: после переподключения её нужно заполнить заново.
+					if attempt > 0 {
+						if err := s.resetFilter(ctx, req.Sensors); err != nil {
+							return nil, err
+						}
+					}
+					return s.queryWindow(ctx, from, to, limit)
 				})
 			}
-			rows.Close()
-			if err := rows.Err(); err != nil {
-				errCh <- fmt.Errorf("sqlite: rows err: %w", err)
-				return
-			}
-
-			if len(chunk) > 0 {
+			rows, err := storage.ReadCapped(cursor, next, s.maxRows, read, func(chunk []storage.SensorEvent) error {
 				select {
 				case <-ctx.Done():
-					errCh <- ctx.Err()
-					return
+					return ctx.Err()
 				case dataCh <- chunk:
+					return nil
 				}
+			})
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if tuner != nil {
+				tuner.Observe(next.Sub(cursor), rows)
+				window = tuner.Window()
 			}
 
 			if !next.After(cursor) {
@@ -269,8 +459,99 @@ func (s *Store) Stream(ctx context.Context, req storage.StreamRequest) (<-chan [
 	return dataCh, errCh
 }
 
+// queryWindow читает записи отфильтрованных датчиков в окне [from, to); limit > 0 — не больше limit строк.
+func (s *Store) queryWindow(ctx context.Context, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	rows, err := s.stmtWindow.QueryContext(ctx, from.UnixMicro(), to.UnixMicro())
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: window query: %w", err)
+	}
+	defer rows.Close()
+
+	chunk := make([]storage.SensorEvent, 0, 128)
+	for (limit <= 0 || len(chunk) < limit) && rows.Next() {
+		var sensorID int64
+		var ts string
+		var usec sql.NullInt64
+		var value float64
+		var undefined bool
+		if err := rows.Scan(&sensorID, &ts, &usec, &value, &undefined); err != nil {
+			return nil, fmt.Errorf("sqlite: window scan: %w", err)
+		}
+		parsed, err := s.parseTimestamp(ts, usec.Int64)
+		if err != nil {
+			return nil, err
+		}
+		chunk = append(chunk, storage.SensorEvent{
+			SensorID:  s.configIDToHash(sensorID), // конвертируем в hash
+			Timestamp: parsed,
+			Value:     value,
+			Undefined: undefined,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite: rows err: %w", err)
+	}
+	return chunk, nil
+}
+
+// isRetryable — временные ошибки SQLite: занятая или заблокированная база (SQLITE_BUSY, SQLITE_LOCKED)
+// и обрыв соединения.
+func isRetryable(err error) bool {
+	var coded interface{ Code() int }
+	if errors.As(err, &coded) {
+		switch coded.Code() & 0xff {
+		case sqliteBusy, sqliteLocked:
+			return true
+		}
+	}
+	return storage.IsTransient(err)
+}
+
+// EventsFor реализует EventHistoryStorage: сырые записи одного датчика в окне [from, to].
+func (s *Store) EventsFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	return s.history(ctx, eventsSQL, sensor, from, to, limit)
+}
+
+// ChangesFor реализует ChangeHistoryStorage: только изменения значения одного датчика в окне [from, to].
+func (s *Store) ChangesFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	return s.history(ctx, changesSQL, sensor, from, to, limit)
+}
+
+// history выполняет запрос истории одного датчика (eventsSQL или changesSQL).
+func (s *Store) history(ctx context.Context, query string, sensor int64, from, to time.Time, limit int) ([]storage.SensorEvent, error) {
+	configIDs, err := s.hashToConfigIDs([]int64{sensor})
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = -1 // в SQLite отрицательный LIMIT означает «без ограничения»
+	}
+	rows, err := s.db.QueryContext(ctx, s.withTime(s.withColumns(query)), configIDs[0], from.UnixMicro(), to.UnixMicro(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: events query: %w", err)
+	}
+	defer rows.Close()
+
+	var events []storage.SensorEvent
+	for rows.Next() {
+		var ts string
+		var usec sql.NullInt64
+		var value float64
+		var undefined bool
+		if err := rows.Scan(&ts, &usec, &value, &undefined); err != nil {
+			return nil, fmt.Errorf("sqlite: events scan: %w", err)
+		}
+		parsed, err := s.parseTimestamp(ts, usec.Int64)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, storage.SensorEvent{SensorID: sensor, Timestamp: parsed, Value: value, Undefined: undefined})
+	}
+	return events, rows.Err()
+}
+
 func (s *Store) ensureFilterTable(ctx context.Context) error {
-	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TEMP TABLE IF NOT EXISTS %s(sensor_id INTEGER PRIMARY KEY)`, filterTable))
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TEMP TABLE IF NOT EXISTS %s(sensor_id INTEGER PRIMARY KEY)`, s.filterTable))
 	if err != nil {
 		return fmt.Errorf("sqlite: init filter table: %w", err)
 	}
@@ -291,22 +572,75 @@ func (s *Store) ensureIndexes(ctx context.Context) error {
 
 func (s *Store) prepareStatements(ctx context.Context) error {
 	var err error
-	s.stmtWarmup, err = s.db.PrepareContext(ctx, warmupSQL)
+	s.stmtWarmup, err = s.db.PrepareContext(ctx, s.withTime(s.withColumns(s.withFilter(warmupSQL))))
 	if err != nil {
 		return fmt.Errorf("sqlite: prepare warmup: %w", err)
 	}
-	s.stmtWindow, err = s.db.PrepareContext(ctx, windowSQL)
+	s.stmtWindow, err = s.db.PrepareContext(ctx, s.withTime(s.withColumns(s.withFilter(windowSQL))))
 	if err != nil {
 		return fmt.Errorf("sqlite: prepare window: %w", err)
 	}
 	return nil
 }
 
+// withFilter подставляет имя таблицы фильтра из конфига вместо имени по умолчанию.
+func (s *Store) withFilter(query string) string {
+	return strings.Replace(query, filterRef, "temp."+s.filterTable, 1)
+}
+
+// withColumns подставляет колонку значения и выражение признака undefined вместо выражений по умолчанию.
+func (s *Store) withColumns(query string) string {
+	if s.valueColumn != "" && s.valueColumn != storage.ValueDefault {
+		query = strings.Replace(query, valueDefault, `"`+s.valueColumn+`" AS value`, 1)
+	}
+	if s.undefinedExpr == "" {
+		return query
+	}
+	return strings.Replace(query, storage.UndefinedDefault, s.undefinedExpr+" AS undefined", 1)
+}
+
+// withTime подставляет вычисление времени записи через tsMicroFunc вместо tsMicroSQL,
+// если в колонке timestamp возможны форматы TimeLayouts или метки в поясе TimeZone.
+func (s *Store) withTime(query string) string {
+	if s.timeKey == 0 {
+		return query
+	}
+	return strings.ReplaceAll(query, tsMicroSQL, fmt.Sprintf("%s(%d, timestamp, time_usec)", tsMicroFunc, s.timeKey))
+}
+
+// checkValueColumn проверяет по PRAGMA table_info, что в main_history есть колонка значения.
+// Колонку по умолчанию не проверяет: без неё таблица не является историей UniSet.
+func (s *Store) checkValueColumn(ctx context.Context) error {
+	if s.valueColumn == storage.ValueDefault {
+		return nil
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT name FROM pragma_table_info('main_history')`)
+	if err != nil {
+		return fmt.Errorf("sqlite: table info: %w", err)
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("sqlite: table info: %w", err)
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("sqlite: table info: %w", err)
+	}
+	if err := storage.RequireColumn("main_history", s.valueColumn, columns); err != nil {
+		return fmt.Errorf("sqlite: value column: %w", err)
+	}
+	return nil
+}
+
 func (s *Store) resetFilter(ctx context.Context, sensors []int64) error {
 	if err := s.ensureFilterTable(ctx); err != nil {
 		return err
 	}
-	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s`, filterTable)); err != nil {
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`DELETE FROM temp.%s`, s.filterTable)); err != nil {
 		return fmt.Errorf("sqlite: failed to clear filter: %w", err)
 	}
 	if len(sensors) == 0 {
@@ -323,7 +657,7 @@ func (s *Store) resetFilter(ctx context.Context, sensors []int64) error {
 	if err != nil {
 		return fmt.Errorf("sqlite: begin tx: %w", err)
 	}
-	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT OR REPLACE INTO %s(sensor_id) VALUES (?)`, filterTable))
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT OR REPLACE INTO temp.%s(sensor_id) VALUES (?)`, s.filterTable))
 	if err != nil {
 		tx.Rollback()
 		return fmt.Errorf("sqlite: prepare insert: %w", err)
@@ -367,46 +701,122 @@ func applyPragmas(ctx context.Context, db *sql.DB, p Pragmas) error {
 	return nil
 }
 
-func parseTimestamp(raw string, usec int64) (time.Time, error) {
-	layouts := []string{
-		time.RFC3339Nano,
-		time.RFC3339,
-		"2006-01-02 15:04:05",
+// parseTimestamp разбирает метку времени по встроенным и настроенным форматам.
+// Метки без зоны трактуются в часовом поясе хранилища (по умолчанию UTC).
+// usec (time_usec) добавляется, только если в самой метке нет дробной части секунды.
+func (s *Store) parseTimestamp(raw string, usec int64) (time.Time, error) {
+	loc := s.timeZone
+	if loc == nil {
+		loc = time.UTC
+	}
+	layouts := s.timeLayouts
+	if len(layouts) == 0 {
+		layouts = defaultTimeLayouts
 	}
 	var parsed time.Time
 	var err error
 	for _, layout := range layouts {
-		parsed, err = time.Parse(layout, strings.TrimSpace(raw))
+		parsed, err = time.ParseInLocation(layout, strings.TrimSpace(raw), loc)
 		if err == nil {
+			// Дробная часть в самой метке важнее time_usec (см. tsMicroSQL).
+			if parsed.Nanosecond()/int(time.Microsecond) != 0 {
+				return parsed, nil
+			}
 			return parsed.Add(time.Duration(usec) * time.Microsecond), nil
 		}
 	}
 	return time.Time{}, fmt.Errorf("sqlite: unknown timestamp format %q: %v", raw, err)
 }
 
+// fracUsecSQL — дробная часть секунды из текстовой метки timestamp в микросекундах:
+// "…12:30:00.5Z" → 500000, "…12:30:00.123456789" → 123456, без точки — 0.
+// Цифры после точки считаются явно: strftime('%f') даёт только миллисекунды.
+const fracUsecSQL = `CASE
+	WHEN instr(timestamp, '.') = 0 THEN 0
+	WHEN substr(timestamp, instr(timestamp, '.') + 1) GLOB '[0-9][0-9][0-9][0-9][0-9][0-9]*' THEN CAST(substr(timestamp, instr(timestamp, '.') + 1, 6) AS INTEGER)
+	WHEN substr(timestamp, instr(timestamp, '.') + 1) GLOB '[0-9][0-9][0-9][0-9][0-9]*' THEN CAST(substr(timestamp, instr(timestamp, '.') + 1, 5) AS INTEGER) * 10
+	WHEN substr(timestamp, instr(timestamp, '.') + 1) GLOB '[0-9][0-9][0-9][0-9]*' THEN CAST(substr(timestamp, instr(timestamp, '.') + 1, 4) AS INTEGER) * 100
+	WHEN substr(timestamp, instr(timestamp, '.') + 1) GLOB '[0-9][0-9][0-9]*' THEN CAST(substr(timestamp, instr(timestamp, '.') + 1, 3) AS INTEGER) * 1000
+	WHEN substr(timestamp, instr(timestamp, '.') + 1) GLOB '[0-9][0-9]*' THEN CAST(substr(timestamp, instr(timestamp, '.') + 1, 2) AS INTEGER) * 10000
+	WHEN substr(timestamp, instr(timestamp, '.') + 1) GLOB '[0-9]*' THEN CAST(substr(timestamp, instr(timestamp, '.') + 1, 1) AS INTEGER) * 100000
+	ELSE 0
+END`
+
+// tsMicroSQL — время записи в микросекундах Unix. В истории встречаются два соглашения:
+// полная метка RFC3339Nano в timestamp при пустом time_usec и метка с точностью до секунды
+// плюс time_usec. Если timestamp несёт дробную часть секунды, time_usec игнорируется,
+// иначе добавляется — так одна запись не учитывает микросекунды дважды (как и parseTimestamp).
+const tsMicroSQL = `(strftime('%s', timestamp) * 1000000 + COALESCE(NULLIF(` + fracUsecSQL + `, 0), time_usec, 0))`
+
+// tsMicroFunc — функция SQLite, которая считает время записи в микросекундах Unix тем же разбором,
+// что и parseTimestamp хранилища с номером из первого аргумента. strftime в tsMicroSQL понимает
+// только ISO-форматы и считает метки без зоны UTC, поэтому для форматов TimeLayouts и пояса
+// TimeZone окна, warmup и диапазон отбираются через неё.
+const tsMicroFunc = "tm_ts_micro"
+
+var (
+	timeParsers   sync.Map // номер хранилища → *Store, время которого считает tsMicroFunc
+	timeParserSeq atomic.Int64
+)
+
+func init() {
+	sqlitelib.MustRegisterDeterministicScalarFunction(tsMicroFunc, 3, tsMicro)
+}
+
+// tsMicro — реализация tsMicroFunc(store, timestamp, time_usec). Пустая или неразборчивая
+// метка даёт NULL, как strftime: такая запись не попадает ни в одно окно.
+func tsMicro(_ *sqlitelib.FunctionContext, args []driver.Value) (driver.Value, error) {
+	key, _ := args[0].(int64)
+	store, ok := timeParsers.Load(key)
+	if !ok {
+		return nil, fmt.Errorf("sqlite: %s: store %d is closed", tsMicroFunc, key)
+	}
+	var raw string
+	switch ts := args[1].(type) {
+	case string:
+		raw = ts
+	case []byte:
+		raw = string(ts)
+	default:
+		return nil, nil
+	}
+	usec, _ := args[2].(int64)
+	parsed, err := store.(*Store).parseTimestamp(raw, usec)
+	if err != nil {
+		return nil, nil
+	}
+	return parsed.UnixMicro(), nil
+}
+
+// valueDefault — выражение значения в запросах, когда колонка значения не переопределена (ValueColumn).
+const valueDefault = storage.ValueDefault + " AS value"
+
 const warmupSQL = `
 WITH base AS (
 	SELECT sensor_id,
 	       timestamp AS ts,
 	       COALESCE(time_usec, 0) AS usec,
-	       (strftime('%s', timestamp) * 1000000 + COALESCE(time_usec, 0)) AS ts_micro,
-	       value
+	       ` + tsMicroSQL + ` AS ts_micro,
+	       ` + valueDefault + `,
+	       ` + storage.UndefinedDefault + `
 	FROM main_history
-	WHERE sensor_id IN (SELECT sensor_id FROM ` + filterTable + `)
+	WHERE sensor_id IN (SELECT sensor_id FROM ` + filterRef + `)
 ),
 ranked AS (
 	SELECT sensor_id,
 	       ts,
 	       usec,
 	       value,
+	       undefined,
 	       ROW_NUMBER() OVER (
 	           PARTITION BY sensor_id
-	           ORDER BY ts_micro DESC
+	           ORDER BY ts_micro DESC, undefined ASC, value DESC
 	       ) AS rn
 	FROM base
 	WHERE ts_micro <= ?
+	  AND ts_micro >= ?
 )
-SELECT sensor_id, ts, usec, value
+SELECT sensor_id, ts, usec, value, undefined
 FROM ranked
 WHERE rn = 1;
 `
@@ -416,36 +826,75 @@ WITH base AS (
 	SELECT sensor_id,
 	       timestamp,
 	       COALESCE(time_usec, 0) AS usec,
-	       (strftime('%s', timestamp) * 1000000 + COALESCE(time_usec, 0)) AS ts_micro,
-	       value
+	       ` + tsMicroSQL + ` AS ts_micro,
+	       ` + valueDefault + `,
+	       ` + storage.UndefinedDefault + `
 	FROM main_history
-	WHERE sensor_id IN (SELECT sensor_id FROM ` + filterTable + `)
+	WHERE sensor_id IN (SELECT sensor_id FROM ` + filterRef + `)
 )
 SELECT sensor_id,
        timestamp,
        usec,
-       value
+       value,
+       undefined
 FROM base
 WHERE ts_micro >= ?
   AND ts_micro < ?
 ORDER BY ts_micro, sensor_id;
 `
 
+const eventsSQL = `
+SELECT timestamp,
+       COALESCE(time_usec, 0) AS usec,
+       ` + valueDefault + `,
+       ` + storage.UndefinedDefault + `
+FROM main_history
+WHERE sensor_id = ?
+  AND ` + tsMicroSQL + ` >= ?
+  AND ` + tsMicroSQL + ` <= ?
+ORDER BY ` + tsMicroSQL + `
+LIMIT ?;
+`
+
+// changesSQL — eventsSQL без повторов: первая запись окна и записи, значение или undefined
+// которых отличается от предыдущей.
+const changesSQL = `
+WITH events AS (
+	SELECT timestamp,
+	       COALESCE(time_usec, 0) AS usec,
+	       ` + tsMicroSQL + ` AS ts_micro,
+	       ` + valueDefault + `,
+	       ` + storage.UndefinedDefault + `
+	FROM main_history
+	WHERE sensor_id = ?
+	  AND ` + tsMicroSQL + ` >= ?
+	  AND ` + tsMicroSQL + ` <= ?
+),
+marked AS (
+	SELECT timestamp,
+	       usec,
+	       ts_micro,
+	       value,
+	       undefined,
+	       ROW_NUMBER() OVER w AS rn,
+	       LAG(value) OVER w AS prev_value,
+	       LAG(undefined) OVER w AS prev_undefined
+	FROM events
+	WINDOW w AS (ORDER BY ts_micro)
+)
+SELECT timestamp, usec, value, undefined
+FROM marked
+WHERE rn = 1 OR value IS NOT prev_value OR undefined IS NOT prev_undefined
+ORDER BY ts_micro
+LIMIT ?;
+`
+
 func (s *Store) Range(ctx context.Context, sensors []int64, from, to time.Time) (time.Time, time.Time, int64, error) {
 	if err := s.resetFilter(ctx, sensors); err != nil {
 		return time.Time{}, time.Time{}, 0, err
 	}
-	args := []interface{}{}
-	var where string
-	if !from.IsZero() {
-		args = append(args, from.Format(time.RFC3339Nano))
-		where += " AND (strftime('%s', timestamp) * 1000000 + COALESCE(time_usec, 0)) >= strftime('%s', ?) * 1000000"
-	}
-	if !to.IsZero() {
-		args = append(args, to.Format(time.RFC3339Nano))
-		where += " AND (strftime('%s', timestamp) * 1000000 + COALESCE(time_usec, 0)) <= strftime('%s', ?) * 1000000"
-	}
-	row := s.db.QueryRowContext(ctx, fmt.Sprintf(rangeSQL, where), args...)
+	where, args := periodCond(from, to)
+	row := s.db.QueryRowContext(ctx, s.withTime(strings.Replace(s.withFilter(rangeSQL), rangeWhere, where, 1)), args...)
 	var minTs, maxTs sql.NullString
 	var minUsec, maxUsec sql.NullInt64
 	if err := row.Scan(&minTs, &minUsec, &maxTs, &maxUsec); err != nil {
@@ -455,38 +904,79 @@ func (s *Store) Range(ctx context.Context, sensors []int64, from, to time.Time)
 		return time.Time{}, time.Time{}, 0, nil
 	}
 	var count int64
-	if err := s.db.QueryRowContext(ctx, fmt.Sprintf(countSQL, where), args...).Scan(&count); err != nil {
+	if err := s.db.QueryRowContext(ctx, s.withTime(fmt.Sprintf(s.withFilter(countSQL), where)), args...).Scan(&count); err != nil {
 		return time.Time{}, time.Time{}, 0, fmt.Errorf("sqlite: sensor count: %w", err)
 	}
-	minTime, err := parseTimestamp(minTs.String, minUsec.Int64)
+	minTime, err := s.parseTimestamp(minTs.String, minUsec.Int64)
 	if err != nil {
 		return time.Time{}, time.Time{}, 0, err
 	}
-	maxTime, err := parseTimestamp(maxTs.String, maxUsec.Int64)
+	maxTime, err := s.parseTimestamp(maxTs.String, maxUsec.Int64)
 	if err != nil {
 		return time.Time{}, time.Time{}, 0, err
 	}
 	return minTime, maxTime, count, nil
 }
 
+// ListSensors реализует storage.SensorLister: различные sensor_id в окне [from, to] как есть,
+// без фильтра по конфигу.
+func (s *Store) ListSensors(ctx context.Context, from, to time.Time) ([]storage.SensorIdentity, error) {
+	where, args := periodCond(from, to)
+	rows, err := s.db.QueryContext(ctx, s.withTime(`SELECT DISTINCT sensor_id FROM main_history WHERE 1=1`+where+` ORDER BY sensor_id`), args...)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: list sensors: %w", err)
+	}
+	defer rows.Close()
+	var out []storage.SensorIdentity
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("sqlite: list sensors scan: %w", err)
+		}
+		out = append(out, storage.SensorIdentity{ID: id})
+	}
+	return out, rows.Err()
+}
+
+// periodCond — условия на период [from, to] по времени записи в микросекундах Unix
+// (нулевая граница не ограничивает) и их аргументы.
+func periodCond(from, to time.Time) (string, []any) {
+	var where string
+	var args []any
+	if !from.IsZero() {
+		where += " AND " + tsMicroSQL + " >= ?"
+		args = append(args, from.UnixMicro())
+	}
+	if !to.IsZero() {
+		where += " AND " + tsMicroSQL + " <= ?"
+		args = append(args, to.UnixMicro())
+	}
+	return where, args
+}
+
+// rangeWhere — место условий по периоду в rangeSQL; подставляется через strings.Replace,
+// а не fmt.Sprintf, потому что tsMicroSQL содержит strftime('%s', …).
+const rangeWhere = "/* where */"
+
 const rangeSQL = `
 WITH filtered AS (
 	SELECT timestamp,
-	       COALESCE(time_usec, 0) AS usec
+	       COALESCE(time_usec, 0) AS usec,
+	       ` + tsMicroSQL + ` AS ts_micro
 	FROM main_history
-	WHERE sensor_id IN (SELECT sensor_id FROM ` + filterTable + `)
-	%s
+	WHERE sensor_id IN (SELECT sensor_id FROM ` + filterRef + `)
+	` + rangeWhere + `
 ),
 min_row AS (
 	SELECT timestamp, usec
 	FROM filtered
-	ORDER BY timestamp, usec
+	ORDER BY ts_micro
 	LIMIT 1
 ),
 max_row AS (
 	SELECT timestamp, usec
 	FROM filtered
-	ORDER BY timestamp DESC, usec DESC
+	ORDER BY ts_micro DESC
 	LIMIT 1
 )
 SELECT
@@ -497,7 +987,7 @@ SELECT
 `
 
 const countSQL = `
-SELECT COUNT(DISTINCT sensor_id) FROM main_history WHERE sensor_id IN (SELECT sensor_id FROM ` + filterTable + `) %s;
+SELECT COUNT(DISTINCT sensor_id) FROM main_history WHERE sensor_id IN (SELECT sensor_id FROM ` + filterRef + `) %s;
 `
 
 func IsSource(src string) bool {
@@ -509,6 +999,7 @@ func IsSource(src string) bool {
 	case strings.HasPrefix(lower, "sqlite://"),
 		strings.HasPrefix(lower, "file:"),
 		strings.HasSuffix(lower, ".db"),
+		strings.HasSuffix(lower, ".db.gz"),
 		src == ":memory:":
 		return true
 	default:
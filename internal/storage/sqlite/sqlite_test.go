@@ -1,15 +1,20 @@
 package sqlite
 
 import (
+	"compress/gzip"
 	"context"
 	"database/sql"
+	"io"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	_ "modernc.org/sqlite"
 
 	"github.com/pv/uniset-timemachine-go/internal/storage"
+	"github.com/pv/uniset-timemachine-go/pkg/config"
 )
 
 func TestStoreWarmupStreamAndRange(t *testing.T) {
@@ -126,6 +131,8 @@ type historyRow struct {
 	ts       time.Time
 	usec     int64
 	value    float64
+	// text — метка timestamp как есть (вместо ts в RFC3339); при нулевом usec time_usec пишется NULL.
+	text string
 }
 
 func prepareSQLiteDB(t *testing.T, rows []historyRow) string {
@@ -159,8 +166,14 @@ CREATE TABLE main_history(
 	defer stmt.Close()
 
 	for _, row := range rows {
-		ts := row.ts.Format(time.RFC3339)
-		if _, err := stmt.Exec(row.sensorID, ts, row.usec, row.value); err != nil {
+		ts, usec := row.ts.Format(time.RFC3339), any(row.usec)
+		if row.text != "" {
+			ts = row.text
+			if row.usec == 0 {
+				usec = nil
+			}
+		}
+		if _, err := stmt.Exec(row.sensorID, ts, usec, row.value); err != nil {
 			db.Close()
 			t.Fatalf("insert row: %v", err)
 		}
@@ -171,3 +184,757 @@ CREATE TABLE main_history(
 	}
 	return path
 }
+
+func TestStoreIDModeLayouts(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	id := int64(10001)
+	withIDs := config.NewSensorRegistry()
+	key := withIDs.NewKey("Sensor10001_S", &id)
+	if err := withIDs.Add(key); err != nil {
+		t.Fatalf("registry add: %v", err)
+	}
+	// Реестр без ID из конфига раньше не допускался для SQLite вовсе.
+	noIDs := config.NewSensorRegistry()
+	if err := noIDs.Add(noIDs.NewKey("Sensor10001_S", nil)); err != nil {
+		t.Fatalf("registry add: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		registry *config.SensorRegistry
+		stored   int64 // значение sensor_id в таблице
+		mode     storage.IDMode
+	}{
+		{"configid auto", withIDs, id, storage.IDModeAuto},
+		{"hash auto", withIDs, key.Hash, storage.IDModeAuto},
+		{"hash without config ids", noIDs, key.Hash, storage.IDModeAuto},
+		{"hash explicit", withIDs, key.Hash, storage.IDModeHash},
+	}
+	for _, tc := range cases {
+		src := prepareSQLiteDB(t, []historyRow{
+			{sensorID: tc.stored, ts: start, value: 1},
+			{sensorID: tc.stored, ts: start.Add(time.Second), value: 2},
+		})
+		store, err := New(ctx, Config{Source: src, Registry: tc.registry, IDMode: tc.mode})
+		if err != nil {
+			t.Fatalf("%s: sqlite.New error: %v", tc.name, err)
+		}
+		events, err := store.Warmup(ctx, []int64{key.Hash}, start.Add(2*time.Second))
+		store.Close()
+		if err != nil {
+			t.Fatalf("%s: Warmup returned error: %v", tc.name, err)
+		}
+		if len(events) != 1 || events[0].SensorID != key.Hash || events[0].Value != 2 {
+			t.Fatalf("%s: Warmup events = %#v, want value 2 for hash %d", tc.name, events, key.Hash)
+		}
+	}
+
+	src := prepareSQLiteDB(t, []historyRow{{sensorID: key.Hash, ts: start, value: 1}})
+	if _, err := New(ctx, Config{Source: src, Registry: noIDs, IDMode: storage.IDModeConfigID}); err == nil {
+		t.Fatal("configid mode without config ids must fail")
+	}
+}
+
+func TestStoreEventsFor(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rows := []historyRow{
+		{sensorID: 10001, ts: start, value: 1},
+		{sensorID: 10001, ts: start.Add(time.Second), usec: 250000, value: 2},
+		{sensorID: 10002, ts: start.Add(2 * time.Second), value: 20},
+		{sensorID: 10001, ts: start.Add(3 * time.Second), value: 3},
+		{sensorID: 10001, ts: start.Add(9 * time.Second), value: 9},
+	}
+	src := prepareSQLiteDB(t, rows)
+	store, err := New(ctx, Config{Source: src})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	events, err := store.EventsFor(ctx, 10001, start, start.Add(3*time.Second), 0)
+	if err != nil {
+		t.Fatalf("EventsFor returned error: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %#v", len(events), events)
+	}
+	if ev := events[1]; ev.Value != 2 || !ev.Timestamp.Equal(start.Add(1250*time.Millisecond)) {
+		t.Fatalf("second event mismatch: %#v", ev)
+	}
+	for _, ev := range events {
+		if ev.SensorID != 10001 {
+			t.Fatalf("unexpected sensor in result: %#v", ev)
+		}
+	}
+
+	limited, err := store.EventsFor(ctx, 10001, start, start.Add(10*time.Second), 2)
+	if err != nil {
+		t.Fatalf("EventsFor with limit returned error: %v", err)
+	}
+	if len(limited) != 2 || limited[0].Value != 1 || limited[1].Value != 2 {
+		t.Fatalf("limited events mismatch: %#v", limited)
+	}
+}
+
+func TestStoreChangesFor(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var rows []historyRow
+	for i, v := range []float64{5, 5, 5, 7, 7, 5, 5} {
+		rows = append(rows, historyRow{sensorID: 10001, ts: start.Add(time.Duration(i) * time.Second), value: v})
+	}
+	rows = append(rows, historyRow{sensorID: 10002, ts: start.Add(500 * time.Millisecond), value: 7})
+	src := prepareSQLiteDB(t, rows)
+	store, err := New(ctx, Config{Source: src})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	// Первая запись окна остаётся всегда, даже если совпадает с записью до from.
+	changes, err := store.ChangesFor(ctx, 10001, start.Add(time.Second), start.Add(10*time.Second), 0)
+	if err != nil {
+		t.Fatalf("ChangesFor returned error: %v", err)
+	}
+	var got []float64
+	for _, ev := range changes {
+		got = append(got, ev.Value)
+	}
+	if len(got) != 3 || got[0] != 5 || got[1] != 7 || got[2] != 5 {
+		t.Fatalf("changes = %v, want [5 7 5]", got)
+	}
+	if !changes[1].Timestamp.Equal(start.Add(3 * time.Second)) {
+		t.Fatalf("change ts = %s, want +3s", changes[1].Timestamp)
+	}
+
+	limited, err := store.ChangesFor(ctx, 10001, start, start.Add(10*time.Second), 2)
+	if err != nil {
+		t.Fatalf("ChangesFor with limit returned error: %v", err)
+	}
+	if len(limited) != 2 || limited[1].Value != 7 {
+		t.Fatalf("limited changes = %#v, want [5 7]", limited)
+	}
+}
+
+func TestStoreWarmupLookback(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rows := []historyRow{
+		{sensorID: 10001, ts: start.Add(-2 * time.Hour), value: 1},
+		{sensorID: 10002, ts: start.Add(-10 * time.Minute), value: 2},
+	}
+	src := prepareSQLiteDB(t, rows)
+
+	unbounded, err := New(ctx, Config{Source: src})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(unbounded.Close)
+	events, err := unbounded.Warmup(ctx, []int64{10001, 10002}, start)
+	if err != nil {
+		t.Fatalf("Warmup returned error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("unbounded warmup expected 2 events, got %d", len(events))
+	}
+
+	bounded, err := New(ctx, Config{Source: src, WarmupLookback: time.Hour})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(bounded.Close)
+	events, err = bounded.Warmup(ctx, []int64{10001, 10002}, start)
+	if err != nil {
+		t.Fatalf("Warmup returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].SensorID != 10002 {
+		t.Fatalf("lookback warmup expected only sensor 10002, got %#v", events)
+	}
+}
+
+func TestStoreWarmupSameTimestampDuplicates(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	ts := start.Add(-time.Second)
+	rows := []historyRow{
+		{sensorID: 10001, ts: ts, value: 3},
+		{sensorID: 10001, ts: ts, value: 7},
+		{sensorID: 10001, ts: ts, value: 5},
+	}
+	store, err := New(ctx, Config{Source: prepareSQLiteDB(t, rows)})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	// Из дублей момента warmup выбирает то же, что replay при применении потока: большее значение.
+	events, err := store.Warmup(ctx, []int64{10001}, start)
+	if err != nil {
+		t.Fatalf("Warmup returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].Value != 7 {
+		t.Fatalf("warmup expected value 7, got %#v", events)
+	}
+}
+
+func TestStoreUndefinedColumn(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rows := []historyRow{
+		{sensorID: 10001, ts: start.Add(-time.Second), value: 1},
+		{sensorID: 10001, ts: start.Add(time.Second), value: 2},
+	}
+	src := prepareSQLiteDB(t, rows)
+	db, err := sql.Open("sqlite", src)
+	if err != nil {
+		t.Fatalf("open sqlite db: %v", err)
+	}
+	if _, err := db.Exec(`ALTER TABLE main_history ADD COLUMN undef INTEGER`); err != nil {
+		db.Close()
+		t.Fatalf("add column: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE main_history SET undef = 1 WHERE value = 1`); err != nil {
+		db.Close()
+		t.Fatalf("update: %v", err)
+	}
+	db.Close()
+
+	if _, err := New(ctx, Config{Source: src, UndefinedColumn: "undef; DROP"}); err == nil {
+		t.Fatalf("expected error for invalid column name")
+	}
+
+	store, err := New(ctx, Config{Source: src, UndefinedColumn: "undef"})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	warm, err := store.Warmup(ctx, []int64{10001}, start)
+	if err != nil {
+		t.Fatalf("Warmup returned error: %v", err)
+	}
+	if len(warm) != 1 || !warm[0].Undefined {
+		t.Fatalf("warmup expected undefined event, got %#v", warm)
+	}
+
+	events, err := store.EventsFor(ctx, 10001, start.Add(-time.Minute), start.Add(time.Minute), 0)
+	if err != nil {
+		t.Fatalf("EventsFor returned error: %v", err)
+	}
+	if len(events) != 2 || !events[0].Undefined || events[1].Undefined {
+		t.Fatalf("events undefined flags mismatch: %#v", events)
+	}
+}
+
+func TestStoreValueColumn(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rows := []historyRow{
+		{sensorID: 10001, ts: start.Add(-time.Second), value: 1},
+		{sensorID: 10001, ts: start.Add(time.Second), value: 2},
+	}
+	src := prepareSQLiteDB(t, rows)
+	db, err := sql.Open("sqlite", src)
+	if err != nil {
+		t.Fatalf("open sqlite db: %v", err)
+	}
+	if _, err := db.Exec(`ALTER TABLE main_history ADD COLUMN raw REAL`); err != nil {
+		db.Close()
+		t.Fatalf("add column: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE main_history SET raw = value * 100`); err != nil {
+		db.Close()
+		t.Fatalf("update: %v", err)
+	}
+	db.Close()
+
+	if _, err := New(ctx, Config{Source: src, ValueColumn: "raw; DROP"}); err == nil {
+		t.Fatalf("expected error for invalid column name")
+	}
+	if _, err := New(ctx, Config{Source: src, ValueColumn: "confirm"}); err == nil || !strings.Contains(err.Error(), `column "confirm" not found`) {
+		t.Fatalf("missing column err = %v, want not found", err)
+	}
+
+	store, err := New(ctx, Config{Source: src, ValueColumn: "raw"})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	warm, err := store.Warmup(ctx, []int64{10001}, start)
+	if err != nil {
+		t.Fatalf("Warmup returned error: %v", err)
+	}
+	if len(warm) != 1 || warm[0].Value != 100 {
+		t.Fatalf("warmup expected raw value 100, got %#v", warm)
+	}
+	dataCh, errCh := store.Stream(ctx, storage.StreamRequest{
+		Sensors: []int64{10001},
+		From:    start,
+		To:      start.Add(time.Minute),
+	})
+	var streamed []float64
+	for chunk := range dataCh {
+		for _, ev := range chunk {
+			streamed = append(streamed, ev.Value)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	if len(streamed) != 1 || streamed[0] != 200 {
+		t.Fatalf("stream expected raw value 200, got %v", streamed)
+	}
+	events, err := store.EventsFor(ctx, 10001, start.Add(-time.Minute), start.Add(time.Minute), 0)
+	if err != nil {
+		t.Fatalf("EventsFor returned error: %v", err)
+	}
+	if len(events) != 2 || events[0].Value != 100 || events[1].Value != 200 {
+		t.Fatalf("events expected raw values, got %#v", events)
+	}
+}
+
+func TestStoreStreamAutoWindow(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var rows []historyRow
+	for i := 0; i < 600; i++ {
+		rows = append(rows, historyRow{sensorID: 10001, ts: start.Add(time.Duration(i) * time.Second), value: float64(i)})
+	}
+	src := prepareSQLiteDB(t, rows)
+	store, err := New(ctx, Config{Source: src, WindowTargetRows: 5})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	dataCh, errCh := store.Stream(ctx, storage.StreamRequest{
+		Sensors: []int64{10001},
+		From:    start,
+		To:      start.Add(10 * time.Minute),
+	})
+	var sizes []int
+	total := 0
+	for chunk := range dataCh {
+		total += len(chunk)
+		sizes = append(sizes, len(chunk))
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	if total != 600 {
+		t.Fatalf("expected 600 events, got %d", total)
+	}
+	// Первое окно — значение по умолчанию (1 минута), далее окно сжимается к цели в 5 строк.
+	if sizes[0] != 60 {
+		t.Fatalf("first batch = %d rows, want 60", sizes[0])
+	}
+	if last := sizes[len(sizes)-1]; last > 10 {
+		t.Fatalf("auto window did not converge: batch sizes %v", sizes)
+	}
+}
+
+func TestStoreStreamMaxRowsPerWindow(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var rows []historyRow
+	for i := 0; i < 600; i++ {
+		rows = append(rows, historyRow{sensorID: 10001, ts: start.Add(time.Duration(i) * time.Second), value: float64(i)})
+	}
+	src := prepareSQLiteDB(t, rows)
+	store, err := New(ctx, Config{Source: src, MaxRowsPerWindow: 50})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	// Одно окно на весь период — 600 строк при пределе 50: окно делится по времени.
+	dataCh, errCh := store.Stream(ctx, storage.StreamRequest{
+		Sensors: []int64{10001},
+		From:    start,
+		To:      start.Add(10 * time.Minute),
+		Window:  10 * time.Minute,
+	})
+	var values []float64
+	for chunk := range dataCh {
+		if len(chunk) > 50 {
+			t.Fatalf("chunk of %d rows exceeds MaxRowsPerWindow", len(chunk))
+		}
+		for _, ev := range chunk {
+			values = append(values, ev.Value)
+		}
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	if len(values) != 600 {
+		t.Fatalf("expected 600 events, got %d", len(values))
+	}
+	for i, v := range values {
+		if v != float64(i) {
+			t.Fatalf("event %d = %g, order is broken", i, v)
+		}
+	}
+}
+
+func TestStoreTimeLayouts(t *testing.T) {
+	ctx := context.Background()
+	src := prepareSQLiteDB(t, nil)
+	msk := time.FixedZone("MSK", 3*3600)
+	store, err := New(ctx, Config{
+		Source:      src,
+		TimeLayouts: []string{"2006-01-02T15:04:05", "01/02/2006 15:04:05"},
+		TimeZone:    msk,
+	})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	want := time.Date(2024, 6, 1, 12, 30, 0, 0, msk)
+	cases := []struct {
+		raw  string
+		usec int64
+		want time.Time
+	}{
+		{"2024-06-01T12:30:00", 0, want},
+		{"06/01/2024 12:30:00", 250, want.Add(250 * time.Microsecond)},
+		// встроенный формат без зоны тоже трактуется в заданном поясе
+		{"2024-06-01 12:30:00", 0, want},
+		// метка с зоной не зависит от TimeZone
+		{"2024-06-01T09:30:00Z", 0, want},
+		// дробная часть в метке важнее time_usec
+		{"2024-06-01T12:30:00.5", 250, want.Add(500 * time.Millisecond)},
+	}
+	for _, tc := range cases {
+		got, err := store.parseTimestamp(tc.raw, tc.usec)
+		if err != nil {
+			t.Fatalf("parseTimestamp(%q): %v", tc.raw, err)
+		}
+		if !got.Equal(tc.want) {
+			t.Fatalf("parseTimestamp(%q) = %s, want %s", tc.raw, got, tc.want)
+		}
+	}
+
+	plain, err := New(ctx, Config{Source: src})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(plain.Close)
+	if _, err := plain.parseTimestamp("06/01/2024 12:30:00", 0); err == nil {
+		t.Fatalf("expected error for layout that is not configured")
+	}
+	got, err := plain.parseTimestamp("2024-06-01 12:30:00", 0)
+	if err != nil || got.Location() != time.UTC || got.Hour() != 12 {
+		t.Fatalf("zoneless timestamp must default to UTC, got %s (%v)", got, err)
+	}
+}
+
+func TestStoreTimeLayoutsWindows(t *testing.T) {
+	ctx := context.Background()
+	msk := time.FixedZone("MSK", 3*3600)
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, msk)
+	rows := []historyRow{
+		// до окна: метка в поясе хранилища без зоны — 11:59:00 MSK (08:59 UTC)
+		{sensorID: 10001, text: "2024-06-01 11:59:00", value: 1},
+		// в окне: формат из TimeLayouts, который strftime не разбирает
+		{sensorID: 10001, text: "06/01/2024 12:00:30", usec: 250, value: 2},
+		// в окне: метка без зоны, по UTC-прочтению она была бы на 3 часа позже окна
+		{sensorID: 10002, text: "2024-06-01T12:01:00", value: 3},
+		// метка с зоной не зависит от пояса хранилища: 09:01:30Z = 12:01:30 MSK
+		{sensorID: 10002, text: "2024-06-01T09:01:30Z", value: 4},
+		// после окна
+		{sensorID: 10001, text: "06/01/2024 12:05:00", value: 5},
+	}
+	store, err := New(ctx, Config{
+		Source:      prepareSQLiteDB(t, rows),
+		TimeLayouts: []string{"2006-01-02T15:04:05", "01/02/2006 15:04:05"},
+		TimeZone:    msk,
+	})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+	sensors := []int64{10001, 10002}
+
+	warm, err := store.Warmup(ctx, sensors, start)
+	if err != nil {
+		t.Fatalf("Warmup returned error: %v", err)
+	}
+	if len(warm) != 1 || warm[0].Value != 1 || !warm[0].Timestamp.Equal(start.Add(-time.Minute)) {
+		t.Fatalf("warmup expected zoned row before start, got %#v", warm)
+	}
+
+	dataCh, errCh := store.Stream(ctx, storage.StreamRequest{
+		Sensors: sensors,
+		From:    start,
+		To:      start.Add(5 * time.Minute),
+		Window:  time.Minute,
+	})
+	var got []storage.SensorEvent
+	for chunk := range dataCh {
+		got = append(got, chunk...)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Stream error: %v", err)
+	}
+	want := []struct {
+		value float64
+		ts    time.Time
+	}{
+		{2, start.Add(30*time.Second + 250*time.Microsecond)},
+		{3, start.Add(time.Minute)},
+		{4, start.Add(90 * time.Second)},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("stream expected %d events, got %#v", len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Value != w.value || !got[i].Timestamp.Equal(w.ts) {
+			t.Fatalf("event %d = %v at %v, want %v at %v", i, got[i].Value, got[i].Timestamp, w.value, w.ts)
+		}
+	}
+
+	from, to, count, err := store.Range(ctx, sensors, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Range returned error: %v", err)
+	}
+	if count != 2 || !from.Equal(start.Add(-time.Minute)) || !to.Equal(start.Add(5*time.Minute)) {
+		t.Fatalf("range = %v..%v (%d), want layout rows as bounds", from, to, count)
+	}
+}
+
+func TestStoreTimeZoneRange(t *testing.T) {
+	ctx := context.Background()
+	rows := []historyRow{
+		{sensorID: 10001, text: "2024-06-01 12:00:00", value: 1},
+		{sensorID: 10001, text: "2024-06-01 13:00:00", value: 2},
+	}
+	// Только пояс (--source-timezone), встроенные форматы: границы тоже считаются в поясе.
+	msk := time.FixedZone("MSK", 3*3600)
+	store, err := New(ctx, Config{Source: prepareSQLiteDB(t, rows), TimeZone: msk})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	from := time.Date(2024, 6, 1, 12, 30, 0, 0, msk)
+	minTs, maxTs, count, err := store.Range(ctx, []int64{10001}, from, from.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Range returned error: %v", err)
+	}
+	if want := from.Add(30 * time.Minute); count != 1 || !minTs.Equal(want) || !maxTs.Equal(want) {
+		t.Fatalf("range = %v..%v (%d), want only 13:00 MSK", minTs, maxTs, count)
+	}
+}
+
+func TestStoreSubsecondTimestamps(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rows := []historyRow{
+		// дробная часть в timestamp, time_usec пустой
+		{sensorID: 10001, text: "2024-06-01T00:00:01.25Z", value: 1},
+		// секунды в timestamp плюс time_usec
+		{sensorID: 10002, ts: start.Add(time.Second), usec: 500000, value: 2},
+		// оба поля: дробная часть метки важнее, time_usec не добавляется второй раз
+		{sensorID: 10001, text: "2024-06-01T00:00:01.75Z", usec: 750000, value: 3},
+		{sensorID: 10002, text: "2024-06-01T00:00:02.000001500Z", value: 4},
+		// нулевая дробная часть — time_usec учитывается
+		{sensorID: 10001, text: "2024-06-01T00:00:02.000Z", usec: 300, value: 5},
+	}
+	src := prepareSQLiteDB(t, rows)
+	store, err := New(ctx, Config{Source: src})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	want := []struct {
+		value float64
+		ts    time.Time
+	}{
+		{1, start.Add(1250 * time.Millisecond)},
+		{2, start.Add(1500 * time.Millisecond)},
+		{3, start.Add(1750 * time.Millisecond)},
+		{5, start.Add(2*time.Second + 300*time.Microsecond)},
+	}
+	sensors := []int64{10001, 10002}
+	dataCh, errCh := store.Stream(ctx, storage.StreamRequest{Sensors: sensors, From: start, To: start.Add(2*time.Second + 200*time.Microsecond), Window: time.Second})
+	var streamed []storage.SensorEvent
+	for batch := range dataCh {
+		streamed = append(streamed, batch...)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	// Окно [from, to) отсекает запись 5 (2.0003s); запись 4 (2.0000015s) попадает в него.
+	if len(streamed) != 4 || streamed[3].Value != 4 || !streamed[3].Timestamp.Equal(start.Add(2*time.Second+1500*time.Nanosecond)) {
+		t.Fatalf("streamed = %#v", streamed)
+	}
+	for i, w := range want[:3] {
+		if ev := streamed[i]; ev.Value != w.value || !ev.Timestamp.Equal(w.ts) {
+			t.Fatalf("stream event %d = %#v, want value %v at %s", i, ev, w.value, w.ts)
+		}
+	}
+
+	events, err := store.EventsFor(ctx, 10001, start, start.Add(3*time.Second), 0)
+	if err != nil {
+		t.Fatalf("EventsFor returned error: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("EventsFor = %#v", events)
+	}
+	for i, w := range []int{0, 2, 3} {
+		if ev := events[i]; ev.Value != want[w].value || !ev.Timestamp.Equal(want[w].ts) {
+			t.Fatalf("EventsFor %d = %#v, want value %v at %s", i, ev, want[w].value, want[w].ts)
+		}
+	}
+
+	min, max, _, err := store.Range(ctx, sensors, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Range returned error: %v", err)
+	}
+	if !min.Equal(want[0].ts) || !max.Equal(want[3].ts) {
+		t.Fatalf("Range = %s .. %s, want %s .. %s", min, max, want[0].ts, want[3].ts)
+	}
+}
+
+func TestStoreListSensors(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rows := []historyRow{
+		{sensorID: 10002, ts: start, value: 1},
+		{sensorID: 10001, ts: start.Add(time.Second), value: 2},
+		{sensorID: 10002, ts: start.Add(2 * time.Second), value: 3},
+		{sensorID: 99999, ts: start.Add(time.Hour), value: 4}, // вне конфига и вне периода
+	}
+	src := prepareSQLiteDB(t, rows)
+	// Реестр не задан: sensor_id отдаются как есть.
+	store, err := New(ctx, Config{Source: src})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	t.Cleanup(store.Close)
+
+	got, err := store.ListSensors(ctx, start, start.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("ListSensors returned error: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != 10001 || got[1].ID != 10002 {
+		t.Fatalf("ListSensors = %#v", got)
+	}
+	all, err := store.ListSensors(ctx, time.Time{}, time.Time{})
+	if err != nil || len(all) != 3 || all[2].ID != 99999 {
+		t.Fatalf("ListSensors without period = %#v (%v)", all, err)
+	}
+}
+
+func TestStoreGzipSource(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	src := prepareSQLiteDB(t, []historyRow{
+		{sensorID: 10001, ts: start, value: 1},
+		{sensorID: 10001, ts: start.Add(time.Second), value: 2},
+	})
+
+	archive := src + ".gz"
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		t.Fatalf("compress db: %v", err)
+	}
+	in.Close()
+	if err := zw.Close(); err != nil {
+		t.Fatalf("close gzip: %v", err)
+	}
+	out.Close()
+
+	if !IsSource(archive) || !IsSource("sqlite://"+archive) {
+		t.Fatalf("IsSource must accept %s", archive)
+	}
+
+	tmpDir := t.TempDir()
+	store, err := New(ctx, Config{Source: archive, TmpDir: tmpDir})
+	if err != nil {
+		t.Fatalf("sqlite.New error: %v", err)
+	}
+	events, err := store.EventsFor(ctx, 10001, start, start.Add(time.Second), 0)
+	if err != nil {
+		t.Fatalf("EventsFor returned error: %v", err)
+	}
+	if len(events) != 2 || events[1].Value != 2 {
+		t.Fatalf("unexpected events from archive: %#v", events)
+	}
+	store.Close()
+	if left, _ := filepath.Glob(filepath.Join(tmpDir, "timemachine-*.db")); len(left) != 0 {
+		t.Fatalf("temp copy was not removed: %v", left)
+	}
+
+	if _, err := New(ctx, Config{Source: src + ".missing.gz", TmpDir: tmpDir}); err == nil {
+		t.Fatalf("expected error for missing archive")
+	}
+}
+
+func TestStoreFilterTableCollision(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	rows := []historyRow{
+		{sensorID: 10001, ts: start, value: 1},
+		{sensorID: 10002, ts: start.Add(time.Second), value: 2},
+	}
+	src := prepareSQLiteDB(t, rows)
+	db, err := sql.Open("sqlite", src)
+	if err != nil {
+		t.Fatalf("open sqlite db: %v", err)
+	}
+	// Пользовательская таблица с именем по умолчанию, другой схемой и «чужим» датчиком.
+	if _, err := db.Exec(`CREATE TABLE tm_sensors(name TEXT, sensor_id INTEGER); INSERT INTO tm_sensors VALUES ('user', 10002)`); err != nil {
+		db.Close()
+		t.Fatalf("create user table: %v", err)
+	}
+	db.Close()
+
+	if _, err := New(ctx, Config{Source: src, FilterTable: "tm; DROP"}); err == nil {
+		t.Fatalf("expected error for invalid filter table name")
+	}
+
+	for _, name := range []string{"", "tm_filter_1"} {
+		store, err := New(ctx, Config{Source: src, FilterTable: name})
+		if err != nil {
+			t.Fatalf("sqlite.New(%q) error: %v", name, err)
+		}
+		warm, err := store.Warmup(ctx, []int64{10001}, start.Add(time.Minute))
+		if err != nil {
+			store.Close()
+			t.Fatalf("Warmup(%q) returned error: %v", name, err)
+		}
+		if len(warm) != 1 || warm[0].SensorID != 10001 {
+			store.Close()
+			t.Fatalf("Warmup(%q) expected only sensor 10001, got %#v", name, warm)
+		}
+		_, _, count, err := store.Range(ctx, []int64{10001}, time.Time{}, time.Time{})
+		store.Close()
+		if err != nil || count != 1 {
+			t.Fatalf("Range(%q) count=%d err=%v, want 1", name, count, err)
+		}
+	}
+
+	db, err = sql.Open("sqlite", src)
+	if err != nil {
+		t.Fatalf("open sqlite db: %v", err)
+	}
+	defer db.Close()
+	var userRows int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM main.tm_sensors WHERE name = 'user'`).Scan(&userRows); err != nil || userRows != 1 {
+		t.Fatalf("user table changed: rows=%d err=%v", userRows, err)
+	}
+}
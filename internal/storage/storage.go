@@ -2,6 +2,10 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -10,6 +14,18 @@ type SensorEvent struct {
 	SensorID  int64
 	Timestamp time.Time
 	Value     float64
+	Undefined bool // датчик в неопределённом состоянии (Value не имеет смысла)
+}
+
+// Outranks сообщает, вытесняет ли событие ev событие cur того же датчика с тем же временем
+// (дубли строк): определённое значение важнее неопределённого, из двух определённых — большее.
+// Порядок дублей в выборке БД не задан, поэтому Warmup хранилищ и применение потока в replay
+// выбирают между ними по этому правилу, а не по порядку чтения.
+func Outranks(ev, cur SensorEvent) bool {
+	if ev.Undefined != cur.Undefined {
+		return cur.Undefined
+	}
+	return !ev.Undefined && ev.Value > cur.Value
 }
 
 // StreamRequest задаёт параметры подгрузки истории.
@@ -17,7 +33,7 @@ type StreamRequest struct {
 	Sensors []int64
 	From    time.Time
 	To      time.Time
-	Window  time.Duration
+	Window  time.Duration // 0 — автоподбор окна (если хранилище поддерживает, иначе значение по умолчанию)
 }
 
 // Storage — интерфейс для чтения истории из конкретного хранилища (Postgres, SQLite...).
@@ -35,3 +51,141 @@ type Storage interface {
 type UnknownAwareStorage interface {
 	RangeWithUnknown(ctx context.Context, sensors []int64, from, to time.Time) (time.Time, time.Time, int64, int64, error)
 }
+
+// EventHistoryStorage опционально отдаёт сырые записи истории одного датчика
+// в окне [from, to] по возрастанию времени. limit <= 0 — без ограничения.
+type EventHistoryStorage interface {
+	EventsFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]SensorEvent, error)
+}
+
+// ChangeHistoryStorage опционально отдаёт из сырой истории одного датчика только изменения:
+// первую запись окна [from, to] и записи, значение или признак undefined которых отличается
+// от предыдущей записи датчика. limit <= 0 — без ограничения.
+type ChangeHistoryStorage interface {
+	ChangesFor(ctx context.Context, sensor int64, from, to time.Time, limit int) ([]SensorEvent, error)
+}
+
+// ChangesOnly оставляет из упорядоченных по времени событий одного датчика только изменения
+// (как ChangeHistoryStorage) — для хранилищ, где фильтр выполняется в памяти.
+func ChangesOnly(events []SensorEvent) []SensorEvent {
+	out := make([]SensorEvent, 0, len(events))
+	for i, ev := range events {
+		if i > 0 && ev.Value == events[i-1].Value && ev.Undefined == events[i-1].Undefined {
+			continue
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+// SensorIdentity — датчик, найденный в истории SensorLister: ID в том виде, в каком он записан
+// в таблице (sensor_id SQLite/PostgreSQL), или имя, если таблица хранит имена (ClickHouse).
+type SensorIdentity struct {
+	ID   int64
+	Name string
+}
+
+// SensorLister опционально перечисляет все различные датчики истории в окне [from, to]
+// (нулевая граница — без ограничения), не глядя на конфиг. Нужен --discover-sensors,
+// чтобы собрать конфиг по данным, когда XML под базу нет.
+type SensorLister interface {
+	ListSensors(ctx context.Context, from, to time.Time) ([]SensorIdentity, error)
+}
+
+// Pinger опционально проверяет доступность хранилища (для readiness-проверок).
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// UndefinedDefault — выражение признака undefined в SQL-запросах, когда колонка не задана.
+const UndefinedDefault = "0 AS undefined"
+
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// UndefinedExpr строит SQL-выражение признака undefined по имени колонки и шаблону
+// (шаблон содержит %s для имени колонки). Пустая колонка — пустое выражение.
+func UndefinedExpr(column, pattern string) (string, error) {
+	if column == "" {
+		return "", nil
+	}
+	if !identRe.MatchString(column) {
+		return "", fmt.Errorf("invalid undefined column name %q", column)
+	}
+	return fmt.Sprintf(pattern, column), nil
+}
+
+// ValueDefault — колонка значения истории, когда другая не задана (--value-column).
+const ValueDefault = "value"
+
+// ValueColumn проверяет имя колонки значения; пустое имя — ValueDefault.
+func ValueColumn(column string) (string, error) {
+	if column == "" {
+		return ValueDefault, nil
+	}
+	if !identRe.MatchString(column) {
+		return "", fmt.Errorf("invalid value column name %q", column)
+	}
+	return column, nil
+}
+
+// FilterTableDefault — имя временной таблицы фильтра датчиков, когда другое не задано (--filter-table).
+const FilterTableDefault = "tm_sensors"
+
+// FilterTable проверяет имя временной таблицы фильтра; пустое имя — FilterTableDefault.
+func FilterTable(name string) (string, error) {
+	if name == "" {
+		return FilterTableDefault, nil
+	}
+	if !identRe.MatchString(name) {
+		return "", fmt.Errorf("invalid filter table name %q", name)
+	}
+	return name, nil
+}
+
+// RequireColumn проверяет, что колонка column есть среди колонок columns таблицы table (без учёта регистра).
+func RequireColumn(table, column string, columns []string) error {
+	for _, c := range columns {
+		if strings.EqualFold(c, column) {
+			return nil
+		}
+	}
+	return fmt.Errorf("column %q not found in table %s (columns: %s)", column, table, strings.Join(columns, ", "))
+}
+
+// redactedSecret заменяет секреты в строках подключения.
+const redactedSecret = "xxxxx"
+
+// secretKeyRe находит пары key=value с секретами в DSN вида "host=... password=...".
+var secretKeyRe = regexp.MustCompile(`(?i)\b(password|passwd|pwd|token|secret)=('[^']*'|[^\s&;]*)`)
+
+// textURLRe находит URL со схемой в произвольном тексте (например, в строке лога).
+var textURLRe = regexp.MustCompile(`\b[A-Za-z][A-Za-z0-9+.-]*://[^\s"'<>]+`)
+
+// RedactText скрывает пароли и токены во всех URL и парах key=value произвольного текста.
+func RedactText(text string) string {
+	text = textURLRe.ReplaceAllStringFunc(text, RedactDSN)
+	return secretKeyRe.ReplaceAllString(text, "${1}="+redactedSecret)
+}
+
+// RedactDSN скрывает пароль и токены в строке подключения для вывода в логи и API.
+func RedactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" && strings.Contains(dsn, "://") {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedSecret)
+		}
+		if u.RawQuery != "" {
+			q := u.Query()
+			for key := range q {
+				if secretKeyRe.MatchString(key + "=") {
+					q.Set(key, redactedSecret)
+				}
+			}
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return secretKeyRe.ReplaceAllString(dsn, "${1}="+redactedSecret)
+}
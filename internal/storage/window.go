@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"log"
+	"time"
+)
+
+// DefaultWindowTargetRows — целевое число строк за один запрос окна в режиме автоподбора.
+const DefaultWindowTargetRows = 10000
+
+const (
+	// minSplitWindow — окно короче этого не делится по MaxRowsPerWindow: оно читается целиком.
+	minSplitWindow   = time.Millisecond
+	minAutoWindow    = 100 * time.Millisecond
+	maxAutoWindow    = time.Hour
+	maxWindowFactor  = 4 // окно меняется не более чем в 4 раза за шаг
+	initialAutoWidth = 5 * time.Second
+)
+
+// WindowTuner подбирает длительность окна Stream так, чтобы за запрос возвращалось
+// около target строк: плотные участки читаются короткими окнами, редкие — длинными.
+type WindowTuner struct {
+	window time.Duration
+	target int
+}
+
+// NewWindowTuner создаёт автоподбор окна. initial <= 0 — стартовое окно по умолчанию,
+// target <= 0 — DefaultWindowTargetRows.
+func NewWindowTuner(initial time.Duration, target int) *WindowTuner {
+	if initial <= 0 {
+		initial = initialAutoWidth
+	}
+	if target <= 0 {
+		target = DefaultWindowTargetRows
+	}
+	return &WindowTuner{window: clampWindow(initial), target: target}
+}
+
+// Window возвращает длительность следующего окна.
+func (t *WindowTuner) Window() time.Duration {
+	return t.window
+}
+
+// Observe учитывает, что окно длительностью span вернуло rows строк, и пересчитывает следующее окно.
+func (t *WindowTuner) Observe(span time.Duration, rows int) {
+	if span <= 0 {
+		return
+	}
+	next := t.window * maxWindowFactor
+	if rows > 0 {
+		next = time.Duration(float64(span) * float64(t.target) / float64(rows))
+	}
+	if lo := t.window / maxWindowFactor; next < lo {
+		next = lo
+	}
+	if hi := t.window * maxWindowFactor; next > hi {
+		next = hi
+	}
+	t.window = clampWindow(next)
+}
+
+func clampWindow(d time.Duration) time.Duration {
+	if d < minAutoWindow {
+		return minAutoWindow
+	}
+	if d > maxAutoWindow {
+		return maxAutoWindow
+	}
+	return d
+}
+
+// ReadCapped читает окно Stream [from, to) функцией read и передаёт строки в emit по возрастанию времени.
+// maxRows > 0 ограничивает число строк одного запроса (MaxRowsPerWindow в конфигурациях хранилищ):
+// read получает limit = maxRows+1 и прекращает чтение, набрав limit строк; если строк больше maxRows,
+// прочитанное отбрасывается, окно делится пополам по времени и половины читаются по очереди тем же
+// способом. Окно короче minSplitWindow делить некуда — оно читается целиком (limit 0).
+// Возвращает общее число прочитанных строк (для WindowTuner).
+func ReadCapped(from, to time.Time, maxRows int, read func(from, to time.Time, limit int) ([]SensorEvent, error), emit func([]SensorEvent) error) (int, error) {
+	limit := 0
+	if maxRows > 0 {
+		limit = maxRows + 1
+	}
+	chunk, err := read(from, to, limit)
+	if err != nil {
+		return 0, err
+	}
+	if limit > 0 && len(chunk) > maxRows {
+		span := to.Sub(from)
+		if span >= 2*minSplitWindow {
+			// Граница половин — на целой микросекунде: точнее метки в SQL-хранилищах не бывают.
+			mid := from.Add(span / 2).Truncate(time.Microsecond)
+			n, err := ReadCapped(from, mid, maxRows, read, emit)
+			if err != nil {
+				return n, err
+			}
+			m, err := ReadCapped(mid, to, maxRows, read, emit)
+			return n + m, err
+		}
+		log.Printf("[storage] window %s..%s holds more than %d rows and is too short to split; reading it whole",
+			from.Format(time.RFC3339Nano), to.Format(time.RFC3339Nano), maxRows)
+		if chunk, err = read(from, to, 0); err != nil {
+			return 0, err
+		}
+	}
+	if len(chunk) == 0 {
+		return 0, nil
+	}
+	return len(chunk), emit(chunk)
+}
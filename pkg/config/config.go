@@ -10,14 +10,82 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // SensorMeta содержит дополнительную информацию о датчике.
 type SensorMeta struct {
-	ID       int64
-	TextName string
-	IOType   string
+	ID          int64
+	TextName    string
+	IOType      string
+	Group       string       // группа/подсистема из атрибута group (или section); пусто — без группы
+	Calibration *Calibration // линейное преобразование значения (nil — без преобразования)
+	Limits      *Limits      // допустимые границы значения (nil — без контроля)
+	Pulse       *Pulse       // автосброс дискретного датчика (nil — значение удерживается до события)
+}
+
+// Calibration описывает линейное преобразование значения датчика: value*Scale + Offset.
+type Calibration struct {
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+// Apply применяет преобразование к значению.
+func (c Calibration) Apply(value float64) float64 {
+	return value*c.Scale + c.Offset
+}
+
+// Limits задаёт допустимые границы значения датчика (атрибуты min/max); nil — граница не задана.
+type Limits struct {
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// Violated возвращает нарушенную границу: значение ниже Min или выше Max.
+func (l Limits) Violated(value float64) (limit float64, bound string, ok bool) {
+	if l.Min != nil && value < *l.Min {
+		return *l.Min, "min", true
+	}
+	if l.Max != nil && value > *l.Max {
+		return *l.Max, "max", true
+	}
+	return 0, "", false
+}
+
+// Pulse описывает импульсный дискретный датчик: если за Hold после последнего события
+// нового события не было, значение возвращается к Value (атрибуты pulse_hold/pulse_value).
+type Pulse struct {
+	Hold  time.Duration `json:"hold"`
+	Value float64       `json:"value"`
+}
+
+// IsDiscrete возвращает true для дискретных датчиков (iotype DI/DO).
+func (m SensorMeta) IsDiscrete() bool {
+	return IsDiscreteIOType(m.IOType)
+}
+
+// IsDiscreteIOType возвращает true для дискретных типов ввода-вывода (DI/DO).
+func IsDiscreteIOType(iotype string) bool {
+	switch strings.ToUpper(strings.TrimSpace(iotype)) {
+	case "DI", "DO":
+		return true
+	default:
+		return false
+	}
+}
+
+// GuessIOType угадывает iotype по префиксу имени (DI, DO, AI, AO) для датчиков без iotype
+// в конфиге; остальные считаются аналоговыми входами (AI).
+func GuessIOType(name string) string {
+	upper := strings.ToUpper(strings.TrimSpace(name))
+	for _, prefix := range []string{"DI", "DO", "AI", "AO"} {
+		if strings.HasPrefix(upper, prefix) {
+			return prefix
+		}
+	}
+	return "AI"
 }
 
 // Config описывает связь имён датчиков с их ID и наборы датчиков.
@@ -29,8 +97,13 @@ type Config struct {
 	Registry   *SensorRegistry // реестр датчиков с hash идентификаторами
 }
 
-// Load загружает конфигурацию датчиков из JSON или XML.
+// Load загружает конфигурацию датчиков из JSON или XML с хешированием имён по умолчанию.
 func Load(path string) (*Config, error) {
+	return LoadWithHasher(path, nil)
+}
+
+// LoadWithHasher загружает конфигурацию, вычисляя hash имён указанным алгоритмом (nil — DefaultHasher).
+func LoadWithHasher(path string, hasher Hasher) (*Config, error) {
 	if path == "" {
 		return nil, fmt.Errorf("config: path is empty")
 	}
@@ -43,7 +116,7 @@ func Load(path string) (*Config, error) {
 		Sensors:    map[string]int64{},
 		Sets:       map[string][]string{},
 		SensorMeta: map[string]SensorMeta{},
-		Registry:   NewSensorRegistry(),
+		Registry:   NewSensorRegistryWithHasher(hasher),
 	}
 
 	switch ext := strings.ToLower(filepath.Ext(path)); ext {
@@ -83,6 +156,32 @@ func (c *Config) Resolve(selector string) ([]int64, error) {
 	return c.resolveLegacy(selector)
 }
 
+// NamedSet описывает именованный набор датчиков из Sets.
+type NamedSet struct {
+	Name    string
+	Members []int64 // датчики набора в том виде, в каком их возвращает Resolve
+	Err     error   // набор не резолвится (например, содержит неизвестный датчик)
+}
+
+// NamedSets возвращает наборы из Sets, отсортированные по имени. Члены резолвятся так же,
+// как при Resolve(name), поэтому ошибка набора означает, что --slist с ним не запустится.
+func (c *Config) NamedSets() []NamedSet {
+	if c == nil {
+		return nil
+	}
+	names := make([]string, 0, len(c.Sets))
+	for name := range c.Sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sets := make([]NamedSet, 0, len(names))
+	for _, name := range names {
+		members, err := c.Resolve(name)
+		sets = append(sets, NamedSet{Name: name, Members: members, Err: err})
+	}
+	return sets
+}
+
 // resolveWithRegistry использует Registry для резолвинга селекторов.
 func (c *Config) resolveWithRegistry(selector string) ([]int64, error) {
 	if selector == "" || strings.EqualFold(selector, "ALL") {
@@ -315,6 +414,141 @@ func (c *Config) KeyByHash(hash int64) (*SensorKey, bool) {
 	return c.Registry.ByHash(hash)
 }
 
+// Calibrations возвращает преобразования значений hash → Calibration.
+// Дискретные датчики не преобразуются и в результат не попадают.
+func (c *Config) Calibrations() map[int64]Calibration {
+	if c == nil {
+		return nil
+	}
+	result := make(map[int64]Calibration)
+	for name, meta := range c.SensorMeta {
+		if meta.Calibration == nil || meta.IsDiscrete() {
+			continue
+		}
+		hash := c.Registry.HashForName(name)
+		if c.Registry != nil {
+			if key, ok := c.Registry.ByName(name); ok {
+				hash = key.Hash
+			}
+		}
+		result[hash] = *meta.Calibration
+	}
+	return result
+}
+
+// Limits возвращает границы значений hash → Limits. Датчики без min/max в результат не попадают.
+func (c *Config) Limits() map[int64]Limits {
+	if c == nil {
+		return nil
+	}
+	result := make(map[int64]Limits)
+	for name, meta := range c.SensorMeta {
+		if meta.Limits == nil {
+			continue
+		}
+		hash := c.Registry.HashForName(name)
+		if c.Registry != nil {
+			if key, ok := c.Registry.ByName(name); ok {
+				hash = key.Hash
+			}
+		}
+		result[hash] = *meta.Limits
+	}
+	return result
+}
+
+// Pulses возвращает автосброс импульсных датчиков hash → Pulse. Датчики без pulse_hold в результат не попадают.
+func (c *Config) Pulses() map[int64]Pulse {
+	if c == nil {
+		return nil
+	}
+	result := make(map[int64]Pulse)
+	for name, meta := range c.SensorMeta {
+		if meta.Pulse == nil {
+			continue
+		}
+		hash := c.Registry.HashForName(name)
+		if c.Registry != nil {
+			if key, ok := c.Registry.ByName(name); ok {
+				hash = key.Hash
+			}
+		}
+		result[hash] = *meta.Pulse
+	}
+	return result
+}
+
+// DiscreteSensors возвращает hash дискретных датчиков (iotype DI/DO). Для датчиков без iotype
+// тип угадывается по имени (GuessIOType), как в списке датчиков API.
+func (c *Config) DiscreteSensors() map[int64]struct{} {
+	if c == nil {
+		return nil
+	}
+	result := make(map[int64]struct{})
+	for name, meta := range c.SensorMeta {
+		iotype := meta.IOType
+		if strings.TrimSpace(iotype) == "" {
+			iotype = GuessIOType(name)
+		}
+		if !IsDiscreteIOType(iotype) {
+			continue
+		}
+		hash := c.Registry.HashForName(name)
+		if c.Registry != nil {
+			if key, ok := c.Registry.ByName(name); ok {
+				hash = key.Hash
+			}
+		}
+		result[hash] = struct{}{}
+	}
+	return result
+}
+
+// IOTypes возвращает iotype датчиков hash → iotype (в верхнем регистре).
+// Датчики без iotype в результат не попадают.
+func (c *Config) IOTypes() map[int64]string {
+	if c == nil {
+		return nil
+	}
+	result := make(map[int64]string)
+	for name, meta := range c.SensorMeta {
+		iotype := strings.ToUpper(strings.TrimSpace(meta.IOType))
+		if iotype == "" {
+			continue
+		}
+		hash := c.Registry.HashForName(name)
+		if c.Registry != nil {
+			if key, ok := c.Registry.ByName(name); ok {
+				hash = key.Hash
+			}
+		}
+		result[hash] = iotype
+	}
+	return result
+}
+
+// TextNames возвращает описания датчиков hash → textname. Датчики без textname в результат не попадают.
+func (c *Config) TextNames() map[int64]string {
+	if c == nil {
+		return nil
+	}
+	result := make(map[int64]string)
+	for name, meta := range c.SensorMeta {
+		textName := strings.TrimSpace(meta.TextName)
+		if textName == "" {
+			continue
+		}
+		hash := c.Registry.HashForName(name)
+		if c.Registry != nil {
+			if key, ok := c.Registry.ByName(name); ok {
+				hash = key.Hash
+			}
+		}
+		result[hash] = textName
+	}
+	return result
+}
+
 // HasIDs возвращает true, если все датчики в конфиге имеют ID.
 func (c *Config) HasIDs() bool {
 	if c == nil || c.Registry == nil {
@@ -339,6 +573,14 @@ type xmlSensor struct {
 	Name       string `xml:"name,attr"`
 	TextName   string `xml:"textname,attr"`
 	IOType     string `xml:"iotype,attr"`
+	Group      string `xml:"group,attr"`
+	Section    string `xml:"section,attr"`
+	CalScale   string `xml:"cal_scale,attr"`
+	CalOffset  string `xml:"cal_offset,attr"`
+	Min        string `xml:"min,attr"`
+	Max        string `xml:"max,attr"`
+	PulseHold  string `xml:"pulse_hold,attr"`
+	PulseValue string `xml:"pulse_value,attr"`
 }
 
 func parseXMLSensors(cfg *Config, data []byte, baseDir string) error {
@@ -418,8 +660,8 @@ func addXMLSensors(cfg *Config, items []xmlSensor, hash32seen map[uint32]string,
 		// idfromfile="1" или отсутствие атрибута означает, что ID должен быть указан
 		var idPtr *int64
 		if effectiveIDFromFile == "0" {
-			// Генерируем config_id из MurmurHash2(name) для совместимости с UniSet
-			generatedID := int64(Hash32ForName(item.Name))
+			// Генерируем config_id из hash32(name) (по умолчанию MurmurHash2) для совместимости с UniSet
+			generatedID := int64(cfg.Registry.Hasher().Hash32(item.Name))
 			idPtr = &generatedID
 		} else {
 			// idfromfile="1" или не задан - ID обязателен
@@ -431,30 +673,144 @@ func addXMLSensors(cfg *Config, items []xmlSensor, hash32seen map[uint32]string,
 		}
 
 		// Проверка коллизий hash32(name)
-		h32 := Hash32ForName(item.Name)
+		h32 := cfg.Registry.Hasher().Hash32(item.Name)
 		if existingName, exists := hash32seen[h32]; exists {
 			return fmt.Errorf("config: hash32 collision detected: %q and %q have same hash32=%d", existingName, item.Name, h32)
 		}
 		hash32seen[h32] = item.Name
 
 		// Создаём SensorKey и добавляем в Registry
-		key := NewSensorKey(item.Name, idPtr)
+		key := cfg.Registry.NewKey(item.Name, idPtr)
 		if err := cfg.Registry.Add(key); err != nil {
 			return fmt.Errorf("config: %w", err)
 		}
 
+		calib, err := parseCalibration(item)
+		if err != nil {
+			return err
+		}
+		limits, err := parseLimits(item)
+		if err != nil {
+			return err
+		}
+		pulse, err := parsePulse(item)
+		if err != nil {
+			return err
+		}
+
 		// Сохраняем в Sensors для совместимости
 		cfg.Sensors[item.Name] = *idPtr
 
 		cfg.SensorMeta[item.Name] = SensorMeta{
-			ID:       key.Hash, // Используем hash как основной ID
-			TextName: item.TextName,
-			IOType:   item.IOType,
+			ID:          key.Hash, // Используем hash как основной ID
+			TextName:    item.TextName,
+			IOType:      item.IOType,
+			Group:       sensorGroup(item),
+			Calibration: calib,
+			Limits:      limits,
+			Pulse:       pulse,
 		}
 	}
 	return nil
 }
 
+// sensorGroup возвращает группу датчика: атрибут group, а при его отсутствии — section.
+func sensorGroup(item xmlSensor) string {
+	if group := strings.TrimSpace(item.Group); group != "" {
+		return group
+	}
+	return strings.TrimSpace(item.Section)
+}
+
+// parseCalibration разбирает атрибуты cal_scale/cal_offset. Если оба не заданы, возвращает nil.
+// Отсутствующий cal_scale считается равным 1, отсутствующий cal_offset — 0.
+func parseCalibration(item xmlSensor) (*Calibration, error) {
+	scaleRaw := strings.TrimSpace(item.CalScale)
+	offsetRaw := strings.TrimSpace(item.CalOffset)
+	if scaleRaw == "" && offsetRaw == "" {
+		return nil, nil
+	}
+	calib := &Calibration{Scale: 1}
+	if scaleRaw != "" {
+		v, err := strconv.ParseFloat(scaleRaw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("config: sensor %q: invalid cal_scale %q: %w", item.Name, item.CalScale, err)
+		}
+		calib.Scale = v
+	}
+	if offsetRaw != "" {
+		v, err := strconv.ParseFloat(offsetRaw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("config: sensor %q: invalid cal_offset %q: %w", item.Name, item.CalOffset, err)
+		}
+		calib.Offset = v
+	}
+	return calib, nil
+}
+
+// parseLimits разбирает атрибуты min/max. Если оба не заданы, возвращает nil.
+func parseLimits(item xmlSensor) (*Limits, error) {
+	var limits Limits
+	for _, attr := range []struct {
+		name string
+		raw  string
+		dst  **float64
+	}{{"min", item.Min, &limits.Min}, {"max", item.Max, &limits.Max}} {
+		raw := strings.TrimSpace(attr.raw)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("config: sensor %q: invalid %s %q: %w", item.Name, attr.name, attr.raw, err)
+		}
+		*attr.dst = &v
+	}
+	if limits.Min == nil && limits.Max == nil {
+		return nil, nil
+	}
+	if limits.Min != nil && limits.Max != nil && *limits.Min > *limits.Max {
+		return nil, fmt.Errorf("config: sensor %q: min %g is greater than max %g", item.Name, *limits.Min, *limits.Max)
+	}
+	return &limits, nil
+}
+
+// parsePulse разбирает атрибуты pulse_hold/pulse_value. Без pulse_hold возвращает nil;
+// отсутствующий pulse_value считается равным 0. Автосброс допустим только для дискретных датчиков.
+func parsePulse(item xmlSensor) (*Pulse, error) {
+	holdRaw := strings.TrimSpace(item.PulseHold)
+	valueRaw := strings.TrimSpace(item.PulseValue)
+	if holdRaw == "" {
+		if valueRaw != "" {
+			return nil, fmt.Errorf("config: sensor %q: pulse_value requires pulse_hold", item.Name)
+		}
+		return nil, nil
+	}
+	iotype := item.IOType
+	if strings.TrimSpace(iotype) == "" {
+		iotype = GuessIOType(item.Name)
+	}
+	if !IsDiscreteIOType(iotype) {
+		return nil, fmt.Errorf("config: sensor %q: pulse_hold is only supported for discrete sensors (iotype DI/DO)", item.Name)
+	}
+	hold, err := time.ParseDuration(holdRaw)
+	if err != nil {
+		return nil, fmt.Errorf("config: sensor %q: invalid pulse_hold %q: %w", item.Name, item.PulseHold, err)
+	}
+	if hold <= 0 {
+		return nil, fmt.Errorf("config: sensor %q: pulse_hold must be positive, got %q", item.Name, item.PulseHold)
+	}
+	pulse := &Pulse{Hold: hold}
+	if valueRaw != "" {
+		v, err := strconv.ParseFloat(valueRaw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("config: sensor %q: invalid pulse_value %q: %w", item.Name, item.PulseValue, err)
+		}
+		pulse.Value = v
+	}
+	return pulse, nil
+}
+
 func loadIncludedSensors(cfg *Config, path string, hash32seen map[uint32]string, globalIDFromFile string) error {
 	data, err := os.ReadFile(path)
 	if err != nil {
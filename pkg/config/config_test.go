@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadJSONAndResolve(t *testing.T) {
@@ -90,6 +91,37 @@ func TestLoadJSONAndResolve(t *testing.T) {
 	}
 }
 
+func TestConfigNamedSets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sensors.json")
+	content := `{
+		"sensors": {"Input1_S": 1, "Input2_S": 2, "Output1_C": 10},
+		"sets": {
+			"outputs": ["Output1_C"],
+			"inputs": ["Input1_S", "Input2_S"],
+			"broken": ["Input1_S", "Missing_S"]
+		}
+	}`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	sets := cfg.NamedSets()
+	if len(sets) != 3 || sets[0].Name != "broken" || sets[1].Name != "inputs" || sets[2].Name != "outputs" {
+		t.Fatalf("NamedSets() = %+v, want sorted by name", sets)
+	}
+	if sets[0].Err == nil || len(sets[0].Members) != 0 {
+		t.Fatalf("broken set = %+v, want resolve error", sets[0])
+	}
+	if !reflect.DeepEqual(sets[1].Members, []int64{1, 2}) || sets[1].Err != nil {
+		t.Fatalf("inputs set = %+v", sets[1])
+	}
+}
+
 func TestLoadXMLMissingID(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "sensors.xml")
@@ -139,6 +171,41 @@ func TestLoadXMLWithIDFromFile0(t *testing.T) {
 	}
 }
 
+func TestWriteSensorsXML(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, sensors []GeneratedSensor) *Config {
+		t.Helper()
+		var b strings.Builder
+		if err := WriteSensorsXML(&b, sensors); err != nil {
+			t.Fatalf("WriteSensorsXML: %v", err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+			t.Fatalf("write temp config: %v", err)
+		}
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load generated config: %v\n%s", err, b.String())
+		}
+		return cfg
+	}
+
+	// Заготовки по ID из истории: ID сохраняются, имена Sensor<ID>_S.
+	cfg := write("ids.xml", []GeneratedSensor{SkeletonSensor(10001), SkeletonSensor(10002)})
+	if id := cfg.Sensors["Sensor10002_S"]; id != 10002 || len(cfg.Sensors) != 2 {
+		t.Fatalf("sensors = %v", cfg.Sensors)
+	}
+
+	// Только имена (ClickHouse): idfromfile="0", спецсимволы экранируются.
+	cfg = write("names.xml", []GeneratedSensor{{Name: "DI_Pump<1>", IOType: "DI"}, {Name: "AI_Level&Temp", IOType: "AI"}})
+	if id := cfg.Sensors["DI_Pump<1>"]; id != int64(Hash32ForName("DI_Pump<1>")) {
+		t.Fatalf("generated id = %d, sensors = %v", id, cfg.Sensors)
+	}
+	if _, ok := cfg.Sensors["AI_Level&Temp"]; !ok {
+		t.Fatalf("sensors = %v", cfg.Sensors)
+	}
+}
+
 func TestLoadXMLWithExplicitID(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, "sensors.xml")
@@ -171,8 +238,8 @@ func TestLoadXMLWithGlobalIDFromFile0(t *testing.T) {
 <UNISETPLC>
   <ObjectsMap idfromfile="0">
     <sensors name="Sensors">
-      <item name="Sensor1" textname="Датчик 1" iotype="DI"/>
-      <item name="Sensor2" textname="Датчик 2" iotype="AI"/>
+      <item name="Sensor1" textname="Датчик 1" iotype="DI" group="Pumps"/>
+      <item name="Sensor2" textname="Датчик 2" iotype="AI" section="Valves"/>
       <item name="Sensor3" textname="Датчик 3" iotype="AO"/>
     </sensors>
   </ObjectsMap>
@@ -207,4 +274,262 @@ func TestLoadXMLWithGlobalIDFromFile0(t *testing.T) {
 	} else if meta.IOType != "DI" {
 		t.Fatalf("expected iotype DI, got %s", meta.IOType)
 	}
+	for name, want := range map[string]string{"Sensor1": "Pumps", "Sensor2": "Valves", "Sensor3": ""} {
+		if got := cfg.SensorMeta[name].Group; got != want {
+			t.Fatalf("sensor %q group = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadXMLCalibration(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sensors.xml")
+	content := `<?xml version="1.0" encoding="utf-8"?>
+<uniset>
+	<sensors>
+		<item id="1" name="Raw_AS" iotype="AI" cal_scale="0.1" cal_offset="-5"/>
+		<item id="2" name="Offset_AS" iotype="AI" cal_offset="2"/>
+		<item id="3" name="Switch_S" iotype="DI" cal_scale="10"/>
+		<item id="4" name="Plain_AS" iotype="AI"/>
+	</sensors>
+</uniset>`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	calib := cfg.Calibrations()
+	if len(calib) != 2 {
+		t.Fatalf("expected 2 calibrations (discrete exempt), got %d: %+v", len(calib), calib)
+	}
+	raw := calib[HashForName("Raw_AS")]
+	if got := raw.Apply(100); got != 5 {
+		t.Fatalf("Raw_AS calibrated value = %v, want 5", got)
+	}
+	off := calib[HashForName("Offset_AS")]
+	if off.Scale != 1 || off.Offset != 2 {
+		t.Fatalf("Offset_AS calibration = %+v, want scale=1 offset=2", off)
+	}
+	if _, ok := calib[HashForName("Switch_S")]; ok {
+		t.Fatalf("discrete sensor must not be calibrated")
+	}
+	if got := cfg.IOTypes()[HashForName("Switch_S")]; got != "DI" {
+		t.Fatalf("Switch_S iotype = %q, want DI", got)
+	}
+	discrete := cfg.DiscreteSensors()
+	if _, ok := discrete[HashForName("Switch_S")]; !ok || len(discrete) != 1 {
+		t.Fatalf("discrete sensors = %v, want only Switch_S", discrete)
+	}
+}
+
+func TestConfigTextNames(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sensors.xml")
+	content := `<?xml version="1.0" encoding="utf-8"?>
+<uniset>
+	<sensors>
+		<item id="1" name="Level_AS" iotype="AI" textname="Tank level"/>
+		<item id="2" name="Plain_AS" iotype="AI" textname="  "/>
+	</sensors>
+</uniset>`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	names := cfg.TextNames()
+	if len(names) != 1 || names[HashForName("Level_AS")] != "Tank level" {
+		t.Fatalf("TextNames() = %+v, want only Level_AS", names)
+	}
+}
+
+func TestLoadXMLLimits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sensors.xml")
+	content := `<?xml version="1.0" encoding="utf-8"?>
+<uniset>
+	<sensors>
+		<item id="1" name="Level_AS" iotype="AI" min="0" max="100"/>
+		<item id="2" name="Temp_AS" iotype="AI" max="80.5"/>
+		<item id="3" name="Plain_AS" iotype="AI"/>
+	</sensors>
+</uniset>`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	limits := cfg.Limits()
+	if len(limits) != 2 {
+		t.Fatalf("expected 2 limits, got %+v", limits)
+	}
+	level := limits[HashForName("Level_AS")]
+	if limit, bound, ok := level.Violated(-1); !ok || bound != "min" || limit != 0 {
+		t.Fatalf("Level_AS -1: %v %q %v", limit, bound, ok)
+	}
+	if _, _, ok := level.Violated(100); ok {
+		t.Fatalf("Level_AS 100 must be within limits")
+	}
+	temp := limits[HashForName("Temp_AS")]
+	if limit, bound, ok := temp.Violated(81); !ok || bound != "max" || limit != 80.5 {
+		t.Fatalf("Temp_AS 81: %v %q %v", limit, bound, ok)
+	}
+	if _, _, ok := temp.Violated(-1e9); ok {
+		t.Fatalf("Temp_AS has no min")
+	}
+
+	bad := filepath.Join(dir, "bad.xml")
+	if err := os.WriteFile(bad, []byte(`<uniset><sensors><item id="1" name="A_AS" min="10" max="1"/></sensors></uniset>`), 0o644); err != nil {
+		t.Fatalf("write bad config: %v", err)
+	}
+	if _, err := Load(bad); err == nil || !strings.Contains(err.Error(), "min") {
+		t.Fatalf("expected min > max error, got %v", err)
+	}
+}
+
+func TestLoadXMLPulse(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sensors.xml")
+	content := `<?xml version="1.0" encoding="utf-8"?>
+<uniset>
+	<sensors>
+		<item id="1" name="Button_S" iotype="DI" pulse_hold="500ms"/>
+		<item id="2" name="Alarm_C" iotype="DO" pulse_hold="2s" pulse_value="1"/>
+		<item id="3" name="Plain_S" iotype="DI"/>
+	</sensors>
+</uniset>`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	pulses := cfg.Pulses()
+	if len(pulses) != 2 {
+		t.Fatalf("expected 2 pulses, got %+v", pulses)
+	}
+	if p := pulses[HashForName("Button_S")]; p.Hold != 500*time.Millisecond || p.Value != 0 {
+		t.Fatalf("Button_S pulse = %+v", p)
+	}
+	if p := pulses[HashForName("Alarm_C")]; p.Hold != 2*time.Second || p.Value != 1 {
+		t.Fatalf("Alarm_C pulse = %+v", p)
+	}
+
+	for _, tc := range []struct{ item, want string }{
+		{`<item id="1" name="Level_AS" iotype="AI" pulse_hold="1s"/>`, "discrete"},
+		{`<item id="1" name="Button_S" iotype="DI" pulse_hold="soon"/>`, "pulse_hold"},
+		{`<item id="1" name="Button_S" iotype="DI" pulse_hold="0s"/>`, "positive"},
+		{`<item id="1" name="Button_S" iotype="DI" pulse_value="1"/>`, "requires pulse_hold"},
+	} {
+		bad := filepath.Join(dir, "bad.xml")
+		if err := os.WriteFile(bad, []byte(`<uniset><sensors>`+tc.item+`</sensors></uniset>`), 0o644); err != nil {
+			t.Fatalf("write bad config: %v", err)
+		}
+		if _, err := Load(bad); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected %q error, got %v", tc.item, tc.want, err)
+		}
+	}
+}
+
+func TestLoadXMLInvalidCalibration(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sensors.xml")
+	content := `<?xml version="1.0" encoding="utf-8"?>
+<uniset>
+	<sensors>
+		<item id="1" name="Raw_AS" iotype="AI" cal_scale="abc"/>
+	</sensors>
+</uniset>`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "cal_scale") {
+		t.Fatalf("expected cal_scale error, got %v", err)
+	}
+}
+
+func TestHashersRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sensors.xml")
+	content := `<?xml version="1.0" encoding="utf-8"?>
+<uniset>
+	<sensors>
+		<item name="Level_AS" idfromfile="0" min="0" max="100"/>
+		<item name="Pump1_S" id="7"/>
+	</sensors>
+</uniset>`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write temp config: %v", err)
+	}
+
+	hashes := map[string]int64{}
+	for _, algo := range []string{HashCity64, HashMurmur2, HashFNV} {
+		h, err := HasherByName(algo)
+		if err != nil {
+			t.Fatalf("HasherByName(%q): %v", algo, err)
+		}
+		if h.Name() != algo {
+			t.Fatalf("Name() = %q, want %q", h.Name(), algo)
+		}
+		cfg, err := LoadWithHasher(path, h)
+		if err != nil {
+			t.Fatalf("%s: LoadWithHasher returned error: %v", algo, err)
+		}
+		for _, name := range []string{"Level_AS", "Pump1_S"} {
+			key, ok := cfg.Registry.ByName(name)
+			if !ok || key.Hash != h.Hash64(name) || cfg.Registry.HashForName(name) != key.Hash {
+				t.Fatalf("%s: ByName(%q) = %+v, want hash %d", algo, name, key, h.Hash64(name))
+			}
+			if back, ok := cfg.NameByHash(key.Hash); !ok || back != name {
+				t.Fatalf("%s: NameByHash(%d) = %q, want %q", algo, key.Hash, back, name)
+			}
+		}
+		if id := cfg.Sensors["Level_AS"]; id != int64(h.Hash32("Level_AS")) {
+			t.Fatalf("%s: generated config_id = %d, want hash32 %d", algo, id, h.Hash32("Level_AS"))
+		}
+		if _, ok := cfg.Limits()[h.Hash64("Level_AS")]; !ok {
+			t.Fatalf("%s: limits not keyed by hasher hash", algo)
+		}
+		hashes[algo] = h.Hash64("Level_AS")
+	}
+	if hashes[HashCity64] != HashForName("Level_AS") {
+		t.Fatalf("cityhash64 must match default HashForName")
+	}
+	if hashes[HashCity64] == hashes[HashMurmur2] || hashes[HashCity64] == hashes[HashFNV] || hashes[HashMurmur2] == hashes[HashFNV] {
+		t.Fatalf("algorithms produce equal hashes: %v", hashes)
+	}
+
+	if h, err := HasherByName(""); err != nil || h.Name() != HashCity64 {
+		t.Fatalf("empty algo should be cityhash64, got %v, %v", h, err)
+	}
+	if _, err := HasherByName("sha1"); err == nil {
+		t.Fatalf("expected error for unknown algorithm")
+	}
+}
+
+func TestGuessIOType(t *testing.T) {
+	cases := map[string]string{
+		"DI_Door":  "DI",
+		"do_Lamp":  "DO",
+		"AI_Temp":  "AI",
+		"AO_Valve": "AO",
+		"Input1_S": "AI",
+		" DIn1 ":   "DI",
+		"":         "AI",
+	}
+	for name, want := range cases {
+		if got := GuessIOType(name); got != want {
+			t.Fatalf("GuessIOType(%q) = %q, want %q", name, got, want)
+		}
+	}
 }
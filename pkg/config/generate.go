@@ -0,0 +1,68 @@
+package config
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GeneratedSensor — датчик генерируемого XML-конфига (gen-sensors-xml, --discover-sensors).
+type GeneratedSensor struct {
+	ID       int64 // 0 — без атрибута id: config_id считается из имени (idfromfile="0")
+	Name     string
+	IOType   string
+	TextName string
+}
+
+// SkeletonSensor — заготовка датчика, известного только по ID: имя Sensor<ID>_S и iotype AI,
+// как по умолчанию в gen-sensors-xml. Имена и типы предполагается поправить вручную.
+func SkeletonSensor(id int64) GeneratedSensor {
+	return GeneratedSensor{
+		ID:       id,
+		Name:     fmt.Sprintf("Sensor%d_S", id),
+		IOType:   "AI",
+		TextName: fmt.Sprintf("generated sensor %d", id),
+	}
+}
+
+// XMLItem возвращает элемент <item …/> конфига UniSet.
+func (s GeneratedSensor) XMLItem() string {
+	var b strings.Builder
+	b.WriteString("<item")
+	if s.ID != 0 {
+		fmt.Fprintf(&b, ` id="%d"`, s.ID)
+	}
+	for _, attr := range [][2]string{{"iotype", s.IOType}, {"name", s.Name}, {"textname", s.TextName}} {
+		b.WriteString(" " + attr[0] + `="`)
+		xml.EscapeText(&b, []byte(attr[1]))
+		b.WriteString(`"`)
+	}
+	b.WriteString("/>")
+	return b.String()
+}
+
+// WriteSensorsXML пишет полный конфиг с секцией <sensors>, который читает Load.
+// Если хотя бы у одного датчика нет ID, ObjectsMap получает idfromfile="0".
+func WriteSensorsXML(w io.Writer, sensors []GeneratedSensor) error {
+	idFromFile := "1"
+	for _, s := range sensors {
+		if s.ID == 0 {
+			idFromFile = "0"
+			break
+		}
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, `<?xml version="1.0" encoding="utf-8"?>`)
+	fmt.Fprintln(bw, `<UNISETPLC>`)
+	fmt.Fprintf(bw, "  <ObjectsMap idfromfile=%q>\n", idFromFile)
+	fmt.Fprintln(bw, `    <sensors name="Sensors">`)
+	for _, s := range sensors {
+		fmt.Fprintln(bw, "      "+s.XMLItem())
+	}
+	fmt.Fprintln(bw, `    </sensors>`)
+	fmt.Fprintln(bw, `  </ObjectsMap>`)
+	fmt.Fprintln(bw, `</UNISETPLC>`)
+	return bw.Flush()
+}
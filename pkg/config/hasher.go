@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/aviddiviner/go-murmur"
+	"github.com/go-faster/city"
+)
+
+// Алгоритмы хеширования имён датчиков (значения флага --hash-algo).
+const (
+	HashCity64  = "cityhash64" // uniset::hash64() = CityHash64, uniset::hash32() = MurmurHash2 (по умолчанию)
+	HashMurmur2 = "murmur2"    // MurmurHash64A и MurmurHash2, seed=0
+	HashFNV     = "fnv"        // FNV-1a 64 и 32 бит
+)
+
+// Hasher вычисляет идентификаторы датчика по имени.
+// Hash64 — основной внутренний идентификатор (name_hid), Hash32 — uniset_hid и config_id при idfromfile="0".
+// Алгоритм должен совпадать с тем, которым пользуется UniSet на объекте, иначе name_hid/uniset_hid в БД не найдутся.
+type Hasher interface {
+	Name() string
+	Hash64(name string) int64
+	Hash32(name string) uint32
+}
+
+// DefaultHasher — хеширование UniSet по умолчанию (CityHash64 + MurmurHash2).
+var DefaultHasher Hasher = cityHasher{}
+
+// HasherByName возвращает Hasher по имени алгоритма; пустое имя — DefaultHasher.
+func HasherByName(algo string) (Hasher, error) {
+	switch strings.ToLower(strings.TrimSpace(algo)) {
+	case "", HashCity64:
+		return cityHasher{}, nil
+	case HashMurmur2:
+		return murmurHasher{}, nil
+	case HashFNV:
+		return fnvHasher{}, nil
+	default:
+		return nil, fmt.Errorf("config: unknown hash algorithm %q (expected %s|%s|%s)", algo, HashCity64, HashMurmur2, HashFNV)
+	}
+}
+
+type cityHasher struct{}
+
+func (cityHasher) Name() string { return HashCity64 }
+
+func (cityHasher) Hash64(name string) int64 { return int64(city.Hash64([]byte(name))) }
+
+func (cityHasher) Hash32(name string) uint32 { return murmur.MurmurHash2([]byte(name), 0) }
+
+type murmurHasher struct{}
+
+func (murmurHasher) Name() string { return HashMurmur2 }
+
+func (murmurHasher) Hash64(name string) int64 { return int64(murmur.MurmurHash64A([]byte(name), 0)) }
+
+func (murmurHasher) Hash32(name string) uint32 { return murmur.MurmurHash2([]byte(name), 0) }
+
+type fnvHasher struct{}
+
+func (fnvHasher) Name() string { return HashFNV }
+
+func (fnvHasher) Hash64(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+func (fnvHasher) Hash32(name string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return h.Sum32()
+}
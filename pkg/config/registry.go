@@ -11,16 +11,44 @@ type SensorRegistry struct {
 	byName  map[string]int64      // name → hash
 	byID    map[int64]int64       // configID → hash (только если есть ID)
 	hasIDs  bool                  // true если все датчики имеют ID
+	hasher  Hasher                // алгоритм hash(name)
 }
 
-// NewSensorRegistry создаёт пустой реестр датчиков.
+// NewSensorRegistry создаёт пустой реестр датчиков с DefaultHasher.
 func NewSensorRegistry() *SensorRegistry {
+	return NewSensorRegistryWithHasher(DefaultHasher)
+}
+
+// NewSensorRegistryWithHasher создаёт пустой реестр датчиков с указанным алгоритмом хеширования (nil — DefaultHasher).
+func NewSensorRegistryWithHasher(h Hasher) *SensorRegistry {
+	if h == nil {
+		h = DefaultHasher
+	}
 	return &SensorRegistry{
 		sensors: make(map[int64]*SensorKey),
 		byName:  make(map[string]int64),
 		byID:    make(map[int64]int64),
 		hasIDs:  true,
+		hasher:  h,
+	}
+}
+
+// Hasher возвращает алгоритм хеширования реестра (DefaultHasher для nil-реестра).
+func (r *SensorRegistry) Hasher() Hasher {
+	if r == nil || r.hasher == nil {
+		return DefaultHasher
 	}
+	return r.hasher
+}
+
+// HashForName вычисляет hash имени датчика алгоритмом реестра.
+func (r *SensorRegistry) HashForName(name string) int64 {
+	return r.Hasher().Hash64(name)
+}
+
+// NewKey создаёт SensorKey с hash, вычисленным алгоритмом реестра (в реестр не добавляет).
+func (r *SensorRegistry) NewKey(name string, id *int64) *SensorKey {
+	return NewSensorKeyWithHasher(name, id, r.Hasher())
 }
 
 // Add добавляет датчик в реестр. Возвращает ошибку при коллизии hash.
@@ -1,26 +1,26 @@
 package config
 
-import (
-	"github.com/aviddiviner/go-murmur"
-	"github.com/go-faster/city"
-)
-
 // SensorKey представляет ключ датчика с уникальным hash идентификатором.
-// Совместимость с UniSet:
+// Совместимость с UniSet (при DefaultHasher):
 //   - Hash (int64) использует CityHash64 - соответствует uniset::hash64()
 //   - Hash32ForName (uint32) использует MurmurHash2 seed=0 - соответствует uniset::hash32()
 type SensorKey struct {
 	Name string // имя датчика (всегда есть)
 	ID   *int64 // ID из конфига (nil если idfromfile="0")
-	Hash int64  // cityhash64(name) - основной внутренний идентификатор
+	Hash int64  // Hasher.Hash64(name) (по умолчанию cityhash64) - основной внутренний идентификатор
 }
 
-// NewSensorKey создаёт новый SensorKey с вычисленным hash.
+// NewSensorKey создаёт новый SensorKey с hash, вычисленным DefaultHasher.
 func NewSensorKey(name string, id *int64) *SensorKey {
+	return NewSensorKeyWithHasher(name, id, DefaultHasher)
+}
+
+// NewSensorKeyWithHasher создаёт SensorKey с hash, вычисленным указанным алгоритмом.
+func NewSensorKeyWithHasher(name string, id *int64, h Hasher) *SensorKey {
 	return &SensorKey{
 		Name: name,
 		ID:   id,
-		Hash: int64(city.Hash64([]byte(name))),
+		Hash: h.Hash64(name),
 	}
 }
 
@@ -40,12 +40,12 @@ func (k *SensorKey) ConfigID() int64 {
 // HashForName вычисляет CityHash64 для имени датчика.
 // Совместимо с uniset::hash64().
 func HashForName(name string) int64 {
-	return int64(city.Hash64([]byte(name)))
+	return DefaultHasher.Hash64(name)
 }
 
 // Hash32ForName вычисляет MurmurHash2 (32-bit, seed=0) для имени датчика.
 // Совместимо с uniset::hash32().
 // Используется для генерации config_id когда idfromfile="0".
 func Hash32ForName(name string) uint32 {
-	return murmur.MurmurHash2([]byte(name), 0)
+	return DefaultHasher.Hash32(name)
 }
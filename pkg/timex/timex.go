@@ -0,0 +1,109 @@
+// Package timex разбирает моменты времени в аргументах командной строки: RFC3339
+// и относительные значения now, today, yesterday со смещением (now-1h, today+8h, now-2d).
+package timex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Syntax — краткое описание допустимых значений для справки флагов.
+const Syntax = "RFC3339, now, now-1h, today, yesterday"
+
+// Parse разбирает момент времени относительно текущего (см. ParseAt).
+// now округляется до секунды, как и метки в истории датчиков.
+func Parse(value string) (time.Time, error) {
+	return ParseAt(value, time.Now().Truncate(time.Second))
+}
+
+// ParseAt разбирает момент времени относительно now и возвращает его в UTC:
+//   - RFC3339 с зоной: 2024-06-01T00:00:00Z, 2024-06-01T03:00:00+03:00;
+//   - now — текущий момент, today — начало текущих суток UTC, yesterday — начало предыдущих;
+//   - смещение от них: now-1h, today+8h30m, yesterday+12h, now-2d (d — сутки, 24h).
+//
+// Дата без времени или время без зоны отклоняются: неясно, в какой зоне их считать.
+func ParseAt(value string, now time.Time) (time.Time, error) {
+	raw := strings.TrimSpace(value)
+	if raw == "" {
+		return time.Time{}, fmt.Errorf("empty time: want %s", Syntax)
+	}
+	lower := strings.ToLower(raw)
+	now = now.UTC()
+	for _, base := range []struct {
+		name string
+		ts   func() time.Time
+	}{
+		{"now", func() time.Time { return now }},
+		{"today", func() time.Time { return midnight(now) }},
+		{"yesterday", func() time.Time { return midnight(now).AddDate(0, 0, -1) }},
+	} {
+		if !strings.HasPrefix(lower, base.name) {
+			continue
+		}
+		rest := lower[len(base.name):]
+		if rest == "" {
+			return base.ts(), nil
+		}
+		if rest[0] != '+' && rest[0] != '-' {
+			continue
+		}
+		offset, err := parseOffset(rest)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid offset in %q: %w", raw, err)
+		}
+		return base.ts().Add(offset), nil
+	}
+	ts, err := time.Parse(time.RFC3339, raw)
+	if err == nil {
+		return ts.UTC(), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04"} {
+		if _, perr := time.Parse(layout, raw); perr == nil {
+			return time.Time{}, fmt.Errorf("ambiguous time %q: time zone is missing, use RFC3339 like %s", raw, suggest(raw))
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: want %s", raw, Syntax)
+}
+
+// parseOffset разбирает смещение со знаком: длительность Go (-1h30m) или целые сутки (-2d).
+func parseOffset(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if s[0] == '-' {
+		sign = -1
+	}
+	body := s[1:]
+	if body == "" {
+		return 0, fmt.Errorf("missing duration after %q", s[:1])
+	}
+	if days, ok := strings.CutSuffix(body, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("want whole days like 2d, got %q", body)
+		}
+		return sign * time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(body)
+	if err != nil {
+		return 0, fmt.Errorf("want duration with unit like 1h or 30m, got %q", body)
+	}
+	return sign * d, nil
+}
+
+func midnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// suggest дополняет дату или время без зоны до примера в RFC3339 (UTC).
+func suggest(raw string) string {
+	s := strings.Replace(raw, " ", "T", 1)
+	switch len(s) {
+	case len("2006-01-02"):
+		s += "T00:00:00"
+	case len("2006-01-02T15:04"):
+		s += ":00"
+	}
+	return s + "Z"
+}
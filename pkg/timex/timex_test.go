@@ -0,0 +1,53 @@
+package timex
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAt(t *testing.T) {
+	now := time.Date(2024, 6, 15, 13, 45, 10, 0, time.FixedZone("MSK", 3*3600))
+	nowUTC := now.UTC()
+	today := time.Date(2024, 6, 15, 0, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"now":                       nowUTC,
+		" NOW ":                     nowUTC,
+		"now-1h":                    nowUTC.Add(-time.Hour),
+		"now+30m":                   nowUTC.Add(30 * time.Minute),
+		"now-2d":                    nowUTC.Add(-48 * time.Hour),
+		"today":                     today,
+		"today+8h30m":               today.Add(8*time.Hour + 30*time.Minute),
+		"yesterday":                 today.AddDate(0, 0, -1),
+		"yesterday+12h":             today.Add(-12 * time.Hour),
+		"2024-06-01T00:00:00Z":      time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		"2024-06-01T03:00:00+03:00": time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for in, want := range cases {
+		got, err := ParseAt(in, now)
+		if err != nil {
+			t.Fatalf("ParseAt(%q): %v", in, err)
+		}
+		if !got.Equal(want) || got.Location() != time.UTC {
+			t.Fatalf("ParseAt(%q) = %s, want %s UTC", in, got, want)
+		}
+	}
+
+	errs := map[string]string{
+		"":                    "empty time",
+		"now-":                "missing duration",
+		"now-1":               "want duration with unit",
+		"today+xd":            "whole days",
+		"2024-06-01":          "2024-06-01T00:00:00Z",
+		"2024-06-01 12:00:00": "2024-06-01T12:00:00Z",
+		"2024-06-01T12:00":    "time zone is missing",
+		"nowish":              "invalid time",
+		"tomorrow":            "invalid time",
+	}
+	for in, want := range errs {
+		_, err := ParseAt(in, now)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("ParseAt(%q) err = %v, want containing %q", in, err, want)
+		}
+	}
+}